@@ -41,6 +41,18 @@ var (
 	// If text length is at or above this threshold, approximate counting (length/4) is used.
 	// Set to 0 to always use precise counting (default behavior).
 	fuzzyTokenThreshold atomic.Int64
+
+	// batchDiscount is the default ratio of the price charged for batch requests,
+	// it can be overridden per model by Price.BatchDiscount
+	batchDiscount    uint64 = math.Float64bits(1)
+	batchWorkers     atomic.Int64
+	batchConcurrency atomic.Int64
+
+	mcpStdioMaxProcesses      atomic.Int64
+	mcpStdioMaxGroupProcesses atomic.Int64
+	mcpStdioIdleTimeout       atomic.Int64
+
+	mcpSamplingModel atomic.Value
 )
 
 func init() {
@@ -52,6 +64,12 @@ func init() {
 	defaultMCPHost.Store("")
 	publicMCPHost.Store("")
 	groupMCPHost.Store("")
+	batchWorkers.Store(2)
+	batchConcurrency.Store(8)
+	mcpStdioMaxProcesses.Store(32)
+	mcpStdioMaxGroupProcesses.Store(4)
+	mcpStdioIdleTimeout.Store(600)
+	mcpSamplingModel.Store("")
 }
 
 func GetRetryTimes() int64 {
@@ -294,3 +312,74 @@ func SetFuzzyTokenThreshold(threshold int64) {
 	threshold = env.Int64("FUZZY_TOKEN_THRESHOLD", threshold)
 	fuzzyTokenThreshold.Store(threshold)
 }
+
+func GetBatchDiscount() float64 {
+	return math.Float64frombits(atomic.LoadUint64(&batchDiscount))
+}
+
+func SetBatchDiscount(discount float64) {
+	discount = env.Float64("BATCH_DISCOUNT", discount)
+	atomic.StoreUint64(&batchDiscount, math.Float64bits(discount))
+}
+
+// GetBatchWorkers is the max number of batches processed at the same time by one instance
+func GetBatchWorkers() int64 {
+	return batchWorkers.Load()
+}
+
+func SetBatchWorkers(workers int64) {
+	workers = env.Int64("BATCH_WORKERS", workers)
+	batchWorkers.Store(workers)
+}
+
+// GetBatchConcurrency is the max number of in-flight requests of a single batch
+func GetBatchConcurrency() int64 {
+	return batchConcurrency.Load()
+}
+
+func SetBatchConcurrency(concurrency int64) {
+	concurrency = env.Int64("BATCH_CONCURRENCY", concurrency)
+	batchConcurrency.Store(concurrency)
+}
+
+// GetMCPStdioMaxProcesses is the max number of stdio mcp processes of one instance, 0 means no limit
+func GetMCPStdioMaxProcesses() int64 {
+	return mcpStdioMaxProcesses.Load()
+}
+
+func SetMCPStdioMaxProcesses(processes int64) {
+	processes = env.Int64("MCP_STDIO_MAX_PROCESSES", processes)
+	mcpStdioMaxProcesses.Store(processes)
+}
+
+// GetMCPStdioMaxGroupProcesses is the max number of stdio mcp processes of a group, 0 means no limit
+func GetMCPStdioMaxGroupProcesses() int64 {
+	return mcpStdioMaxGroupProcesses.Load()
+}
+
+func SetMCPStdioMaxGroupProcesses(processes int64) {
+	processes = env.Int64("MCP_STDIO_MAX_GROUP_PROCESSES", processes)
+	mcpStdioMaxGroupProcesses.Store(processes)
+}
+
+// GetMCPStdioIdleTimeout is the seconds a stdio mcp process is kept without use, 0 means never closed
+func GetMCPStdioIdleTimeout() int64 {
+	return mcpStdioIdleTimeout.Load()
+}
+
+func SetMCPStdioIdleTimeout(timeout int64) {
+	timeout = env.Int64("MCP_STDIO_IDLE_TIMEOUT", timeout)
+	mcpStdioIdleTimeout.Store(timeout)
+}
+
+// GetMCPSamplingModel is the model of the mcp sampling requests whose model
+// preferences match no model of the token, empty means they are rejected
+func GetMCPSamplingModel() string {
+	m, _ := mcpSamplingModel.Load().(string)
+	return m
+}
+
+func SetMCPSamplingModel(model string) {
+	model = env.String("MCP_SAMPLING_MODEL", model)
+	mcpSamplingModel.Store(model)
+}
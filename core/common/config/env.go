@@ -21,6 +21,24 @@ var (
 	RedisKeyPrefix       string
 	ConfigFilePath       string
 
+	// File storage used by the /v1/files API
+	FileStorageType string
+	FileStoragePath string
+	FileMaxSize     int64
+
+	// Prometheus /metrics endpoint, the group label can be dropped and
+	// the distinct values of each label are capped to bound the cardinality
+	DisableMetrics           bool
+	MetricsDisableGroupLabel bool
+	MetricsMaxLabelValues    int64
+
+	// OpenTelemetry tracing of the relay requests, exported by otlp over http
+	TracingEnabled bool
+
+	// TokenKeySalt is the salt of the token key hashes, a random salt is
+	// generated and stored in the database when it is empty
+	TokenKeySalt string
+
 	// OnCall Lark configuration for urgent alerts
 	OnCallLarkAppID     string
 	OnCallLarkAppSecret string
@@ -41,6 +59,18 @@ func ReloadEnv() {
 	RedisKeyPrefix = os.Getenv("REDIS_KEY_PREFIX")
 	ConfigFilePath = env.String("CONFIG_FILE_PATH", "./config.yaml")
 
+	FileStorageType = env.String("FILE_STORAGE_TYPE", "local")
+	FileStoragePath = env.String("FILE_STORAGE_PATH", "./files")
+	FileMaxSize = env.Int64("FILE_MAX_SIZE", 512*1024*1024)
+
+	DisableMetrics = env.Bool("DISABLE_METRICS", false)
+	MetricsDisableGroupLabel = env.Bool("METRICS_DISABLE_GROUP_LABEL", false)
+	MetricsMaxLabelValues = env.Int64("METRICS_MAX_LABEL_VALUES", 1000)
+
+	TracingEnabled = env.Bool("TRACING_ENABLED", false)
+
+	TokenKeySalt = os.Getenv("TOKEN_KEY_SALT")
+
 	// OnCall Lark configuration
 	OnCallLarkAppID = os.Getenv("ON_CALL_LARK_APP_ID")
 	OnCallLarkAppSecret = os.Getenv("ON_CALL_LARK_APP_SECRET")
@@ -111,6 +111,9 @@ func Consume(
 		user,
 		metadata,
 	)
+
+	recordMetrics(now, meta, code, firstByteAt, usage, amount, downstreamResult)
+
 	if err != nil {
 		log.Error("error batch record consume: " + err.Error())
 		notify.ErrorThrottle("recordConsume", time.Minute*5, "record consume failed", err.Error())
@@ -145,7 +148,10 @@ func checkNeedRecordConsume(code int, meta *meta.Meta) bool {
 		mode.ResponsesGet,
 		mode.ResponsesDelete,
 		mode.ResponsesCancel,
-		mode.ResponsesInputItems:
+		mode.ResponsesInputItems,
+		mode.FilesGet,
+		mode.FilesDelete,
+		mode.FilesContent:
 		return code != http.StatusOK
 	default:
 		return true
@@ -3,6 +3,8 @@ package consume
 import (
 	"time"
 
+	"github.com/labring/aiproxy/core/common/metrics"
+	"github.com/labring/aiproxy/core/common/tracing"
 	"github.com/labring/aiproxy/core/model"
 	"github.com/labring/aiproxy/core/relay/meta"
 )
@@ -26,6 +28,7 @@ func recordConsume(
 	return model.BatchRecordLogs(
 		now,
 		meta.RequestID,
+		tracing.TraceID(meta.TraceContext()),
 		meta.RequestAt,
 		meta.RetryAt,
 		firstByteAt,
@@ -33,6 +36,8 @@ func recordConsume(
 		code,
 		meta.Channel.ID,
 		meta.OriginModel,
+		meta.ModelAlias,
+		meta.ChannelStrategy,
 		meta.Token.ID,
 		meta.Token.Name,
 		meta.Endpoint,
@@ -40,6 +45,7 @@ func recordConsume(
 		int(meta.Mode),
 		ip,
 		retryTimes,
+		meta.FallbackModel,
 		requestDetail,
 		downstreamResult,
 		usage,
@@ -69,8 +75,39 @@ func recordSummary(
 		meta.OriginModel,
 		meta.Token.ID,
 		meta.Token.Name,
+		int(meta.Mode),
 		downstreamResult,
 		usage,
 		amount,
 	)
 }
+
+func recordMetrics(
+	now time.Time,
+	meta *meta.Meta,
+	code int,
+	firstByteAt time.Time,
+	usage model.Usage,
+	amount float64,
+	downstreamResult bool,
+) {
+	metrics.ObserveRequest(metrics.Request{
+		Model:       meta.OriginModel,
+		ChannelID:   meta.Channel.ID,
+		Group:       meta.Group.ID,
+		Mode:        meta.Mode.String(),
+		Code:        code,
+		RequestAt:   meta.RequestAt,
+		FirstByteAt: firstByteAt,
+		EndAt:       now,
+		Tokens: map[string]int64{
+			"input":          int64(usage.InputTokens),
+			"output":         int64(usage.OutputTokens),
+			"cached":         int64(usage.CachedTokens),
+			"cache_creation": int64(usage.CacheCreationTokens),
+			"reasoning":      int64(usage.ReasoningTokens),
+		},
+		Amount:     amount,
+		Downstream: downstreamResult,
+	})
+}
@@ -0,0 +1,110 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+var _ Storage = (*LocalStorage)(nil)
+
+type LocalStorage struct {
+	dir string
+}
+
+func NewLocalStorage(dir string) (*LocalStorage, error) {
+	if dir == "" {
+		return nil, errors.New("local file storage path is empty")
+	}
+
+	absDir, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := os.MkdirAll(absDir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &LocalStorage{dir: absDir}, nil
+}
+
+func (l *LocalStorage) path(key string) (string, error) {
+	if key == "" ||
+		strings.Contains(key, "..") ||
+		filepath.IsAbs(key) {
+		return "", fmt.Errorf("invalid file key: %s", key)
+	}
+
+	return filepath.Join(l.dir, filepath.FromSlash(key)), nil
+}
+
+func (l *LocalStorage) Put(_ context.Context, key string, r io.Reader) (int64, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(p), 0o755); err != nil {
+		return 0, err
+	}
+
+	// write to a temp file first so readers never see a partial file
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".upload-*")
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := io.Copy(tmp, r)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+
+	if err != nil {
+		_ = os.Remove(tmp.Name())
+		return 0, err
+	}
+
+	if err := os.Rename(tmp.Name(), p); err != nil {
+		_ = os.Remove(tmp.Name())
+		return 0, err
+	}
+
+	return n, nil
+}
+
+func (l *LocalStorage) Get(_ context.Context, key string) (io.ReadCloser, error) {
+	p, err := l.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(p)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+
+	return f, nil
+}
+
+func (l *LocalStorage) Delete(_ context.Context, key string) error {
+	p, err := l.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
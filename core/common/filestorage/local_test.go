@@ -0,0 +1,68 @@
+package filestorage_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/labring/aiproxy/core/common/filestorage"
+)
+
+func TestLocalStorage(t *testing.T) {
+	storage, err := filestorage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+
+	ctx := context.Background()
+
+	n, err := storage.Put(ctx, "group1/file-abc", strings.NewReader("hello"))
+	if err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if n != 5 {
+		t.Errorf("Expected 5 bytes written, got %d", n)
+	}
+
+	r, err := storage.Get(ctx, "group1/file-abc")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	content, err := io.ReadAll(r)
+	r.Close()
+
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	if string(content) != "hello" {
+		t.Errorf("Expected content hello, got %s", content)
+	}
+
+	if err := storage.Delete(ctx, "group1/file-abc"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if _, err := storage.Get(ctx, "group1/file-abc"); !errors.Is(err, filestorage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+
+	if err := storage.Delete(ctx, "group1/file-abc"); err != nil {
+		t.Errorf("Delete of missing file should not fail, got %v", err)
+	}
+}
+
+func TestLocalStorageInvalidKey(t *testing.T) {
+	storage, err := filestorage.NewLocalStorage(t.TempDir())
+	if err != nil {
+		t.Fatalf("NewLocalStorage failed: %v", err)
+	}
+
+	if _, err := storage.Put(context.Background(), "../escape", strings.NewReader("x")); err == nil {
+		t.Error("Expected error for key escaping the storage dir")
+	}
+}
@@ -0,0 +1,76 @@
+package filestorage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+)
+
+var ErrNotFound = errors.New("file not found")
+
+// Storage stores the content of files uploaded through the files api,
+// local disk is built in, other backends (e.g. S3 compatible object stores)
+// can be plugged in with RegisterFactory
+type Storage interface {
+	Put(ctx context.Context, key string, r io.Reader) (int64, error)
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+	Delete(ctx context.Context, key string) error
+}
+
+// Factory creates a storage from the configured path or dsn
+type Factory func(path string) (Storage, error)
+
+const TypeLocal = "local"
+
+var (
+	factoriesLock sync.RWMutex
+	factories     = map[string]Factory{
+		TypeLocal: func(path string) (Storage, error) {
+			return NewLocalStorage(path)
+		},
+	}
+)
+
+func RegisterFactory(typ string, factory Factory) {
+	factoriesLock.Lock()
+	defer factoriesLock.Unlock()
+
+	factories[typ] = factory
+}
+
+func getFactory(typ string) (Factory, bool) {
+	factoriesLock.RLock()
+	defer factoriesLock.RUnlock()
+
+	factory, ok := factories[typ]
+
+	return factory, ok
+}
+
+var Default Storage
+
+func Init(typ, path string) error {
+	if typ == "" {
+		typ = TypeLocal
+	}
+
+	factory, ok := getFactory(typ)
+	if !ok {
+		return fmt.Errorf("unsupported file storage type: %s", typ)
+	}
+
+	storage, err := factory(path)
+	if err != nil {
+		return fmt.Errorf("init %s file storage failed: %w", typ, err)
+	}
+
+	Default = storage
+
+	return nil
+}
+
+func Enabled() bool {
+	return Default != nil
+}
@@ -0,0 +1,16 @@
+package metrics
+
+type LabelLimiter = labelLimiter
+
+var (
+	Limit                    = (*labelLimiter).limit
+	RequestsTotal            = requestsTotal
+	RetriesTotal             = retriesTotal
+	TokensTotal              = tokensTotal
+	UsedAmountTotal          = usedAmountTotal
+	RateLimitRejectionsTotal = rateLimitRejectionsTotal
+)
+
+func NewLabelLimiter() *labelLimiter {
+	return &labelLimiter{values: make(map[string]struct{})}
+}
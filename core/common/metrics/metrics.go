@@ -0,0 +1,229 @@
+package metrics
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+)
+
+const (
+	namespace = "aiproxy"
+
+	// otherLabelValue replaces the label values beyond the max label values
+	otherLabelValue = "other"
+)
+
+const (
+	RejectReasonRPM          = "rpm"
+	RejectReasonTPM          = "tpm"
+	RejectReasonQueueFull    = "queue_full"
+	RejectReasonQueueTimeout = "queue_timeout"
+	// RejectReasonCanceled is a request canceled by the client while queued
+	RejectReasonCanceled = "canceled"
+	// RejectReasonOther is a request rejected by an unrecognized error
+	RejectReasonOther = "other"
+)
+
+var (
+	relayLabels     = []string{"model", "channel", "group", "mode"}
+	relayCodeLabels = []string{"model", "channel", "group", "mode", "code"}
+	relayTypeLabels = []string{"model", "channel", "group", "mode", "type"}
+)
+
+// latencyBuckets covers fast embeddings to long reasoning requests
+var latencyBuckets = []float64{0.1, 0.25, 0.5, 1, 2.5, 5, 10, 20, 30, 60, 120, 300}
+
+var Registry = prometheus.NewRegistry()
+
+var (
+	requestsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Total number of relay requests returned to the downstream",
+	}, relayCodeLabels)
+
+	requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Duration of relay requests, including retries",
+		Buckets:   latencyBuckets,
+	}, relayLabels)
+
+	requestTTFB = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_ttfb_seconds",
+		Help:      "Time to the first byte of the upstream response of relay requests",
+		Buckets:   latencyBuckets,
+	}, relayLabels)
+
+	tokensTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "tokens_total",
+		Help:      "Total number of tokens used by relay requests",
+	}, relayTypeLabels)
+
+	usedAmountTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "used_amount_total",
+		Help:      "Total amount used by relay requests",
+	}, relayLabels)
+
+	retriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "retries_total",
+		Help:      "Total number of failed upstream attempts that were retried",
+	}, []string{"model", "channel", "mode", "code"})
+
+	rateLimitRejectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "rate_limit_rejections_total",
+		Help:      "Total number of requests rejected by the group rpm/tpm limits",
+	}, []string{"model", "group", "reason"})
+)
+
+func init() {
+	Registry.MustRegister(
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+		requestsTotal,
+		requestDuration,
+		requestTTFB,
+		tokensTotal,
+		usedAmountTotal,
+		retriesTotal,
+		rateLimitRejectionsTotal,
+	)
+}
+
+// labelLimiter caps the distinct values of a label, the values beyond
+// the limit are reported as otherLabelValue
+type labelLimiter struct {
+	mu     sync.RWMutex
+	values map[string]struct{}
+}
+
+var (
+	modelLimiter   = &labelLimiter{values: make(map[string]struct{})}
+	channelLimiter = &labelLimiter{values: make(map[string]struct{})}
+	groupLimiter   = &labelLimiter{values: make(map[string]struct{})}
+)
+
+func (l *labelLimiter) limit(value string) string {
+	maxValues := config.MetricsMaxLabelValues
+	if maxValues <= 0 {
+		return value
+	}
+
+	l.mu.RLock()
+	_, ok := l.values[value]
+	l.mu.RUnlock()
+
+	if ok {
+		return value
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.values[value]; ok {
+		return value
+	}
+
+	if int64(len(l.values)) >= maxValues {
+		return otherLabelValue
+	}
+
+	l.values[value] = struct{}{}
+
+	return value
+}
+
+// ModelLabel returns the model label value, capped by the max label values
+func ModelLabel(model string) string {
+	return modelLimiter.limit(model)
+}
+
+// ChannelLabel returns the channel label value, capped by the max label values
+func ChannelLabel(channelID int) string {
+	if channelID == 0 {
+		return ""
+	}
+	return channelLimiter.limit(strconv.Itoa(channelID))
+}
+
+func groupLabel(group string) string {
+	if config.MetricsDisableGroupLabel {
+		return ""
+	}
+	return groupLimiter.limit(group)
+}
+
+// Request is a finished relay request or a failed upstream attempt
+type Request struct {
+	Model       string
+	ChannelID   int
+	Group       string
+	Mode        string
+	Code        int
+	RequestAt   time.Time
+	FirstByteAt time.Time
+	EndAt       time.Time
+	// Tokens is the used tokens by type, like input, output and cached
+	Tokens map[string]int64
+	Amount float64
+	// Downstream is false for the upstream attempts that were retried
+	Downstream bool
+}
+
+func ObserveRequest(r Request) {
+	if config.DisableMetrics {
+		return
+	}
+
+	model := ModelLabel(r.Model)
+	channel := ChannelLabel(r.ChannelID)
+	code := strconv.Itoa(r.Code)
+
+	if !r.Downstream {
+		retriesTotal.WithLabelValues(model, channel, r.Mode, code).Inc()
+		return
+	}
+
+	group := groupLabel(r.Group)
+	labels := []string{model, channel, group, r.Mode}
+
+	requestsTotal.WithLabelValues(model, channel, group, r.Mode, code).Inc()
+
+	if !r.RequestAt.IsZero() && !r.EndAt.IsZero() {
+		requestDuration.WithLabelValues(labels...).Observe(r.EndAt.Sub(r.RequestAt).Seconds())
+	}
+
+	if !r.RequestAt.IsZero() && !r.FirstByteAt.IsZero() {
+		requestTTFB.WithLabelValues(labels...).Observe(r.FirstByteAt.Sub(r.RequestAt).Seconds())
+	}
+
+	for tokenType, tokens := range r.Tokens {
+		if tokens <= 0 {
+			continue
+		}
+
+		tokensTotal.WithLabelValues(model, channel, group, r.Mode, tokenType).
+			Add(float64(tokens))
+	}
+
+	if r.Amount > 0 {
+		usedAmountTotal.WithLabelValues(labels...).Add(r.Amount)
+	}
+}
+
+func AddRateLimitRejection(model, group, reason string) {
+	if config.DisableMetrics {
+		return
+	}
+
+	rateLimitRejectionsTotal.WithLabelValues(ModelLabel(model), groupLabel(group), reason).Inc()
+}
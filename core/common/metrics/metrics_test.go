@@ -0,0 +1,149 @@
+package metrics_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/common/metrics"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func setMaxLabelValues(t *testing.T, maxValues int64) {
+	t.Helper()
+
+	old := config.MetricsMaxLabelValues
+	config.MetricsMaxLabelValues = maxValues
+
+	t.Cleanup(func() {
+		config.MetricsMaxLabelValues = old
+	})
+}
+
+func TestLabelLimiter(t *testing.T) {
+	setMaxLabelValues(t, 2)
+
+	l := metrics.NewLabelLimiter()
+
+	assert.Equal(t, "a", metrics.Limit(l, "a"))
+	assert.Equal(t, "b", metrics.Limit(l, "b"))
+	assert.Equal(t, "other", metrics.Limit(l, "c"))
+	// the values seen before the cap keep their label
+	assert.Equal(t, "a", metrics.Limit(l, "a"))
+	assert.Equal(t, "b", metrics.Limit(l, "b"))
+	assert.Equal(t, "other", metrics.Limit(l, "d"))
+}
+
+func TestLabelLimiterUnlimited(t *testing.T) {
+	setMaxLabelValues(t, 0)
+
+	l := metrics.NewLabelLimiter()
+	for _, value := range []string{"a", "b", "c"} {
+		assert.Equal(t, value, metrics.Limit(l, value))
+	}
+}
+
+func TestObserveRequest(t *testing.T) {
+	setMaxLabelValues(t, 1000)
+
+	now := time.Now()
+
+	metrics.ObserveRequest(metrics.Request{
+		Model:       "test-observe",
+		ChannelID:   1,
+		Group:       "group",
+		Mode:        "chat",
+		Code:        200,
+		RequestAt:   now,
+		FirstByteAt: now.Add(time.Second),
+		EndAt:       now.Add(2 * time.Second),
+		Tokens:      map[string]int64{"input": 10, "output": 5, "cached": 0},
+		Amount:      0.5,
+		Downstream:  true,
+	})
+
+	assert.InDelta(t, 1, testutil.ToFloat64(
+		metrics.RequestsTotal.WithLabelValues("test-observe", "1", "group", "chat", "200"),
+	), 0)
+	assert.InDelta(t, 10, testutil.ToFloat64(
+		metrics.TokensTotal.WithLabelValues("test-observe", "1", "group", "chat", "input"),
+	), 0)
+	assert.InDelta(t, 5, testutil.ToFloat64(
+		metrics.TokensTotal.WithLabelValues("test-observe", "1", "group", "chat", "output"),
+	), 0)
+	assert.InDelta(t, 0.5, testutil.ToFloat64(
+		metrics.UsedAmountTotal.WithLabelValues("test-observe", "1", "group", "chat"),
+	), 0)
+
+	// the zero tokens are not reported
+	assert.False(t, metrics.TokensTotal.DeleteLabelValues(
+		"test-observe", "1", "group", "chat", "cached",
+	))
+}
+
+func TestObserveRequestRetry(t *testing.T) {
+	setMaxLabelValues(t, 1000)
+
+	metrics.ObserveRequest(metrics.Request{
+		Model:     "test-observe-retry",
+		ChannelID: 2,
+		Group:     "group",
+		Mode:      "chat",
+		Code:      500,
+	})
+
+	assert.InDelta(t, 1, testutil.ToFloat64(
+		metrics.RetriesTotal.WithLabelValues("test-observe-retry", "2", "chat", "500"),
+	), 0)
+
+	// a retried attempt is not a downstream request
+	assert.False(t, metrics.RequestsTotal.DeleteLabelValues(
+		"test-observe-retry", "2", "group", "chat", "500",
+	))
+}
+
+func TestObserveRequestDisabled(t *testing.T) {
+	old := config.DisableMetrics
+	config.DisableMetrics = true
+
+	t.Cleanup(func() {
+		config.DisableMetrics = old
+	})
+
+	metrics.ObserveRequest(metrics.Request{
+		Model:      "test-observe-disabled",
+		Code:       200,
+		Downstream: true,
+	})
+	metrics.AddRateLimitRejection("test-observe-disabled", "group", metrics.RejectReasonRPM)
+
+	assert.False(t, metrics.RequestsTotal.DeleteLabelValues(
+		"test-observe-disabled", "", "", "", "200",
+	))
+	assert.False(t, metrics.RateLimitRejectionsTotal.DeleteLabelValues(
+		"test-observe-disabled", "group", metrics.RejectReasonRPM,
+	))
+}
+
+func TestObserveRequestLabelLimit(t *testing.T) {
+	setMaxLabelValues(t, 1)
+
+	// take the only model label value if no earlier test did
+	metrics.ModelLabel("test-observe-first")
+
+	metrics.ObserveRequest(metrics.Request{
+		Model:      "test-observe-limit",
+		Group:      "group",
+		Mode:       "chat",
+		Code:       200,
+		Downstream: true,
+	})
+
+	assert.False(t, metrics.RequestsTotal.DeleteLabelValues(
+		"test-observe-limit", "", "group", "chat", "200",
+	))
+	assert.Positive(t, testutil.ToFloat64(
+		metrics.RequestsTotal.WithLabelValues("other", "", "group", "chat", "200"),
+	))
+}
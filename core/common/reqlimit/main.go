@@ -123,24 +123,43 @@ var (
 	redisChannelModelRecord  = newRedisChannelModelRecord()
 )
 
-func PushChannelModelRequest(ctx context.Context, channel, model string) (int64, int64, int64) {
+// ReserveChannelModelRequest records a request of the model on the channel if
+// the requests of the last minute of the channel are below channelRPM and of
+// the model on the channel are below modelRPM, zero is no limit. The check and
+// the record are atomic, so the concurrent requests can not exceed the limits,
+// a request over the limits is recorded as an over limit request
+func ReserveChannelModelRequest(
+	ctx context.Context,
+	channel, model string,
+	channelRPM, modelRPM int64,
+) (count, secondCount int64, ok bool) {
+	pattern := []string{channel, "*"}
+
 	if common.RedisEnabled {
-		count, overLimitCount, secondCount, err := redisChannelModelRecord.PushRequest(
+		count, secondCount, ok, err := redisChannelModelRecord.ReserveRequest(
 			ctx,
-			0,
 			time.Minute,
-			1,
+			channelRPM,
+			modelRPM,
+			pattern,
 			channel,
 			model,
 		)
 		if err == nil {
-			return count, overLimitCount, secondCount
+			return count, secondCount, ok
 		}
 
-		log.Error("redis push request error: " + err.Error())
+		log.Error("redis reserve request error: " + err.Error())
 	}
 
-	return memoryChannelModelRecord.PushRequest(0, time.Minute, 1, channel, model)
+	return memoryChannelModelRecord.ReserveRequest(
+		time.Minute,
+		channelRPM,
+		modelRPM,
+		pattern,
+		channel,
+		model,
+	)
 }
 
 func GetChannelModelRequest(ctx context.Context, channel, model string) (int64, int64) {
@@ -341,3 +360,121 @@ func GetChannelModelTokensRequest(ctx context.Context, channel, model string) (i
 
 	return memoryChannelModelTokensRecord.GetRequest(time.Minute, channel, model)
 }
+
+var (
+	memoryGroupMCPToolLimiter = NewInMemoryRecord()
+	redisGroupMCPToolLimiter  = newRedisGroupMCPToolRecord()
+)
+
+// PushGroupMCPToolRequest records a call of the mcp tools matching the pattern by the group
+func PushGroupMCPToolRequest(
+	ctx context.Context,
+	group, pattern string,
+	overed int64,
+) (int64, int64, int64) {
+	if common.RedisEnabled {
+		count, overLimitCount, secondCount, err := redisGroupMCPToolLimiter.PushRequest(
+			ctx,
+			overed,
+			time.Minute,
+			1,
+			group,
+			pattern,
+		)
+		if err == nil {
+			return count, overLimitCount, secondCount
+		}
+
+		log.Error("redis push request error: " + err.Error())
+	}
+
+	return memoryGroupMCPToolLimiter.PushRequest(overed, time.Minute, 1, group, pattern)
+}
+
+var (
+	memoryGroupTokennameMCPToolLimiter = NewInMemoryRecord()
+	redisGroupTokennameMCPToolLimiter  = newRedisGroupTokennameMCPToolRecord()
+)
+
+// PushGroupTokennameMCPToolRequest records a call of the mcp tools matching the pattern by
+// the token of the group
+func PushGroupTokennameMCPToolRequest(
+	ctx context.Context,
+	group, tokenname, pattern string,
+	overed int64,
+) (int64, int64, int64) {
+	if common.RedisEnabled {
+		count, overLimitCount, secondCount, err := redisGroupTokennameMCPToolLimiter.PushRequest(
+			ctx,
+			overed,
+			time.Minute,
+			1,
+			group,
+			tokenname,
+			pattern,
+		)
+		if err == nil {
+			return count, overLimitCount, secondCount
+		}
+
+		log.Error("redis push request error: " + err.Error())
+	}
+
+	return memoryGroupTokennameMCPToolLimiter.PushRequest(
+		overed,
+		time.Minute,
+		1,
+		group,
+		tokenname,
+		pattern,
+	)
+}
+
+// ChannelModelRates is the normal requests and tokens of the last minute of a
+// channel, and of a model on the channel
+type ChannelModelRates struct {
+	RPM      int64
+	TPM      int64
+	ModelRPM int64
+	ModelTPM int64
+}
+
+// GetChannelsModelRates gets the rates of the channels, and of the model on
+// the channels, in one lookup
+func GetChannelsModelRates(
+	ctx context.Context,
+	model string,
+	channels []string,
+) map[string]ChannelModelRates {
+	if common.RedisEnabled {
+		rates, err := getRedisChannelsModelRates(ctx, model, channels)
+		if err == nil {
+			return rates
+		}
+
+		log.Error("redis get channels rates error: " + err.Error())
+	}
+
+	requests, modelRequests := memoryChannelModelRecord.getFirstKeyNormalRequests(
+		time.Minute,
+		channels,
+		model,
+	)
+	tokens, modelTokens := memoryChannelModelTokensRecord.getFirstKeyNormalRequests(
+		time.Minute,
+		channels,
+		model,
+	)
+
+	rates := make(map[string]ChannelModelRates, len(channels))
+	for _, channel := range channels {
+		rates[channel] = ChannelModelRates{
+			RPM:      requests[channel],
+			TPM:      tokens[channel],
+			ModelRPM: modelRequests[channel],
+			ModelTPM: modelTokens[channel],
+		}
+	}
+
+	return rates
+}
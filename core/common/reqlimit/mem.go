@@ -20,6 +20,8 @@ type entry struct {
 
 type InMemoryRecord struct {
 	entries sync.Map
+	// reserveMu serializes the reservations, they count over several entries
+	reserveMu sync.Mutex
 }
 
 func NewInMemoryRecord() *InMemoryRecord {
@@ -128,6 +130,116 @@ func (m *InMemoryRecord) GetRequest(
 	return totalCount, secondCount
 }
 
+// ReserveRequest records a request of the keys as a normal request only if
+// the normal requests of the entries matching the pattern are below
+// patternLimit and of the keys are below limit, zero is no limit, otherwise
+// it is recorded as an over limit request. The check and the record are
+// atomic among the reservations of the record
+func (m *InMemoryRecord) ReserveRequest(
+	duration time.Duration,
+	patternLimit, limit int64,
+	pattern []string,
+	keys ...string,
+) (totalCount, secondCount int64, ok bool) {
+	m.reserveMu.Lock()
+	defer m.reserveMu.Unlock()
+
+	now := time.Now()
+	windowStart := now.Unix()
+	cutoff := windowStart - int64(duration.Seconds())
+
+	ok = patternLimit <= 0 || m.getNormalRequest(cutoff, pattern) < patternLimit
+
+	e := m.getEntry(keys)
+
+	e.Lock()
+	defer e.Unlock()
+
+	e.lastAccess.Store(now)
+
+	normalCount, overCount := m.cleanupAndCount(e, cutoff)
+	ok = ok && (limit <= 0 || normalCount < limit)
+
+	wc, exists := e.windows[windowStart]
+	if !exists {
+		wc = &windowCounts{}
+		e.windows[windowStart] = wc
+	}
+
+	if ok {
+		wc.normal++
+	} else {
+		wc.over++
+	}
+
+	return normalCount + overCount + 1, wc.normal + wc.over, ok
+}
+
+func (m *InMemoryRecord) getNormalRequest(cutoff int64, pattern []string) (normalCount int64) {
+	m.entries.Range(func(key, value any) bool {
+		k, _ := key.(string)
+		if !matchKeys(pattern, parseKeys(k)) {
+			return true
+		}
+
+		e, _ := value.(*entry)
+		e.Lock()
+		normal, _ := m.cleanupAndCount(e, cutoff)
+		e.Unlock()
+
+		normalCount += normal
+
+		return true
+	})
+
+	return normalCount
+}
+
+// getFirstKeyNormalRequests gets the normal requests of the last duration of
+// the entries of two keys by their first key, and of the entries whose second
+// key is the given second key, in one pass over the entries
+func (m *InMemoryRecord) getFirstKeyNormalRequests(
+	duration time.Duration,
+	firsts []string,
+	second string,
+) (totals, matched map[string]int64) {
+	cutoff := time.Now().Unix() - int64(duration.Seconds())
+
+	totals = make(map[string]int64, len(firsts))
+	matched = make(map[string]int64, len(firsts))
+
+	for _, first := range firsts {
+		totals[first] = 0
+	}
+
+	m.entries.Range(func(key, value any) bool {
+		k, _ := key.(string)
+
+		keys := parseKeys(k)
+		if len(keys) != 2 {
+			return true
+		}
+
+		if _, ok := totals[keys[0]]; !ok {
+			return true
+		}
+
+		e, _ := value.(*entry)
+		e.Lock()
+		normal, _ := m.cleanupAndCount(e, cutoff)
+		e.Unlock()
+
+		totals[keys[0]] += normal
+		if keys[1] == second {
+			matched[keys[0]] += normal
+		}
+
+		return true
+	})
+
+	return totals, matched
+}
+
 func (m *InMemoryRecord) cleanupInactiveEntries(interval, maxInactivity time.Duration) {
 	ticker := time.NewTicker(interval)
 	defer ticker.Stop()
@@ -3,6 +3,7 @@ package reqlimit_test
 import (
 	"fmt"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
@@ -290,3 +291,109 @@ func BenchmarkGetRequest(b *testing.B) {
 		}
 	})
 }
+
+func TestReserveRequest(t *testing.T) {
+	rl := reqlimit.NewInMemoryRecord()
+
+	pattern := []string{"channel1", "*"}
+
+	for i := range 3 {
+		_, _, ok := rl.ReserveRequest(time.Minute, 3, 2, pattern, "channel1", "model1")
+		if ok != (i < 2) {
+			t.Errorf("Request %d: expected ok %v, got %v", i+1, i < 2, ok)
+		}
+	}
+
+	// the channel limit counts the requests of all models
+	if _, _, ok := rl.ReserveRequest(time.Minute, 3, 2, pattern, "channel1", "model2"); !ok {
+		t.Error("Expected the third request of the channel to be reserved")
+	}
+
+	totalCount, _, ok := rl.ReserveRequest(time.Minute, 3, 2, pattern, "channel1", "model2")
+	if ok {
+		t.Error("Expected the fourth request of the channel to be rejected")
+	}
+
+	if totalCount != 2 {
+		t.Errorf("Expected totalCount 2, got %d", totalCount)
+	}
+
+	// the rejected requests are recorded as over limit requests
+	totalCount, _ = rl.GetRequest(time.Minute, "channel1", "*")
+	if totalCount != 5 {
+		t.Errorf("Expected 5 recorded requests, got %d", totalCount)
+	}
+
+	// zero is no limit
+	for range 5 {
+		if _, _, ok := rl.ReserveRequest(time.Minute, 0, 0, []string{"channel2", "*"}, "channel2", "model1"); !ok {
+			t.Error("Expected the request without limits to be reserved")
+		}
+	}
+}
+
+func TestReserveRequestConcurrent(t *testing.T) {
+	rl := reqlimit.NewInMemoryRecord()
+
+	const limit = 10
+
+	var (
+		wg       sync.WaitGroup
+		reserved atomic.Int64
+	)
+
+	for i := range 100 {
+		wg.Go(func() {
+			model := fmt.Sprintf("model%d", i%3)
+
+			_, _, ok := rl.ReserveRequest(
+				time.Minute,
+				limit,
+				0,
+				[]string{"channel1", "*"},
+				"channel1",
+				model,
+			)
+			if ok {
+				reserved.Add(1)
+			}
+		})
+	}
+
+	wg.Wait()
+
+	if reserved.Load() != limit {
+		t.Errorf("Expected %d reserved requests, got %d", limit, reserved.Load())
+	}
+}
+
+func TestGetChannelsModelRates(t *testing.T) {
+	ctx := t.Context()
+	channel1, channel2, channel3 := t.Name()+"1", t.Name()+"2", t.Name()+"3"
+
+	reqlimit.ReserveChannelModelRequest(ctx, channel1, "model1", 0, 0)
+	reqlimit.ReserveChannelModelRequest(ctx, channel1, "model1", 0, 0)
+	reqlimit.ReserveChannelModelRequest(ctx, channel1, "model2", 0, 0)
+	reqlimit.ReserveChannelModelRequest(ctx, channel2, "model2", 0, 0)
+	reqlimit.PushChannelModelTokensRequest(ctx, channel1, "model1", 100)
+	reqlimit.PushChannelModelTokensRequest(ctx, channel1, "model2", 50)
+
+	// a rejected request doesn't count
+	if _, _, ok := reqlimit.ReserveChannelModelRequest(ctx, channel2, "model2", 1, 0); ok {
+		t.Error("Expected the request over the channel rpm to be rejected")
+	}
+
+	rates := reqlimit.GetChannelsModelRates(ctx, "model1", []string{channel1, channel2, channel3})
+
+	expected := map[string]reqlimit.ChannelModelRates{
+		channel1: {RPM: 3, TPM: 150, ModelRPM: 2, ModelTPM: 100},
+		channel2: {RPM: 1},
+		channel3: {},
+	}
+
+	for channel, want := range expected {
+		if got := rates[channel]; got != want {
+			t.Errorf("Channel %s: expected %+v, got %+v", channel, want, got)
+		}
+	}
+}
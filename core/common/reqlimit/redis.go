@@ -51,6 +51,18 @@ func newRedisChannelModelTokensRecord() *redisRateRecord {
 	}
 }
 
+func newRedisGroupMCPToolRecord() *redisRateRecord {
+	return &redisRateRecord{
+		prefix: "group-mcp-tool-record",
+	}
+}
+
+func newRedisGroupTokennameMCPToolRecord() *redisRateRecord {
+	return &redisRateRecord{
+		prefix: "group-tokenname-mcp-tool-record",
+	}
+}
+
 const pushRequestLuaScript = `
 local key = KEYS[1]
 local window_seconds = tonumber(ARGV[1])
@@ -139,9 +151,120 @@ end
 return string.format("%d:%d", total, current_second_count)
 `
 
+// reserveRequestLuaScript records a request of KEYS[2] as a normal request
+// only if the normal requests of the keys matching the KEYS[1] pattern and of
+// KEYS[2] are below their limits
+const reserveRequestLuaScript = `
+local pattern = KEYS[1]
+local key = KEYS[2]
+local window_seconds = tonumber(ARGV[1])
+local current_time = tonumber(ARGV[2])
+local pattern_limit = tonumber(ARGV[3])
+local limit = tonumber(ARGV[4])
+local cutoff_slice = current_time - window_seconds
+
+local function parse_count(value)
+    if not value then return 0, 0 end
+    local r, e = value:match("^(%d+):(%d+)$")
+    return tonumber(r) or 0, tonumber(e) or 0
+end
+
+local function count_key(k)
+    local normal = 0
+    local over = 0
+    local all_fields = redis.call('HGETALL', k)
+    for i = 1, #all_fields, 2 do
+        if tonumber(all_fields[i]) < cutoff_slice then
+            redis.call('HDEL', k, all_fields[i])
+        else
+            local c, oc = parse_count(all_fields[i+1])
+            normal = normal + c
+            over = over + oc
+        end
+    end
+    return normal, over
+end
+
+local ok = 1
+
+if pattern_limit > 0 then
+    local pattern_normal = 0
+    for _, k in ipairs(redis.call('KEYS', pattern)) do
+        local normal = count_key(k)
+        pattern_normal = pattern_normal + normal
+    end
+    if pattern_normal >= pattern_limit then
+        ok = 0
+    end
+end
+
+local normal, over = count_key(key)
+if limit > 0 and normal >= limit then
+    ok = 0
+end
+
+local current_c, current_oc = parse_count(redis.call('HGET', key, tostring(current_time)))
+if ok == 1 then
+    current_c = current_c + 1
+else
+    current_oc = current_oc + 1
+end
+redis.call('HSET', key, current_time, current_c .. ":" .. current_oc)
+redis.call('EXPIRE', key, window_seconds)
+
+return string.format("%d:%d:%d", ok, normal + over + 1, current_c + current_oc)
+`
+
+// getFirstKeyNormalCountLuaScript returns for each KEYS pattern, ending with
+// the "*" of the second key, the normal requests of the matching keys and of
+// the key with ARGV[1] as the second key
+const getFirstKeyNormalCountLuaScript = `
+local second = ARGV[1]
+local window_seconds = tonumber(ARGV[2])
+local current_time = tonumber(ARGV[3])
+local cutoff_slice = current_time - window_seconds
+
+local function parse_count(value)
+    if not value then return 0, 0 end
+    local r, e = value:match("^(%d+):(%d+)$")
+    return tonumber(r) or 0, tonumber(e) or 0
+end
+
+local result = {}
+
+for i, pattern in ipairs(KEYS) do
+    local second_key = string.sub(pattern, 1, -2) .. second
+    local total = 0
+    local matched = 0
+
+    for _, key in ipairs(redis.call('KEYS', pattern)) do
+        local count = 0
+        local all_fields = redis.call('HGETALL', key)
+        for j = 1, #all_fields, 2 do
+            if tonumber(all_fields[j]) >= cutoff_slice then
+                local c = parse_count(all_fields[j+1])
+                count = count + c
+            end
+        end
+
+        total = total + count
+        if key == second_key then
+            matched = count
+        end
+    end
+
+    result[2*i-1] = total
+    result[2*i] = matched
+end
+
+return result
+`
+
 var (
-	pushRequestScript     = redis.NewScript(pushRequestLuaScript)
-	getRequestCountScript = redis.NewScript(getRequestCountLuaScript)
+	pushRequestScript            = redis.NewScript(pushRequestLuaScript)
+	getRequestCountScript        = redis.NewScript(getRequestCountLuaScript)
+	reserveRequestScript         = redis.NewScript(reserveRequestLuaScript)
+	getFirstKeyNormalCountScript = redis.NewScript(getFirstKeyNormalCountLuaScript)
 )
 
 func (r *redisRateRecord) buildKey(keys ...string) string {
@@ -232,3 +355,85 @@ func (r *redisRateRecord) PushRequest(
 
 	return countInt, overLimitCountInt, secondCountInt, nil
 }
+
+func (r *redisRateRecord) ReserveRequest(
+	ctx context.Context,
+	duration time.Duration,
+	patternLimit, limit int64,
+	pattern []string,
+	keys ...string,
+) (totalCount, secondCount int64, ok bool, err error) {
+	result, err := reserveRequestScript.Run(
+		ctx,
+		common.RDB,
+		[]string{r.buildKey(pattern...), r.buildKey(keys...)},
+		duration.Seconds(),
+		time.Now().Unix(),
+		patternLimit,
+		limit,
+	).Text()
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	parts := strings.Split(result, ":")
+	if len(parts) != 3 {
+		return 0, 0, false, errors.New("invalid result")
+	}
+
+	totalCount, err = strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	secondCount, err = strconv.ParseInt(parts[2], 10, 64)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	return totalCount, secondCount, parts[0] == "1", nil
+}
+
+func getRedisChannelsModelRates(
+	ctx context.Context,
+	model string,
+	channels []string,
+) (map[string]ChannelModelRates, error) {
+	keys := make([]string, 0, len(channels)*2)
+	for _, channel := range channels {
+		keys = append(keys, redisChannelModelRecord.buildKey(channel, "*"))
+	}
+
+	for _, channel := range channels {
+		keys = append(keys, redisChannelModelTokensRecord.buildKey(channel, "*"))
+	}
+
+	counts, err := getFirstKeyNormalCountScript.Run(
+		ctx,
+		common.RDB,
+		keys,
+		model,
+		time.Minute.Seconds(),
+		time.Now().Unix(),
+	).Int64Slice()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(counts) != len(keys)*2 {
+		return nil, errors.New("invalid result")
+	}
+
+	rates := make(map[string]ChannelModelRates, len(channels))
+	for i, channel := range channels {
+		tokens := (len(channels) + i) * 2
+		rates[channel] = ChannelModelRates{
+			RPM:      counts[i*2],
+			ModelRPM: counts[i*2+1],
+			TPM:      counts[tokens],
+			ModelTPM: counts[tokens+1],
+		}
+	}
+
+	return rates, nil
+}
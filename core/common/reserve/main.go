@@ -0,0 +1,45 @@
+// Package reserve holds the amounts reserved by the requests in progress, so the
+// concurrent requests can not spend more than the remaining balance or quota
+package reserve
+
+import (
+	"context"
+	"time"
+
+	"github.com/labring/aiproxy/core/common"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	memoryReserver = NewInMemoryReservation()
+	redisReserver  = newRedisReservation()
+)
+
+// Reserve sets the amount reserved by the id under the key until the ttl,
+// and returns the total amount reserved under the key including it
+func Reserve(ctx context.Context, key, id string, amount float64, ttl time.Duration) float64 {
+	if common.RedisEnabled {
+		total, err := redisReserver.Reserve(ctx, key, id, amount, ttl)
+		if err == nil {
+			return total
+		}
+
+		log.Error("redis reserve error: " + err.Error())
+	}
+
+	return memoryReserver.Reserve(key, id, amount, ttl)
+}
+
+// Release releases the amount reserved by the id under the key
+func Release(ctx context.Context, key, id string) {
+	if common.RedisEnabled {
+		err := redisReserver.Release(ctx, key, id)
+		if err == nil {
+			return
+		}
+
+		log.Error("redis release reservation error: " + err.Error())
+	}
+
+	memoryReserver.Release(key, id)
+}
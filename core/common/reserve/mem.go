@@ -0,0 +1,112 @@
+package reserve
+
+import (
+	"sync"
+	"time"
+)
+
+type reservation struct {
+	amount    float64
+	expiresAt time.Time
+}
+
+type entry struct {
+	sync.Mutex
+	reservations map[string]reservation
+}
+
+type InMemoryReservation struct {
+	entries sync.Map
+}
+
+func NewInMemoryReservation() *InMemoryReservation {
+	m := &InMemoryReservation{}
+	go m.cleanupEmptyEntries(time.Minute)
+
+	return m
+}
+
+func (m *InMemoryReservation) getEntry(key string) *entry {
+	actual, _ := m.entries.LoadOrStore(key, &entry{
+		reservations: make(map[string]reservation),
+	})
+
+	e, _ := actual.(*entry)
+
+	return e
+}
+
+func (m *InMemoryReservation) Reserve(
+	key, id string,
+	amount float64,
+	ttl time.Duration,
+) float64 {
+	e := m.getEntry(key)
+
+	e.Lock()
+	defer e.Unlock()
+
+	now := time.Now()
+
+	if amount > 0 {
+		e.reservations[id] = reservation{
+			amount:    amount,
+			expiresAt: now.Add(ttl),
+		}
+	} else {
+		delete(e.reservations, id)
+	}
+
+	var total float64
+	for id, r := range e.reservations {
+		if !r.expiresAt.After(now) {
+			delete(e.reservations, id)
+			continue
+		}
+
+		total += r.amount
+	}
+
+	return total
+}
+
+func (m *InMemoryReservation) Release(key, id string) {
+	value, ok := m.entries.Load(key)
+	if !ok {
+		return
+	}
+
+	e, _ := value.(*entry)
+
+	e.Lock()
+	delete(e.reservations, id)
+	e.Unlock()
+}
+
+func (m *InMemoryReservation) cleanupEmptyEntries(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		now := time.Now()
+
+		m.entries.Range(func(key, value any) bool {
+			e, _ := value.(*entry)
+
+			e.Lock()
+			defer e.Unlock()
+
+			for id, r := range e.reservations {
+				if !r.expiresAt.After(now) {
+					delete(e.reservations, id)
+				}
+			}
+
+			if len(e.reservations) == 0 {
+				m.entries.CompareAndDelete(key, e)
+			}
+
+			return true
+		})
+	}
+}
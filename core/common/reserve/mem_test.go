@@ -0,0 +1,35 @@
+package reserve_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/common/reserve"
+)
+
+func TestInMemoryReservation(t *testing.T) {
+	m := reserve.NewInMemoryReservation()
+
+	if total := m.Reserve("group", "a", 1.5, time.Minute); total != 1.5 {
+		t.Fatalf("expected 1.5, got %v", total)
+	}
+
+	if total := m.Reserve("group", "b", 2, time.Minute); total != 3.5 {
+		t.Fatalf("expected 3.5, got %v", total)
+	}
+
+	// reserving again replaces the amount of the id
+	if total := m.Reserve("group", "a", 0.5, time.Minute); total != 2.5 {
+		t.Fatalf("expected 2.5, got %v", total)
+	}
+
+	m.Release("group", "b")
+
+	if total := m.Reserve("group", "c", 1, -time.Second); total != 0.5 {
+		t.Fatalf("expected expired reservation to be ignored, got %v", total)
+	}
+
+	if total := m.Reserve("other", "a", 1, time.Minute); total != 1 {
+		t.Fatalf("expected 1, got %v", total)
+	}
+}
@@ -0,0 +1,94 @@
+package reserve
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/labring/aiproxy/core/common"
+	"github.com/redis/go-redis/v9"
+)
+
+type redisReservation struct {
+	prefix string
+}
+
+func newRedisReservation() *redisReservation {
+	return &redisReservation{
+		prefix: "quota-reservation",
+	}
+}
+
+// the reservations of a key are the fields of a hash, the value of a field is
+// `amount:expires_at`, the expired fields are removed when the key is reserved
+const reserveLuaScript = `
+local key = KEYS[1]
+local id = ARGV[1]
+local amount = tonumber(ARGV[2])
+local expires_at = tonumber(ARGV[3])
+local current_time = tonumber(ARGV[4])
+local key_ttl = tonumber(ARGV[5])
+
+local total = 0
+
+local all_fields = redis.call('HGETALL', key)
+for i = 1, #all_fields, 2 do
+	local field = all_fields[i]
+	local a, e = all_fields[i+1]:match("^(.-):(%d+)$")
+	a = tonumber(a) or 0
+	e = tonumber(e) or 0
+	if e <= current_time then
+		redis.call('HDEL', key, field)
+	elseif field ~= id then
+		total = total + a
+	end
+end
+
+if amount > 0 then
+	redis.call('HSET', key, id, ARGV[2] .. ":" .. ARGV[3])
+	total = total + amount
+else
+	redis.call('HDEL', key, id)
+end
+
+if redis.call('PTTL', key) < key_ttl then
+	redis.call('PEXPIRE', key, key_ttl)
+end
+
+return tostring(total)
+`
+
+var reserveScript = redis.NewScript(reserveLuaScript)
+
+func (r *redisReservation) buildKey(key string) string {
+	return common.RedisKey(r.prefix + ":" + key)
+}
+
+func (r *redisReservation) Reserve(
+	ctx context.Context,
+	key, id string,
+	amount float64,
+	ttl time.Duration,
+) (float64, error) {
+	now := time.Now()
+
+	result, err := reserveScript.Run(
+		ctx,
+		common.RDB,
+		[]string{r.buildKey(key)},
+		id,
+		strconv.FormatFloat(amount, 'f', -1, 64),
+		now.Add(ttl).UnixMilli(),
+		now.UnixMilli(),
+		ttl.Milliseconds(),
+	).Text()
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseFloat(result, 64)
+}
+
+func (r *redisReservation) Release(ctx context.Context, key, id string) error {
+	return common.RDB.HDel(ctx, r.buildKey(key), id).Err()
+}
@@ -0,0 +1,728 @@
+package controller
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/bytedance/sonic/ast"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/common/filestorage"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	// BatchLease is how long a claimed batch stays with its worker without renewal
+	BatchLease         = time.Minute * 2
+	batchLeaseInterval = time.Second * 20
+	batchMaxErrors     = 100
+)
+
+type batchRunner struct {
+	batch      *model.Batch
+	mode       mode.Mode
+	controller RelayController
+	log        *log.Entry
+
+	mu    sync.RWMutex
+	group model.GroupCache
+	token model.TokenCache
+
+	cancelled atomic.Bool
+	// leaseLost is set when another worker has claimed the batch
+	leaseLost atomic.Bool
+	// ownerErr is set when the token or group can no longer be used
+	ownerErr  atomic.Value
+	completed atomic.Int64
+	failed    atomic.Int64
+}
+
+// batchRelayController is the relay controller of the mode with the batch discount applied
+func batchRelayController(m mode.Mode) RelayController {
+	rc := relayController(m)
+
+	getRequestPrice := rc.GetRequestPrice
+	rc.GetRequestPrice = func(c *gin.Context, mc model.ModelConfig) (model.Price, error) {
+		price, err := getRequestPrice(c, mc)
+		if err != nil {
+			return price, err
+		}
+
+		return price.WithBatchDiscount(config.GetBatchDiscount()), nil
+	}
+
+	return rc
+}
+
+// RunBatch processes a claimed batch until it is finished or ctx is done,
+// an interrupted batch is released and resumed later from its saved results
+func RunBatch(ctx context.Context, b *model.Batch) {
+	m, ok := batchEndpointModes[b.Endpoint]
+	if !ok {
+		failBatch(b, "invalid_endpoint", "unsupported endpoint: "+b.Endpoint)
+		return
+	}
+
+	r := &batchRunner{
+		batch:      b,
+		mode:       m,
+		controller: batchRelayController(m),
+		log:        log.WithField("batch_id", b.ID),
+	}
+
+	r.cancelled.Store(b.Status == model.BatchStatusCancelling)
+
+	if err := r.loadOwner(); err != nil {
+		failBatch(b, "invalid_token", err.Error())
+		return
+	}
+
+	// the run is stopped when the lease is lost, the batch is left to its new worker
+	ctx, stopRun := context.WithCancel(ctx)
+	defer stopRun()
+
+	stopRenew := r.renewLease(ctx, stopRun)
+	defer stopRenew()
+
+	if b.Status == model.BatchStatusValidating {
+		if !r.validate(ctx) {
+			return
+		}
+	}
+
+	status, interrupted := r.execute(ctx)
+	if r.leaseLost.Load() {
+		return
+	}
+
+	if interrupted {
+		if err := model.ReleaseBatchLease(b.ID, b.WorkerID); err != nil {
+			r.log.Errorf("release batch lease failed: %v", err)
+		}
+
+		return
+	}
+
+	r.finalize(ctx, status)
+}
+
+func (r *batchRunner) loadOwner() error {
+	token, err := model.GetTokenByID(r.batch.TokenID)
+	if err != nil {
+		return fmt.Errorf("get token failed: %w", err)
+	}
+
+	tokenCache, err := model.GetAndValidateTokenByKeyHash(token.KeyHash)
+	if err != nil {
+		return err
+	}
+
+	group, err := model.CacheGetGroup(r.batch.GroupID)
+	if err != nil {
+		return fmt.Errorf("get group failed: %w", err)
+	}
+
+	if group.Status != model.GroupStatusEnabled && group.Status != model.GroupStatusInternal {
+		return errors.New("group is disabled")
+	}
+
+	modelCaches := model.LoadModelCaches()
+
+	tokenCache.SetAvailableSets(group.GetAvailableSets())
+	tokenCache.SetModelsBySet(modelCaches.EnabledModelsBySet)
+
+	r.mu.Lock()
+	r.group = *group
+	r.token = *tokenCache
+	r.mu.Unlock()
+
+	return nil
+}
+
+func (r *batchRunner) owner() (model.GroupCache, model.TokenCache) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.group, r.token
+}
+
+// renewLease keeps the lease of the batch, refreshes the owner and watches for
+// cancellation, stopRun is called when the lease has been claimed by another worker
+func (r *batchRunner) renewLease(ctx context.Context, stopRun context.CancelFunc) (stop func()) {
+	ctx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(batchLeaseInterval)
+		defer ticker.Stop()
+
+		lastCompleted, lastFailed := r.completed.Load(), r.failed.Load()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			status, err := model.RenewBatchLease(r.batch.ID, r.batch.WorkerID, BatchLease)
+			if errors.Is(err, model.ErrBatchLeaseLost) {
+				r.log.Warn("batch lease lost, stop processing")
+				r.leaseLost.Store(true)
+				stopRun()
+
+				return
+			}
+
+			if err != nil {
+				r.log.Errorf("renew batch lease failed: %v", err)
+				continue
+			}
+
+			if status == model.BatchStatusCancelling {
+				r.cancelled.Store(true)
+			}
+
+			if err := r.loadOwner(); err != nil {
+				r.ownerErr.Store(err.Error())
+			}
+
+			// the unchanged counts are not written, an update that changes
+			// nothing is not counted as affected by mysql
+			completed, failed := r.completed.Load(), r.failed.Load()
+			if completed == lastCompleted && failed == lastFailed {
+				continue
+			}
+
+			if err := model.UpdateLeasedBatch(r.batch.ID, r.batch.WorkerID, map[string]any{
+				"completed": completed,
+				"failed":    failed,
+			}); err != nil {
+				r.log.Errorf("update batch request counts failed: %v", err)
+				continue
+			}
+
+			lastCompleted, lastFailed = completed, failed
+		}
+	}()
+
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+// rangeInput calls fn with every non-empty line of the input file,
+// the line number is zero based and counts the empty lines as well
+func (r *batchRunner) rangeInput(ctx context.Context, fn func(line int, data []byte) bool) error {
+	file, err := model.GetFile(r.batch.GroupID, r.batch.TokenID, r.batch.InputFileID)
+	if err != nil {
+		return fmt.Errorf("get input file failed: %w", err)
+	}
+
+	rc, err := filestorage.Default.Get(ctx, file.StorageKey)
+	if err != nil {
+		return fmt.Errorf("read input file failed: %w", err)
+	}
+	defer rc.Close()
+
+	reader := bufio.NewReader(rc)
+
+	for line := 0; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if err != nil && !errors.Is(err, io.EOF) {
+			return fmt.Errorf("read input file failed: %w", err)
+		}
+
+		data = bytes.TrimSpace(data)
+		if len(data) > 0 && !fn(line, data) {
+			return nil
+		}
+
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+	}
+}
+
+func parseBatchInput(data []byte, endpoint string) (*relaymodel.BatchInput, error) {
+	var input relaymodel.BatchInput
+	if err := sonic.Unmarshal(data, &input); err != nil {
+		return nil, fmt.Errorf("invalid json: %w", err)
+	}
+
+	if input.CustomID == "" {
+		return nil, errors.New("custom_id is required")
+	}
+
+	if input.Method != http.MethodPost {
+		return nil, errors.New("method must be POST")
+	}
+
+	if input.URL != endpoint {
+		return nil, fmt.Errorf("url must be the endpoint of the batch: %s", endpoint)
+	}
+
+	body, err := sonic.Get(input.Body)
+	if err != nil || body.TypeSafe() != ast.V_OBJECT {
+		return nil, errors.New("body must be a json object")
+	}
+
+	if stream, _ := body.Get("stream").Bool(); stream {
+		return nil, errors.New("stream is not supported in batch requests")
+	}
+
+	return &input, nil
+}
+
+func (r *batchRunner) validate(ctx context.Context) bool {
+	var (
+		total  int
+		errs   []*relaymodel.BatchErrorData
+		custom = make(map[string]struct{})
+	)
+
+	err := r.rangeInput(ctx, func(line int, data []byte) bool {
+		total++
+
+		lineNumber := line + 1
+
+		input, err := parseBatchInput(data, r.batch.Endpoint)
+		if err != nil {
+			errs = append(errs, &relaymodel.BatchErrorData{
+				Code:    "invalid_request",
+				Message: err.Error(),
+				Line:    &lineNumber,
+			})
+		} else if _, ok := custom[input.CustomID]; ok {
+			errs = append(errs, &relaymodel.BatchErrorData{
+				Code:    "duplicate_custom_id",
+				Message: "duplicate custom_id: " + input.CustomID,
+				Line:    &lineNumber,
+			})
+		} else {
+			custom[input.CustomID] = struct{}{}
+		}
+
+		return len(errs) < batchMaxErrors
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return false
+		}
+
+		failBatch(r.batch, "invalid_file", err.Error())
+
+		return false
+	}
+
+	if total == 0 {
+		failBatch(r.batch, "empty_file", "the input file has no requests")
+		return false
+	}
+
+	if len(errs) > 0 {
+		failBatchWithErrors(r.batch, errs)
+		return false
+	}
+
+	now := time.Now()
+
+	err = model.UpdateLeasedBatch(r.batch.ID, r.batch.WorkerID, map[string]any{
+		"status":         model.BatchStatusInProgress,
+		"total":          total,
+		"in_progress_at": now,
+	})
+	if err != nil {
+		r.log.Errorf("update batch status failed: %v", err)
+		return false
+	}
+
+	r.batch.Status = model.BatchStatusInProgress
+	r.batch.Total = total
+	r.batch.InProgressAt = now
+
+	return true
+}
+
+// execute sends the unfinished requests of the batch,
+// it returns the final status or interrupted when ctx is done
+func (r *batchRunner) execute(ctx context.Context) (status string, interrupted bool) {
+	finished, err := model.GetBatchOutputLines(r.batch.ID)
+	if err != nil {
+		r.log.Errorf("get batch outputs failed: %v", err)
+		return "", true
+	}
+
+	for _, success := range finished {
+		if success {
+			r.completed.Add(1)
+		} else {
+			r.failed.Add(1)
+		}
+	}
+
+	// the worker processing the batch stopped while finalizing
+	if r.batch.Status == model.BatchStatusFinalizing {
+		switch {
+		case !r.batch.CancellingAt.IsZero():
+			return model.BatchStatusCancelled, false
+		case r.batch.Completed+r.batch.Failed < r.batch.Total:
+			return model.BatchStatusExpired, false
+		default:
+			return model.BatchStatusCompleted, false
+		}
+	}
+
+	status = model.BatchStatusCompleted
+
+	concurrency := max(config.GetBatchConcurrency(), 1)
+	sem := make(chan struct{}, concurrency)
+
+	// in-flight requests are not cancelled on shutdown, the results are kept instead
+	reqCtx := context.WithoutCancel(ctx)
+
+	var wg sync.WaitGroup
+
+	err = r.rangeInput(ctx, func(line int, data []byte) bool {
+		if _, ok := finished[line]; ok {
+			return true
+		}
+
+		select {
+		case sem <- struct{}{}:
+		case <-ctx.Done():
+			interrupted = true
+			return false
+		}
+
+		switch {
+		case ctx.Err() != nil:
+			interrupted = true
+		case r.cancelled.Load():
+			status = model.BatchStatusCancelled
+		case r.ownerErr.Load() != nil:
+			status = model.BatchStatusFailed
+		case time.Now().After(r.batch.ExpiresAt):
+			status = model.BatchStatusExpired
+		default:
+			wg.Add(1)
+
+			go func() {
+				defer func() {
+					<-sem
+					wg.Done()
+				}()
+
+				r.runRequest(reqCtx, line, data)
+			}()
+
+			return true
+		}
+
+		<-sem
+
+		return false
+	})
+
+	wg.Wait()
+
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", true
+		}
+
+		r.log.Errorf("read batch input failed: %v", err)
+		r.ownerErr.Store(err.Error())
+
+		return model.BatchStatusFailed, false
+	}
+
+	return status, interrupted
+}
+
+func (r *batchRunner) runRequest(ctx context.Context, line int, data []byte) {
+	output := &relaymodel.BatchOutput{
+		ID: "batch_req_" + common.ShortUUID(),
+	}
+
+	success := false
+
+	input, err := parseBatchInput(data, r.batch.Endpoint)
+	if err != nil {
+		output.Error = &relaymodel.BatchOutputError{
+			Code:    "invalid_request",
+			Message: err.Error(),
+		}
+	} else {
+		output.CustomID = input.CustomID
+		output.Response = r.relay(ctx, input)
+		success = output.Response.StatusCode == http.StatusOK
+	}
+
+	content, err := sonic.MarshalString(output)
+	if err != nil {
+		r.log.Errorf("marshal batch output failed: %v", err)
+		return
+	}
+
+	err = model.SaveBatchOutput(&model.BatchOutput{
+		BatchID: r.batch.ID,
+		Line:    line,
+		Success: success,
+		Content: content,
+	})
+	if err != nil {
+		r.log.Errorf("save batch output failed: %v", err)
+		return
+	}
+
+	if success {
+		r.completed.Add(1)
+	} else {
+		r.failed.Add(1)
+	}
+}
+
+// relay runs a request of the batch through the same distribute and relay
+// path as a live request, without the group rpm and tpm limits
+func (r *batchRunner) relay(
+	ctx context.Context,
+	input *relaymodel.BatchInput,
+) *relaymodel.BatchOutputResponse {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		input.URL,
+		bytes.NewReader(input.Body),
+	)
+	if err != nil {
+		body, _ := relaymodel.WrapperOpenAIErrorWithMessage(
+			err.Error(),
+			"invalid_request",
+			http.StatusBadRequest,
+		).MarshalJSON()
+
+		return &relaymodel.BatchOutputResponse{
+			StatusCode: http.StatusBadRequest,
+			Body:       body,
+		}
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	c.Request = req
+
+	now := time.Now()
+	requestID := middleware.GenRequestID(now)
+
+	middleware.SetRequestAt(c, now)
+	middleware.SetRequestID(c, requestID)
+
+	group, token := r.owner()
+	c.Set(middleware.Group, group)
+	c.Set(middleware.Token, token)
+	c.Set(middleware.ModelCaches, model.LoadModelCaches())
+	c.Set(middleware.BatchID, r.batch.ID)
+
+	middleware.NewDistribute(r.mode)(c)
+
+	if !c.IsAborted() {
+		metadata := maps.Clone(middleware.GetRequestMetadata(c))
+		if metadata == nil {
+			metadata = make(map[string]string, 2)
+		}
+
+		metadata["batch_id"] = r.batch.ID
+		metadata["batch_custom_id"] = input.CustomID
+		c.Set(middleware.RequestMetadata, metadata)
+
+		relay(c, r.mode, r.controller)
+	}
+
+	body := w.Body.Bytes()
+	if !sonic.Valid(body) {
+		body, _ = sonic.Marshal(w.Body.String())
+	}
+
+	return &relaymodel.BatchOutputResponse{
+		StatusCode: w.Code,
+		RequestID:  requestID,
+		Body:       body,
+	}
+}
+
+func (r *batchRunner) finalize(ctx context.Context, status string) {
+	now := time.Now()
+
+	// the batch taken over by another worker is finalized by that worker
+	err := model.UpdateLeasedBatch(r.batch.ID, r.batch.WorkerID, map[string]any{
+		"status":        model.BatchStatusFinalizing,
+		"finalizing_at": now,
+		"completed":     r.completed.Load(),
+		"failed":        r.failed.Load(),
+	})
+	if err != nil {
+		r.log.Errorf("update batch status failed: %v", err)
+		return
+	}
+
+	outputFileID, err := r.writeOutputFile(ctx, true)
+	if err != nil {
+		r.log.Errorf("write batch output file failed: %v", err)
+		return
+	}
+
+	errorFileID, err := r.writeOutputFile(ctx, false)
+	if err != nil {
+		r.log.Errorf("write batch error file failed: %v", err)
+		return
+	}
+
+	values := map[string]any{
+		"status":         status,
+		"output_file_id": outputFileID,
+		"error_file_id":  errorFileID,
+		"lease_until":    time.Time{},
+	}
+
+	switch status {
+	case model.BatchStatusCancelled:
+		values["cancelled_at"] = time.Now()
+	case model.BatchStatusExpired:
+		values["expired_at"] = time.Now()
+	case model.BatchStatusFailed:
+		values["failed_at"] = time.Now()
+
+		if msg, ok := r.ownerErr.Load().(string); ok {
+			errs, _ := sonic.MarshalString([]*relaymodel.BatchErrorData{
+				{Code: "batch_failed", Message: msg},
+			})
+			values["errors"] = errs
+		}
+	default:
+		values["completed_at"] = time.Now()
+	}
+
+	if err := model.UpdateLeasedBatch(r.batch.ID, r.batch.WorkerID, values); err != nil {
+		r.log.Errorf("update batch status failed: %v", err)
+		return
+	}
+
+	if err := model.DeleteBatchOutputs(r.batch.ID); err != nil {
+		r.log.Errorf("delete batch outputs failed: %v", err)
+	}
+}
+
+// writeOutputFile writes the saved results to a file owned by the batch token,
+// it returns an empty id when there is no result to write
+func (r *batchRunner) writeOutputFile(ctx context.Context, success bool) (string, error) {
+	tmp, err := os.CreateTemp("", "aiproxy-batch-*.jsonl")
+	if err != nil {
+		return "", err
+	}
+
+	defer func() {
+		tmp.Close()
+		os.Remove(tmp.Name())
+	}()
+
+	writer := bufio.NewWriter(tmp)
+
+	count := 0
+
+	err = model.RangeBatchOutputs(r.batch.ID, success, func(o *model.BatchOutput) error {
+		count++
+
+		if _, err := writer.WriteString(o.Content); err != nil {
+			return err
+		}
+
+		return writer.WriteByte('\n')
+	})
+	if err != nil {
+		return "", err
+	}
+
+	if count == 0 {
+		return "", nil
+	}
+
+	if err := writer.Flush(); err != nil {
+		return "", err
+	}
+
+	if _, err := tmp.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	filename := r.batch.ID + "_output.jsonl"
+	if !success {
+		filename = r.batch.ID + "_error.jsonl"
+	}
+
+	file := &model.File{
+		ID:       model.NewFileID(),
+		GroupID:  r.batch.GroupID,
+		TokenID:  r.batch.TokenID,
+		Filename: filename,
+		Purpose:  batchOutputPurpose,
+		Status:   model.FileStatusProcessed,
+	}
+	file.StorageKey = model.FileStorageKey(file.GroupID, file.ID)
+
+	n, err := filestorage.Default.Put(ctx, file.StorageKey, tmp)
+	if err != nil {
+		return "", err
+	}
+
+	file.Bytes = n
+
+	if err := model.CreateFile(file); err != nil {
+		_ = filestorage.Default.Delete(ctx, file.StorageKey)
+		return "", err
+	}
+
+	return file.ID, nil
+}
+
+func failBatch(b *model.Batch, code, message string) {
+	failBatchWithErrors(b, []*relaymodel.BatchErrorData{
+		{Code: code, Message: message},
+	})
+}
+
+func failBatchWithErrors(b *model.Batch, errs []*relaymodel.BatchErrorData) {
+	errsJSON, _ := sonic.MarshalString(errs)
+
+	err := model.UpdateLeasedBatch(b.ID, b.WorkerID, map[string]any{
+		"status":      model.BatchStatusFailed,
+		"errors":      errsJSON,
+		"failed_at":   time.Now(),
+		"lease_until": time.Time{},
+	})
+	if err != nil {
+		log.Errorf("update batch %s status failed: %v", b.ID, err)
+	}
+}
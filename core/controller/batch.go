@@ -0,0 +1,330 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/common/filestorage"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+	"gorm.io/gorm"
+)
+
+const (
+	batchCompletionWindow = "24h"
+	batchExpires          = time.Hour * 24
+	batchFilePurpose      = "batch"
+	batchOutputPurpose    = "batch_output"
+)
+
+// endpoints that can be used by the requests of a batch
+var batchEndpointModes = map[string]mode.Mode{
+	"/v1/chat/completions": mode.ChatCompletions,
+	"/v1/completions":      mode.Completions,
+	"/v1/embeddings":       mode.Embeddings,
+	"/v1/responses":        mode.Responses,
+}
+
+func toBatchObject(b *model.Batch) *relaymodel.Batch {
+	unix := func(t time.Time) *int64 {
+		if t.IsZero() {
+			return nil
+		}
+
+		v := t.Unix()
+
+		return &v
+	}
+
+	fileID := func(id string) *string {
+		if id == "" {
+			return nil
+		}
+
+		return &id
+	}
+
+	batch := &relaymodel.Batch{
+		ID:               b.ID,
+		Object:           "batch",
+		Endpoint:         b.Endpoint,
+		InputFileID:      b.InputFileID,
+		CompletionWindow: b.CompletionWindow,
+		Status:           b.Status,
+		OutputFileID:     fileID(b.OutputFileID),
+		ErrorFileID:      fileID(b.ErrorFileID),
+		CreatedAt:        b.CreatedAt.Unix(),
+		InProgressAt:     unix(b.InProgressAt),
+		ExpiresAt:        unix(b.ExpiresAt),
+		FinalizingAt:     unix(b.FinalizingAt),
+		CompletedAt:      unix(b.CompletedAt),
+		FailedAt:         unix(b.FailedAt),
+		ExpiredAt:        unix(b.ExpiredAt),
+		CancellingAt:     unix(b.CancellingAt),
+		CancelledAt:      unix(b.CancelledAt),
+		RequestCounts: relaymodel.BatchRequestCounts{
+			Total:     b.Total,
+			Completed: b.Completed,
+			Failed:    b.Failed,
+		},
+		Metadata: b.Metadata,
+	}
+
+	if b.Errors != "" {
+		var data []*relaymodel.BatchErrorData
+		if err := sonic.UnmarshalString(b.Errors, &data); err == nil {
+			batch.Errors = &relaymodel.BatchErrors{
+				Object: "list",
+				Data:   data,
+			}
+		}
+	}
+
+	return batch
+}
+
+func abortBatchError(c *gin.Context, err error, id string) {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		middleware.AbortLogWithMessage(c, http.StatusNotFound, "No such Batch: "+id)
+		return
+	}
+
+	middleware.AbortLogWithMessage(c, http.StatusInternalServerError, err.Error())
+}
+
+// CreateBatch godoc
+//
+//	@Summary		CreateBatch
+//	@Description	Create a batch from an uploaded jsonl file of requests, the requests
+//	@Description	are executed offline and billed with the batch discount
+//	@Tags			relay
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			request	body		model.BatchRequest	true	"Request"
+//	@Success		200		{object}	model.Batch
+//	@Router			/v1/batches [post]
+func CreateBatch(c *gin.Context) {
+	token := middleware.GetToken(c)
+	if token.ID == 0 {
+		middleware.AbortLogWithMessage(
+			c,
+			http.StatusBadRequest,
+			"batches can only be created with a group token",
+		)
+
+		return
+	}
+
+	if !filestorage.Enabled() {
+		middleware.AbortLogWithMessage(
+			c,
+			http.StatusServiceUnavailable,
+			"file storage is not enabled",
+		)
+
+		return
+	}
+
+	var req relaymodel.BatchRequest
+	if err := common.UnmarshalRequestReusable(c.Request, &req); err != nil {
+		middleware.AbortLogWithMessage(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	endpointMode, ok := batchEndpointModes[req.Endpoint]
+	if !ok {
+		endpoints := make([]string, 0, len(batchEndpointModes))
+		for endpoint := range batchEndpointModes {
+			endpoints = append(endpoints, endpoint)
+		}
+
+		slices.Sort(endpoints)
+
+		middleware.AbortLogWithMessage(
+			c,
+			http.StatusBadRequest,
+			"unsupported endpoint: "+req.Endpoint+
+				", supported endpoints: "+strings.Join(endpoints, ", "),
+		)
+
+		return
+	}
+
+	// the requests of the batch are relayed with the token, fail early
+	// instead of failing every request of the batch
+	if !token.AllowsMode(endpointMode) {
+		middleware.AbortLogWithMessage(
+			c,
+			http.StatusForbidden,
+			fmt.Sprintf(
+				"token (%s[%d]) is not allowed to call the %s endpoint",
+				token.Name,
+				token.ID,
+				endpointMode,
+			),
+		)
+
+		return
+	}
+
+	if req.CompletionWindow != batchCompletionWindow {
+		middleware.AbortLogWithMessage(
+			c,
+			http.StatusBadRequest,
+			"completion_window must be "+batchCompletionWindow,
+		)
+
+		return
+	}
+
+	group := middleware.GetGroup(c)
+
+	file, err := model.GetFile(group.ID, token.ID, req.InputFileID)
+	if err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			middleware.AbortLogWithMessage(
+				c,
+				http.StatusBadRequest,
+				"No such File object: "+req.InputFileID,
+			)
+		} else {
+			middleware.AbortLogWithMessage(c, http.StatusInternalServerError, err.Error())
+		}
+
+		return
+	}
+
+	if file.Purpose != batchFilePurpose {
+		middleware.AbortLogWithMessage(
+			c,
+			http.StatusBadRequest,
+			"the purpose of the input file must be "+batchFilePurpose,
+		)
+
+		return
+	}
+
+	batch := &model.Batch{
+		ID:               model.NewBatchID(),
+		GroupID:          group.ID,
+		TokenID:          token.ID,
+		Endpoint:         req.Endpoint,
+		InputFileID:      req.InputFileID,
+		CompletionWindow: req.CompletionWindow,
+		Status:           model.BatchStatusValidating,
+		Metadata:         req.Metadata,
+		ExpiresAt:        time.Now().Add(batchExpires),
+	}
+
+	if err := model.CreateBatch(batch); err != nil {
+		middleware.AbortLogWithMessage(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, toBatchObject(batch))
+}
+
+// GetBatch godoc
+//
+//	@Summary		GetBatch
+//	@Description	GetBatch
+//	@Tags			relay
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string	true	"Batch ID"
+//	@Success		200	{object}	model.Batch
+//	@Router			/v1/batches/{id} [get]
+func GetBatch(c *gin.Context) {
+	group := middleware.GetGroup(c)
+	token := middleware.GetToken(c)
+	id := c.Param("id")
+
+	batch, err := model.GetBatch(group.ID, token.ID, id)
+	if err != nil {
+		abortBatchError(c, err, id)
+		return
+	}
+
+	c.JSON(http.StatusOK, toBatchObject(batch))
+}
+
+// ListBatches godoc
+//
+//	@Summary		ListBatches
+//	@Description	ListBatches
+//	@Tags			relay
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			after	query		string	false	"After batch id"
+//	@Param			limit	query		integer	false	"Limit"
+//	@Success		200		{object}	model.BatchList
+//	@Router			/v1/batches [get]
+func ListBatches(c *gin.Context) {
+	group := middleware.GetGroup(c)
+	token := middleware.GetToken(c)
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 100 {
+		limit = 20
+	}
+
+	after := c.Query("after")
+
+	batches, hasMore, err := model.ListBatches(group.ID, token.ID, after, limit)
+	if err != nil {
+		abortBatchError(c, err, after)
+		return
+	}
+
+	list := relaymodel.BatchList{
+		Object:  "list",
+		Data:    make([]*relaymodel.Batch, 0, len(batches)),
+		HasMore: hasMore,
+	}
+	for _, b := range batches {
+		list.Data = append(list.Data, toBatchObject(b))
+	}
+
+	if len(list.Data) > 0 {
+		list.FirstID = list.Data[0].ID
+		list.LastID = list.Data[len(list.Data)-1].ID
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+// CancelBatch godoc
+//
+//	@Summary		CancelBatch
+//	@Description	Cancel an in progress batch, the finished requests are still
+//	@Description	written to the output file
+//	@Tags			relay
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string	true	"Batch ID"
+//	@Success		200	{object}	model.Batch
+//	@Router			/v1/batches/{id}/cancel [post]
+func CancelBatch(c *gin.Context) {
+	group := middleware.GetGroup(c)
+	token := middleware.GetToken(c)
+	id := c.Param("id")
+
+	batch, err := model.CancelBatch(group.ID, token.ID, id)
+	if err != nil {
+		abortBatchError(c, err, id)
+		return
+	}
+
+	c.JSON(http.StatusOK, toBatchObject(batch))
+}
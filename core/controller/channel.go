@@ -214,16 +214,26 @@ func GetChannel(c *gin.Context) {
 
 // AddChannelRequest represents the request body for adding a channel
 type AddChannelRequest struct {
-	ModelMapping map[string]string    `json:"model_mapping"`
-	Configs      model.ChannelConfigs `json:"configs"`
-	Name         string               `json:"name"`
-	Key          string               `json:"key"`
-	BaseURL      string               `json:"base_url"`
-	Models       []string             `json:"models"`
-	Type         model.ChannelType    `json:"type"`
-	Priority     int32                `json:"priority"`
-	Status       int                  `json:"status"`
-	Sets         []string             `json:"sets"`
+	ModelMapping   map[string]string    `json:"model_mapping"`
+	Configs        model.ChannelConfigs `json:"configs"`
+	Name           string               `json:"name"`
+	Key            string               `json:"key"`
+	BaseURL        string               `json:"base_url"`
+	Models         []string             `json:"models"`
+	Type           model.ChannelType    `json:"type"`
+	Priority       int32                `json:"priority"`
+	Cost           float64              `json:"cost"`
+	MaxConcurrency int64                `json:"max_concurrency"`
+	// map[model]max_concurrency
+	ModelMaxConcurrency map[string]int64 `json:"model_max_concurrency"`
+	RPM                 int64            `json:"rpm"`
+	TPM                 int64            `json:"tpm"`
+	// map[model]rpm
+	ModelRPM map[string]int64 `json:"model_rpm"`
+	// map[model]tpm
+	ModelTPM map[string]int64 `json:"model_tpm"`
+	Status   int              `json:"status"`
+	Sets     []string         `json:"sets"`
 }
 
 func (r *AddChannelRequest) ToChannel() (*model.Channel, error) {
@@ -267,16 +277,23 @@ func (r *AddChannelRequest) ToChannel() (*model.Channel, error) {
 	}
 
 	return &model.Channel{
-		Type:         r.Type,
-		Name:         r.Name,
-		Key:          r.Key,
-		BaseURL:      r.BaseURL,
-		Models:       slices.Clone(r.Models),
-		ModelMapping: maps.Clone(r.ModelMapping),
-		Priority:     r.Priority,
-		Status:       r.Status,
-		Configs:      r.Configs,
-		Sets:         slices.Clone(r.Sets),
+		Type:                r.Type,
+		Name:                r.Name,
+		Key:                 r.Key,
+		BaseURL:             r.BaseURL,
+		Models:              slices.Clone(r.Models),
+		ModelMapping:        maps.Clone(r.ModelMapping),
+		Priority:            r.Priority,
+		Cost:                r.Cost,
+		MaxConcurrency:      r.MaxConcurrency,
+		ModelMaxConcurrency: maps.Clone(r.ModelMaxConcurrency),
+		RPM:                 r.RPM,
+		TPM:                 r.TPM,
+		ModelRPM:            maps.Clone(r.ModelRPM),
+		ModelTPM:            maps.Clone(r.ModelTPM),
+		Status:              r.Status,
+		Configs:             r.Configs,
+		Sets:                slices.Clone(r.Sets),
 	}, nil
 }
 
@@ -0,0 +1,16 @@
+package controller
+
+type ChannelStrategy = channelStrategy
+
+var (
+	NewChannelStrategy     = newChannelStrategy
+	PickChannel            = (*channelStrategy).pick
+	MinChannels            = minChannels
+	PickByRoundRobin       = pickByRoundRobin
+	GetChannelLoads        = getChannelLoads
+	FilterChannels         = filterChannels
+	GetFallbackModelConfig = getFallbackModelConfig
+	SamplingModel          = samplingModel
+)
+
+type MonitorCollector = monitorCollector
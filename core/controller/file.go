@@ -0,0 +1,374 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/common/filestorage"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+	"gorm.io/gorm"
+)
+
+// FilesUpload godoc
+//
+//	@Summary		FilesUpload
+//	@Description	Upload a file, the file is stored by the proxy unless a model is given,
+//	@Description	in which case it is uploaded to a channel of that model
+//	@Tags			relay
+//	@Accept			multipart/form-data
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			file					formData	file	true	"File"
+//	@Param			purpose					formData	string	true	"Purpose"
+//	@Param			model					formData	string	false	"Upload to a channel of the model"
+//	@Param			expires_after[seconds]	formData	integer	false	"Expires after seconds"
+//	@Param			Aiproxy-Channel			header		string	false	"Optional Aiproxy-Channel header"
+//	@Success		200						{object}	model.File
+//	@Router			/v1/files [post]
+func FilesUpload() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.NewFilesDistribute(mode.FilesUpload),
+		NewFilesRelay(mode.FilesUpload, localFilesUpload),
+	}
+}
+
+// FilesList godoc
+//
+//	@Summary		FilesList
+//	@Description	List the files stored by the proxy
+//	@Tags			relay
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			purpose	query		string	false	"Purpose"
+//	@Param			limit	query		integer	false	"Limit"
+//	@Param			order	query		string	false	"Order, asc or desc"
+//	@Param			after	query		string	false	"After file id"
+//	@Success		200		{object}	model.FileList
+//	@Router			/v1/files [get]
+func FilesList() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.NewFilesDistribute(mode.FilesList),
+		NewFilesRelay(mode.FilesList, localFilesList),
+	}
+}
+
+// FilesGet godoc
+//
+//	@Summary		FilesGet
+//	@Description	FilesGet
+//	@Tags			relay
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string	true	"File ID"
+//	@Success		200	{object}	model.File
+//	@Router			/v1/files/{id} [get]
+func FilesGet() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.NewFilesDistribute(mode.FilesGet),
+		NewFilesRelay(mode.FilesGet, localFilesGet),
+	}
+}
+
+// FilesDelete godoc
+//
+//	@Summary		FilesDelete
+//	@Description	FilesDelete
+//	@Tags			relay
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		string	true	"File ID"
+//	@Success		200	{object}	model.FileDeleted
+//	@Router			/v1/files/{id} [delete]
+func FilesDelete() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.NewFilesDistribute(mode.FilesDelete),
+		NewFilesRelay(mode.FilesDelete, localFilesDelete),
+	}
+}
+
+// FilesContent godoc
+//
+//	@Summary		FilesContent
+//	@Description	FilesContent
+//	@Tags			relay
+//	@Produce		octet-stream
+//	@Security		ApiKeyAuth
+//	@Param			id	path	string	true	"File ID"
+//	@Success		200	{file}	file
+//	@Router			/v1/files/{id}/content [get]
+func FilesContent() []gin.HandlerFunc {
+	return []gin.HandlerFunc{
+		middleware.NewFilesDistribute(mode.FilesContent),
+		NewFilesRelay(mode.FilesContent, localFilesContent),
+	}
+}
+
+// NewFilesRelay serves the request from the local file storage when the
+// distributor marked it as local, otherwise relays it to the pinned channel
+func NewFilesRelay(m mode.Mode, local func(c *gin.Context)) func(c *gin.Context) {
+	relay := NewRelay(m)
+
+	return func(c *gin.Context) {
+		if !middleware.IsLocalFileRequest(c) {
+			relay(c)
+			return
+		}
+
+		if !filestorage.Enabled() {
+			middleware.AbortLogWithMessageWithMode(m, c,
+				http.StatusServiceUnavailable,
+				"file storage is not enabled",
+			)
+
+			return
+		}
+
+		local(c)
+	}
+}
+
+func toFileObject(f *model.File) *relaymodel.File {
+	file := &relaymodel.File{
+		ID:        f.ID,
+		Object:    "file",
+		Bytes:     f.Bytes,
+		CreatedAt: f.CreatedAt.Unix(),
+		Filename:  f.Filename,
+		Purpose:   f.Purpose,
+		Status:    f.Status,
+	}
+	if !f.ExpiresAt.IsZero() {
+		expiresAt := f.ExpiresAt.Unix()
+		file.ExpiresAt = &expiresAt
+	}
+
+	return file
+}
+
+func localFilesUpload(c *gin.Context) {
+	if _, err := c.MultipartForm(); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			middleware.AbortLogWithMessageWithMode(mode.FilesUpload, c,
+				http.StatusRequestEntityTooLarge,
+				fmt.Sprintf("file size exceeds the limit of %d bytes", config.FileMaxSize),
+			)
+
+			return
+		}
+
+		middleware.AbortLogWithMessageWithMode(mode.FilesUpload, c,
+			http.StatusBadRequest,
+			"invalid multipart form: "+err.Error(),
+		)
+
+		return
+	}
+
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		middleware.AbortLogWithMessageWithMode(mode.FilesUpload, c,
+			http.StatusBadRequest,
+			"purpose is required",
+		)
+
+		return
+	}
+
+	fileHeader, err := c.FormFile("file")
+	if err != nil {
+		middleware.AbortLogWithMessageWithMode(mode.FilesUpload, c,
+			http.StatusBadRequest,
+			"file is required: "+err.Error(),
+		)
+
+		return
+	}
+
+	if config.FileMaxSize > 0 && fileHeader.Size > config.FileMaxSize {
+		middleware.AbortLogWithMessageWithMode(mode.FilesUpload, c,
+			http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("file size exceeds the limit of %d bytes", config.FileMaxSize),
+		)
+
+		return
+	}
+
+	var expiresAt time.Time
+	if seconds := c.PostForm("expires_after[seconds]"); seconds != "" {
+		s, err := strconv.ParseInt(seconds, 10, 64)
+		if err != nil || s <= 0 {
+			middleware.AbortLogWithMessageWithMode(mode.FilesUpload, c,
+				http.StatusBadRequest,
+				"invalid expires_after[seconds]",
+			)
+
+			return
+		}
+
+		expiresAt = time.Now().Add(time.Duration(s) * time.Second)
+	}
+
+	group := middleware.GetGroup(c)
+	token := middleware.GetToken(c)
+
+	file := &model.File{
+		ID:        model.NewFileID(),
+		GroupID:   group.ID,
+		TokenID:   token.ID,
+		Filename:  fileHeader.Filename,
+		Purpose:   purpose,
+		ExpiresAt: expiresAt,
+		Status:    model.FileStatusProcessed,
+	}
+	file.StorageKey = model.FileStorageKey(group.ID, file.ID)
+
+	src, err := fileHeader.Open()
+	if err != nil {
+		middleware.AbortLogWithMessageWithMode(mode.FilesUpload, c,
+			http.StatusBadRequest,
+			"open file failed: "+err.Error(),
+		)
+
+		return
+	}
+	defer src.Close()
+
+	n, err := filestorage.Default.Put(c.Request.Context(), file.StorageKey, src)
+	if err != nil {
+		middleware.AbortLogWithMessageWithMode(mode.FilesUpload, c,
+			http.StatusInternalServerError,
+			"store file failed: "+err.Error(),
+		)
+
+		return
+	}
+
+	file.Bytes = n
+
+	if err := model.CreateFile(file); err != nil {
+		_ = filestorage.Default.Delete(c.Request.Context(), file.StorageKey)
+
+		middleware.AbortLogWithMessageWithMode(mode.FilesUpload, c,
+			http.StatusInternalServerError,
+			"create file failed: "+err.Error(),
+		)
+
+		return
+	}
+
+	c.JSON(http.StatusOK, toFileObject(file))
+}
+
+func localFilesList(c *gin.Context) {
+	group := middleware.GetGroup(c)
+	token := middleware.GetToken(c)
+
+	limit, _ := strconv.Atoi(c.Query("limit"))
+	if limit <= 0 || limit > 10000 {
+		limit = 10000
+	}
+
+	files, hasMore, err := model.ListFiles(
+		group.ID,
+		token.ID,
+		c.Query("purpose"),
+		c.Query("after"),
+		limit,
+		c.Query("order") == "asc",
+	)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			status = http.StatusBadRequest
+		}
+
+		middleware.AbortLogWithMessageWithMode(mode.FilesList, c, status, err.Error())
+
+		return
+	}
+
+	list := relaymodel.FileList{
+		Object:  "list",
+		Data:    make([]*relaymodel.File, 0, len(files)),
+		HasMore: hasMore,
+	}
+	for _, f := range files {
+		list.Data = append(list.Data, toFileObject(f))
+	}
+
+	if len(list.Data) > 0 {
+		list.FirstID = list.Data[0].ID
+		list.LastID = list.Data[len(list.Data)-1].ID
+	}
+
+	c.JSON(http.StatusOK, list)
+}
+
+func localFilesGet(c *gin.Context) {
+	c.JSON(http.StatusOK, toFileObject(middleware.GetLocalFile(c)))
+}
+
+func localFilesDelete(c *gin.Context) {
+	file := middleware.GetLocalFile(c)
+
+	if err := model.DeleteFile(file.GroupID, file.TokenID, file.ID); err != nil {
+		middleware.AbortLogWithMessageWithMode(mode.FilesDelete, c,
+			http.StatusInternalServerError,
+			"delete file failed: "+err.Error(),
+		)
+
+		return
+	}
+
+	if err := filestorage.Default.Delete(c.Request.Context(), file.StorageKey); err != nil {
+		common.GetLogger(c).Errorf("delete file %s content failed: %v", file.ID, err)
+	}
+
+	c.JSON(http.StatusOK, relaymodel.FileDeleted{
+		ID:      file.ID,
+		Object:  "file",
+		Deleted: true,
+	})
+}
+
+func localFilesContent(c *gin.Context) {
+	file := middleware.GetLocalFile(c)
+
+	rc, err := filestorage.Default.Get(c.Request.Context(), file.StorageKey)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, filestorage.ErrNotFound) {
+			status = http.StatusNotFound
+		}
+
+		middleware.AbortLogWithMessageWithMode(mode.FilesContent, c,
+			status,
+			"get file content failed: "+err.Error(),
+		)
+
+		return
+	}
+	defer rc.Close()
+
+	c.Header("Content-Type", "application/octet-stream")
+	c.Header("Content-Disposition", mime.FormatMediaType(
+		"attachment",
+		map[string]string{"filename": file.Filename},
+	))
+	c.Header("Content-Length", strconv.FormatInt(file.Bytes, 10))
+	c.Status(http.StatusOK)
+
+	_, _ = io.Copy(c.Writer, rc)
+}
@@ -0,0 +1,75 @@
+package controller_test
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/common/filestorage"
+	"github.com/labring/aiproxy/core/controller"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// countingReader counts the bytes read from the body
+type countingReader struct {
+	io.Reader
+	n int
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += n
+
+	return n, err
+}
+
+func TestFilesUploadTooLarge(t *testing.T) {
+	require.NoError(t, filestorage.Init(filestorage.TypeLocal, t.TempDir()))
+	t.Cleanup(func() {
+		filestorage.Default = nil
+	})
+
+	maxSize := config.FileMaxSize
+	config.FileMaxSize = 1024
+	t.Cleanup(func() {
+		config.FileMaxSize = maxSize
+	})
+
+	var body bytes.Buffer
+
+	form := multipart.NewWriter(&body)
+	require.NoError(t, form.WriteField("purpose", "batch"))
+
+	file, err := form.CreateFormFile("file", "input.jsonl")
+	require.NoError(t, err)
+
+	_, err = file.Write(bytes.Repeat([]byte("x"), 8<<20))
+	require.NoError(t, err)
+	require.NoError(t, form.Close())
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	reader := &countingReader{Reader: &body}
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/files", reader)
+	c.Request.Header.Set("Content-Type", form.FormDataContentType())
+	c.Set(middleware.Group, model.GroupCache{ID: "g1"})
+	c.Set(middleware.Token, model.TokenCache{ID: 1})
+
+	for _, handler := range controller.FilesUpload() {
+		if handler(c); c.IsAborted() || c.Writer.Written() {
+			break
+		}
+	}
+
+	assert.Equal(t, http.StatusRequestEntityTooLarge, w.Code)
+	// the upload is rejected without reading the whole body
+	assert.Less(t, reader.n, 2<<20)
+}
@@ -356,6 +356,13 @@ type CreateGroupRequest struct {
 
 	BalanceAlertEnabled   bool    `json:"balance_alert_enabled"`
 	BalanceAlertThreshold float64 `json:"balance_alert_threshold"`
+
+	QueueMaxWait  int64 `json:"queue_max_wait"`
+	QueueMaxDepth int64 `json:"queue_max_depth"`
+
+	MCPAllowTools []string         `json:"mcp_allow_tools"`
+	MCPDenyTools  []string         `json:"mcp_deny_tools"`
+	MCPToolsRPM   map[string]int64 `json:"mcp_tools_rpm"`
 }
 
 func (r *CreateGroupRequest) ToGroup() *model.Group {
@@ -366,6 +373,13 @@ func (r *CreateGroupRequest) ToGroup() *model.Group {
 
 		BalanceAlertEnabled:   r.BalanceAlertEnabled,
 		BalanceAlertThreshold: r.BalanceAlertThreshold,
+
+		QueueMaxWait:  r.QueueMaxWait,
+		QueueMaxDepth: r.QueueMaxDepth,
+
+		MCPAllowTools: r.MCPAllowTools,
+		MCPDenyTools:  r.MCPDenyTools,
+		MCPToolsRPM:   r.MCPToolsRPM,
 	}
 }
 
@@ -463,6 +477,12 @@ type SaveGroupModelConfigRequest struct {
 
 	OverrideForceSaveDetail bool `json:"override_force_save_detail"`
 	ForceSaveDetail         bool `json:"force_save_detail"`
+
+	OverrideFallbackModel bool   `json:"override_fallback_model"`
+	FallbackModel         string `json:"fallback_model"`
+
+	OverridePlugin bool                      `json:"override_plugin"`
+	Plugin         map[string]map[string]any `json:"plugin"`
 }
 
 func (r *SaveGroupModelConfigRequest) ToGroupModelConfig(groupID string) model.GroupModelConfig {
@@ -483,6 +503,12 @@ func (r *SaveGroupModelConfigRequest) ToGroupModelConfig(groupID string) model.G
 
 		OverrideForceSaveDetail: r.OverrideForceSaveDetail,
 		ForceSaveDetail:         r.ForceSaveDetail,
+
+		OverrideFallbackModel: r.OverrideFallbackModel,
+		FallbackModel:         r.FallbackModel,
+
+		OverridePlugin: r.OverridePlugin,
+		Plugin:         r.Plugin,
 	}
 }
 
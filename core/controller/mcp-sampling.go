@@ -0,0 +1,350 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ErrNoSamplingModel is returned when the model preferences of a sampling
+// request match no model of the token and there is no default sampling model
+var ErrNoSamplingModel = errors.New("no model is available for sampling")
+
+// RelayMCPSampling answers a sampling/createMessage request of the mcp with
+// the chat completions of the token, the usage is billed to the group like the
+// requests of the token and logged with the mcp id in the metadata
+func RelayMCPSampling(
+	ctx context.Context,
+	group model.GroupCache,
+	token model.TokenCache,
+	mcpID string,
+	params mcp.CreateMessageParams,
+) (*mcp.CreateMessageResult, error) {
+	modelCaches := model.LoadModelCaches()
+
+	token.SetAvailableSets(group.GetAvailableSets())
+	token.SetModelsBySet(modelCaches.EnabledModelsBySet)
+
+	modelName := samplingModel(&token, modelCaches, params.ModelPreferences)
+	if modelName == "" {
+		return nil, ErrNoSamplingModel
+	}
+
+	body, err := samplingRequestBody(modelName, params)
+	if err != nil {
+		return nil, err
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		"/v1/chat/completions",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	c.Request = req
+
+	now := time.Now()
+	middleware.SetRequestAt(c, now)
+	middleware.SetRequestID(c, middleware.GenRequestID(now))
+
+	c.Set(middleware.Group, group)
+	c.Set(middleware.Token, token)
+	c.Set(middleware.ModelCaches, modelCaches)
+
+	middleware.NewDistribute(mode.ChatCompletions)(c)
+
+	if !c.IsAborted() {
+		metadata := maps.Clone(middleware.GetRequestMetadata(c))
+		if metadata == nil {
+			metadata = make(map[string]string, 1)
+		}
+
+		metadata["mcp_id"] = mcpID
+		c.Set(middleware.RequestMetadata, metadata)
+
+		relay(c, mode.ChatCompletions, relayController(mode.ChatCompletions))
+	}
+
+	return samplingResult(w.Code, w.Body.Bytes())
+}
+
+// samplingModel returns the chat model of the token matching the model
+// preferences, the hints are evaluated in order and a hint matches a model by
+// its name first and then as a substring of the name, the substring matches of
+// a hint are ranked by the priorities. When no hint matches the models of the
+// token are ranked by the priorities, and the default sampling model is
+// returned when the priorities prefer no model
+func samplingModel(
+	token *model.TokenCache,
+	modelCaches *model.ModelCaches,
+	preferences *mcp.ModelPreferences,
+) string {
+	var models []string
+
+	token.Range(func(m string) bool {
+		if mc, ok := modelCaches.EnabledModelConfigsMap[m]; ok && mc.Type == mode.ChatCompletions {
+			models = append(models, m)
+		}
+		return true
+	})
+
+	slices.Sort(models)
+
+	if preferences == nil {
+		return config.GetMCPSamplingModel()
+	}
+
+	for _, hint := range preferences.Hints {
+		if hint.Name == "" {
+			continue
+		}
+
+		for _, m := range models {
+			if strings.EqualFold(m, hint.Name) {
+				return m
+			}
+		}
+
+		name := strings.ToLower(hint.Name)
+
+		var matches []string
+		for _, m := range models {
+			if strings.Contains(strings.ToLower(m), name) {
+				matches = append(matches, m)
+			}
+		}
+
+		if len(matches) > 0 {
+			if m, ok := rankSamplingModels(matches, modelCaches, preferences); ok {
+				return m
+			}
+			return matches[0]
+		}
+	}
+
+	if m, ok := rankSamplingModels(models, modelCaches, preferences); ok {
+		return m
+	}
+
+	return config.GetMCPSamplingModel()
+}
+
+// rankSamplingModels returns the model preferred by the priorities, the price
+// of the tokens stands for the capability of a model, so the cost and the
+// speed priorities prefer the cheapest model and the intelligence priority
+// prefers the most expensive one. False is returned when the priorities cancel
+// out, the models with the same price are taken in order
+func rankSamplingModels(
+	models []string,
+	modelCaches *model.ModelCaches,
+	preferences *mcp.ModelPreferences,
+) (string, bool) {
+	weight := preferences.IntelligencePriority -
+		preferences.CostPriority -
+		preferences.SpeedPriority
+	if weight == 0 || len(models) == 0 {
+		return "", false
+	}
+
+	tokenPrice := func(m string) float64 {
+		price := modelCaches.EnabledModelConfigsMap[m].Price
+		return float64(price.InputPrice)/float64(price.GetInputPriceUnit()) +
+			float64(price.OutputPrice)/float64(price.GetOutputPriceUnit())
+	}
+
+	best := models[0]
+	for _, m := range models[1:] {
+		if (weight > 0 && tokenPrice(m) > tokenPrice(best)) ||
+			(weight < 0 && tokenPrice(m) < tokenPrice(best)) {
+			best = m
+		}
+	}
+
+	return best, true
+}
+
+// samplingContent is the text, image or audio content of a sampling message
+type samplingContent struct {
+	Type     string `json:"type"`
+	Text     string `json:"text,omitempty"`
+	Data     string `json:"data,omitempty"`
+	MIMEType string `json:"mimeType,omitempty"`
+}
+
+func samplingContents(content any) ([]samplingContent, error) {
+	data, err := sonic.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(data), []byte("[")) {
+		var contents []samplingContent
+		if err := sonic.Unmarshal(data, &contents); err != nil {
+			return nil, err
+		}
+
+		return contents, nil
+	}
+
+	var c samplingContent
+	if err := sonic.Unmarshal(data, &c); err != nil {
+		return nil, err
+	}
+
+	return []samplingContent{c}, nil
+}
+
+// samplingMessageContent converts the content of a sampling message to the
+// content of a chat message, a single text is sent as a string
+func samplingMessageContent(content any) (any, error) {
+	contents, err := samplingContents(content)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(contents) == 1 && contents[0].Type == relaymodel.ContentTypeText {
+		return contents[0].Text, nil
+	}
+
+	parts := make([]any, 0, len(contents))
+	for _, c := range contents {
+		switch c.Type {
+		case relaymodel.ContentTypeText:
+			parts = append(parts, relaymodel.MessageContent{
+				Type: relaymodel.ContentTypeText,
+				Text: c.Text,
+			})
+		case "image":
+			parts = append(parts, relaymodel.MessageContent{
+				Type: relaymodel.ContentTypeImageURL,
+				ImageURL: &relaymodel.ImageURL{
+					URL: fmt.Sprintf("data:%s;base64,%s", c.MIMEType, c.Data),
+				},
+			})
+		case "audio":
+			parts = append(parts, map[string]any{
+				"type": relaymodel.ContentTypeInputAudio,
+				"input_audio": map[string]string{
+					"data":   c.Data,
+					"format": strings.TrimPrefix(c.MIMEType, "audio/"),
+				},
+			})
+		default:
+			return nil, fmt.Errorf("unsupported sampling content type: %s", c.Type)
+		}
+	}
+
+	return parts, nil
+}
+
+func samplingRequestBody(modelName string, params mcp.CreateMessageParams) ([]byte, error) {
+	messages := make([]relaymodel.Message, 0, len(params.Messages)+1)
+	if params.SystemPrompt != "" {
+		messages = append(messages, relaymodel.Message{
+			Role:    "system",
+			Content: params.SystemPrompt,
+		})
+	}
+
+	for _, m := range params.Messages {
+		content, err := samplingMessageContent(m.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		messages = append(messages, relaymodel.Message{
+			Role:    string(m.Role),
+			Content: content,
+		})
+	}
+
+	request := relaymodel.GeneralOpenAIRequest{
+		Model:     modelName,
+		Messages:  messages,
+		MaxTokens: params.MaxTokens,
+	}
+
+	if params.Temperature != 0 {
+		request.Temperature = &params.Temperature
+	}
+
+	if len(params.StopSequences) > 0 {
+		request.Stop = params.StopSequences
+	}
+
+	return sonic.Marshal(request)
+}
+
+// samplingStopReasons maps the finish reasons of the chat completions to the
+// stop reasons of the sampling result
+var samplingStopReasons = map[relaymodel.FinishReason]string{
+	relaymodel.FinishReasonStop:   "endTurn",
+	relaymodel.FinishReasonLength: "maxTokens",
+}
+
+func samplingResult(statusCode int, body []byte) (*mcp.CreateMessageResult, error) {
+	if statusCode != http.StatusOK {
+		var errResp relaymodel.OpenAIErrorResponse
+		if err := sonic.Unmarshal(body, &errResp); err == nil && errResp.Error.Message != "" {
+			return nil, fmt.Errorf(
+				"sampling failed with status %d: %s",
+				statusCode,
+				errResp.Error.Message,
+			)
+		}
+
+		return nil, fmt.Errorf("sampling failed with status %d: %s", statusCode, body)
+	}
+
+	var resp relaymodel.TextResponse
+	if err := sonic.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Choices) == 0 || resp.Choices[0] == nil {
+		return nil, errors.New("sampling response has no choices")
+	}
+
+	choice := resp.Choices[0]
+
+	// the reasoning is not part of the sampled message
+	choice.Message.ReasoningContent = ""
+
+	stopReason, ok := samplingStopReasons[choice.FinishReason]
+	if !ok {
+		stopReason = choice.FinishReason
+	}
+
+	return &mcp.CreateMessageResult{
+		SamplingMessage: mcp.SamplingMessage{
+			Role:    mcp.RoleAssistant,
+			Content: mcp.NewTextContent(choice.Message.StringContent()),
+		},
+		Model:      resp.Model,
+		StopReason: stopReason,
+	}, nil
+}
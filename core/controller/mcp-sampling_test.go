@@ -0,0 +1,127 @@
+package controller_test
+
+import (
+	"testing"
+
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/controller"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSamplingModel(t *testing.T) {
+	oldSamplingModel := config.GetMCPSamplingModel()
+	config.SetMCPSamplingModel("default-model")
+	t.Cleanup(func() {
+		config.SetMCPSamplingModel(oldSamplingModel)
+	})
+
+	chatModel := func(name string, inputPrice, outputPrice float64) model.ModelConfig {
+		return model.ModelConfig{
+			Model: name,
+			Type:  mode.ChatCompletions,
+			Price: model.Price{
+				InputPrice:  model.ZeroNullFloat64(inputPrice),
+				OutputPrice: model.ZeroNullFloat64(outputPrice),
+			},
+		}
+	}
+
+	modelCaches := &model.ModelCaches{
+		EnabledModelsBySet: map[string][]string{
+			model.ChannelDefaultSet: {
+				"claude-haiku",
+				"claude-opus",
+				"claude-sonnet",
+				"gpt-4o",
+				"text-embedding",
+			},
+		},
+		EnabledModelConfigsMap: map[string]model.ModelConfig{
+			"claude-haiku":  chatModel("claude-haiku", 0.001, 0.005),
+			"claude-opus":   chatModel("claude-opus", 0.015, 0.075),
+			"claude-sonnet": chatModel("claude-sonnet", 0.003, 0.015),
+			"gpt-4o":        chatModel("gpt-4o", 0.0025, 0.01),
+			"text-embedding": {
+				Model: "text-embedding",
+				Type:  mode.Embeddings,
+			},
+		},
+	}
+
+	token := model.TokenCache{}
+	token.SetAvailableSets([]string{model.ChannelDefaultSet})
+	token.SetModelsBySet(modelCaches.EnabledModelsBySet)
+
+	tests := []struct {
+		name        string
+		preferences *mcp.ModelPreferences
+		want        string
+	}{
+		{
+			name: "no preferences",
+			want: "default-model",
+		},
+		{
+			name: "exact hint",
+			preferences: &mcp.ModelPreferences{
+				Hints:        []mcp.ModelHint{{Name: "GPT-4o"}},
+				CostPriority: 1,
+			},
+			want: "gpt-4o",
+		},
+		{
+			name: "ambiguous hint without priorities",
+			preferences: &mcp.ModelPreferences{
+				Hints: []mcp.ModelHint{{Name: "claude"}},
+			},
+			want: "claude-haiku",
+		},
+		{
+			name: "ambiguous hint ranked by intelligence",
+			preferences: &mcp.ModelPreferences{
+				Hints:                []mcp.ModelHint{{Name: "claude"}},
+				IntelligencePriority: 0.8,
+				SpeedPriority:        0.2,
+			},
+			want: "claude-opus",
+		},
+		{
+			name: "no matching hint ranked by cost",
+			preferences: &mcp.ModelPreferences{
+				Hints:                []mcp.ModelHint{{Name: "gemini"}},
+				CostPriority:         0.6,
+				IntelligencePriority: 0.4,
+			},
+			want: "claude-haiku",
+		},
+		{
+			name: "no matching hint ranked by intelligence",
+			preferences: &mcp.ModelPreferences{
+				IntelligencePriority: 1,
+			},
+			want: "claude-opus",
+		},
+		{
+			name: "priorities cancel out",
+			preferences: &mcp.ModelPreferences{
+				Hints:                []mcp.ModelHint{{Name: "gemini"}},
+				CostPriority:         0.5,
+				IntelligencePriority: 0.5,
+			},
+			want: "default-model",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(
+				t,
+				tt.want,
+				controller.SamplingModel(&token, modelCaches, tt.preferences),
+			)
+		})
+	}
+}
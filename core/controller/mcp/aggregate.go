@@ -0,0 +1,472 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// AggregateToolSeparator joins the mcp id and the tool name of an aggregated tool
+	AggregateToolSeparator = mcpproxy.ToolNameSeparator
+
+	aggregateMCPType       = "mcp_aggregate"
+	aggregateServerName    = "aiproxy-aggregate"
+	aggregateServerVersion = "1.0.0"
+	aggregateListTimeout   = 10 * time.Second
+)
+
+// aggregateBackend is a public or group mcp of the aggregated server,
+// its server is created on the first use and kept until the server is closed
+type aggregateBackend struct {
+	id string
+	// listTools lists the tools without the server when it is not nil
+	listTools func(ctx context.Context) ([]mcp.Tool, error)
+	newServer func() (mcpservers.Server, func(), error)
+
+	once   sync.Once
+	server mcpservers.Server
+	close  func()
+	err    error
+}
+
+func (b *aggregateBackend) getServer() (mcpservers.Server, error) {
+	b.once.Do(func() {
+		b.server, b.close, b.err = b.newServer()
+	})
+
+	return b.server, b.err
+}
+
+func (b *aggregateBackend) tools(ctx context.Context) ([]mcp.Tool, error) {
+	ctx, cancel := context.WithTimeout(ctx, aggregateListTimeout)
+	defer cancel()
+
+	if b.listTools != nil {
+		return b.listTools(ctx)
+	}
+
+	server, err := b.getServer()
+	if err != nil {
+		return nil, err
+	}
+
+	return mcpservers.ListServerTools(ctx, server)
+}
+
+// aggregateServer merges the tools of the enabled public mcps and group mcps
+// into one namespace, the tools are named `mcp_id__tool`
+type aggregateServer struct {
+	log      *logrus.Entry
+	policy   toolCallPolicy
+	backends []*aggregateBackend
+}
+
+func newAggregateServer(c *gin.Context) (*aggregateServer, error) {
+	group := middleware.GetGroup(c)
+
+	s := &aggregateServer{
+		log:    common.GetLogger(c),
+		policy: newToolCallPolicy(c),
+	}
+
+	// the group mcps shadow the public mcps with the same id
+	groupIDs := make(map[string]struct{})
+
+	if group.ID != "" {
+		groupMcps, err := model.GetEnabledGroupMCPs(group.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, groupMcp := range groupMcps {
+			groupIDs[groupMcp.ID] = struct{}{}
+			s.backends = append(s.backends, newGroupAggregateBackend(c, groupMcp.ToGroupMCPCache()))
+		}
+	}
+
+	publicMcps, err := model.GetAllPublicMCPs(model.PublicMCPStatusEnabled)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, publicMcp := range publicMcps {
+		if _, ok := groupIDs[publicMcp.ID]; ok || !IsHostedMCP(publicMcp.Type) {
+			continue
+		}
+
+		backend, ok, err := newPublicAggregateBackend(c, publicMcp, group.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		if ok {
+			s.backends = append(s.backends, backend)
+		}
+	}
+
+	slices.SortFunc(s.backends, func(a, b *aggregateBackend) int {
+		return strings.Compare(a.id, b.id)
+	})
+
+	return s, nil
+}
+
+// newPublicAggregateBackend returns the backend of the public mcp, false when
+// the group has not filled the required reusing params of the mcp
+func newPublicAggregateBackend(
+	c *gin.Context,
+	publicMcp model.PublicMCP,
+	groupID string,
+) (*aggregateBackend, bool, error) {
+	reusing := publicMCPReusing(publicMcp)
+
+	var params model.Params
+	if len(reusing) > 0 {
+		reusingParams, err := model.CacheGetPublicMCPReusingParam(publicMcp.ID, groupID)
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, false, err
+		}
+
+		params = reusingParams.Params
+		if !checkParamsIsFull(params, reusing) {
+			return nil, false, nil
+		}
+	}
+
+	publicMcpCache := publicMcp.ToPublicMCPCache()
+	recorder := newToolCallRecorder(c, publicMcpCache)
+
+	return &aggregateBackend{
+		id: publicMcp.ID,
+		listTools: func(ctx context.Context) ([]mcp.Tool, error) {
+			return getPublicMCPTools(
+				ctx,
+				publicMcp,
+				groupID,
+				model.TestConfig{},
+				params,
+				reusing,
+			)
+		},
+		newServer: func() (mcpservers.Server, func(), error) {
+			server, closeServer, err := newPublicMCPServer(
+				c,
+				publicMcpCache,
+				groupID,
+				staticParams(params),
+			)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			return recorder.wrap(server), closeServer, nil
+		},
+	}, true, nil
+}
+
+func newGroupAggregateBackend(c *gin.Context, groupMcp *model.GroupMCPCache) *aggregateBackend {
+	return &aggregateBackend{
+		id: groupMcp.ID,
+		newServer: func() (mcpservers.Server, func(), error) {
+			return newGroupMCPServer(c, groupMcp)
+		},
+	}
+}
+
+// publicMCPReusing returns the reusing params of the public mcp by name
+func publicMCPReusing(publicMcp model.PublicMCP) map[string]model.ReusingParam {
+	switch publicMcp.Type {
+	case model.PublicMCPTypeProxySSE, model.PublicMCPTypeProxyStreamable:
+		if publicMcp.ProxyConfig == nil {
+			return nil
+		}
+
+		reusing := make(map[string]model.ReusingParam, len(publicMcp.ProxyConfig.Reusing))
+		for _, v := range publicMcp.ProxyConfig.Reusing {
+			reusing[v.Name] = v.ReusingParam
+		}
+
+		return reusing
+	case model.PublicMCPTypeEmbed:
+		if publicMcp.EmbedConfig == nil {
+			return nil
+		}
+
+		return publicMcp.EmbedConfig.Reusing
+	case model.PublicMCPTypeStdio:
+		if publicMcp.StdioConfig == nil {
+			return nil
+		}
+
+		return publicMcp.StdioConfig.Reusing
+	default:
+		return nil
+	}
+}
+
+// Close closes the servers of the backends
+func (s *aggregateServer) Close() {
+	for _, backend := range s.backends {
+		// wait for the server that is being created
+		backend.once.Do(func() {})
+
+		if backend.close != nil {
+			backend.close()
+		}
+	}
+}
+
+// resolve returns the backend and the tool name of an aggregated tool,
+// the longest matched mcp id wins since the ids may contain the separator
+func (s *aggregateServer) resolve(name string) (*aggregateBackend, string, bool) {
+	var found *aggregateBackend
+
+	for _, backend := range s.backends {
+		prefix := backend.id + AggregateToolSeparator
+		if len(name) <= len(prefix) || !strings.HasPrefix(name, prefix) {
+			continue
+		}
+
+		if found == nil || len(backend.id) > len(found.id) {
+			found = backend
+		}
+	}
+
+	if found == nil {
+		return nil, "", false
+	}
+
+	return found, strings.TrimPrefix(name, found.id+AggregateToolSeparator), true
+}
+
+type aggregateRequest struct {
+	ID     mcp.RequestId `json:"id"`
+	Method string        `json:"method"`
+}
+
+func (s *aggregateServer) HandleMessage(
+	ctx context.Context,
+	message json.RawMessage,
+) mcp.JSONRPCMessage {
+	var req aggregateRequest
+	if err := sonic.Unmarshal(message, &req); err != nil {
+		return mcpservers.CreateMCPErrorResponse(nil, mcp.PARSE_ERROR, err.Error())
+	}
+
+	switch mcp.MCPMethod(req.Method) {
+	case mcp.MethodInitialize:
+		return s.initialize(req.ID)
+	case mcp.MethodPing:
+		return mcpservers.CreateMCPResultResponse(req.ID, json.RawMessage("{}"))
+	case mcp.MethodToolsList:
+		return s.handleListTools(ctx, req.ID)
+	case mcp.MethodToolsCall:
+		return s.handleCallTool(ctx, req.ID, message)
+	default:
+		if strings.HasPrefix(req.Method, "notifications/") {
+			return nil
+		}
+
+		return mcpservers.CreateMCPErrorResponse(
+			req.ID,
+			mcp.METHOD_NOT_FOUND,
+			fmt.Sprintf("method %s is not supported by the aggregated mcp", req.Method),
+		)
+	}
+}
+
+func (s *aggregateServer) initialize(id mcp.RequestId) mcp.JSONRPCMessage {
+	result := mcp.InitializeResult{
+		ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
+		ServerInfo: mcp.Implementation{
+			Name:    aggregateServerName,
+			Version: aggregateServerVersion,
+		},
+	}
+	result.Capabilities.Tools = &struct {
+		ListChanged bool `json:"listChanged,omitempty"`
+	}{}
+
+	data, err := sonic.Marshal(result)
+	if err != nil {
+		return mcpservers.CreateMCPErrorResponse(id, mcp.INTERNAL_ERROR, err.Error())
+	}
+
+	return mcpservers.CreateMCPResultResponse(id, data)
+}
+
+// listTools lists the tools of all backends allowed by the tool policy,
+// the backends that fail are skipped
+func (s *aggregateServer) listTools(ctx context.Context) []mcp.Tool {
+	results := make([][]mcp.Tool, len(s.backends))
+	errs := make([]error, len(s.backends))
+
+	var wg sync.WaitGroup
+	for i, backend := range s.backends {
+		wg.Go(func() {
+			results[i], errs[i] = backend.tools(ctx)
+		})
+	}
+
+	wg.Wait()
+
+	var tools []mcp.Tool
+
+	for i, backend := range s.backends {
+		if errs[i] != nil {
+			s.log.Warnf("list tools of mcp %s error: %v", backend.id, errs[i])
+			continue
+		}
+
+		for _, tool := range results[i] {
+			tool.Name = mcpproxy.ToolName(backend.id, tool.Name)
+			if s.policy.allows(tool.Name) {
+				tools = append(tools, tool)
+			}
+		}
+	}
+
+	return tools
+}
+
+func (s *aggregateServer) handleListTools(
+	ctx context.Context,
+	id mcp.RequestId,
+) mcp.JSONRPCMessage {
+	tools := s.listTools(ctx)
+	if tools == nil {
+		tools = []mcp.Tool{}
+	}
+
+	data, err := sonic.Marshal(mcp.ListToolsResult{Tools: tools})
+	if err != nil {
+		return mcpservers.CreateMCPErrorResponse(id, mcp.INTERNAL_ERROR, err.Error())
+	}
+
+	return mcpservers.CreateMCPResultResponse(id, data)
+}
+
+// handleCallTool routes the call to the backend of the tool with the name of the backend
+func (s *aggregateServer) handleCallTool(
+	ctx context.Context,
+	id mcp.RequestId,
+	message json.RawMessage,
+) mcp.JSONRPCMessage {
+	var req map[string]json.RawMessage
+	if err := sonic.Unmarshal(message, &req); err != nil {
+		return mcpservers.CreateMCPErrorResponse(id, mcp.PARSE_ERROR, err.Error())
+	}
+
+	var params map[string]json.RawMessage
+	if err := sonic.Unmarshal(req["params"], &params); err != nil {
+		return mcpservers.CreateMCPErrorResponse(id, mcp.INVALID_PARAMS, "invalid tool call params")
+	}
+
+	var name string
+	if err := sonic.Unmarshal(params["name"], &name); err != nil || name == "" {
+		return mcpservers.CreateMCPErrorResponse(id, mcp.INVALID_PARAMS, "tool name is required")
+	}
+
+	backend, tool, ok := s.resolve(name)
+	if !ok {
+		return mcpservers.CreateMCPErrorResponse(
+			id,
+			mcp.INVALID_PARAMS,
+			fmt.Sprintf("tool %s not found", name),
+		)
+	}
+
+	// the rpm limits are checked by the guard of the backend
+	if !s.policy.allows(name) {
+		return mcpservers.CreateMCPErrorResponse(
+			id,
+			mcpproxy.ToolCallDeniedCode,
+			fmt.Sprintf("tool %s is not allowed", name),
+		)
+	}
+
+	server, err := backend.getServer()
+	if err != nil {
+		return mcpservers.CreateMCPErrorResponse(
+			id,
+			mcp.INTERNAL_ERROR,
+			fmt.Sprintf("connect to mcp %s error: %v", backend.id, err),
+		)
+	}
+
+	params["name"], err = sonic.Marshal(tool)
+	if err != nil {
+		return mcpservers.CreateMCPErrorResponse(id, mcp.INTERNAL_ERROR, err.Error())
+	}
+
+	req["params"], err = sonic.Marshal(params)
+	if err != nil {
+		return mcpservers.CreateMCPErrorResponse(id, mcp.INTERNAL_ERROR, err.Error())
+	}
+
+	backendMessage, err := sonic.Marshal(req)
+	if err != nil {
+		return mcpservers.CreateMCPErrorResponse(id, mcp.INTERNAL_ERROR, err.Error())
+	}
+
+	return server.HandleMessage(ctx, backendMessage)
+}
+
+// AggregateMCPSSEServer godoc
+//
+//	@Summary		Aggregated MCP SSE Server
+//	@Description	Serves the tools of all enabled public and group mcps, named mcp_id__tool
+//	@Security		ApiKeyAuth
+//	@Router			/mcp/aggregate/sse [get]
+func AggregateMCPSSEServer(c *gin.Context) {
+	server, err := newAggregateServer(c)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer server.Close()
+
+	handleSSEMCPServer(c, server, aggregateMCPType, sseEndpoint)
+}
+
+// AggregateMCPStreamable godoc
+//
+//	@Summary		Aggregated MCP Streamable Server
+//	@Description	Serves the tools of all enabled public and group mcps, named mcp_id__tool
+//	@Security		ApiKeyAuth
+//	@Router			/mcp/aggregate [get]
+//	@Router			/mcp/aggregate [post]
+//	@Router			/mcp/aggregate [delete]
+func AggregateMCPStreamable(c *gin.Context) {
+	server, err := newAggregateServer(c)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, mcpservers.CreateMCPErrorResponse(
+			mcp.NewRequestId(nil),
+			mcp.INTERNAL_ERROR,
+			err.Error(),
+		))
+
+		return
+	}
+	defer server.Close()
+
+	handleStreamableMCPServer(c, server)
+}
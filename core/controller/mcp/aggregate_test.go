@@ -0,0 +1,286 @@
+package controller_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	controller "github.com/labring/aiproxy/core/controller/mcp"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// useTestDB points DB and LogDB to a new sqlite database with the tables of models
+func useTestDB(t *testing.T, models ...any) {
+	t.Helper()
+
+	db, err := model.OpenSQLite(filepath.Join(t.TempDir(), "aiproxy.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(models...))
+
+	oldDB, oldLogDB := model.DB, model.LogDB
+	model.DB, model.LogDB = db, db
+
+	t.Cleanup(func() {
+		model.DB, model.LogDB = oldDB, oldLogDB
+
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+}
+
+// openAPISpec returns the spec of the operations, each operation is posted to its path
+func openAPISpec(t *testing.T, operations ...string) string {
+	t.Helper()
+
+	paths := make(map[string]any, len(operations))
+	for _, operation := range operations {
+		paths["/"+operation] = map[string]any{
+			"post": map[string]any{
+				"operationId": operation,
+				"responses":   map[string]any{"200": map[string]any{"description": "ok"}},
+			},
+		}
+	}
+
+	spec, err := sonic.MarshalString(map[string]any{
+		"openapi": "3.0.0",
+		"info":    map[string]any{"title": "test", "version": "1.0.0"},
+		"paths":   paths,
+	})
+	require.NoError(t, err)
+
+	return spec
+}
+
+// newTestAggregateServer creates the aggregated server of the group g1 with the token,
+// the tools of the mcps reply with the name of the mcp and the path of the tool
+func newTestAggregateServer(t *testing.T, token model.TokenCache) mcpservers.Server {
+	t.Helper()
+
+	useTestDB(t, &model.Group{}, &model.GroupMCP{}, &model.PublicMCP{})
+
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.URL.Query().Get("mcp")+":"+strings.TrimPrefix(r.URL.Path, "/"))
+	}))
+	t.Cleanup(backend.Close)
+
+	openAPIConfig := func(name string, operations ...string) *model.MCPOpenAPIConfig {
+		return &model.MCPOpenAPIConfig{
+			OpenAPIContent: openAPISpec(t, operations...),
+			ServerAddr:     backend.URL + "?mcp=" + name,
+		}
+	}
+
+	for _, groupMcp := range []*model.GroupMCP{
+		{
+			ID:            "github",
+			GroupID:       "g1",
+			Type:          model.GroupMCPTypeOpenAPI,
+			OpenAPIConfig: openAPIConfig("github", "create_issue", "delete_repo"),
+		},
+		{
+			ID:            "github__beta",
+			GroupID:       "g1",
+			Type:          model.GroupMCPTypeOpenAPI,
+			OpenAPIConfig: openAPIConfig("github-beta", "search"),
+		},
+		{
+			ID:            "gitlab",
+			GroupID:       "g2",
+			Type:          model.GroupMCPTypeOpenAPI,
+			OpenAPIConfig: openAPIConfig("gitlab", "merge"),
+		},
+	} {
+		require.NoError(t, model.DB.Create(groupMcp).Error)
+	}
+
+	for _, publicMcp := range []*model.PublicMCP{
+		{
+			ID:            "docs",
+			Status:        model.PublicMCPStatusEnabled,
+			Type:          model.PublicMCPTypeOpenAPI,
+			OpenAPIConfig: openAPIConfig("docs", "read_docs"),
+		},
+		{
+			// shadowed by the group mcp with the same id
+			ID:            "github",
+			Status:        model.PublicMCPStatusEnabled,
+			Type:          model.PublicMCPTypeOpenAPI,
+			OpenAPIConfig: openAPIConfig("public-github", "star"),
+		},
+		{
+			ID:            "slack",
+			Status:        model.PublicMCPStatusDisabled,
+			Type:          model.PublicMCPTypeOpenAPI,
+			OpenAPIConfig: openAPIConfig("slack", "post"),
+		},
+	} {
+		require.NoError(t, model.DB.Create(publicMcp).Error)
+	}
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/mcp/aggregate", nil)
+	c.Set(middleware.Group, model.GroupCache{ID: "g1"})
+	c.Set(middleware.Token, token)
+
+	s, err := controller.NewAggregateServer(c)
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+
+	return s
+}
+
+func handle(t *testing.T, s mcpservers.Server, message string) map[string]any {
+	t.Helper()
+
+	resp := s.HandleMessage(context.Background(), json.RawMessage(message))
+	require.NotNil(t, resp)
+
+	data, err := sonic.Marshal(resp)
+	require.NoError(t, err)
+
+	var result map[string]any
+	require.NoError(t, sonic.Unmarshal(data, &result))
+
+	return result
+}
+
+func toolNames(t *testing.T, resp map[string]any) []string {
+	t.Helper()
+
+	result, ok := resp["result"].(map[string]any)
+	require.True(t, ok, resp)
+
+	tools, ok := result["tools"].([]any)
+	require.True(t, ok, resp)
+
+	names := make([]string, 0, len(tools))
+	for _, tool := range tools {
+		tool, ok := tool.(map[string]any)
+		require.True(t, ok, resp)
+
+		name, ok := tool["name"].(string)
+		require.True(t, ok, resp)
+
+		names = append(names, name)
+	}
+
+	return names
+}
+
+func TestAggregateListTools(t *testing.T) {
+	resp := handle(
+		t,
+		newTestAggregateServer(t, model.TokenCache{}),
+		`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+	)
+
+	assert.Equal(
+		t,
+		[]string{
+			"docs__read_docs",
+			"github__create_issue",
+			"github__delete_repo",
+			"github__beta__search",
+		},
+		toolNames(t, resp),
+	)
+
+	resp = handle(
+		t,
+		newTestAggregateServer(t, model.TokenCache{
+			MCPAllowTools: []string{"github__*"},
+			MCPDenyTools:  []string{"*__delete_*"},
+		}),
+		`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`,
+	)
+
+	assert.Equal(t, []string{"github__create_issue", "github__beta__search"}, toolNames(t, resp))
+}
+
+func TestAggregateCallTool(t *testing.T) {
+	s := newTestAggregateServer(t, model.TokenCache{MCPDenyTools: []string{"github__delete_repo"}})
+
+	tests := []struct {
+		name     string
+		tool     string
+		wantText string
+		wantErr  string
+	}{
+		{name: "routed", tool: "github__create_issue", wantText: "github:create_issue"},
+		{name: "longest mcp id", tool: "github__beta__search", wantText: "github-beta:search"},
+		{
+			name:    "denied",
+			tool:    "github__delete_repo",
+			wantErr: "tool github__delete_repo is not allowed",
+		},
+		{name: "unknown mcp", tool: "slack__post", wantErr: "tool slack__post not found"},
+		{name: "no tool name", tool: "github__", wantErr: "tool github__ not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := handle(
+				t,
+				s,
+				`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"`+tt.tool+`","arguments":{}}}`,
+			)
+			assert.InDelta(t, 7, resp["id"], 0)
+
+			if tt.wantErr != "" {
+				errObj, ok := resp["error"].(map[string]any)
+				require.True(t, ok, resp)
+				assert.Equal(t, tt.wantErr, errObj["message"])
+
+				return
+			}
+
+			result, ok := resp["result"].(map[string]any)
+			require.True(t, ok, resp)
+
+			content, ok := result["content"].([]any)
+			require.True(t, ok, resp)
+			require.Len(t, content, 1)
+
+			part, ok := content[0].(map[string]any)
+			require.True(t, ok, resp)
+
+			// the openapi tools reply with the dumped http response
+			text, ok := part["text"].(string)
+			require.True(t, ok, resp)
+			assert.True(t, strings.HasSuffix(text, "\r\n\r\n"+tt.wantText), text)
+		})
+	}
+}
+
+func TestAggregateInitialize(t *testing.T) {
+	s := newTestAggregateServer(t, model.TokenCache{})
+
+	resp := handle(t, s, `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+
+	result, ok := resp["result"].(map[string]any)
+	require.True(t, ok, resp)
+	assert.Equal(t, mcp.LATEST_PROTOCOL_VERSION, result["protocolVersion"])
+	assert.Contains(t, result["capabilities"], "tools")
+
+	assert.Nil(
+		t,
+		s.HandleMessage(
+			context.Background(),
+			json.RawMessage(`{"jsonrpc":"2.0","method":"notifications/initialized"}`),
+		),
+	)
+}
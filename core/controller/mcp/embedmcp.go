@@ -217,6 +217,35 @@ func GetEmbedConfig(
 	return embedConfig, nil
 }
 
+// GetStdioConfig fills the env of the stdio command with the init config, the
+// configs that are not provided become the reusing params of the groups
+func GetStdioConfig(
+	stdioConfig *model.MCPStdioConfig,
+	ct mcpservers.ConfigTemplates,
+	initConfig map[string]string,
+) (*model.MCPStdioConfig, error) {
+	if stdioConfig == nil {
+		return nil, errors.New("stdio config is empty")
+	}
+
+	embedConfig, err := GetEmbedConfig(ct, initConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	config := *stdioConfig
+
+	config.Env = maps.Clone(stdioConfig.Env)
+	if config.Env == nil {
+		config.Env = make(map[string]string, len(embedConfig.Init))
+	}
+
+	maps.Copy(config.Env, embedConfig.Init)
+	config.Reusing = embedConfig.Reusing
+
+	return &config, nil
+}
+
 func GetProxyConfig(
 	proxyConfigType mcpservers.ProxyConfigTemplates,
 	initConfig map[string]string,
@@ -328,6 +357,13 @@ func ToPublicMCP(
 		}
 
 		pmcp.ProxyConfig = proxyConfig
+	case model.PublicMCPTypeStdio:
+		stdioConfig, err := GetStdioConfig(e.StdioConfig, e.ConfigTemplates, initConfig)
+		if err != nil {
+			return nil, err
+		}
+
+		pmcp.StdioConfig = stdioConfig
 	default:
 	}
 
@@ -0,0 +1,55 @@
+package controller_test
+
+import (
+	"maps"
+	"slices"
+	"testing"
+
+	controller "github.com/labring/aiproxy/core/controller/mcp"
+	"github.com/labring/aiproxy/core/model"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetStdioConfig(t *testing.T) {
+	stdioConfig := &model.MCPStdioConfig{
+		Command: "npx",
+		Args:    []string{"-y", "server"},
+		Env:     map[string]string{"MODE": "stdio"},
+	}
+	templates := mcpservers.ConfigTemplates{
+		"API_KEY": {
+			Name:     "API Key",
+			Required: mcpservers.ConfigRequiredTypeInitOrReusingOnly,
+		},
+		"REGION": {
+			Name:     "Region",
+			Required: mcpservers.ConfigRequiredTypeInitOnly,
+		},
+	}
+
+	_, err := controller.GetStdioConfig(stdioConfig, templates, nil)
+	require.Error(t, err)
+
+	config, err := controller.GetStdioConfig(
+		stdioConfig,
+		templates,
+		map[string]string{"REGION": "us"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "npx", config.Command)
+	assert.Equal(t, []string{"-y", "server"}, config.Args)
+	assert.Equal(t, map[string]string{"MODE": "stdio", "REGION": "us"}, config.Env)
+	assert.Equal(t, []string{"API_KEY"}, slices.Collect(maps.Keys(config.Reusing)))
+	assert.Equal(t, map[string]string{"MODE": "stdio"}, stdioConfig.Env)
+
+	config, err = controller.GetStdioConfig(
+		stdioConfig,
+		templates,
+		map[string]string{"REGION": "us", "API_KEY": "key"},
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "key", config.Env["API_KEY"])
+	assert.Empty(t, config.Reusing)
+}
@@ -0,0 +1,10 @@
+package controller
+
+var (
+	NewAggregateServer  = newAggregateServer
+	NewToolCallPolicy   = newToolCallPolicy
+	ToolCallPolicyGuard = toolCallPolicy.guard
+
+	NewToolCallRecorder     = newToolCallRecorder
+	ToolCallRecorderObserve = (*toolCallRecorder).observe
+)
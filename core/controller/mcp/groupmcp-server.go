@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"maps"
 	"net/http"
 	"net/url"
@@ -47,62 +48,83 @@ func handleGroupSSEMCPServer(
 	groupMcp *model.GroupMCPCache,
 	endpoint EndpointProvider,
 ) {
+	server, closeServer, err := newGroupMCPServer(c, groupMcp)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if closeServer != nil {
+		defer closeServer()
+	}
+
+	handleSSEMCPServer(c, server, string(groupMcp.Type), endpoint)
+}
+
+// newGroupMCPServer creates the server of the group mcp guarded by the tool
+// policy and sampling through the relay, the returned func closes the
+// connection to the proxied mcp and is nil when there is none
+func newGroupMCPServer(
+	c *gin.Context,
+	groupMcp *model.GroupMCPCache,
+) (mcpservers.Server, func(), error) {
+	server, closeServer, err := newGroupMCPBackend(c, groupMcp)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	server = mcpproxy.SamplingServer(server, newSamplingHandler(c, groupMcp.ID))
+
+	return mcpproxy.GuardServer(server, newToolCallPolicy(c).guard(groupMcp.ID)), closeServer, nil
+}
+
+func newGroupMCPBackend(
+	c *gin.Context,
+	groupMcp *model.GroupMCPCache,
+) (mcpservers.Server, func(), error) {
 	switch groupMcp.Type {
-	case model.GroupMCPTypeProxySSE:
-		client, err := transport.NewSSE(
-			groupMcp.ProxyConfig.URL,
-			transport.WithHeaders(groupMcp.ProxyConfig.Headers),
-		)
-		if err != nil {
-			http.Error(c.Writer, err.Error(), http.StatusBadRequest)
-			return
+	case model.GroupMCPTypeProxySSE, model.GroupMCPTypeProxyStreamable:
+		if groupMcp.ProxyConfig == nil || groupMcp.ProxyConfig.URL == "" {
+			return nil, nil, errors.New("invalid proxy configuration")
 		}
 
-		err = client.Start(c.Request.Context())
-		if err != nil {
-			http.Error(c.Writer, err.Error(), http.StatusBadRequest)
-			return
+		var (
+			client transport.Interface
+			err    error
+		)
+
+		if groupMcp.Type == model.GroupMCPTypeProxySSE {
+			client, err = transport.NewSSE(
+				groupMcp.ProxyConfig.URL,
+				transport.WithHeaders(groupMcp.ProxyConfig.Headers),
+			)
+		} else {
+			client, err = transport.NewStreamableHTTP(
+				groupMcp.ProxyConfig.URL,
+				transport.WithHTTPHeaders(groupMcp.ProxyConfig.Headers),
+			)
 		}
-		defer client.Close()
 
-		handleSSEMCPServer(c,
-			mcpservers.WrapMCPClient2Server(client),
-			string(model.GroupMCPTypeProxySSE),
-			endpoint,
-		)
-	case model.GroupMCPTypeProxyStreamable:
-		client, err := transport.NewStreamableHTTP(
-			groupMcp.ProxyConfig.URL,
-			transport.WithHTTPHeaders(groupMcp.ProxyConfig.Headers),
-		)
 		if err != nil {
-			http.Error(c.Writer, err.Error(), http.StatusBadRequest)
-			return
+			return nil, nil, err
 		}
 
-		err = client.Start(c.Request.Context())
-		if err != nil {
-			http.Error(c.Writer, err.Error(), http.StatusBadRequest)
-			return
+		mcpproxy.EnableSampling(client)
+
+		if err := client.Start(c.Request.Context()); err != nil {
+			return nil, nil, err
 		}
-		defer client.Close()
 
-		handleSSEMCPServer(
-			c,
-			mcpservers.WrapMCPClient2Server(client),
-			string(model.GroupMCPTypeProxyStreamable),
-			endpoint,
-		)
+		return mcpservers.WrapMCPClient2Server(client), func() { _ = client.Close() }, nil
 	case model.GroupMCPTypeOpenAPI:
 		server, err := newOpenAPIMCPServer(groupMcp.OpenAPIConfig)
 		if err != nil {
-			http.Error(c.Writer, err.Error(), http.StatusBadRequest)
-			return
+			return nil, nil, err
 		}
 
-		handleSSEMCPServer(c, server, string(model.GroupMCPTypeOpenAPI), endpoint)
+		return server, nil, nil
 	default:
-		http.Error(c.Writer, "unsupported mcp type", http.StatusBadRequest)
+		return nil, nil, errors.New("unsupported mcp type")
 	}
 }
 
@@ -152,7 +174,12 @@ func GroupMCPStreamable(c *gin.Context) {
 }
 
 // handleGroupProxyStreamable processes Streamable proxy requests for group
-func handleGroupProxyStreamable(c *gin.Context, config *model.GroupMCPProxyConfig) {
+func handleGroupProxyStreamable(
+	c *gin.Context,
+	config *model.GroupMCPProxyConfig,
+	guard mcpproxy.ToolCallGuard,
+	sampling mcpproxy.SamplingHandler,
+) {
 	if config == nil || config.URL == "" {
 		return
 	}
@@ -176,6 +203,11 @@ func handleGroupProxyStreamable(c *gin.Context, config *model.GroupMCPProxyConfi
 	}
 
 	backendURL.RawQuery = backendQuery.Encode()
-	mcpproxy.NewStreamableProxy(backendURL.String(), headers, getStore()).
-		ServeHTTP(c.Writer, c.Request)
+	mcpproxy.NewStreamableProxy(
+		backendURL.String(),
+		headers,
+		getStore(),
+		mcpproxy.WithMessageObserver(mcpproxy.GuardObserver(guard, nil)),
+		mcpproxy.WithStreamableSamplingHandler(sampling),
+	).ServeHTTP(c.Writer, c.Request)
 }
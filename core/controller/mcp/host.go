@@ -58,7 +58,14 @@ func HostMCPSSEServer(c *gin.Context) {
 		group := middleware.GetGroup(c)
 		paramsFunc := newGroupParams(publicMcp.ID, group.ID)
 
-		handlePublicSSEMCP(c, publicMcp, paramsFunc, sseEndpoint)
+		handlePublicSSEMCP(
+			c,
+			publicMcp,
+			group.ID,
+			paramsFunc,
+			sseEndpoint,
+			newToolCallRecorder(c, publicMcp),
+		)
 	}, func(c *gin.Context, mcpID string) {
 		group := middleware.GetGroup(c)
 
@@ -110,7 +117,13 @@ func HostMCPStreamable(c *gin.Context) {
 		group := middleware.GetGroup(c)
 		paramsFunc := newGroupParams(publicMcp.ID, group.ID)
 
-		handlePublicStreamable(c, publicMcp, paramsFunc)
+		handlePublicStreamable(
+			c,
+			publicMcp,
+			group.ID,
+			paramsFunc,
+			newToolCallRecorder(c, publicMcp),
+		)
 	}, func(c *gin.Context, mcpID string) {
 		group := middleware.GetGroup(c)
 
@@ -26,6 +26,18 @@ func handleSSEMCPServer(
 	s mcpservers.Server,
 	mcpType string,
 	endpoint EndpointProvider,
+) {
+	handleSSEMCPSession(c, s, mcpType, endpoint, nil)
+}
+
+// handleSSEMCPSession is handleSSEMCPServer with a hook called with the new
+// session, the returned func is called when the connection is closed
+func handleSSEMCPSession(
+	c *gin.Context,
+	s mcpservers.Server,
+	mcpType string,
+	endpoint EndpointProvider,
+	onSession func(session string) func(),
 ) {
 	// Store the session
 	store := getStore()
@@ -42,6 +54,10 @@ func handleSSEMCPServer(
 		store.Delete(newSession)
 	}()
 
+	if onSession != nil {
+		defer onSession(newSession)()
+	}
+
 	ctx, cancel := context.WithCancel(c.Request.Context())
 	defer cancel()
 
@@ -82,6 +98,7 @@ func handleEmbedSSEMCP(
 	config *model.MCPEmbeddingConfig,
 	paramsFunc ParamsFunc,
 	endpoint EndpointProvider,
+	recorder *toolCallRecorder,
 ) {
 	reusingConfig, err := prepareEmbedReusingConfig(mcpID, paramsFunc, config.Reusing)
 	if err != nil {
@@ -95,7 +112,7 @@ func handleEmbedSSEMCP(
 		return
 	}
 
-	handleSSEMCPServer(c, server, string(model.PublicMCPTypeEmbed), endpoint)
+	handleSSEMCPServer(c, recorder.wrap(server), string(model.PublicMCPTypeEmbed), endpoint)
 }
 
 // prepareEmbedReusingConfig 准备嵌入MCP的reusing配置
@@ -170,9 +187,12 @@ func handleStreamableMCPServer(c *gin.Context, s mcpservers.Server) {
 }
 
 func handleGroupStreamable(c *gin.Context, groupMcp *model.GroupMCPCache) {
+	guard := newToolCallPolicy(c).guard(groupMcp.ID)
+	sampling := newSamplingHandler(c, groupMcp.ID)
+
 	switch groupMcp.Type {
 	case model.GroupMCPTypeProxyStreamable:
-		handleGroupProxyStreamable(c, groupMcp.ProxyConfig)
+		handleGroupProxyStreamable(c, groupMcp.ProxyConfig, guard, sampling)
 	case model.GroupMCPTypeOpenAPI:
 		server, err := newOpenAPIMCPServer(groupMcp.OpenAPIConfig)
 		if err != nil {
@@ -185,7 +205,10 @@ func handleGroupStreamable(c *gin.Context, groupMcp *model.GroupMCPCache) {
 			return
 		}
 
-		handleStreamableMCPServer(c, server)
+		handleStreamableMCPServer(
+			c,
+			mcpproxy.GuardServer(mcpproxy.SamplingServer(server, sampling), guard),
+		)
 	default:
 		c.JSON(http.StatusBadRequest, mcpservers.CreateMCPErrorResponse(
 			mcp.NewRequestId(nil),
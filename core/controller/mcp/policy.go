@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"slices"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/reqlimit"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+)
+
+// toolCallPolicy is the mcp tool policy of the group and the token of the request,
+// a tool is callable when both allow it
+type toolCallPolicy struct {
+	group model.GroupCache
+	token model.TokenCache
+}
+
+func newToolCallPolicy(c *gin.Context) toolCallPolicy {
+	return toolCallPolicy{
+		group: middleware.GetGroup(c),
+		token: middleware.GetToken(c),
+	}
+}
+
+// allows reports whether the tool named `mcp_id__tool` can be called
+func (p toolCallPolicy) allows(name string) bool {
+	return p.group.AllowsMCPTool(name) && p.token.AllowsMCPTool(name)
+}
+
+func (p toolCallPolicy) empty() bool {
+	return len(p.group.MCPAllowTools) == 0 &&
+		len(p.group.MCPDenyTools) == 0 &&
+		len(p.group.MCPToolsRPM) == 0 &&
+		len(p.token.MCPAllowTools) == 0 &&
+		len(p.token.MCPDenyTools) == 0 &&
+		len(p.token.MCPToolsRPM) == 0
+}
+
+// guard returns the guard of the tool calls of the mcp, nil when there is no rule
+func (p toolCallPolicy) guard(mcpID string) mcpproxy.ToolCallGuard {
+	if p.empty() {
+		return nil
+	}
+
+	return func(ctx context.Context, tool string) *mcpproxy.ToolCallError {
+		name := mcpproxy.ToolName(mcpID, tool)
+		if !p.allows(name) {
+			return &mcpproxy.ToolCallError{
+				Code:    mcpproxy.ToolCallDeniedCode,
+				Message: fmt.Sprintf("tool %s is not allowed", name),
+			}
+		}
+
+		// the internal groups are not limited by the group rpm like the models
+		if p.group.Status != model.GroupStatusInternal {
+			for _, pattern := range matchedPatterns(p.group.MCPToolsRPM, name) {
+				rpm := p.group.MCPToolsRPM[pattern]
+
+				count, _, _ := reqlimit.PushGroupMCPToolRequest(ctx, p.group.ID, pattern, rpm)
+				if count > rpm {
+					return toolCallRateLimited(name, pattern, rpm)
+				}
+			}
+		}
+
+		for _, pattern := range matchedPatterns(p.token.MCPToolsRPM, name) {
+			rpm := p.token.MCPToolsRPM[pattern]
+
+			count, _, _ := reqlimit.PushGroupTokennameMCPToolRequest(
+				ctx,
+				p.group.ID,
+				p.token.Name,
+				pattern,
+				rpm,
+			)
+			if count > rpm {
+				return toolCallRateLimited(name, pattern, rpm)
+			}
+		}
+
+		return nil
+	}
+}
+
+// matchedPatterns returns the sorted patterns of the rpm limits matching the tool
+func matchedPatterns(limits map[string]int64, name string) []string {
+	var patterns []string
+	for _, pattern := range slices.Sorted(maps.Keys(limits)) {
+		if model.MatchMCPTool([]string{pattern}, name) {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+func toolCallRateLimited(name, pattern string, rpm int64) *mcpproxy.ToolCallError {
+	return &mcpproxy.ToolCallError{
+		Code:    mcpproxy.ToolCallRateLimitedCode,
+		Message: fmt.Sprintf("tool %s rate limit exceeded", name),
+		Data: map[string]any{
+			"pattern": pattern,
+			"rpm":     rpm,
+		},
+	}
+}
@@ -0,0 +1,83 @@
+package controller_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	controller "github.com/labring/aiproxy/core/controller/mcp"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newToolCallGuard returns the guard of the mcp by the policy of the request of the group and the token
+func newToolCallGuard(
+	group model.GroupCache,
+	token model.TokenCache,
+	mcpID string,
+) mcpproxy.ToolCallGuard {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/mcp/public/"+mcpID, nil)
+	c.Set(middleware.Group, group)
+	c.Set(middleware.Token, token)
+
+	return controller.ToolCallPolicyGuard(controller.NewToolCallPolicy(c), mcpID)
+}
+
+func TestToolCallGuard(t *testing.T) {
+	assert.Nil(t, newToolCallGuard(model.GroupCache{}, model.TokenCache{}, "github"))
+
+	guard := newToolCallGuard(
+		model.GroupCache{
+			ID:           "policy-test",
+			MCPDenyTools: []string{"*__delete_*"},
+			MCPToolsRPM:  map[string]int64{"github__*": 3},
+		},
+		model.TokenCache{
+			Name:          "t1",
+			MCPAllowTools: []string{"github__*"},
+			MCPToolsRPM:   map[string]int64{"github__search": 1},
+		},
+		"github",
+	)
+	require.NotNil(t, guard)
+
+	err := guard(t.Context(), "delete_repo")
+	require.NotNil(t, err)
+	assert.Equal(t, mcpproxy.ToolCallDeniedCode, err.Code)
+	assert.Equal(t, "tool github__delete_repo is not allowed", err.Message)
+
+	assert.Nil(t, guard(t.Context(), "search"))
+
+	err = guard(t.Context(), "search")
+	require.NotNil(t, err)
+	assert.Equal(t, mcpproxy.ToolCallRateLimitedCode, err.Code)
+
+	// the calls rejected by the token limit still count for the group limit
+	assert.Nil(t, guard(t.Context(), "create_issue"))
+
+	err = guard(t.Context(), "create_issue")
+	require.NotNil(t, err)
+	assert.Equal(t, mcpproxy.ToolCallRateLimitedCode, err.Code)
+	assert.Equal(t, "tool github__create_issue rate limit exceeded", err.Message)
+}
+
+func TestToolCallGuardInternalGroup(t *testing.T) {
+	guard := newToolCallGuard(
+		model.GroupCache{
+			ID:          "policy-test-internal",
+			Status:      model.GroupStatusInternal,
+			MCPToolsRPM: map[string]int64{"*": 1},
+		},
+		model.TokenCache{},
+		"github",
+	)
+
+	for range 3 {
+		assert.Nil(t, guard(t.Context(), "search"))
+	}
+}
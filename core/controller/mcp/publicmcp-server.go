@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"maps"
 	"net/http"
@@ -41,23 +42,40 @@ func PublicMCPSSEServer(c *gin.Context) {
 	group := middleware.GetGroup(c)
 	paramsFunc := newGroupParams(publicMcp.ID, group.ID)
 
-	handlePublicSSEMCP(c, publicMcp, paramsFunc, sseEndpoint)
+	handlePublicSSEMCP(
+		c,
+		publicMcp,
+		group.ID,
+		paramsFunc,
+		sseEndpoint,
+		newToolCallRecorder(c, publicMcp),
+	)
 }
 
+// handlePublicSSEMCP serves the public mcp over SSE, the tool calls are
+// recorded when the recorder is not nil
 func handlePublicSSEMCP(
 	c *gin.Context,
 	publicMcp *model.PublicMCPCache,
+	groupID string,
 	paramsFunc ParamsFunc,
 	endpoint EndpointProvider,
+	recorder *toolCallRecorder,
 ) {
 	switch publicMcp.Type {
 	case model.PublicMCPTypeProxySSE:
-		if err := handlePublicProxySSE(c, publicMcp, paramsFunc, endpoint); err != nil {
+		if err := handlePublicProxySSE(c, publicMcp, paramsFunc, endpoint, recorder); err != nil {
 			http.Error(c.Writer, err.Error(), http.StatusBadRequest)
 			return
 		}
 	case model.PublicMCPTypeProxyStreamable:
-		if err := handlePublicProxyStreamableSSE(c, publicMcp, paramsFunc, endpoint); err != nil {
+		if err := handlePublicProxyStreamableSSE(
+			c,
+			publicMcp,
+			paramsFunc,
+			endpoint,
+			recorder,
+		); err != nil {
 			http.Error(c.Writer, err.Error(), http.StatusBadRequest)
 			return
 		}
@@ -68,9 +86,23 @@ func handlePublicSSEMCP(
 			return
 		}
 
-		handleSSEMCPServer(c, server, string(model.PublicMCPTypeOpenAPI), endpoint)
+		handleSSEMCPServer(
+			c,
+			recorder.wrap(server),
+			string(model.PublicMCPTypeOpenAPI),
+			endpoint,
+		)
 	case model.PublicMCPTypeEmbed:
-		handleEmbedSSEMCP(c, publicMcp.ID, publicMcp.EmbedConfig, paramsFunc, endpoint)
+		handleEmbedSSEMCP(
+			c,
+			publicMcp.ID,
+			publicMcp.EmbedConfig,
+			paramsFunc,
+			endpoint,
+			recorder,
+		)
+	case model.PublicMCPTypeStdio:
+		handleStdioSSEMCP(c, publicMcp, groupID, paramsFunc, endpoint, recorder)
 	default:
 		http.Error(c.Writer, "unknown mcp type", http.StatusBadRequest)
 	}
@@ -82,6 +114,7 @@ func handlePublicProxySSE(
 	publicMcp *model.PublicMCPCache,
 	paramsFunc ParamsFunc,
 	endpoint EndpointProvider,
+	recorder *toolCallRecorder,
 ) error {
 	client, err := createProxySSEClient(c, publicMcp, paramsFunc)
 	if err != nil {
@@ -91,7 +124,7 @@ func handlePublicProxySSE(
 
 	handleSSEMCPServer(
 		c,
-		mcpservers.WrapMCPClient2Server(client),
+		recorder.wrap(mcpservers.WrapMCPClient2Server(client)),
 		string(model.PublicMCPTypeProxySSE),
 		endpoint,
 	)
@@ -105,6 +138,7 @@ func handlePublicProxyStreamableSSE(
 	publicMcp *model.PublicMCPCache,
 	paramsFunc ParamsFunc,
 	endpoint EndpointProvider,
+	recorder *toolCallRecorder,
 ) error {
 	client, err := createProxyStreamableClient(c, publicMcp, paramsFunc)
 	if err != nil {
@@ -114,7 +148,7 @@ func handlePublicProxyStreamableSSE(
 
 	handleSSEMCPServer(
 		c,
-		mcpservers.WrapMCPClient2Server(client),
+		recorder.wrap(mcpservers.WrapMCPClient2Server(client)),
 		string(model.PublicMCPTypeProxyStreamable),
 		endpoint,
 	)
@@ -122,6 +156,80 @@ func handlePublicProxyStreamableSSE(
 	return nil
 }
 
+// newPublicMCPServer creates the server of the public mcp, the returned func
+// closes the connection to the proxied mcp and is nil when there is none
+func newPublicMCPServer(
+	c *gin.Context,
+	publicMcp *model.PublicMCPCache,
+	groupID string,
+	paramsFunc ParamsFunc,
+) (mcpservers.Server, func(), error) {
+	switch publicMcp.Type {
+	case model.PublicMCPTypeProxySSE, model.PublicMCPTypeProxyStreamable:
+		if publicMcp.ProxyConfig == nil || publicMcp.ProxyConfig.URL == "" {
+			return nil, nil, errors.New("invalid proxy configuration")
+		}
+
+		var (
+			client transport.Interface
+			err    error
+		)
+
+		if publicMcp.Type == model.PublicMCPTypeProxySSE {
+			client, err = createProxySSEClient(c, publicMcp, paramsFunc)
+		} else {
+			client, err = createProxyStreamableClient(c, publicMcp, paramsFunc)
+		}
+
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return mcpservers.WrapMCPClient2Server(client), func() { _ = client.Close() }, nil
+	case model.PublicMCPTypeOpenAPI:
+		server, err := newOpenAPIMCPServer(publicMcp.OpenAPIConfig)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return server, nil, nil
+	case model.PublicMCPTypeEmbed:
+		if publicMcp.EmbedConfig == nil {
+			return nil, nil, errors.New("invalid embed configuration")
+		}
+
+		reusingConfig, err := prepareEmbedReusingConfig(
+			publicMcp.ID,
+			paramsFunc,
+			publicMcp.EmbedConfig.Reusing,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		server, err := mcpservers.GetMCPServer(
+			publicMcp.ID,
+			publicMcp.EmbedConfig.Init,
+			reusingConfig,
+		)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return server, nil, nil
+	case model.PublicMCPTypeStdio:
+		// the process is shared by the group and stopped by the manager
+		process, err := getStdioProcess(c.Request.Context(), publicMcp, groupID, paramsFunc)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		return process, nil, nil
+	default:
+		return nil, nil, errors.New("unknown mcp type")
+	}
+}
+
 // createProxySSEClient 创建代理SSE客户端
 func createProxySSEClient(
 	c *gin.Context,
@@ -161,6 +269,8 @@ func createProxyStreamableClient(
 		return nil, err
 	}
 
+	mcpproxy.EnableSampling(client)
+
 	if err := client.Start(c.Request.Context()); err != nil {
 		return nil, err
 	}
@@ -274,13 +384,23 @@ func PublicMCPStreamable(c *gin.Context) {
 	group := middleware.GetGroup(c)
 	paramsFunc := newGroupParams(publicMcp.ID, group.ID)
 
-	handlePublicStreamable(c, publicMcp, paramsFunc)
+	handlePublicStreamable(
+		c,
+		publicMcp,
+		group.ID,
+		paramsFunc,
+		newToolCallRecorder(c, publicMcp),
+	)
 }
 
+// handlePublicStreamable serves the public mcp over streamable http, the tool
+// calls are recorded when the recorder is not nil
 func handlePublicStreamable(
 	c *gin.Context,
 	publicMcp *model.PublicMCPCache,
+	groupID string,
 	paramsFunc ParamsFunc,
+	recorder *toolCallRecorder,
 ) {
 	switch publicMcp.Type {
 	case model.PublicMCPTypeProxySSE:
@@ -292,10 +412,10 @@ func handlePublicStreamable(
 		defer client.Close()
 
 		mcpproxy.NewStatelessStreamableHTTPServer(
-			mcpservers.WrapMCPClient2Server(client),
+			recorder.wrap(mcpservers.WrapMCPClient2Server(client)),
 		).ServeHTTP(c.Writer, c.Request)
 	case model.PublicMCPTypeProxyStreamable:
-		handlePublicProxyStreamable(c, paramsFunc, publicMcp.ProxyConfig)
+		handlePublicProxyStreamable(c, paramsFunc, publicMcp.ProxyConfig, recorder)
 	case model.PublicMCPTypeOpenAPI:
 		server, err := newOpenAPIMCPServer(publicMcp.OpenAPIConfig)
 		if err != nil {
@@ -308,9 +428,17 @@ func handlePublicStreamable(
 			return
 		}
 
-		handleStreamableMCPServer(c, server)
+		handleStreamableMCPServer(c, recorder.wrap(server))
 	case model.PublicMCPTypeEmbed:
-		handlePublicEmbedStreamable(c, publicMcp.ID, paramsFunc, publicMcp.EmbedConfig)
+		handlePublicEmbedStreamable(
+			c,
+			publicMcp.ID,
+			paramsFunc,
+			publicMcp.EmbedConfig,
+			recorder,
+		)
+	case model.PublicMCPTypeStdio:
+		handleStdioStreamable(c, publicMcp, groupID, paramsFunc, recorder)
 	default:
 		c.JSON(http.StatusBadRequest, mcpservers.CreateMCPErrorResponse(
 			mcp.NewRequestId(nil),
@@ -325,6 +453,7 @@ func handlePublicEmbedStreamable(
 	mcpID string,
 	paramsFunc ParamsFunc,
 	config *model.MCPEmbeddingConfig,
+	recorder *toolCallRecorder,
 ) {
 	var reusingConfig map[string]string
 	if len(config.Reusing) != 0 {
@@ -353,7 +482,7 @@ func handlePublicEmbedStreamable(
 		return
 	}
 
-	handleStreamableMCPServer(c, server)
+	handleStreamableMCPServer(c, recorder.wrap(server))
 }
 
 // handlePublicProxyStreamable processes Streamable proxy requests
@@ -361,6 +490,7 @@ func handlePublicProxyStreamable(
 	c *gin.Context,
 	paramsFunc ParamsFunc,
 	config *model.PublicMCPProxyConfig,
+	recorder *toolCallRecorder,
 ) {
 	if config == nil || config.URL == "" {
 		c.JSON(http.StatusBadRequest, mcpservers.CreateMCPErrorResponse(
@@ -409,8 +539,13 @@ func handlePublicProxyStreamable(
 	}
 
 	backendURL.RawQuery = backendQuery.Encode()
-	mcpproxy.NewStreamableProxy(backendURL.String(), headers, getStore()).
-		ServeHTTP(c.Writer, c.Request)
+	mcpproxy.NewStreamableProxy(
+		backendURL.String(),
+		headers,
+		getStore(),
+		mcpproxy.WithMessageObserver(recorder.observer()),
+		mcpproxy.WithStreamableSamplingHandler(recorder.samplingHandler()),
+	).ServeHTTP(c.Writer, c.Request)
 }
 
 // TestPublicMCPSSEServer godoc
@@ -446,5 +581,5 @@ func TestPublicMCPSSEServer(c *gin.Context) {
 
 	paramsFunc := newGroupParams(publicMcp.ID, groupID)
 
-	handlePublicSSEMCP(c, publicMcp, paramsFunc, sseEndpoint)
+	handlePublicSSEMCP(c, publicMcp, groupID, paramsFunc, sseEndpoint, nil)
 }
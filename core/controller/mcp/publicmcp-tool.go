@@ -185,6 +185,7 @@ func checkParamsIsFull(params model.Params, reusing map[string]model.ReusingPara
 func getPublicMCPTools(
 	ctx context.Context,
 	publicMcp model.PublicMCP,
+	groupID string,
 	testConfig model.TestConfig,
 	params map[string]string,
 	reusing map[string]model.ReusingParam,
@@ -216,6 +217,8 @@ func getPublicMCPTools(
 		return getProxySSEMCPTools(ctx, publicMcp, testConfig, params, reusing)
 	case model.PublicMCPTypeProxyStreamable:
 		return getProxyStreamableMCPTools(ctx, publicMcp, testConfig, params, reusing)
+	case model.PublicMCPTypeStdio:
+		return getStdioMCPTools(ctx, publicMcp, groupID, testConfig, params, reusing)
 	default:
 		return nil, nil
 	}
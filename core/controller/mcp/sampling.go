@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"context"
+
+	"github.com/gin-gonic/gin"
+	relaycontroller "github.com/labring/aiproxy/core/controller"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// newSamplingHandler answers the sampling requests of the mcp through the
+// relay with the models of the token of the request
+func newSamplingHandler(c *gin.Context, mcpID string) mcpproxy.SamplingHandler {
+	group := middleware.GetGroup(c)
+	token := middleware.GetToken(c)
+
+	return func(
+		ctx context.Context,
+		params mcp.CreateMessageParams,
+	) (*mcp.CreateMessageResult, error) {
+		return relaycontroller.RelayMCPSampling(ctx, group, token, mcpID, params)
+	}
+}
@@ -0,0 +1,195 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	"github.com/labring/aiproxy/core/model"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+var (
+	stdioManager     *mcpproxy.StdioManager
+	stdioManagerOnce sync.Once
+)
+
+func getStdioManager() *mcpproxy.StdioManager {
+	stdioManagerOnce.Do(func() {
+		stdioManager = mcpproxy.NewStdioManager(getStore())
+	})
+
+	return stdioManager
+}
+
+// CloseStdioMCPs stops the processes of the stdio mcps
+func CloseStdioMCPs() {
+	getStdioManager().Close()
+}
+
+// getStdioProcess returns the process of the stdio mcp for the group, the
+// reusing params of the group are passed to the process as env
+func getStdioProcess(
+	ctx context.Context,
+	publicMcp *model.PublicMCPCache,
+	groupID string,
+	paramsFunc ParamsFunc,
+) (*mcpproxy.StdioProcess, error) {
+	stdioConfig := publicMcp.StdioConfig
+	if stdioConfig == nil || stdioConfig.Command == "" {
+		return nil, errors.New("invalid stdio configuration")
+	}
+
+	reusingConfig, err := prepareEmbedReusingConfig(
+		publicMcp.ID,
+		paramsFunc,
+		stdioConfig.Reusing,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	env := maps.Clone(stdioConfig.Env)
+	if env == nil {
+		env = make(map[string]string, len(reusingConfig))
+	}
+
+	maps.Copy(env, reusingConfig)
+
+	// sorted to keep the same process while the env is not changed
+	envs := make([]string, 0, len(env))
+	for _, key := range slices.Sorted(maps.Keys(env)) {
+		envs = append(envs, key+"="+env[key])
+	}
+
+	idleTimeout := stdioConfig.IdleTimeout
+	if idleTimeout == 0 {
+		idleTimeout = config.GetMCPStdioIdleTimeout()
+	}
+
+	return getStdioManager().Get(
+		ctx,
+		publicMcp.ID,
+		groupID,
+		mcpproxy.StdioConfig{
+			Command:     stdioConfig.Command,
+			Args:        stdioConfig.Args,
+			Env:         envs,
+			IdleTimeout: time.Duration(idleTimeout) * time.Second,
+		},
+		mcpproxy.StdioLimits{
+			MaxProcesses:      int(config.GetMCPStdioMaxProcesses()),
+			MaxGroupProcesses: int(config.GetMCPStdioMaxGroupProcesses()),
+		},
+	)
+}
+
+// handleStdioSSEMCP serves the process of the group over SSE, the session is
+// closed when the process exits
+func handleStdioSSEMCP(
+	c *gin.Context,
+	publicMcp *model.PublicMCPCache,
+	groupID string,
+	paramsFunc ParamsFunc,
+	endpoint EndpointProvider,
+	recorder *toolCallRecorder,
+) {
+	process, err := getStdioProcess(c.Request.Context(), publicMcp, groupID, paramsFunc)
+	if err != nil {
+		http.Error(c.Writer, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	go func() {
+		select {
+		case <-process.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	c.Request = c.Request.WithContext(ctx)
+
+	handleSSEMCPSession(
+		c,
+		recorder.wrap(process),
+		string(model.PublicMCPTypeStdio),
+		endpoint,
+		func(session string) func() {
+			process.Attach(session)
+			return func() {
+				process.Detach(session)
+			}
+		},
+	)
+}
+
+// handleStdioStreamable serves the process of the group over stateless streamable http
+func handleStdioStreamable(
+	c *gin.Context,
+	publicMcp *model.PublicMCPCache,
+	groupID string,
+	paramsFunc ParamsFunc,
+	recorder *toolCallRecorder,
+) {
+	process, err := getStdioProcess(c.Request.Context(), publicMcp, groupID, paramsFunc)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, mcpservers.CreateMCPErrorResponse(
+			mcp.NewRequestId(nil),
+			mcp.INVALID_REQUEST,
+			err.Error(),
+		))
+
+		return
+	}
+
+	handleStreamableMCPServer(c, recorder.wrap(process))
+}
+
+func getStdioMCPTools(
+	ctx context.Context,
+	publicMcp model.PublicMCP,
+	groupID string,
+	testConfig model.TestConfig,
+	params map[string]string,
+	reusing map[string]model.ReusingParam,
+) ([]mcp.Tool, error) {
+	if publicMcp.StdioConfig == nil {
+		return nil, nil
+	}
+
+	// the test params run in their own process
+	var effectiveParams map[string]string
+	switch {
+	case testConfig.Enabled && checkParamsIsFull(testConfig.Params, reusing):
+		effectiveParams = testConfig.Params
+		groupID = ""
+	case checkParamsIsFull(params, reusing):
+		effectiveParams = params
+	default:
+		return nil, nil
+	}
+
+	process, err := getStdioProcess(
+		ctx,
+		publicMcp.ToPublicMCPCache(),
+		groupID,
+		staticParams(effectiveParams),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	return mcpservers.ListServerTools(ctx, process)
+}
@@ -0,0 +1,329 @@
+package controller
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/balance"
+	"github.com/labring/aiproxy/core/common/consume"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/mcp"
+	log "github.com/sirupsen/logrus"
+)
+
+const methodToolsCall = "tools/call"
+
+// toolCallRecorder records and bills the tools/call messages of a public mcp,
+// each call is logged with the mcp id as the model and the tool name in the
+// metadata, and the sampling requests of the mcp are answered through the relay
+type toolCallRecorder struct {
+	mcp      *model.PublicMCPCache
+	group    model.GroupCache
+	token    model.TokenCache
+	ip       string
+	endpoint string
+	guard    mcpproxy.ToolCallGuard
+	sampling mcpproxy.SamplingHandler
+}
+
+func newToolCallRecorder(c *gin.Context, publicMcp *model.PublicMCPCache) *toolCallRecorder {
+	return &toolCallRecorder{
+		mcp:      publicMcp,
+		group:    middleware.GetGroup(c),
+		token:    middleware.GetToken(c),
+		ip:       c.ClientIP(),
+		endpoint: c.Request.URL.Path,
+		guard:    newToolCallPolicy(c).guard(publicMcp.ID),
+		sampling: newSamplingHandler(c, publicMcp.ID),
+	}
+}
+
+func (r *toolCallRecorder) price(tool string) float64 {
+	if price, ok := r.mcp.Price.ToolsCallPrices[tool]; ok {
+		return price
+	}
+	return r.mcp.Price.DefaultToolsCallPrice
+}
+
+type toolCallMessage struct {
+	ID     any    `json:"id"`
+	Method string `json:"method"`
+	Params struct {
+		Name string `json:"name"`
+	} `json:"params"`
+}
+
+// begin starts a tool call when the message is a tools/call request, the
+// group balance is checked before a priced tool is called, a non-nil reject
+// is returned to the client instead of calling the tool
+func (r *toolCallRecorder) begin(
+	ctx context.Context,
+	message []byte,
+) (call *toolCall, reject mcp.JSONRPCMessage) {
+	var msg toolCallMessage
+	if err := sonic.Unmarshal(message, &msg); err != nil || msg.Method != methodToolsCall {
+		return nil, nil
+	}
+
+	call = &toolCall{
+		recorder:  r,
+		id:        fmt.Sprint(msg.ID),
+		tool:      msg.Params.Name,
+		price:     r.price(msg.Params.Name),
+		requestAt: time.Now(),
+	}
+
+	if call.price <= 0 || r.group.Status == model.GroupStatusInternal {
+		return call, nil
+	}
+
+	groupBalance, consumer, err := balance.GetGroupRemainBalance(ctx, r.group)
+	if err != nil {
+		log.Errorf("get group `%s` balance error: %v", r.group.ID, err)
+
+		return nil, mcpservers.CreateMCPErrorResponse(
+			msg.ID,
+			mcp.INTERNAL_ERROR,
+			fmt.Sprintf("get group `%s` balance error", r.group.ID),
+		)
+	}
+
+	if groupBalance < call.price {
+		return nil, mcpservers.CreateMCPErrorResponse(
+			msg.ID,
+			mcp.INVALID_REQUEST,
+			middleware.ErrGroupBalanceNotEnough.Error(),
+		)
+	}
+
+	call.consumer = consumer
+
+	return call, nil
+}
+
+// wrap records the tool calls handled by the server, the calls rejected by
+// the tool policy are not recorded
+func (r *toolCallRecorder) wrap(s mcpservers.Server) mcpservers.Server {
+	if r == nil {
+		return s
+	}
+
+	return mcpproxy.GuardServer(
+		&recordedServer{Server: mcpproxy.SamplingServer(s, r.sampling), recorder: r},
+		r.guard,
+	)
+}
+
+// samplingHandler returns the handler of the sampling requests, nil when the
+// calls are not recorded
+func (r *toolCallRecorder) samplingHandler() mcpproxy.SamplingHandler {
+	if r == nil {
+		return nil
+	}
+	return r.sampling
+}
+
+// observer records the tool calls posted through a streamable proxy
+func (r *toolCallRecorder) observer() mcpproxy.MessageObserver {
+	if r == nil {
+		return nil
+	}
+
+	return mcpproxy.GuardObserver(r.guard, r.observe)
+}
+
+// observe begins the tool calls of the posted messages, the proxy forwards the
+// posted body as is, so a batch is rejected as a whole when one of its calls
+// is rejected, and none of its calls is recorded
+func (r *toolCallRecorder) observe(
+	ctx context.Context,
+	request []byte,
+) (mcp.JSONRPCMessage, func([]byte)) {
+	messages := mcpproxy.SplitMessages(request)
+
+	var (
+		calls   []*toolCall
+		rejects = make(map[int]mcp.JSONRPCMessage)
+	)
+
+	for i, message := range messages {
+		call, reject := r.begin(ctx, message)
+		if reject != nil {
+			rejects[i] = reject
+			continue
+		}
+
+		if call != nil {
+			calls = append(calls, call)
+		}
+	}
+
+	if len(rejects) > 0 {
+		return mcpproxy.RejectBatch(messages, rejects), nil
+	}
+
+	if len(calls) == 0 {
+		return nil, nil
+	}
+
+	return nil, func(message []byte) {
+		if message == nil {
+			for _, call := range calls {
+				call.finish(nil)
+			}
+
+			return
+		}
+
+		for _, m := range mcpproxy.SplitMessages(message) {
+			for _, call := range calls {
+				call.finish(m)
+			}
+		}
+	}
+}
+
+type recordedServer struct {
+	mcpservers.Server
+	recorder *toolCallRecorder
+}
+
+func (s *recordedServer) HandleMessage(
+	ctx context.Context,
+	message json.RawMessage,
+) mcp.JSONRPCMessage {
+	call, reject := s.recorder.begin(ctx, message)
+	if reject != nil {
+		return reject
+	}
+
+	response := s.Server.HandleMessage(ctx, message)
+	if call == nil {
+		return response
+	}
+
+	if response == nil {
+		call.finish(nil)
+		return response
+	}
+
+	data, err := sonic.Marshal(response)
+	if err != nil {
+		call.finish(nil)
+		return response
+	}
+
+	call.finish(data)
+
+	return response
+}
+
+// toolCall is a tools/call request waiting for its response
+type toolCall struct {
+	recorder  *toolCallRecorder
+	id        string
+	tool      string
+	price     float64
+	consumer  balance.PostGroupConsumer
+	requestAt time.Time
+	once      sync.Once
+}
+
+type toolCallResponse struct {
+	ID    any `json:"id"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Result *struct {
+		IsError bool `json:"isError"`
+		Content []struct {
+			Text string `json:"text"`
+		} `json:"content"`
+	} `json:"result"`
+}
+
+// finish records the call with its response, the other messages are ignored,
+// nil means the call ended without a response
+func (c *toolCall) finish(message []byte) {
+	if message == nil {
+		c.once.Do(func() {
+			c.record(http.StatusInternalServerError, "no response of the tool call")
+		})
+
+		return
+	}
+
+	var resp toolCallResponse
+	if err := sonic.Unmarshal(message, &resp); err != nil ||
+		resp.ID == nil ||
+		fmt.Sprint(resp.ID) != c.id ||
+		(resp.Error == nil && resp.Result == nil) {
+		return
+	}
+
+	c.once.Do(func() {
+		switch {
+		case resp.Error != nil:
+			c.record(http.StatusInternalServerError, resp.Error.Message)
+		case resp.Result.IsError:
+			texts := make([]string, 0, len(resp.Result.Content))
+			for _, content := range resp.Result.Content {
+				if content.Text != "" {
+					texts = append(texts, content.Text)
+				}
+			}
+
+			c.record(http.StatusInternalServerError, strings.Join(texts, "\n"))
+		default:
+			c.record(http.StatusOK, "")
+		}
+	})
+}
+
+// record logs the call and charges the group, only the successful calls are charged
+func (c *toolCall) record(code int, content string) {
+	r := c.recorder
+
+	m := meta.NewMeta(
+		nil,
+		mode.MCPToolsCall,
+		r.mcp.ID,
+		model.ModelConfig{
+			Model: r.mcp.ID,
+			Type:  mode.MCPToolsCall,
+		},
+		meta.WithRequestID(middleware.GenRequestID(c.requestAt)),
+		meta.WithRequestAt(c.requestAt),
+		meta.WithGroup(r.group),
+		meta.WithToken(r.token),
+		meta.WithEndpoint(r.endpoint),
+	)
+
+	consume.AsyncConsume(
+		c.consumer,
+		code,
+		time.Now(),
+		m,
+		model.Usage{},
+		model.Price{PerRequestPrice: model.ZeroNullFloat64(c.price)},
+		content,
+		r.ip,
+		0,
+		nil,
+		true,
+		"",
+		map[string]string{"tool": c.tool},
+	)
+}
@@ -0,0 +1,84 @@
+package controller_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	controller "github.com/labring/aiproxy/core/controller/mcp"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestToolCallRecorderObserveBatch(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/mcp/public/github", nil)
+	c.Set(middleware.Group, model.GroupCache{ID: "g1"})
+	c.Set(middleware.Token, model.TokenCache{ID: 1, Name: "t1"})
+
+	recorder := controller.NewToolCallRecorder(c, &model.PublicMCPCache{
+		ID: "github",
+		Price: model.MCPPrice{
+			// more than the balance of the group
+			ToolsCallPrices: map[string]float64{"train": 1e9},
+		},
+	})
+
+	// the batch is rejected as a whole, every request of the batch is answered
+	reject, onResponse := controller.ToolCallRecorderObserve(recorder, t.Context(), []byte(`[
+		{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}},
+		{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"train"}},
+		{"jsonrpc":"2.0","method":"notifications/cancelled","params":{"requestId":0}},
+		{"jsonrpc":"2.0","id":3,"method":"tools/list"}
+	]`))
+	assert.Nil(t, onResponse)
+
+	data, err := sonic.Marshal(reject)
+	require.NoError(t, err)
+
+	var responses []struct {
+		ID    int `json:"id"`
+		Error struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	require.NoError(t, sonic.Unmarshal(data, &responses), string(data))
+	require.Len(t, responses, 3, string(data))
+
+	assert.Equal(t, 1, responses[0].ID)
+	assert.Equal(t, mcpproxy.BatchRejectedCode, responses[0].Error.Code)
+	assert.Equal(t, 2, responses[1].ID)
+	assert.Equal(t, mcp.INVALID_REQUEST, responses[1].Error.Code)
+	assert.Equal(t, middleware.ErrGroupBalanceNotEnough.Error(), responses[1].Error.Message)
+	assert.Equal(t, 3, responses[2].ID)
+	assert.Equal(t, mcpproxy.BatchRejectedCode, responses[2].Error.Code)
+
+	reject, onResponse = controller.ToolCallRecorderObserve(
+		recorder,
+		t.Context(),
+		[]byte(`{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"train"}}`),
+	)
+	assert.Nil(t, onResponse)
+
+	data, err = sonic.Marshal(reject)
+	require.NoError(t, err)
+	assert.JSONEq(
+		t,
+		`{"jsonrpc":"2.0","id":2,"error":{"code":-32600,"message":"balance not enough"}}`,
+		string(data),
+	)
+
+	reject, onResponse = controller.ToolCallRecorderObserve(recorder, t.Context(), []byte(`[
+		{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}},
+		{"jsonrpc":"2.0","id":3,"method":"tools/list"}
+	]`))
+	assert.Nil(t, reject)
+	assert.NotNil(t, onResponse)
+}
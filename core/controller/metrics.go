@@ -0,0 +1,172 @@
+package controller
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/metrics"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/monitor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+)
+
+const monitorCollectTimeout = 5 * time.Second
+
+var (
+	channelModelErrorRateDesc = prometheus.NewDesc(
+		"aiproxy_channel_model_error_rate",
+		"Current error rate of a model on a channel",
+		[]string{"channel", "model"},
+		nil,
+	)
+	bannedChannelsDesc = prometheus.NewDesc(
+		"aiproxy_banned_channels",
+		"Number of channels currently banned for a model",
+		[]string{"model"},
+		nil,
+	)
+	channelInFlightDesc = prometheus.NewDesc(
+		"aiproxy_channel_in_flight_requests",
+		"Number of in flight requests of a channel",
+		[]string{"channel"},
+		nil,
+	)
+	batchUpdateQueueSizeDesc = prometheus.NewDesc(
+		"aiproxy_batch_update_queue_size",
+		"Number of pending summary updates waiting for the next batch processing",
+		[]string{"type"},
+		nil,
+	)
+)
+
+// monitorCollector collects the channel states of the monitor and the
+// pending batch updates at scrape time, the channel and model labels are capped
+// like the relay metrics, the values beyond the cap are merged into "other"
+type monitorCollector struct{}
+
+// channelModelLabels are the capped labels of a channel model
+type channelModelLabels struct {
+	channel string
+	model   string
+}
+
+func (monitorCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, desc := range []*prometheus.Desc{
+		channelModelErrorRateDesc,
+		bannedChannelsDesc,
+		channelInFlightDesc,
+		batchUpdateQueueSizeDesc,
+	} {
+		ch <- desc
+	}
+}
+
+func (monitorCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), monitorCollectTimeout)
+	defer cancel()
+
+	rates, err := monitor.GetAllChannelModelErrorRates(ctx)
+	if err != nil {
+		log.Errorf("collect channel model error rates failed: %+v", err)
+	}
+
+	// the merged channel models report the highest error rate
+	errorRates := make(map[channelModelLabels]float64)
+
+	for channelID, models := range rates {
+		channel := metrics.ChannelLabel(int(channelID))
+		for model, rate := range models {
+			labels := channelModelLabels{channel: channel, model: metrics.ModelLabel(model)}
+			errorRates[labels] = max(errorRates[labels], rate)
+		}
+	}
+
+	for labels, rate := range errorRates {
+		ch <- prometheus.MustNewConstMetric(
+			channelModelErrorRateDesc,
+			prometheus.GaugeValue,
+			rate,
+			labels.channel,
+			labels.model,
+		)
+	}
+
+	banned, err := monitor.GetAllBannedModelChannels(ctx)
+	if err != nil {
+		log.Errorf("collect banned model channels failed: %+v", err)
+	}
+
+	bannedChannels := make(map[string]int)
+	for model, channels := range banned {
+		bannedChannels[metrics.ModelLabel(model)] += len(channels)
+	}
+
+	for model, count := range bannedChannels {
+		ch <- prometheus.MustNewConstMetric(
+			bannedChannelsDesc,
+			prometheus.GaugeValue,
+			float64(count),
+			model,
+		)
+	}
+
+	inFlight, err := monitor.GetAllChannelsInFlight(ctx)
+	if err != nil {
+		log.Errorf("collect channels in flight failed: %+v", err)
+	}
+
+	channelsInFlight := make(map[string]int64)
+	for channelID, f := range inFlight {
+		channelsInFlight[metrics.ChannelLabel(int(channelID))] += f.Total
+	}
+
+	for channel, total := range channelsInFlight {
+		ch <- prometheus.MustNewConstMetric(
+			channelInFlightDesc,
+			prometheus.GaugeValue,
+			float64(total),
+			channel,
+		)
+	}
+
+	sizes := model.GetBatchUpdateSizes()
+	for typ, size := range map[string]int{
+		"groups":                 sizes.Groups,
+		"tokens":                 sizes.Tokens,
+		"channels":               sizes.Channels,
+		"summaries":              sizes.Summaries,
+		"group_summaries":        sizes.GroupSummaries,
+		"summaries_minute":       sizes.SummariesMinute,
+		"group_summaries_minute": sizes.GroupSummariesMinute,
+	} {
+		ch <- prometheus.MustNewConstMetric(
+			batchUpdateQueueSizeDesc,
+			prometheus.GaugeValue,
+			float64(size),
+			typ,
+		)
+	}
+}
+
+var metricsHandler = sync.OnceValue(func() http.Handler {
+	metrics.Registry.MustRegister(monitorCollector{})
+	return promhttp.HandlerFor(metrics.Registry, promhttp.HandlerOpts{})
+})
+
+// Metrics godoc
+//
+//	@Summary		Prometheus metrics
+//	@Description	Returns the relay, channel and billing metrics in the Prometheus text format
+//	@Tags			monitor
+//	@Produce		plain
+//	@Security		ApiKeyAuth
+//	@Success		200	{string}	string
+//	@Router			/metrics [get]
+func Metrics(c *gin.Context) {
+	metricsHandler().ServeHTTP(c.Writer, c.Request)
+}
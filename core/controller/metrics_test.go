@@ -0,0 +1,66 @@
+package controller_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/controller"
+	"github.com/labring/aiproxy/core/monitor"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMonitorCollectorLabelLimit(t *testing.T) {
+	old := config.MetricsMaxLabelValues
+	config.MetricsMaxLabelValues = 1
+
+	t.Cleanup(func() {
+		config.MetricsMaxLabelValues = old
+	})
+
+	ctx := context.Background()
+	model := "test-monitor-collector"
+
+	// three channels share at most two channel labels, the merged series
+	// must not be collected twice
+	for _, channelID := range []int64{9001, 9002, 9003} {
+		_, _, err := monitor.AddRequest(ctx, model, channelID, true, false, 0, 0)
+		require.NoError(t, err)
+
+		done := monitor.AddInFlight(ctx, model, channelID)
+		t.Cleanup(done)
+	}
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(controller.MonitorCollector{})
+
+	families, err := registry.Gather()
+	require.NoError(t, err)
+
+	var inFlight float64
+
+	for _, family := range families {
+		switch family.GetName() {
+		case "aiproxy_channel_in_flight_requests":
+			for _, m := range family.GetMetric() {
+				inFlight += m.GetGauge().GetValue()
+			}
+		case "aiproxy_channel_model_error_rate":
+			for _, m := range family.GetMetric() {
+				for _, label := range m.GetLabel() {
+					if label.GetName() == "channel" {
+						assert.Contains(
+							t,
+							[]string{"9001", "9002", "9003", "other"},
+							label.GetValue(),
+						)
+					}
+				}
+			}
+		}
+	}
+
+	assert.InDelta(t, 3, inFlight, 0)
+}
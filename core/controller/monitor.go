@@ -166,3 +166,48 @@ func GetAllBannedModelChannels(c *gin.Context) {
 
 	middleware.SuccessResponse(c, channels)
 }
+
+// GetAllChannelsInFlight godoc
+//
+//	@Summary		Get all channels in flight requests
+//	@Description	Returns the in flight requests of all channels
+//	@Tags			monitor
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Success		200	{object}	middleware.APIResponse{data=map[int64]monitor.InFlight}
+//	@Router			/api/monitor/inflight [get]
+func GetAllChannelsInFlight(c *gin.Context) {
+	inFlight, err := monitor.GetAllChannelsInFlight(c.Request.Context())
+	if err != nil {
+		middleware.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	middleware.SuccessResponse(c, inFlight)
+}
+
+// GetChannelInFlight godoc
+//
+//	@Summary		Get channel in flight requests
+//	@Description	Returns the in flight requests of a channel
+//	@Tags			monitor
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id	path		int	true	"Channel ID"
+//	@Success		200	{object}	middleware.APIResponse{data=monitor.InFlight}
+//	@Router			/api/monitor/inflight/{id} [get]
+func GetChannelInFlight(c *gin.Context) {
+	channelID, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		middleware.ErrorResponse(c, http.StatusBadRequest, "Invalid channel ID")
+		return
+	}
+
+	inFlight, err := monitor.GetChannelInFlight(c.Request.Context(), channelID)
+	if err != nil {
+		middleware.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	middleware.SuccessResponse(c, inFlight)
+}
@@ -0,0 +1,137 @@
+package controller
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/labring/aiproxy/core/common/reqlimit"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/monitor"
+	log "github.com/sirupsen/logrus"
+)
+
+// channelLoads is the current load of the channels of a model, the channels
+// that reached their concurrency or rate limits are skipped
+type channelLoads struct {
+	now        time.Time
+	inFlight   map[int64]monitor.InFlight
+	rateLimits map[int64]monitor.RateLimit
+	rates      map[int64]reqlimit.ChannelModelRates
+}
+
+// getChannelLoads gets the loads of the channels, only the loads needed by
+// the limits of the channels or by the strategy are loaded, the loads of all
+// the channels are looked up together
+func getChannelLoads(
+	ctx context.Context,
+	channels []*model.Channel,
+	modelName string,
+	strategy *channelStrategy,
+) *channelLoads {
+	loads := &channelLoads{now: time.Now()}
+
+	needInFlight := strategy.needInFlight()
+	channelIDs := make([]int64, 0, len(channels))
+
+	for _, channel := range channels {
+		if channel.HasConcurrencyLimit(modelName) {
+			needInFlight = true
+		}
+
+		channelIDs = append(channelIDs, int64(channel.ID))
+	}
+
+	inFlight, rateLimits, err := monitor.GetModelChannelsLoad(
+		ctx,
+		modelName,
+		channelIDs,
+		needInFlight,
+	)
+	if err != nil {
+		log.Errorf("get %s channels load failed: %+v", modelName, err)
+	}
+
+	loads.inFlight = inFlight
+	loads.rateLimits = rateLimits
+
+	rateChannels := make([]string, 0, len(channels))
+
+	for _, channel := range channels {
+		limit := rateLimits[int64(channel.ID)]
+		if channel.HasRateLimit(modelName) || limit.RPM > 0 || limit.TPM > 0 {
+			rateChannels = append(rateChannels, strconv.Itoa(channel.ID))
+		}
+	}
+
+	if len(rateChannels) == 0 {
+		return loads
+	}
+
+	rates := reqlimit.GetChannelsModelRates(ctx, modelName, rateChannels)
+
+	loads.rates = make(map[int64]reqlimit.ChannelModelRates, len(rates))
+	for id, rate := range rates {
+		chid, err := strconv.ParseInt(id, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		loads.rates[chid] = rate
+	}
+
+	return loads
+}
+
+func (l *channelLoads) inFlightTotal(channelID int64) int64 {
+	if l == nil {
+		return 0
+	}
+	return l.inFlight[channelID].Total
+}
+
+// isSaturated reports whether the channel reached its max concurrency, its
+// configured or learned rate limits, or the upstream reported it exhausted
+func (l *channelLoads) isSaturated(channel *model.Channel, modelName string) bool {
+	if l == nil {
+		return false
+	}
+
+	chid := int64(channel.ID)
+
+	if inFlight, ok := l.inFlight[chid]; ok &&
+		channel.IsSaturated(modelName, inFlight.Total, inFlight.Model(modelName)) {
+		return true
+	}
+
+	limit := l.rateLimits[chid]
+	if limit.Exhausted(l.now) {
+		return true
+	}
+
+	rates, ok := l.rates[chid]
+	if !ok {
+		return false
+	}
+
+	return reachLimit(rates.RPM, channel.RPM) ||
+		reachLimit(rates.TPM, channel.TPM) ||
+		reachLimit(rates.ModelRPM, minLimit(channel.ModelRPM[modelName], limit.RPM)) ||
+		reachLimit(rates.ModelTPM, minLimit(channel.ModelTPM[modelName], limit.TPM))
+}
+
+func reachLimit(count, limit int64) bool {
+	return limit > 0 && count >= limit
+}
+
+// minLimit returns the lower of the limits, zero means no limit
+func minLimit(a, b int64) int64 {
+	switch {
+	case a <= 0:
+		return b
+	case b <= 0:
+		return a
+	default:
+		return min(a, b)
+	}
+}
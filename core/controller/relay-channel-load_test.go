@@ -0,0 +1,120 @@
+package controller_test
+
+import (
+	"testing"
+
+	"github.com/labring/aiproxy/core/common/reqlimit"
+	"github.com/labring/aiproxy/core/controller"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/monitor"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func channelIDs(channels []*model.Channel) []int {
+	ids := make([]int, 0, len(channels))
+	for _, ch := range channels {
+		ids = append(ids, ch.ID)
+	}
+
+	return ids
+}
+
+func TestFilterSaturatedChannels(t *testing.T) {
+	modelName := t.Name()
+	channels := []*model.Channel{
+		{ID: 201, Type: model.ChannelTypeOpenAI, MaxConcurrency: 1},
+		{
+			ID:                  202,
+			Type:                model.ChannelTypeOpenAI,
+			ModelMaxConcurrency: map[string]int64{modelName: 2},
+		},
+		{ID: 203, Type: model.ChannelTypeOpenAI},
+	}
+
+	for _, ch := range channels {
+		ch.Status = model.ChannelStatusEnabled
+	}
+
+	filter := func() []int {
+		loads := controller.GetChannelLoads(t.Context(), channels, modelName, nil)
+		return channelIDs(
+			controller.FilterChannels(channels, modelName, mode.ChatCompletions, nil, 0, loads),
+		)
+	}
+
+	assert.Equal(t, []int{201, 202, 203}, filter())
+
+	done1, ok := monitor.AcquireInFlight(t.Context(), modelName, 201, 1, 0)
+	require.True(t, ok)
+	// the selected channel is full, the concurrent request can not take it
+	_, ok = monitor.AcquireInFlight(t.Context(), modelName, 201, 1, 0)
+	assert.False(t, ok)
+	assert.Equal(t, []int{202, 203}, filter())
+
+	done2, ok := monitor.AcquireInFlight(t.Context(), modelName, 202, 0, 2)
+	require.True(t, ok)
+	assert.Equal(t, []int{202, 203}, filter())
+
+	done3, ok := monitor.AcquireInFlight(t.Context(), modelName, 202, 0, 2)
+	require.True(t, ok)
+	assert.Equal(t, []int{203}, filter())
+
+	// other models don't count against the model max concurrency
+	loads := controller.GetChannelLoads(t.Context(), channels, "other", nil)
+	assert.Equal(t, []int{202, 203}, channelIDs(
+		controller.FilterChannels(channels, "other", mode.ChatCompletions, nil, 0, loads),
+	))
+
+	done1()
+	done2()
+	done3()
+
+	assert.Equal(t, []int{201, 202, 203}, filter())
+}
+
+func TestFilterRateLimitedChannels(t *testing.T) {
+	modelName := t.Name()
+	channels := []*model.Channel{
+		{ID: 301, Type: model.ChannelTypeOpenAI, RPM: 2},
+		{ID: 302, Type: model.ChannelTypeOpenAI, ModelRPM: map[string]int64{modelName: 1}},
+		{ID: 303, Type: model.ChannelTypeOpenAI},
+		{ID: 304, Type: model.ChannelTypeOpenAI, ModelTPM: map[string]int64{modelName: 100}},
+	}
+
+	for _, ch := range channels {
+		ch.Status = model.ChannelStatusEnabled
+	}
+
+	filter := func() []int {
+		loads := controller.GetChannelLoads(t.Context(), channels, modelName, nil)
+		return channelIDs(
+			controller.FilterChannels(channels, modelName, mode.ChatCompletions, nil, 0, loads),
+		)
+	}
+
+	assert.Equal(t, []int{301, 302, 303, 304}, filter())
+
+	// the channel rpm counts the requests of the other models
+	_, _, ok := reqlimit.ReserveChannelModelRequest(t.Context(), "301", "other", 2, 0)
+	require.True(t, ok)
+	_, _, ok = reqlimit.ReserveChannelModelRequest(t.Context(), "301", modelName, 2, 0)
+	require.True(t, ok)
+	_, _, ok = reqlimit.ReserveChannelModelRequest(t.Context(), "301", modelName, 2, 0)
+	assert.False(t, ok)
+
+	_, _, ok = reqlimit.ReserveChannelModelRequest(t.Context(), "302", modelName, 0, 1)
+	require.True(t, ok)
+
+	reqlimit.PushChannelModelTokensRequest(t.Context(), "304", modelName, 100)
+
+	assert.Equal(t, []int{303}, filter())
+
+	// the learned rate limit of the upstream is applied to the model
+	require.NoError(t, monitor.SetRateLimit(t.Context(), modelName, 303, monitor.RateLimit{RPM: 1}))
+	_, _, ok = reqlimit.ReserveChannelModelRequest(t.Context(), "303", modelName, 0, 0)
+	require.True(t, ok)
+
+	assert.Empty(t, filter())
+}
@@ -4,7 +4,6 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"math/rand/v2"
 	"strconv"
 
 	"github.com/gin-gonic/gin"
@@ -83,7 +82,10 @@ func needPinChannel(m mode.Mode) bool {
 		mode.ResponsesGet,
 		mode.ResponsesDelete,
 		mode.ResponsesCancel,
-		mode.ResponsesInputItems:
+		mode.ResponsesInputItems,
+		mode.FilesGet,
+		mode.FilesDelete,
+		mode.FilesContent:
 		return true
 	default:
 		return false
@@ -140,10 +142,12 @@ var (
 )
 
 func getRandomChannel(
+	ctx context.Context,
 	mc *model.ModelCaches,
 	availableSet []string,
 	modelName string,
 	mode mode.Mode,
+	strategy *channelStrategy,
 	errorRates map[int64]float64,
 	maxErrorRate float64,
 	ignoreChannelMap ...map[int64]struct{},
@@ -188,8 +192,11 @@ func getRandomChannel(
 	}
 
 	channel, err := ignoreChannel(
+		ctx,
 		migratedChannels,
+		modelName,
 		mode,
+		strategy,
 		errorRates,
 		maxErrorRate,
 		ignoreChannelMap...,
@@ -198,21 +205,12 @@ func getRandomChannel(
 	return channel, migratedChannels, err
 }
 
-func getPriority(channel *model.Channel, errorRate float64) int32 {
-	priority := channel.GetPriority()
-
-	if errorRate > 1 {
-		errorRate = 1
-	} else if errorRate < 0.1 {
-		errorRate = 0.1
-	}
-
-	return int32(float64(priority) / errorRate)
-}
-
 func ignoreChannel(
+	ctx context.Context,
 	channels []*model.Channel,
+	modelName string,
 	mode mode.Mode,
+	strategy *channelStrategy,
 	errorRates map[int64]float64,
 	maxErrorRate float64,
 	ignoreChannelIDs ...map[int64]struct{},
@@ -221,52 +219,41 @@ func ignoreChannel(
 		return nil, ErrChannelsNotFound
 	}
 
-	channels = filterChannels(channels, mode, errorRates, maxErrorRate, ignoreChannelIDs...)
+	loads := getChannelLoads(ctx, channels, modelName, strategy)
+
+	channels = filterChannels(
+		channels,
+		modelName,
+		mode,
+		errorRates,
+		maxErrorRate,
+		loads,
+		ignoreChannelIDs...,
+	)
 	if len(channels) == 0 {
 		return nil, ErrChannelsExhausted
 	}
 
-	if len(channels) == 1 {
-		return channels[0], nil
-	}
-
-	var totalWeight int32
-
-	cachedPrioritys := make([]int32, len(channels))
-	for i, ch := range channels {
-		priority := getPriority(ch, errorRates[int64(ch.ID)])
-		totalWeight += priority
-		cachedPrioritys[i] = priority
-	}
-
-	if totalWeight == 0 {
-		return channels[rand.IntN(len(channels))], nil
-	}
-
-	r := rand.Int32N(totalWeight)
-	for i, ch := range channels {
-		r -= cachedPrioritys[i]
-		if r < 0 {
-			return ch, nil
-		}
-	}
-
-	return channels[rand.IntN(len(channels))], nil
+	return strategy.pick(channels, errorRates, loads), nil
 }
 
 func getChannelWithFallback(
+	ctx context.Context,
 	cache *model.ModelCaches,
 	availableSet []string,
 	modelName string,
 	mode mode.Mode,
+	strategy *channelStrategy,
 	errorRates map[int64]float64,
 	ignoreChannelIDs map[int64]struct{},
 ) (*model.Channel, []*model.Channel, error) {
 	channel, migratedChannels, err := getRandomChannel(
+		ctx,
 		cache,
 		availableSet,
 		modelName,
 		mode,
+		strategy,
 		errorRates,
 		maxRetryErrorRate,
 		ignoreChannelIDs,
@@ -280,10 +267,12 @@ func getChannelWithFallback(
 	}
 
 	return getRandomChannel(
+		ctx,
 		cache,
 		availableSet,
 		modelName,
 		mode,
+		strategy,
 		errorRates,
 		0,
 	)
@@ -295,6 +284,10 @@ type initialChannel struct {
 	ignoreChannelIDs  map[int64]struct{}
 	errorRates        map[int64]float64
 	migratedChannels  []*model.Channel
+	strategy          *channelStrategy
+	// failedChannelIDs are the channels of the failed hedged attempts,
+	// the retries skip them like the channel of the first attempt
+	failedChannelIDs map[int64]struct{}
 }
 
 func getInitialChannel(c *gin.Context, modelName string, m mode.Mode) (*initialChannel, error) {
@@ -353,11 +346,21 @@ func getInitialChannel(c *gin.Context, modelName string, m mode.Mode) (*initialC
 		log.Errorf("get channel model error rates failed: %+v", err)
 	}
 
+	strategy, err := newChannelStrategy(c.Request.Context(), mc, modelName)
+	if err != nil {
+		log.Errorf("get %s channel strategy stats failed: %+v", modelName, err)
+	}
+
+	log.Data["ch_strategy"] = strategy.String()
+	c.Set(middleware.ChannelStrategy, strategy.String())
+
 	channel, migratedChannels, err := getChannelWithFallback(
+		c.Request.Context(),
 		mc,
 		availableSet,
 		modelName,
 		m,
+		strategy,
 		errorRates,
 		ignoreChannelIDs,
 	)
@@ -370,22 +373,28 @@ func getInitialChannel(c *gin.Context, modelName string, m mode.Mode) (*initialC
 		ignoreChannelIDs: ignoreChannelIDs,
 		errorRates:       errorRates,
 		migratedChannels: migratedChannels,
+		strategy:         strategy,
 	}, nil
 }
 
-func getWebSearchChannel(
+// getPluginChannel returns a channel for the requests made by plugins
+func getPluginChannel(
 	ctx context.Context,
 	mc *model.ModelCaches,
 	modelName string,
+	m mode.Mode,
 ) (*model.Channel, error) {
 	ignoreChannelIDs, _ := monitor.GetBannedChannelsMapWithModel(ctx, modelName)
 	errorRates, _ := monitor.GetModelChannelErrorRate(ctx, modelName)
+	strategy, _ := newChannelStrategy(ctx, mc, modelName)
 
 	channel, _, err := getChannelWithFallback(
+		ctx,
 		mc,
 		nil,
 		modelName,
-		mode.ChatCompletions,
+		m,
+		strategy,
 		errorRates,
 		ignoreChannelIDs)
 	if err != nil {
@@ -395,7 +404,11 @@ func getWebSearchChannel(
 	return channel, nil
 }
 
-func getRetryChannel(state *retryState, currentRetry, totalRetries int) (*model.Channel, error) {
+func getRetryChannel(
+	ctx context.Context,
+	state *retryState,
+	currentRetry, totalRetries int,
+) (*model.Channel, error) {
 	if state.exhausted {
 		if state.lastHasPermissionChannel == nil {
 			return nil, ErrChannelsExhausted
@@ -411,12 +424,17 @@ func getRetryChannel(state *retryState, currentRetry, totalRetries int) (*model.
 		return state.lastHasPermissionChannel, nil
 	}
 
-	// For the last retry, filter out all previously failed channels if there are other options
-	if currentRetry == totalRetries-1 && len(state.failedChannelIDs) > 0 {
+	// For the last retry, filter out all previously failed channels if there are other options,
+	// the strategies that always pick the same channel filter them out on every retry
+	if (currentRetry == totalRetries-1 || state.strategy.avoidFailed()) &&
+		len(state.failedChannelIDs) > 0 {
 		// Check if there are channels available after filtering out failed channels
 		newChannel, err := ignoreChannel(
+			ctx,
 			state.migratedChannels,
+			state.meta.OriginModel,
 			state.meta.Mode,
+			state.strategy,
 			state.errorRates,
 			maxRetryErrorRate,
 			state.ignoreChannelIDs,
@@ -429,8 +447,11 @@ func getRetryChannel(state *retryState, currentRetry, totalRetries int) (*model.
 	}
 
 	newChannel, err := ignoreChannel(
+		ctx,
 		state.migratedChannels,
+		state.meta.OriginModel,
 		state.meta.Mode,
+		state.strategy,
 		state.errorRates,
 		maxRetryErrorRate,
 		state.ignoreChannelIDs,
@@ -456,9 +477,11 @@ func getRetryChannel(state *retryState, currentRetry, totalRetries int) (*model.
 
 func filterChannels(
 	channels []*model.Channel,
+	modelName string,
 	mode mode.Mode,
 	errorRates map[int64]float64,
 	maxErrorRate float64,
+	loads *channelLoads,
 	ignoreChannel ...map[int64]struct{},
 ) []*model.Channel {
 	filtered := make([]*model.Channel, 0)
@@ -486,6 +509,11 @@ func filterChannels(
 			}
 		}
 
+		// Filter out channels that reached their concurrency or rate limits
+		if loads.isSaturated(channel, modelName) {
+			continue
+		}
+
 		needIgnore := false
 
 		for _, ignores := range ignoreChannel {
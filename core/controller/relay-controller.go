@@ -6,8 +6,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"maps"
 	"math/rand/v2"
 	"net/http"
+	"slices"
 	"strconv"
 	"time"
 
@@ -18,8 +20,10 @@ import (
 	"github.com/labring/aiproxy/core/common/config"
 	"github.com/labring/aiproxy/core/common/consume"
 	"github.com/labring/aiproxy/core/common/conv"
+	"github.com/labring/aiproxy/core/common/tracing"
 	"github.com/labring/aiproxy/core/middleware"
 	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/monitor"
 	"github.com/labring/aiproxy/core/relay/adaptor"
 	"github.com/labring/aiproxy/core/relay/adaptors"
 	"github.com/labring/aiproxy/core/relay/controller"
@@ -28,16 +32,25 @@ import (
 	relaymodel "github.com/labring/aiproxy/core/relay/model"
 	"github.com/labring/aiproxy/core/relay/plugin"
 	"github.com/labring/aiproxy/core/relay/plugin/cache"
+	"github.com/labring/aiproxy/core/relay/plugin/contextoverflow"
+	"github.com/labring/aiproxy/core/relay/plugin/moderation"
 	monitorplugin "github.com/labring/aiproxy/core/relay/plugin/monitor"
 	"github.com/labring/aiproxy/core/relay/plugin/patch"
 	"github.com/labring/aiproxy/core/relay/plugin/streamfake"
+	"github.com/labring/aiproxy/core/relay/plugin/systemprompt"
 	"github.com/labring/aiproxy/core/relay/plugin/thinksplit"
 	"github.com/labring/aiproxy/core/relay/plugin/timeout"
 	websearch "github.com/labring/aiproxy/core/relay/plugin/web-search"
+	"github.com/labring/aiproxy/core/relay/utils"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // https://platform.openai.com/docs/api-reference/chat
 
+// XAiproxyFallbackModel tells the client the model that answered
+// when the requested model fell back to another model
+const XAiproxyFallbackModel = "X-Aiproxy-Fallback-Model"
+
 type (
 	RelayHandler    func(*gin.Context, *meta.Meta) *controller.HandleResult
 	GetRequestUsage func(*gin.Context, model.ModelConfig) (model.Usage, error)
@@ -84,13 +97,21 @@ func (s *storeImpl) SaveStore(store adaptor.StoreCache) error {
 }
 
 func wrapPlugin(ctx context.Context, mc *model.ModelCaches, a adaptor.Adaptor) adaptor.Adaptor {
-	return plugin.WrapperAdaptor(a,
+	getChannel := func(modelName string, m mode.Mode) (*model.Channel, error) {
+		return getPluginChannel(ctx, mc, modelName, m)
+	}
+
+	return plugin.WrapperAdaptor(
+		a,
 		monitorplugin.NewGroupMonitorPlugin(),
-		cache.NewCachePlugin(common.RDB),
+		moderation.NewModerationPlugin(getChannel),
+		systemprompt.NewSystemPromptPlugin(),
+		contextoverflow.NewContextOverflowPlugin(getChannel),
+		cache.NewCachePlugin(common.RDB, getChannel),
 		streamfake.NewStreamFakePlugin(),
 		timeout.NewTimeoutPlugin(),
 		websearch.NewWebSearchPlugin(func(modelName string) (*model.Channel, error) {
-			return getWebSearchChannel(ctx, mc, modelName)
+			return getPluginChannel(ctx, mc, modelName, mode.ChatCompletions)
 		}),
 		thinksplit.NewThinkPlugin(),
 		monitorplugin.NewChannelMonitorPlugin(),
@@ -115,6 +136,26 @@ func relayHandler(c *gin.Context, meta *meta.Meta, mc *model.ModelCaches) *contr
 
 	adaptor = wrapPlugin(c.Request.Context(), mc, adaptor)
 
+	// the channel filter skips the saturated channels, but the concurrent
+	// requests may have taken the last slots of the selected channel since
+	done, ok := monitor.AcquireInFlight(
+		c.Request.Context(),
+		meta.OriginModel,
+		int64(meta.Channel.ID),
+		meta.Channel.MaxConcurrency,
+		meta.Channel.ModelMaxConcurrency,
+	)
+	if !ok {
+		return &controller.HandleResult{
+			Error: relaymodel.WrapperErrorWithMessage(
+				meta.Mode,
+				http.StatusServiceUnavailable,
+				"the channel reached its max concurrency",
+			),
+		}
+	}
+	defer done()
+
 	return controller.Handle(adaptor, c, meta, adaptorStore)
 }
 
@@ -169,7 +210,23 @@ func RelayHelper(
 	meta *meta.Meta,
 	handel RelayHandler,
 ) (*controller.HandleResult, bool) {
+	// each attempt is a child span of the request, so the retries on
+	// other channels are siblings of the first attempt
+	ctx, span := tracing.Start(
+		c.Request.Context(),
+		"relay attempt",
+		attribute.Int("aiproxy.channel.id", meta.Channel.ID),
+		attribute.String("aiproxy.channel.name", meta.Channel.Name),
+		attribute.Int("aiproxy.channel.type", int(meta.Channel.Type)),
+		attribute.String("aiproxy.model", meta.OriginModel),
+		attribute.String("aiproxy.actual_model", meta.ActualModel),
+		attribute.Bool("aiproxy.retry", !meta.RetryAt.IsZero()),
+	)
+	meta.SetTraceContext(ctx)
+
 	result := handel(c, meta)
+	tracing.End(span, result.Error)
+
 	if result.Error == nil {
 		return result, false
 	}
@@ -198,8 +255,16 @@ func relay(c *gin.Context, mode mode.Mode, relayController RelayController) {
 	mc := middleware.GetModelConfig(c)
 
 	// Get initial channel
+	_, span := tracing.Start(c.Request.Context(), "select channel")
 	initialChannel, err := getInitialChannel(c, requestModel, mode)
+	tracing.End(span, err)
+
 	if err != nil || initialChannel == nil || initialChannel.channel == nil {
+		if c.Request.Header.Get(AIProxyChannelHeader) == "" &&
+			relayFallback(c, mode, relayController, nil, model.Price{}, nil, 0) {
+			return
+		}
+
 		middleware.AbortLogWithMessageWithMode(mode, c,
 			http.StatusServiceUnavailable,
 			"the upstream load is saturated, please try again later",
@@ -237,25 +302,53 @@ func relay(c *gin.Context, mode mode.Mode, relayController RelayController) {
 		meta.RequestUsage = requestUsage
 	}
 
-	gbc := middleware.GetGroupBalanceConsumerFromContext(c)
-	if !gbc.CheckBalance(consume.CalculateAmount(http.StatusOK, meta.RequestUsage, price)) {
+	// the estimated cost is reserved until the request is done, so the concurrent
+	// requests can not spend more than the remaining balance and quota
+	reservation, err := middleware.ReserveQuota(
+		c,
+		consume.CalculateAmount(
+			http.StatusOK,
+			estimateRequestUsage(c, mc, meta.RequestUsage),
+			price,
+		),
+	)
+	if err != nil {
+		errType := middleware.GroupBalanceNotEnough
+		if errors.Is(err, middleware.ErrTokenQuotaNotEnough) {
+			errType = middleware.TokenQuotaNotEnough
+		}
+
 		middleware.AbortLogWithMessageWithMode(mode, c,
 			http.StatusForbidden,
-			fmt.Sprintf("group (%s) balance not enough", gbc.Group),
-			relaymodel.WithType(middleware.GroupBalanceNotEnough),
+			err.Error(),
+			relaymodel.WithType(errType),
 		)
 
 		return
 	}
 
-	// First attempt
-	result, retry := RelayHelper(c, meta, relayController.Handler)
+	defer reservation.Settle(context.WithoutCancel(c.Request.Context()))
+
+	// First attempt, hedged with another channel when enabled
+	meta, result, retry := relayHedged(
+		c,
+		mode,
+		relayController.Handler,
+		initialChannel,
+		meta,
+		price,
+	)
 
 	retryTimes := int(config.GetRetryTimes())
 	if mc.RetryTimes > 0 {
 		retryTimes = int(mc.RetryTimes)
 	}
 
+	if retry && retryTimes == 0 && !initialChannel.designatedChannel &&
+		relayFallback(c, mode, relayController, meta, price, result, 0) {
+		return
+	}
+
 	if handleRelayResult(c, result.Error, retry, retryTimes) {
 		recordResult(
 			c,
@@ -281,7 +374,98 @@ func relay(c *gin.Context, mode mode.Mode, relayController RelayController) {
 	)
 
 	// Retry loop
-	retryLoop(c, mode, retryState, relayController.Handler)
+	retryLoop(c, mode, retryState, relayController)
+}
+
+// relayFallback relays the request to the fallback model when the channels of
+// the requested model are exhausted, the last failed attempt is recorded as a
+// retry of the fallback hop
+func relayFallback(
+	c *gin.Context,
+	mode mode.Mode,
+	relayController RelayController,
+	lastMeta *meta.Meta,
+	lastPrice model.Price,
+	lastResult *controller.HandleResult,
+	retryTimes int,
+) bool {
+	if c.Request.Context().Err() != nil {
+		return false
+	}
+
+	mc := middleware.GetModelConfig(c)
+
+	fallback, ok := getFallbackModelConfig(c, mode, mc)
+	if !ok {
+		return false
+	}
+
+	log := common.GetLogger(c)
+
+	if lastMeta != nil {
+		if err := prepareRetry(c); err != nil {
+			log.Errorf("prepare fallback failed: %+v", err)
+			return false
+		}
+
+		lastMeta.FallbackModel = fallback.Model
+		recordResult(
+			c,
+			lastMeta,
+			lastPrice,
+			lastResult,
+			retryTimes,
+			false,
+			middleware.GetRequestUser(c),
+			middleware.GetRequestMetadata(c),
+		)
+	}
+
+	log.Warnf("channels of model %s are exhausted, fallback to %s", mc.Model, fallback.Model)
+
+	c.Set(middleware.FallbackFrom, append(c.GetStringSlice(middleware.FallbackFrom), mc.Model))
+	c.Set(middleware.RequestModel, fallback.Model)
+	c.Set(middleware.ModelConfig, fallback)
+
+	log.Data["fallback_from"] = mc.Model
+	middleware.SetLogModelFields(log.Data, fallback.Model)
+
+	c.Header(XAiproxyFallbackModel, fallback.Model)
+
+	relay(c, mode, relayController)
+
+	return true
+}
+
+// getFallbackModelConfig returns the group adjusted config of the fallback
+// model, a model already fallen back from is not used again, and the token
+// must have access to the fallback model
+func getFallbackModelConfig(
+	c *gin.Context,
+	mode mode.Mode,
+	mc model.ModelConfig,
+) (model.ModelConfig, bool) {
+	if mc.FallbackModel == "" ||
+		slices.Contains(c.GetStringSlice(middleware.FallbackFrom), mc.FallbackModel) {
+		return model.ModelConfig{}, false
+	}
+
+	token := middleware.GetToken(c)
+	if token.FindModel(mc.FallbackModel) == "" {
+		return model.ModelConfig{}, false
+	}
+
+	fallback, ok := middleware.GetModelCaches(c).ModelConfig.GetModelConfig(mc.FallbackModel)
+	if !ok || fallback.IsAlias() || !middleware.CheckRelayMode(mode, fallback.Type) {
+		return model.ModelConfig{}, false
+	}
+
+	if err := middleware.CheckTokenModelBudget(c, fallback.Model); err != nil {
+		common.GetLogger(c).Warnf("skip fallback model: %v", err)
+		return model.ModelConfig{}, false
+	}
+
+	return middleware.GetGroupAdjustedModelConfig(middleware.GetGroup(c), fallback), true
 }
 
 // recordResult records the consumption for the final result
@@ -326,6 +510,8 @@ func recordResult(
 		log.Data["amount"] = strconv.FormatFloat(amount, 'f', -1, 64)
 	}
 
+	middleware.GetQuotaReservation(c).AddConsumed(amount)
+
 	consume.AsyncConsume(
 		gbc.Consumer,
 		code,
@@ -341,6 +527,63 @@ func recordResult(
 		user,
 		metadata,
 	)
+
+	recordModelCalls(c, meta, gbc, user, metadata)
+}
+
+// recordModelCalls records the consumption of the models called by the
+// plugins for the request, such as the embeddings of the semantic cache,
+// with the price of the called model
+func recordModelCalls(
+	c *gin.Context,
+	meta *meta.Meta,
+	gbc *middleware.GroupBalanceConsumer,
+	user string,
+	metadata map[string]string,
+) {
+	for _, call := range plugin.GetModelCalls(meta) {
+		mc, _ := middleware.GetModelCaches(c).ModelConfig.GetModelConfig(call.Meta.OriginModel)
+		price := middleware.GetGroupAdjustedModelConfig(middleware.GetGroup(c), mc).Price
+
+		middleware.GetQuotaReservation(c).AddConsumed(
+			consume.CalculateAmount(http.StatusOK, call.Usage, price),
+		)
+
+		consume.AsyncConsume(
+			gbc.Consumer,
+			http.StatusOK,
+			time.Time{},
+			call.Meta,
+			call.Usage,
+			price,
+			"",
+			c.ClientIP(),
+			0,
+			nil,
+			true,
+			user,
+			metadata,
+		)
+	}
+}
+
+// estimateRequestUsage adds the max output tokens of the request to the usage,
+// the max output tokens of the model are used when the request does not set it
+func estimateRequestUsage(c *gin.Context, mc model.ModelConfig, usage model.Usage) model.Usage {
+	if usage.InputTokens == 0 || usage.OutputTokens != 0 {
+		return usage
+	}
+
+	maxTokens := utils.GetRequestMaxOutputTokens(c.Request)
+	if maxTokens == 0 {
+		if maxOutputTokens, ok := mc.MaxOutputTokens(); ok {
+			maxTokens = int64(maxOutputTokens)
+		}
+	}
+
+	usage.OutputTokens = model.ZeroNullInt64(maxTokens)
+
+	return usage
 }
 
 type retryState struct {
@@ -356,6 +599,8 @@ type retryState struct {
 	requestUsage     model.Usage
 	result           *controller.HandleResult
 	migratedChannels []*model.Channel
+	strategy         *channelStrategy
+	designated       bool
 }
 
 func handleRelayResult(
@@ -394,14 +639,17 @@ func initRetryState(
 		price:            price,
 		requestUsage:     meta.RequestUsage,
 		migratedChannels: channel.migratedChannels,
+		strategy:         channel.strategy,
 		failedChannelIDs: make(map[int64]struct{}),
 	}
 
 	// Record initial failed channel
 	state.failedChannelIDs[int64(meta.Channel.ID)] = struct{}{}
+	maps.Copy(state.failedChannelIDs, channel.failedChannelIDs)
 
 	if channel.designatedChannel {
 		state.exhausted = true
+		state.designated = true
 	}
 
 	if !monitorplugin.ChannelHasPermission(result.Error) {
@@ -417,7 +665,12 @@ func initRetryState(
 	return state
 }
 
-func retryLoop(c *gin.Context, mode mode.Mode, state *retryState, relayController RelayHandler) {
+func retryLoop(
+	c *gin.Context,
+	mode mode.Mode,
+	state *retryState,
+	relayController RelayController,
+) {
 	log := common.GetLogger(c)
 
 	// do not use for i := range state.retryTimes, because the retryTimes is constant
@@ -427,7 +680,10 @@ func retryLoop(c *gin.Context, mode mode.Mode, state *retryState, relayControlle
 		lastStatusCode := state.result.Error.StatusCode()
 		lastChannelID := state.meta.Channel.ID
 
-		newChannel, err := getRetryChannel(state, i, state.retryTimes)
+		_, span := tracing.Start(c.Request.Context(), "select retry channel")
+		newChannel, err := getRetryChannel(c.Request.Context(), state, i, state.retryTimes)
+		tracing.End(span, err)
+
 		if err == nil {
 			err = prepareRetry(c)
 		}
@@ -436,6 +692,11 @@ func retryLoop(c *gin.Context, mode mode.Mode, state *retryState, relayControlle
 			if !errors.Is(err, ErrChannelsExhausted) {
 				log.Errorf("prepare retry failed: %+v", err)
 			}
+
+			if state.meta != nil && state.result != nil && !state.designated &&
+				relayFallback(c, mode, relayController, state.meta, state.price, state.result, i) {
+				return
+			}
 			// when the last request has not recorded the result, record the result
 			if state.meta != nil && state.result != nil {
 				recordResult(
@@ -492,10 +753,15 @@ func retryLoop(c *gin.Context, mode mode.Mode, state *retryState, relayControlle
 
 		var retry bool
 
-		state.result, retry = RelayHelper(c, state.meta, relayController)
+		state.result, retry = RelayHelper(c, state.meta, relayController.Handler)
 
 		done := handleRetryResult(c, retry, newChannel, state)
 
+		if !done && i == state.retryTimes-1 && !state.designated &&
+			relayFallback(c, mode, relayController, state.meta, state.price, state.result, i+1) {
+			return
+		}
+
 		// Record failed channel if retry is needed
 		if !done && state.result.Error != nil {
 			state.failedChannelIDs[int64(newChannel.ID)] = struct{}{}
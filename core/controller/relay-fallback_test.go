@@ -0,0 +1,84 @@
+package controller_test
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/controller"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/stretchr/testify/assert"
+)
+
+func fallbackContext(token model.TokenCache, fallbackFrom ...string) *gin.Context {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	token.SetAvailableSets([]string{"default"})
+	token.SetModelsBySet(map[string][]string{
+		"default": {"gpt-4o", "gpt-4o-mini"},
+	})
+
+	c.Set(middleware.Token, token)
+	c.Set(middleware.Group, model.GroupCache{ID: "test"})
+	c.Set(middleware.ModelCaches, &model.ModelCaches{
+		ModelConfig: modelConfigs{
+			"gpt-4o":      {Model: "gpt-4o", Type: mode.ChatCompletions},
+			"gpt-4o-mini": {Model: "gpt-4o-mini", Type: mode.ChatCompletions},
+			"claude":      {Model: "claude", Type: mode.ChatCompletions},
+		},
+	})
+
+	if len(fallbackFrom) != 0 {
+		c.Set(middleware.FallbackFrom, fallbackFrom)
+	}
+
+	return c
+}
+
+func TestGetFallbackModelConfig(t *testing.T) {
+	mc := model.ModelConfig{Model: "gpt-4o", FallbackModel: "gpt-4o-mini"}
+
+	fallback, ok := controller.GetFallbackModelConfig(
+		fallbackContext(model.TokenCache{}),
+		mode.ChatCompletions,
+		mc,
+	)
+	assert.True(t, ok)
+	assert.Equal(t, "gpt-4o-mini", fallback.Model)
+
+	// the fallback model is not on the endpoint
+	_, ok = controller.GetFallbackModelConfig(
+		fallbackContext(model.TokenCache{}),
+		mode.Embeddings,
+		mc,
+	)
+	assert.False(t, ok)
+
+	// the fallback model was already fallen back from
+	_, ok = controller.GetFallbackModelConfig(
+		fallbackContext(model.TokenCache{}, "gpt-4o-mini"),
+		mode.ChatCompletions,
+		mc,
+	)
+	assert.False(t, ok)
+}
+
+func TestGetFallbackModelConfigAccess(t *testing.T) {
+	// the token is limited to the requested model
+	_, ok := controller.GetFallbackModelConfig(
+		fallbackContext(model.TokenCache{Models: []string{"gpt-4o"}}),
+		mode.ChatCompletions,
+		model.ModelConfig{Model: "gpt-4o", FallbackModel: "gpt-4o-mini"},
+	)
+	assert.False(t, ok)
+
+	// the fallback model is not in the available sets of the group
+	_, ok = controller.GetFallbackModelConfig(
+		fallbackContext(model.TokenCache{}),
+		mode.ChatCompletions,
+		model.ModelConfig{Model: "gpt-4o", FallbackModel: "claude"},
+	)
+	assert.False(t, ok)
+}
@@ -0,0 +1,364 @@
+package controller
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"maps"
+	"net/http/httptest"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/monitor"
+	"github.com/labring/aiproxy/core/relay/controller"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+	monitorplugin "github.com/labring/aiproxy/core/relay/plugin/monitor"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultHedgePercentile = 95
+	defaultHedgeDelay      = time.Second
+	// hedgeCanceledStatusCode is the code of the hedged request canceled
+	// after another channel answered, like the client closed request of nginx
+	hedgeCanceledStatusCode = 499
+)
+
+// hedgeAttempt is a relay attempt on its own gin context, the response is
+// buffered until the attempt wins
+type hedgeAttempt struct {
+	c       *gin.Context
+	w       *httptest.ResponseRecorder
+	cancel  context.CancelFunc
+	channel *model.Channel
+	meta    *meta.Meta
+	result  *controller.HandleResult
+	retry   bool
+	header  chan struct{}
+}
+
+// getHedgeDelay returns the delay before firing the hedged request, the
+// hedging is only applied to the non-stream json requests
+func getHedgeDelay(c *gin.Context, mc model.ModelConfig, requestBody []byte) (time.Duration, bool) {
+	hedge := mc.Hedge
+	if hedge == nil || !hedge.Enabled || len(requestBody) == 0 {
+		return 0, false
+	}
+
+	if node, err := sonic.Get(requestBody, "stream"); err == nil {
+		if stream, _ := node.Bool(); stream {
+			return 0, false
+		}
+	}
+
+	percentile := hedge.Percentile
+	if percentile == 0 {
+		percentile = defaultHedgePercentile
+	}
+
+	delay, ok := monitor.GetModelLatencyPercentile(mc.Model, percentile)
+	if !ok {
+		delay = defaultHedgeDelay
+		if hedge.MaxDelay > 0 {
+			delay = time.Duration(hedge.MaxDelay) * time.Millisecond
+		}
+	}
+
+	if hedge.MinDelay > 0 {
+		delay = max(delay, time.Duration(hedge.MinDelay)*time.Millisecond)
+	}
+
+	if hedge.MaxDelay > 0 {
+		delay = min(delay, time.Duration(hedge.MaxDelay)*time.Millisecond)
+	}
+
+	common.GetLogger(c).Data["hedge_delay"] = delay.String()
+
+	return delay, true
+}
+
+// relayHedged relays the first attempt, when the channel has not received the
+// response headers after the hedge delay a second request is fired to another
+// channel, the first finished one wins and the other is canceled, only the
+// winner is billed and the loser is recorded as a retry
+func relayHedged(
+	c *gin.Context,
+	mode mode.Mode,
+	handler RelayHandler,
+	initial *initialChannel,
+	firstMeta *meta.Meta,
+	price model.Price,
+) (*meta.Meta, *controller.HandleResult, bool) {
+	requestBody, err := common.GetRequestBodyReusable(c.Request)
+	if err != nil || initial.designatedChannel {
+		result, retry := RelayHelper(c, firstMeta, handler)
+		return firstMeta, result, retry
+	}
+
+	delay, ok := getHedgeDelay(c, firstMeta.ModelConfig, requestBody)
+	if !ok {
+		result, retry := RelayHelper(c, firstMeta, handler)
+		return firstMeta, result, retry
+	}
+
+	done := make(chan *hedgeAttempt, 2)
+
+	first := startHedgeAttempt(c, handler, initial.channel, firstMeta, requestBody, false, done)
+	attempts := []*hedgeAttempt{first}
+
+	defer func() {
+		for _, attempt := range attempts {
+			attempt.cancel()
+		}
+	}()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var (
+		winner *hedgeAttempt
+		failed []*hedgeAttempt
+	)
+
+	header := first.header
+	hedge := timer.C
+
+	for pending := 1; winner == nil && pending > 0; {
+		select {
+		case <-header:
+			header = nil
+			hedge = nil
+		case <-hedge:
+			hedge = nil
+			header = nil
+
+			second := startSecondHedgeAttempt(
+				c,
+				mode,
+				handler,
+				initial,
+				firstMeta,
+				requestBody,
+				done,
+			)
+			if second != nil {
+				attempts = append(attempts, second)
+				pending++
+			}
+		case attempt := <-done:
+			pending--
+
+			if attempt.result.Error == nil || !attempt.retry {
+				winner = attempt
+			} else {
+				failed = append(failed, attempt)
+			}
+		}
+	}
+
+	// all attempts failed, the last failed one goes on with the retries
+	if winner == nil {
+		last := failed[len(failed)-1]
+		for _, attempt := range failed[:len(failed)-1] {
+			recordHedgeAttempt(c, attempt, price)
+			skipHedgeChannel(initial, attempt)
+		}
+
+		initial.channel = last.channel
+
+		writeHedgeAttempt(c, last)
+
+		return last.meta, last.result, last.retry
+	}
+
+	for _, attempt := range attempts {
+		if attempt != winner {
+			attempt.cancel()
+		}
+	}
+
+	writeHedgeAttempt(c, winner)
+
+	for _, attempt := range failed {
+		recordHedgeAttempt(c, attempt, price)
+	}
+
+	// wait for the canceled attempts so the context is not used after the request,
+	// the usage of a canceled attempt is not billed even if it has finished
+	for range len(attempts) - len(failed) - 1 {
+		loser := <-done
+		loser.result = &controller.HandleResult{
+			Detail: loser.result.Detail,
+			Error: relaymodel.WrapperErrorWithMessage(
+				mode,
+				hedgeCanceledStatusCode,
+				"hedged request canceled, another channel answered first",
+			),
+		}
+		recordHedgeAttempt(c, loser, price)
+	}
+
+	return winner.meta, winner.result, winner.retry
+}
+
+func startSecondHedgeAttempt(
+	c *gin.Context,
+	mode mode.Mode,
+	handler RelayHandler,
+	initial *initialChannel,
+	firstMeta *meta.Meta,
+	requestBody []byte,
+	done chan *hedgeAttempt,
+) *hedgeAttempt {
+	log := common.GetLogger(c)
+	group := middleware.GetGroup(c)
+
+	channel, _, err := getRandomChannel(
+		c.Request.Context(),
+		middleware.GetModelCaches(c),
+		group.GetAvailableSets(),
+		middleware.GetRequestModel(c),
+		mode,
+		initial.strategy,
+		initial.errorRates,
+		maxRetryErrorRate,
+		initial.ignoreChannelIDs,
+		map[int64]struct{}{int64(initial.channel.ID): {}},
+	)
+	if err != nil {
+		log.Debugf("no channel to hedge: %+v", err)
+		return nil
+	}
+
+	log.Warnf("channel %s (id: %d) has not responded, hedging with channel %s (id: %d)",
+		initial.channel.Name,
+		initial.channel.ID,
+		channel.Name,
+		channel.ID,
+	)
+
+	hedgeMeta := NewMetaByContext(
+		c,
+		channel,
+		mode,
+		meta.WithRequestUsage(firstMeta.RequestUsage),
+		meta.WithRetryAt(time.Now()),
+	)
+
+	return startHedgeAttempt(c, handler, channel, hedgeMeta, requestBody, true, done)
+}
+
+func startHedgeAttempt(
+	c *gin.Context,
+	handler RelayHandler,
+	channel *model.Channel,
+	m *meta.Meta,
+	requestBody []byte,
+	hedged bool,
+	done chan *hedgeAttempt,
+) *hedgeAttempt {
+	w := httptest.NewRecorder()
+	hc, _ := gin.CreateTestContext(w)
+
+	ctx, cancelRequest := context.WithCancel(c.Request.Context())
+	// like the plain relay the upstream request is not canceled by the client,
+	// only the loser of the hedging is canceled and it is not billed
+	upstreamCtx, cancelUpstream := context.WithCancel(context.WithoutCancel(ctx))
+
+	cancel := func() {
+		cancelRequest()
+		cancelUpstream()
+	}
+
+	req := c.Request.Clone(ctx)
+	req.Body = io.NopCloser(bytes.NewReader(requestBody))
+
+	// each attempt logs its own fields, the winner ones are merged back
+	fields := logrus.Fields{}
+	if hedged {
+		fields["hedge"] = "true"
+	}
+
+	common.SetLogger(req, common.GetLogger(c).WithFields(fields))
+
+	hc.Request = req
+	hc.Keys = maps.Clone(c.Keys)
+
+	attempt := &hedgeAttempt{
+		c:       hc,
+		w:       w,
+		cancel:  cancel,
+		channel: channel,
+		meta:    m,
+		header:  make(chan struct{}),
+	}
+
+	m.SetUpstreamContext(upstreamCtx)
+	m.SetResponseHeaderNotify(sync.OnceFunc(func() {
+		close(attempt.header)
+	}))
+
+	go func() {
+		attempt.result, attempt.retry = RelayHelper(hc, m, handler)
+
+		done <- attempt
+	}()
+
+	return attempt
+}
+
+// writeHedgeAttempt writes the buffered response of the attempt and merges its log fields
+func writeHedgeAttempt(c *gin.Context, attempt *hedgeAttempt) {
+	maps.Copy(common.GetLogger(c).Data, common.GetLogger(attempt.c).Data)
+
+	maps.Copy(c.Writer.Header(), attempt.w.Header())
+
+	// the error is written by the caller
+	if attempt.result.Error != nil || !attempt.c.Writer.Written() {
+		return
+	}
+
+	c.Writer.WriteHeader(attempt.w.Code)
+	_, _ = c.Writer.Write(attempt.w.Body.Bytes())
+	c.Writer.Flush()
+}
+
+// skipHedgeChannel keeps the retries off the channel of a failed attempt
+func skipHedgeChannel(initial *initialChannel, attempt *hedgeAttempt) {
+	channelID := int64(attempt.channel.ID)
+
+	if initial.failedChannelIDs == nil {
+		initial.failedChannelIDs = make(map[int64]struct{})
+	}
+
+	initial.failedChannelIDs[channelID] = struct{}{}
+
+	if !monitorplugin.ChannelHasPermission(attempt.result.Error) {
+		if initial.ignoreChannelIDs == nil {
+			initial.ignoreChannelIDs = make(map[int64]struct{})
+		}
+
+		initial.ignoreChannelIDs[channelID] = struct{}{}
+	}
+}
+
+// recordHedgeAttempt records the attempt that did not win as a retry
+func recordHedgeAttempt(c *gin.Context, attempt *hedgeAttempt, price model.Price) {
+	recordResult(
+		c,
+		attempt.meta,
+		price,
+		attempt.result,
+		0,
+		false,
+		middleware.GetRequestUser(c),
+		middleware.GetRequestMetadata(c),
+	)
+}
@@ -0,0 +1,246 @@
+package controller
+
+import (
+	"context"
+	"math/rand/v2"
+	"slices"
+	"sync"
+
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/monitor"
+)
+
+// channelStrategy picks a channel among the filtered channels of a model
+// by the channel strategy of the model config
+type channelStrategy struct {
+	strategy  model.ChannelStrategy
+	modelName string
+	// map[channelID]average latency in milliseconds
+	latencies map[int64]float64
+}
+
+func newChannelStrategy(
+	ctx context.Context,
+	mc *model.ModelCaches,
+	modelName string,
+) (*channelStrategy, error) {
+	s := &channelStrategy{
+		strategy:  model.ChannelStrategyPriority,
+		modelName: modelName,
+	}
+
+	if mc != nil && mc.ModelConfig != nil {
+		if config, ok := mc.ModelConfig.GetModelConfig(modelName); ok &&
+			config.ChannelStrategy != "" {
+			s.strategy = config.ChannelStrategy
+		}
+	}
+
+	if s.strategy == model.ChannelStrategyLatency {
+		latencies, err := monitor.GetModelChannelLatency(ctx, modelName)
+		if err != nil {
+			return s, err
+		}
+
+		s.latencies = latencies
+	}
+
+	return s, nil
+}
+
+func (s *channelStrategy) String() string {
+	if s == nil {
+		return string(model.ChannelStrategyPriority)
+	}
+	return string(s.strategy)
+}
+
+// avoidFailed reports whether the retries should skip the failed channels,
+// the strategies that don't pick randomly would pick the failed channel again
+func (s *channelStrategy) avoidFailed() bool {
+	if s == nil {
+		return false
+	}
+
+	switch s.strategy {
+	case model.ChannelStrategyCheapest,
+		model.ChannelStrategyLatency,
+		model.ChannelStrategyLeastInFlight:
+		return true
+	default:
+		return false
+	}
+}
+
+// needInFlight reports whether the strategy picks by the in flight requests
+func (s *channelStrategy) needInFlight() bool {
+	return s != nil && s.strategy == model.ChannelStrategyLeastInFlight
+}
+
+func (s *channelStrategy) pick(
+	channels []*model.Channel,
+	errorRates map[int64]float64,
+	loads *channelLoads,
+) *model.Channel {
+	if len(channels) == 1 {
+		return channels[0]
+	}
+
+	if s == nil {
+		return pickByPriority(channels, errorRates)
+	}
+
+	switch s.strategy {
+	case model.ChannelStrategyCheapest:
+		return pickByPriority(minChannels(channels, func(ch *model.Channel) float64 {
+			return ch.GetCost()
+		}), errorRates)
+	case model.ChannelStrategyLatency:
+		avgLatency := s.averageLatency(channels)
+		return pickByPriority(minChannels(channels, func(ch *model.Channel) float64 {
+			return s.latencyScore(ch, avgLatency, errorRates)
+		}), errorRates)
+	case model.ChannelStrategyLeastInFlight:
+		return pickByPriority(minChannels(channels, func(ch *model.Channel) float64 {
+			return float64(loads.inFlightTotal(int64(ch.ID)))
+		}), errorRates)
+	case model.ChannelStrategyRoundRobin:
+		return pickByRoundRobin(s.modelName, channels)
+	default:
+		return pickByPriority(channels, errorRates)
+	}
+}
+
+// maxLatencyErrorRate caps the error rate penalty of the latency strategy,
+// a failing channel scores at most ten times its latency
+const maxLatencyErrorRate = 0.9
+
+// averageLatency returns the average latency of the channels with latency stats
+func (s *channelStrategy) averageLatency(channels []*model.Channel) float64 {
+	var (
+		total float64
+		count int
+	)
+
+	for _, ch := range channels {
+		if latency, ok := s.latencies[int64(ch.ID)]; ok {
+			total += latency
+			count++
+		}
+	}
+
+	if count == 0 {
+		return 0
+	}
+
+	return total / float64(count)
+}
+
+// latencyScore returns the latency of the channel raised by its error rate,
+// the failed requests don't record latency so a failing channel would look
+// fast, and a channel without latency stats scores the average latency
+func (s *channelStrategy) latencyScore(
+	ch *model.Channel,
+	avgLatency float64,
+	errorRates map[int64]float64,
+) float64 {
+	latency, ok := s.latencies[int64(ch.ID)]
+	if !ok {
+		latency = avgLatency
+	}
+
+	errorRate := min(max(errorRates[int64(ch.ID)], 0), maxLatencyErrorRate)
+
+	return latency / (1 - errorRate)
+}
+
+// minChannels returns the channels with the minimum value
+func minChannels(
+	channels []*model.Channel,
+	value func(ch *model.Channel) float64,
+) []*model.Channel {
+	result := make([]*model.Channel, 0, len(channels))
+
+	var minValue float64
+	for _, ch := range channels {
+		v := value(ch)
+		switch {
+		case len(result) == 0 || v < minValue:
+			minValue = v
+
+			result = append(result[:0], ch)
+		case v == minValue:
+			result = append(result, ch)
+		}
+	}
+
+	return result
+}
+
+func getPriority(channel *model.Channel, errorRate float64) int32 {
+	priority := channel.GetPriority()
+
+	if errorRate > 1 {
+		errorRate = 1
+	} else if errorRate < 0.1 {
+		errorRate = 0.1
+	}
+
+	return int32(float64(priority) / errorRate)
+}
+
+// pickByPriority picks a channel by weighted random over the channel priority
+// divided by the error rate
+func pickByPriority(channels []*model.Channel, errorRates map[int64]float64) *model.Channel {
+	if len(channels) == 1 {
+		return channels[0]
+	}
+
+	var totalWeight int32
+
+	cachedPrioritys := make([]int32, len(channels))
+	for i, ch := range channels {
+		priority := getPriority(ch, errorRates[int64(ch.ID)])
+		totalWeight += priority
+		cachedPrioritys[i] = priority
+	}
+
+	if totalWeight == 0 {
+		return channels[rand.IntN(len(channels))]
+	}
+
+	r := rand.Int32N(totalWeight)
+	for i, ch := range channels {
+		r -= cachedPrioritys[i]
+		if r < 0 {
+			return ch
+		}
+	}
+
+	return channels[rand.IntN(len(channels))]
+}
+
+var (
+	roundRobinMu sync.Mutex
+	// map[model]next
+	roundRobinCounters = make(map[string]uint64)
+)
+
+func pickByRoundRobin(modelName string, channels []*model.Channel) *model.Channel {
+	sorted := slices.Clone(channels)
+	slices.SortFunc(sorted, func(a, b *model.Channel) int {
+		return a.ID - b.ID
+	})
+
+	return sorted[nextRoundRobin(modelName)%uint64(len(sorted))]
+}
+
+func nextRoundRobin(modelName string) uint64 {
+	roundRobinMu.Lock()
+	defer roundRobinMu.Unlock()
+
+	n := roundRobinCounters[modelName]
+	roundRobinCounters[modelName] = n + 1
+
+	return n
+}
@@ -0,0 +1,132 @@
+package controller_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/controller"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/monitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type modelConfigs map[string]model.ModelConfig
+
+func (m modelConfigs) GetModelConfig(name string) (model.ModelConfig, bool) {
+	config, ok := m[name]
+	return config, ok
+}
+
+func (m modelConfigs) GetModelAliases() []model.ModelConfig {
+	return nil
+}
+
+func strategyModelCaches(modelName string, strategy model.ChannelStrategy) *model.ModelCaches {
+	return &model.ModelCaches{
+		ModelConfig: modelConfigs{
+			modelName: {Model: modelName, ChannelStrategy: strategy},
+		},
+	}
+}
+
+// picks returns how many times each channel is picked
+func picks(
+	t *testing.T,
+	modelName string,
+	strategy model.ChannelStrategy,
+	channels []*model.Channel,
+	errorRates map[int64]float64,
+) map[int]int {
+	t.Helper()
+
+	s, err := controller.NewChannelStrategy(
+		t.Context(),
+		strategyModelCaches(modelName, strategy),
+		modelName,
+	)
+	require.NoError(t, err)
+	assert.Equal(t, string(strategy), s.String())
+
+	result := make(map[int]int)
+	for range 200 {
+		result[controller.PickChannel(s, channels, errorRates, nil).ID]++
+	}
+
+	return result
+}
+
+func TestMinChannels(t *testing.T) {
+	channels := []*model.Channel{{ID: 1, Cost: 2}, {ID: 2, Cost: 1}, {ID: 3}, {ID: 4, Cost: 3}}
+
+	result := controller.MinChannels(channels, func(ch *model.Channel) float64 {
+		return ch.GetCost()
+	})
+	assert.Equal(t, []*model.Channel{channels[1], channels[2]}, result)
+
+	assert.Empty(t, controller.MinChannels(nil, func(*model.Channel) float64 { return 0 }))
+}
+
+func TestPickCheapest(t *testing.T) {
+	channels := []*model.Channel{{ID: 1, Cost: 2}, {ID: 2, Cost: 0.5}, {ID: 3, Cost: 0.5}}
+
+	result := picks(t, t.Name(), model.ChannelStrategyCheapest, channels, nil)
+	assert.Zero(t, result[1])
+	assert.Positive(t, result[2])
+	assert.Positive(t, result[3])
+}
+
+func TestPickLatency(t *testing.T) {
+	modelName := t.Name()
+	channels := []*model.Channel{{ID: 1}, {ID: 2}, {ID: 3}}
+
+	// no channel has latency stats yet
+	result := picks(t, modelName, model.ChannelStrategyLatency, channels, nil)
+	assert.Len(t, result, 3)
+
+	require.NoError(t, monitor.AddLatency(t.Context(), modelName, 1, 100*time.Millisecond))
+	require.NoError(t, monitor.AddLatency(t.Context(), modelName, 2, 300*time.Millisecond))
+
+	// the channel without stats scores the average latency, not zero
+	result = picks(t, modelName, model.ChannelStrategyLatency, channels, nil)
+	assert.Equal(t, map[int]int{1: 200}, result)
+
+	// the failing fast channel is raised above the average latency
+	result = picks(t, modelName, model.ChannelStrategyLatency, channels, map[int64]float64{1: 0.9})
+	assert.Equal(t, map[int]int{3: 200}, result)
+
+	// the error rate is capped, a channel failing every request is ten times slower
+	result = picks(
+		t,
+		modelName,
+		model.ChannelStrategyLatency,
+		channels[:2],
+		map[int64]float64{1: 1, 2: 0},
+	)
+	assert.Equal(t, map[int]int{2: 200}, result)
+}
+
+func TestPickByRoundRobin(t *testing.T) {
+	modelName := t.Name()
+	channels := []*model.Channel{{ID: 3}, {ID: 1}, {ID: 2}}
+
+	ids := make([]int, 0, 6)
+	for range 6 {
+		ids = append(ids, controller.PickByRoundRobin(modelName, channels).ID)
+	}
+
+	assert.Equal(t, []int{1, 2, 3, 1, 2, 3}, ids)
+	// the input order is not changed
+	assert.Equal(t, 3, channels[0].ID)
+
+	result := picks(t, modelName+"-pick", model.ChannelStrategyRoundRobin, channels, nil)
+	assert.Equal(t, map[int]int{1: 67, 2: 67, 3: 66}, result)
+}
+
+func TestPickSingleChannel(t *testing.T) {
+	channels := []*model.Channel{{ID: 1}}
+
+	var s *controller.ChannelStrategy
+	assert.Equal(t, string(model.ChannelStrategyPriority), s.String())
+	assert.Equal(t, 1, controller.PickChannel(s, channels, nil, nil).ID)
+}
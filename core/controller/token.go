@@ -1,6 +1,7 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 	"net/http"
 	"strconv"
@@ -12,6 +13,7 @@ import (
 	"github.com/labring/aiproxy/core/controller/utils"
 	"github.com/labring/aiproxy/core/middleware"
 	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
 )
 
 // TokenResponse represents the response structure for token endpoints
@@ -28,23 +30,48 @@ func (t *TokenResponse) MarshalJSON() ([]byte, error) {
 		CreatedAt            int64 `json:"created_at"`
 		PeriodLastUpdateTime int64 `json:"period_last_update_time"`
 		AccessedAt           int64 `json:"accessed_at"`
+		ExpiresAt            int64 `json:"expires_at"`
+		PreviousKeyExpiresAt int64 `json:"previous_key_expires_at,omitempty"`
 	}{
 		Alias:                (*Alias)(t),
 		CreatedAt:            t.CreatedAt.UnixMilli(),
 		PeriodLastUpdateTime: t.PeriodLastUpdateTime.UnixMilli(),
 		AccessedAt:           t.AccessedAt.UnixMilli(),
+		ExpiresAt:            unixMilliOrZero(t.ExpiresAt),
+		PreviousKeyExpiresAt: unixMilliOrZero(t.PreviousKeyExpiresAt),
 	})
 }
 
+func unixMilliOrZero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
 type (
 	AddTokenRequest struct {
-		Name                 string   `json:"name"`
-		Subnets              []string `json:"subnets"`
-		Models               []string `json:"models"`
-		Quota                float64  `json:"quota"`
-		PeriodQuota          float64  `json:"period_quota"`
-		PeriodType           string   `json:"period_type"`
-		PeriodLastUpdateTime int64    `json:"period_last_update_time"`
+		Name                 string                   `json:"name"`
+		Subnets              []string                 `json:"subnets"`
+		Models               []string                 `json:"models"`
+		Quota                float64                  `json:"quota"`
+		PeriodQuota          float64                  `json:"period_quota"`
+		PeriodType           string                   `json:"period_type"`
+		PeriodLastUpdateTime int64                    `json:"period_last_update_time"`
+		QueueMaxWait         int64                    `json:"queue_max_wait"`
+		QueueMaxDepth        int64                    `json:"queue_max_depth"`
+		ModelBudgets         []model.TokenModelBudget `json:"model_budgets"`
+		ExpiresAt            int64                    `json:"expires_at"`
+		Modes                []mode.Mode              `json:"modes"`
+		MCPAllowTools        []string                 `json:"mcp_allow_tools"`
+		MCPDenyTools         []string                 `json:"mcp_deny_tools"`
+		MCPToolsRPM          map[string]int64         `json:"mcp_tools_rpm"`
+	}
+
+	// RotateTokenRequest is the grace period in seconds the previous key still works,
+	// default is 24 hours, 0 revokes the previous key at once
+	RotateTokenRequest struct {
+		GracePeriod *int64 `json:"grace_period"`
 	}
 
 	UpdateTokenStatusRequest struct {
@@ -64,12 +91,24 @@ func (at *AddTokenRequest) ToToken() *model.Token {
 		Quota:       at.Quota,
 		PeriodQuota: at.PeriodQuota,
 		PeriodType:  model.EmptyNullString(at.PeriodType),
+
+		QueueMaxWait:  at.QueueMaxWait,
+		QueueMaxDepth: at.QueueMaxDepth,
+		ModelBudgets:  at.ModelBudgets,
+		Modes:         at.Modes,
+		MCPAllowTools: at.MCPAllowTools,
+		MCPDenyTools:  at.MCPDenyTools,
+		MCPToolsRPM:   at.MCPToolsRPM,
 	}
 
 	if at.PeriodLastUpdateTime > 0 {
 		token.PeriodLastUpdateTime = time.UnixMilli(at.PeriodLastUpdateTime)
 	}
 
+	if at.ExpiresAt > 0 {
+		token.ExpiresAt = time.UnixMilli(at.ExpiresAt)
+	}
+
 	return token
 }
 
@@ -78,6 +117,14 @@ func validateToken(token AddTokenRequest) error {
 		return fmt.Errorf("invalid subnet: %w", err)
 	}
 
+	if err := model.ValidateTokenModelBudgets(token.ModelBudgets); err != nil {
+		return fmt.Errorf("invalid model budgets: %w", err)
+	}
+
+	if token.ExpiresAt > 0 && time.UnixMilli(token.ExpiresAt).Before(time.Now()) {
+		return errors.New("expires_at is in the past")
+	}
+
 	return nil
 }
 
@@ -705,3 +752,95 @@ func UpdateGroupTokenName(c *gin.Context) {
 
 	middleware.SuccessResponse(c, nil)
 }
+
+const defaultTokenRotateGracePeriod = 24 * time.Hour
+
+func getRotateGracePeriod(c *gin.Context) (time.Duration, error) {
+	var req RotateTokenRequest
+	if c.Request.ContentLength != 0 {
+		if err := c.ShouldBindJSON(&req); err != nil {
+			return 0, err
+		}
+	}
+
+	if req.GracePeriod == nil {
+		return defaultTokenRotateGracePeriod, nil
+	}
+
+	if *req.GracePeriod < 0 {
+		return 0, errors.New("grace_period cannot be negative")
+	}
+
+	return time.Duration(*req.GracePeriod) * time.Second, nil
+}
+
+// RotateToken godoc
+//
+//	@Summary		Rotate token
+//	@Description	Issues a new key for a specific token, the previous key still works during the grace period
+//	@Tags			tokens
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			id		path		int					true	"Token ID"
+//	@Param			rotate	body		RotateTokenRequest	false	"Grace period"
+//	@Success		200		{object}	middleware.APIResponse{data=TokenResponse}
+//	@Router			/api/tokens/{id}/rotate [post]
+func RotateToken(c *gin.Context) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	grace, err := getRotateGracePeriod(c)
+	if err != nil {
+		middleware.ErrorResponse(c, http.StatusBadRequest, "parameter error: "+err.Error())
+		return
+	}
+
+	token, err := model.RotateToken(id, grace)
+	if err != nil {
+		middleware.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	middleware.SuccessResponse(c, &TokenResponse{Token: token})
+}
+
+// RotateGroupToken godoc
+//
+//	@Summary		Rotate group token
+//	@Description	Issues a new key for a token in a specific group, the previous key still works during the grace period
+//	@Tags			token
+//	@Accept			json
+//	@Produce		json
+//	@Security		ApiKeyAuth
+//	@Param			group	path		string				true	"Group name"
+//	@Param			id		path		int					true	"Token ID"
+//	@Param			rotate	body		RotateTokenRequest	false	"Grace period"
+//	@Success		200		{object}	middleware.APIResponse{data=TokenResponse}
+//	@Router			/api/token/{group}/{id}/rotate [post]
+func RotateGroupToken(c *gin.Context) {
+	group := c.Param("group")
+
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil {
+		middleware.ErrorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	grace, err := getRotateGracePeriod(c)
+	if err != nil {
+		middleware.ErrorResponse(c, http.StatusBadRequest, "parameter error: "+err.Error())
+		return
+	}
+
+	token, err := model.RotateGroupToken(group, id, grace)
+	if err != nil {
+		middleware.ErrorResponse(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	middleware.SuccessResponse(c, &TokenResponse{Token: token})
+}
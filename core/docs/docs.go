@@ -5266,6 +5266,92 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/monitor/inflight": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Returns the in flight requests of all channels",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "monitor"
+                ],
+                "summary": "Get all channels in flight requests",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/middleware.APIResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "type": "object",
+                                            "additionalProperties": {
+                                                "$ref": "#/definitions/monitor.InFlight"
+                                            }
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
+        "/api/monitor/inflight/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Returns the in flight requests of a channel",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "monitor"
+                ],
+                "summary": "Get channel in flight requests",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Channel ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/middleware.APIResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/monitor.InFlight"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
         "/api/monitor/models": {
             "get": {
                 "security": [
@@ -6343,6 +6429,70 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/token/{group}/{id}/rotate": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Issues a new key for a token in a specific group, the previous key still works during the grace period",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "token"
+                ],
+                "summary": "Rotate group token",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Group name",
+                        "name": "group",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Token ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Grace period",
+                        "name": "rotate",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/controller.RotateTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/middleware.APIResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/controller.TokenResponse"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
         "/api/token/{group}/{id}/status": {
             "post": {
                 "security": [
@@ -6901,6 +7051,63 @@ const docTemplate = `{
                 }
             }
         },
+        "/api/tokens/{id}/rotate": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Issues a new key for a specific token, the previous key still works during the grace period",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "tokens"
+                ],
+                "summary": "Rotate token",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Token ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Grace period",
+                        "name": "rotate",
+                        "in": "body",
+                        "schema": {
+                            "$ref": "#/definitions/controller.RotateTokenRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "allOf": [
+                                {
+                                    "$ref": "#/definitions/middleware.APIResponse"
+                                },
+                                {
+                                    "type": "object",
+                                    "properties": {
+                                        "data": {
+                                            "$ref": "#/definitions/controller.TokenResponse"
+                                        }
+                                    }
+                                }
+                            ]
+                        }
+                    }
+                }
+            }
+        },
         "/api/tokens/{id}/status": {
             "post": {
                 "security": [
@@ -6976,6 +7183,50 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/mcp/aggregate": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Serves the tools of all enabled public and group mcps, named mcp_id__tool",
+                "summary": "Aggregated MCP Streamable Server",
+                "responses": {}
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Serves the tools of all enabled public and group mcps, named mcp_id__tool",
+                "summary": "Aggregated MCP Streamable Server",
+                "responses": {}
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Serves the tools of all enabled public and group mcps, named mcp_id__tool",
+                "summary": "Aggregated MCP Streamable Server",
+                "responses": {}
+            }
+        },
+        "/mcp/aggregate/sse": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Serves the tools of all enabled public and group mcps, named mcp_id__tool",
+                "summary": "Aggregated MCP SSE Server",
+                "responses": {}
+            }
+        },
         "/mcp/group/{id}": {
             "get": {
                 "security": [
@@ -7062,6 +7313,31 @@ const docTemplate = `{
                 "responses": {}
             }
         },
+        "/metrics": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Returns the relay, channel and billing metrics in the Prometheus text format",
+                "produces": [
+                    "text/plain"
+                ],
+                "tags": [
+                    "monitor"
+                ],
+                "summary": "Prometheus metrics",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "string"
+                        }
+                    }
+                }
+            }
+        },
         "/sse": {
             "get": {
                 "security": [
@@ -7287,30 +7563,174 @@ const docTemplate = `{
                 }
             }
         },
-        "/v1/chat/completions": {
-            "post": {
+        "/v1/batches": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "ChatCompletions",
+                "description": "ListBatches",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "relay"
                 ],
-                "summary": "ChatCompletions",
+                "summary": "ListBatches",
                 "parameters": [
                     {
-                        "description": "Request",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.GeneralOpenAIRequest"
-                        }
+                        "type": "string",
+                        "description": "After batch id",
+                        "name": "after",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Limit",
+                        "name": "limit",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.BatchList"
+                        }
+                    }
+                }
+            },
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Create a batch from an uploaded jsonl file of requests, the requests\nare executed offline and billed with the batch discount",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "relay"
+                ],
+                "summary": "CreateBatch",
+                "parameters": [
+                    {
+                        "description": "Request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.BatchRequest"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_labring_aiproxy_core_model.Batch"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/batches/{id}": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "GetBatch",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "relay"
+                ],
+                "summary": "GetBatch",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Batch ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_labring_aiproxy_core_model.Batch"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/batches/{id}/cancel": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Cancel an in progress batch, the finished requests are still\nwritten to the output file",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "relay"
+                ],
+                "summary": "CancelBatch",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Batch ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_labring_aiproxy_core_model.Batch"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/chat/completions": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "ChatCompletions",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "relay"
+                ],
+                "summary": "ChatCompletions",
+                "parameters": [
+                    {
+                        "description": "Request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.GeneralOpenAIRequest"
+                        }
                     },
                     {
                         "type": "string",
@@ -7566,110 +7986,99 @@ const docTemplate = `{
                 }
             }
         },
-        "/v1/images/edits": {
-            "post": {
+        "/v1/files": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "ImagesEdits",
+                "description": "List the files stored by the proxy",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "relay"
                 ],
-                "summary": "ImagesEdits",
+                "summary": "FilesList",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Prompt",
-                        "name": "prompt",
-                        "in": "formData",
-                        "required": true
+                        "description": "Purpose",
+                        "name": "purpose",
+                        "in": "query"
                     },
                     {
-                        "type": "string",
-                        "description": "Model",
-                        "name": "model",
-                        "in": "formData",
-                        "required": true
+                        "type": "integer",
+                        "description": "Limit",
+                        "name": "limit",
+                        "in": "query"
                     },
                     {
-                        "type": "file",
-                        "description": "Images",
-                        "name": "image",
-                        "in": "formData",
-                        "required": true
+                        "type": "string",
+                        "description": "Order, asc or desc",
+                        "name": "order",
+                        "in": "query"
                     },
                     {
                         "type": "string",
-                        "description": "Optional Aiproxy-Channel header",
-                        "name": "Aiproxy-Channel",
-                        "in": "header"
+                        "description": "After file id",
+                        "name": "after",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.SttJSONResponse"
-                        },
-                        "headers": {
-                            "X-RateLimit-Limit-Requests": {
-                                "type": "integer",
-                                "description": "X-RateLimit-Limit-Requests"
-                            },
-                            "X-RateLimit-Limit-Tokens": {
-                                "type": "integer",
-                                "description": "X-RateLimit-Limit-Tokens"
-                            },
-                            "X-RateLimit-Remaining-Requests": {
-                                "type": "integer",
-                                "description": "X-RateLimit-Remaining-Requests"
-                            },
-                            "X-RateLimit-Remaining-Tokens": {
-                                "type": "integer",
-                                "description": "X-RateLimit-Remaining-Tokens"
-                            },
-                            "X-RateLimit-Reset-Requests": {
-                                "type": "string",
-                                "description": "X-RateLimit-Reset-Requests"
-                            },
-                            "X-RateLimit-Reset-Tokens": {
-                                "type": "string",
-                                "description": "X-RateLimit-Reset-Tokens"
-                            }
+                            "$ref": "#/definitions/model.FileList"
                         }
                     }
                 }
-            }
-        },
-        "/v1/images/generations": {
+            },
             "post": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "ImagesGenerations",
+                "description": "Upload a file, the file is stored by the proxy unless a model is given,\nin which case it is uploaded to a channel of that model",
+                "consumes": [
+                    "multipart/form-data"
+                ],
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "relay"
                 ],
-                "summary": "ImagesGenerations",
+                "summary": "FilesUpload",
                 "parameters": [
                     {
-                        "description": "Request",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.ImageRequest"
-                        }
+                        "type": "file",
+                        "description": "File",
+                        "name": "file",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Purpose",
+                        "name": "purpose",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Upload to a channel of the model",
+                        "name": "model",
+                        "in": "formData"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Expires after seconds",
+                        "name": "expires_after[seconds]",
+                        "in": "formData"
                     },
                     {
                         "type": "string",
@@ -7682,61 +8091,283 @@ const docTemplate = `{
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "$ref": "#/definitions/model.ImageResponse"
-                        },
-                        "headers": {
-                            "X-RateLimit-Limit-Requests": {
-                                "type": "integer",
-                                "description": "X-RateLimit-Limit-Requests"
-                            },
-                            "X-RateLimit-Limit-Tokens": {
-                                "type": "integer",
-                                "description": "X-RateLimit-Limit-Tokens"
-                            },
-                            "X-RateLimit-Remaining-Requests": {
-                                "type": "integer",
-                                "description": "X-RateLimit-Remaining-Requests"
-                            },
-                            "X-RateLimit-Remaining-Tokens": {
-                                "type": "integer",
-                                "description": "X-RateLimit-Remaining-Tokens"
-                            },
-                            "X-RateLimit-Reset-Requests": {
-                                "type": "string",
-                                "description": "X-RateLimit-Reset-Requests"
-                            },
-                            "X-RateLimit-Reset-Tokens": {
-                                "type": "string",
-                                "description": "X-RateLimit-Reset-Tokens"
-                            }
+                            "$ref": "#/definitions/github_com_labring_aiproxy_core_model.File"
                         }
                     }
                 }
             }
         },
-        "/v1/messages": {
-            "post": {
+        "/v1/files/{id}": {
+            "get": {
                 "security": [
                     {
                         "ApiKeyAuth": []
                     }
                 ],
-                "description": "Anthropic",
+                "description": "FilesGet",
                 "produces": [
                     "application/json"
                 ],
                 "tags": [
                     "relay"
                 ],
-                "summary": "Anthropic",
+                "summary": "FilesGet",
                 "parameters": [
                     {
-                        "description": "Request",
-                        "name": "request",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/model.AnthropicMessageRequest"
+                        "type": "string",
+                        "description": "File ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/github_com_labring_aiproxy_core_model.File"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "FilesDelete",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "relay"
+                ],
+                "summary": "FilesDelete",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "File ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.FileDeleted"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/files/{id}/content": {
+            "get": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "FilesContent",
+                "produces": [
+                    "application/octet-stream"
+                ],
+                "tags": [
+                    "relay"
+                ],
+                "summary": "FilesContent",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "File ID",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "file"
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/images/edits": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "ImagesEdits",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "relay"
+                ],
+                "summary": "ImagesEdits",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Prompt",
+                        "name": "prompt",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Model",
+                        "name": "model",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "file",
+                        "description": "Images",
+                        "name": "image",
+                        "in": "formData",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Optional Aiproxy-Channel header",
+                        "name": "Aiproxy-Channel",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.SttJSONResponse"
+                        },
+                        "headers": {
+                            "X-RateLimit-Limit-Requests": {
+                                "type": "integer",
+                                "description": "X-RateLimit-Limit-Requests"
+                            },
+                            "X-RateLimit-Limit-Tokens": {
+                                "type": "integer",
+                                "description": "X-RateLimit-Limit-Tokens"
+                            },
+                            "X-RateLimit-Remaining-Requests": {
+                                "type": "integer",
+                                "description": "X-RateLimit-Remaining-Requests"
+                            },
+                            "X-RateLimit-Remaining-Tokens": {
+                                "type": "integer",
+                                "description": "X-RateLimit-Remaining-Tokens"
+                            },
+                            "X-RateLimit-Reset-Requests": {
+                                "type": "string",
+                                "description": "X-RateLimit-Reset-Requests"
+                            },
+                            "X-RateLimit-Reset-Tokens": {
+                                "type": "string",
+                                "description": "X-RateLimit-Reset-Tokens"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/images/generations": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "ImagesGenerations",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "relay"
+                ],
+                "summary": "ImagesGenerations",
+                "parameters": [
+                    {
+                        "description": "Request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.ImageRequest"
+                        }
+                    },
+                    {
+                        "type": "string",
+                        "description": "Optional Aiproxy-Channel header",
+                        "name": "Aiproxy-Channel",
+                        "in": "header"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/model.ImageResponse"
+                        },
+                        "headers": {
+                            "X-RateLimit-Limit-Requests": {
+                                "type": "integer",
+                                "description": "X-RateLimit-Limit-Requests"
+                            },
+                            "X-RateLimit-Limit-Tokens": {
+                                "type": "integer",
+                                "description": "X-RateLimit-Limit-Tokens"
+                            },
+                            "X-RateLimit-Remaining-Requests": {
+                                "type": "integer",
+                                "description": "X-RateLimit-Remaining-Requests"
+                            },
+                            "X-RateLimit-Remaining-Tokens": {
+                                "type": "integer",
+                                "description": "X-RateLimit-Remaining-Tokens"
+                            },
+                            "X-RateLimit-Reset-Requests": {
+                                "type": "string",
+                                "description": "X-RateLimit-Reset-Requests"
+                            },
+                            "X-RateLimit-Reset-Tokens": {
+                                "type": "string",
+                                "description": "X-RateLimit-Reset-Tokens"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/v1/messages": {
+            "post": {
+                "security": [
+                    {
+                        "ApiKeyAuth": []
+                    }
+                ],
+                "description": "Anthropic",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "relay"
+                ],
+                "summary": "Anthropic",
+                "parameters": [
+                    {
+                        "description": "Request",
+                        "name": "request",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/model.AnthropicMessageRequest"
                         }
                     },
                     {
@@ -8473,27 +9104,60 @@ const docTemplate = `{
                 "configs": {
                     "$ref": "#/definitions/model.ChannelConfigs"
                 },
+                "cost": {
+                    "type": "number"
+                },
                 "key": {
                     "type": "string"
                 },
+                "max_concurrency": {
+                    "type": "integer"
+                },
                 "model_mapping": {
                     "type": "object",
                     "additionalProperties": {
                         "type": "string"
                     }
                 },
-                "models": {
-                    "type": "array",
-                    "items": {
-                        "type": "string"
+                "model_max_concurrency": {
+                    "description": "map[model]max_concurrency",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
                     }
                 },
-                "name": {
-                    "type": "string"
+                "model_rpm": {
+                    "description": "map[model]rpm",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "model_tpm": {
+                    "description": "map[model]tpm",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "models": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "name": {
+                    "type": "string"
                 },
                 "priority": {
                     "type": "integer"
                 },
+                "rpm": {
+                    "type": "integer"
+                },
                 "sets": {
                     "type": "array",
                     "items": {
@@ -8503,6 +9167,9 @@ const docTemplate = `{
                 "status": {
                     "type": "integer"
                 },
+                "tpm": {
+                    "type": "integer"
+                },
                 "type": {
                     "$ref": "#/definitions/model.ChannelType"
                 }
@@ -8511,12 +9178,46 @@ const docTemplate = `{
         "controller.AddTokenRequest": {
             "type": "object",
             "properties": {
+                "expires_at": {
+                    "type": "integer"
+                },
+                "mcp_allow_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_deny_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_tools_rpm": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "model_budgets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.TokenModelBudget"
+                    }
+                },
                 "models": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
+                "modes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/mode.Mode"
+                    }
+                },
                 "name": {
                     "type": "string"
                 },
@@ -8529,6 +9230,12 @@ const docTemplate = `{
                 "period_type": {
                     "type": "string"
                 },
+                "queue_max_depth": {
+                    "type": "integer"
+                },
+                "queue_max_wait": {
+                    "type": "integer"
+                },
                 "quota": {
                     "type": "number"
                 },
@@ -8543,6 +9250,17 @@ const docTemplate = `{
         "controller.BuiltinModelConfig": {
             "type": "object",
             "properties": {
+                "alias": {
+                    "description": "Alias makes the model a virtual model name resolved to the target models",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.ModelAlias"
+                        }
+                    ]
+                },
+                "channel_strategy": {
+                    "$ref": "#/definitions/model.ChannelStrategy"
+                },
                 "config": {
                     "type": "object",
                     "additionalProperties": {}
@@ -8553,9 +9271,21 @@ const docTemplate = `{
                 "exclude_from_tests": {
                     "type": "boolean"
                 },
+                "fallback_model": {
+                    "description": "FallbackModel answers the request when all the channels of the model are exhausted",
+                    "type": "string"
+                },
                 "force_save_detail": {
                     "type": "boolean"
                 },
+                "hedge": {
+                    "description": "Hedge is only applied to the non-stream requests",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.HedgeConfig"
+                        }
+                    ]
+                },
                 "image_prices": {
                     "description": "map[size]price_per_image",
                     "type": "object",
@@ -8632,6 +9362,31 @@ const docTemplate = `{
                 "balance_alert_threshold": {
                     "type": "number"
                 },
+                "mcp_allow_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_deny_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_tools_rpm": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "queue_max_depth": {
+                    "type": "integer"
+                },
+                "queue_max_wait": {
+                    "type": "integer"
+                },
                 "rpm_ratio": {
                     "type": "number"
                 },
@@ -8900,6 +9655,9 @@ const docTemplate = `{
                 "status": {
                     "$ref": "#/definitions/model.PublicMCPStatus"
                 },
+                "stdio_config": {
+                    "$ref": "#/definitions/model.MCPStdioConfig"
+                },
                 "tags": {
                     "type": "array",
                     "items": {
@@ -8985,6 +9743,9 @@ const docTemplate = `{
                 "status": {
                     "$ref": "#/definitions/model.PublicMCPStatus"
                 },
+                "stdio_config": {
+                    "$ref": "#/definitions/model.MCPStdioConfig"
+                },
                 "tags": {
                     "type": "array",
                     "items": {
@@ -9031,6 +9792,33 @@ const docTemplate = `{
                 "id": {
                     "type": "string"
                 },
+                "mcp_allow_tools": {
+                    "description": "MCPAllowTools, MCPDenyTools and MCPToolsRPM limit the mcp tools of all tokens of\nthe group, see the same fields of the token",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_deny_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_tools_rpm": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "queue_max_depth": {
+                    "type": "integer"
+                },
+                "queue_max_wait": {
+                    "description": "Queue mode, requests exceeding the rpm/tpm wait up to QueueMaxWait seconds\ninstead of being rejected, disabled when QueueMaxWait is 0",
+                    "type": "integer"
+                },
                 "request_count": {
                     "type": "integer"
                 },
@@ -9197,6 +9985,9 @@ const docTemplate = `{
                 "status": {
                     "$ref": "#/definitions/model.PublicMCPStatus"
                 },
+                "stdio_config": {
+                    "$ref": "#/definitions/model.MCPStdioConfig"
+                },
                 "tags": {
                     "type": "array",
                     "items": {
@@ -9219,6 +10010,14 @@ const docTemplate = `{
                 }
             }
         },
+        "controller.RotateTokenRequest": {
+            "type": "object",
+            "properties": {
+                "grace_period": {
+                    "type": "integer"
+                }
+            }
+        },
         "controller.SaveEmbedMCPRequest": {
             "type": "object",
             "properties": {
@@ -9239,6 +10038,9 @@ const docTemplate = `{
         "controller.SaveGroupModelConfigRequest": {
             "type": "object",
             "properties": {
+                "fallback_model": {
+                    "type": "string"
+                },
                 "force_save_detail": {
                     "type": "boolean"
                 },
@@ -9252,18 +10054,31 @@ const docTemplate = `{
                 "model": {
                     "type": "string"
                 },
+                "override_fallback_model": {
+                    "type": "boolean"
+                },
                 "override_force_save_detail": {
                     "type": "boolean"
                 },
                 "override_limit": {
                     "type": "boolean"
                 },
+                "override_plugin": {
+                    "type": "boolean"
+                },
                 "override_price": {
                     "type": "boolean"
                 },
                 "override_retry_times": {
                     "type": "boolean"
                 },
+                "plugin": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "object",
+                        "additionalProperties": {}
+                    }
+                },
                 "price": {
                     "$ref": "#/definitions/model.Price"
                 },
@@ -9281,6 +10096,17 @@ const docTemplate = `{
         "controller.SaveModelConfigsRequest": {
             "type": "object",
             "properties": {
+                "alias": {
+                    "description": "Alias makes the model a virtual model name resolved to the target models",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.ModelAlias"
+                        }
+                    ]
+                },
+                "channel_strategy": {
+                    "$ref": "#/definitions/model.ChannelStrategy"
+                },
                 "config": {
                     "type": "object",
                     "additionalProperties": {}
@@ -9291,9 +10117,21 @@ const docTemplate = `{
                 "exclude_from_tests": {
                     "type": "boolean"
                 },
+                "fallback_model": {
+                    "description": "FallbackModel answers the request when all the channels of the model are exhausted",
+                    "type": "string"
+                },
                 "force_save_detail": {
                     "type": "boolean"
                 },
+                "hedge": {
+                    "description": "Hedge is only applied to the non-stream requests",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.HedgeConfig"
+                        }
+                    ]
+                },
                 "image_prices": {
                     "description": "map[size]price_per_image",
                     "type": "object",
@@ -9386,6 +10224,10 @@ const docTemplate = `{
                 "created_at": {
                     "type": "string"
                 },
+                "expires_at": {
+                    "description": "ExpiresAt is the time the token is disabled, zero never expires",
+                    "type": "string"
+                },
                 "group": {
                     "type": "string"
                 },
@@ -9393,14 +10235,53 @@ const docTemplate = `{
                     "type": "integer"
                 },
                 "key": {
+                    "description": "Key is only set when the token is created or rotated, only the hash is stored",
                     "type": "string"
                 },
+                "key_prefix": {
+                    "type": "string"
+                },
+                "mcp_allow_tools": {
+                    "description": "MCPAllowTools and MCPDenyTools limit the tools the token can call on all mcp endpoints,\nthe patterns match the prefixed tool names like ` + "`" + `mcp_id__tool` + "`" + ` and support the *\nwildcard, empty allows all tools and the deny list wins",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_deny_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_tools_rpm": {
+                    "description": "MCPToolsRPM limits the calls per minute of the tools matching the patterns",
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "model_budgets": {
+                    "description": "ModelBudgets limits the amount spent on the models in a period",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.TokenModelBudget"
+                    }
+                },
                 "models": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
+                "modes": {
+                    "description": "Modes limits the relay modes the token can call, empty allows all modes",
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/mode.Mode"
+                    }
+                },
                 "name": {
                     "type": "string"
                 },
@@ -9419,6 +10300,16 @@ const docTemplate = `{
                     "description": "daily, weekly, monthly, default is monthly",
                     "type": "string"
                 },
+                "previous_key_expires_at": {
+                    "type": "string"
+                },
+                "queue_max_depth": {
+                    "type": "integer"
+                },
+                "queue_max_wait": {
+                    "description": "Queue mode, overrides the queue mode of the group when QueueMaxWait is set",
+                    "type": "integer"
+                },
                 "quota": {
                     "type": "number"
                 },
@@ -9517,6 +10408,212 @@ const docTemplate = `{
                 }
             }
         },
+        "github_com_labring_aiproxy_core_model.Batch": {
+            "type": "object",
+            "properties": {
+                "cancelled_at": {
+                    "type": "string"
+                },
+                "cancelling_at": {
+                    "type": "string"
+                },
+                "completed": {
+                    "type": "integer"
+                },
+                "completed_at": {
+                    "type": "string"
+                },
+                "completion_window": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "endpoint": {
+                    "type": "string"
+                },
+                "error_file_id": {
+                    "type": "string"
+                },
+                "errors": {
+                    "type": "string"
+                },
+                "expired_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "failed_at": {
+                    "type": "string"
+                },
+                "finalizing_at": {
+                    "type": "string"
+                },
+                "group": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "in_progress_at": {
+                    "type": "string"
+                },
+                "input_file_id": {
+                    "type": "string"
+                },
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "output_file_id": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "token_id": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
+        "github_com_labring_aiproxy_core_model.File": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "type": "string"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "group": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "purpose": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                },
+                "token_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "github_com_labring_aiproxy_core_relay_model.Batch": {
+            "type": "object",
+            "properties": {
+                "cancelled_at": {
+                    "type": "integer"
+                },
+                "cancelling_at": {
+                    "type": "integer"
+                },
+                "completed_at": {
+                    "type": "integer"
+                },
+                "completion_window": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "integer"
+                },
+                "endpoint": {
+                    "type": "string"
+                },
+                "error_file_id": {
+                    "type": "string"
+                },
+                "errors": {
+                    "$ref": "#/definitions/model.BatchErrors"
+                },
+                "expired_at": {
+                    "type": "integer"
+                },
+                "expires_at": {
+                    "type": "integer"
+                },
+                "failed_at": {
+                    "type": "integer"
+                },
+                "finalizing_at": {
+                    "type": "integer"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "in_progress_at": {
+                    "type": "integer"
+                },
+                "input_file_id": {
+                    "type": "string"
+                },
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "object": {
+                    "type": "string"
+                },
+                "output_file_id": {
+                    "type": "string"
+                },
+                "request_counts": {
+                    "$ref": "#/definitions/model.BatchRequestCounts"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "github_com_labring_aiproxy_core_relay_model.File": {
+            "type": "object",
+            "properties": {
+                "bytes": {
+                    "type": "integer"
+                },
+                "created_at": {
+                    "type": "integer"
+                },
+                "expires_at": {
+                    "type": "integer"
+                },
+                "filename": {
+                    "type": "string"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "object": {
+                    "type": "string"
+                },
+                "purpose": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
         "mcp.Meta": {
             "type": "object",
             "properties": {
@@ -9680,7 +10777,13 @@ const docTemplate = `{
                 18,
                 19,
                 20,
-                21
+                21,
+                22,
+                23,
+                24,
+                25,
+                26,
+                27
             ],
             "x-enum-varnames": [
                 "Unknown",
@@ -9704,7 +10807,13 @@ const docTemplate = `{
                 "ResponsesDelete",
                 "ResponsesCancel",
                 "ResponsesInputItems",
-                "Gemini"
+                "Gemini",
+                "FilesUpload",
+                "FilesList",
+                "FilesGet",
+                "FilesDelete",
+                "FilesContent",
+                "MCPToolsCall"
             ]
         },
         "model.AnthropicMessageRequest": {
@@ -9721,6 +10830,91 @@ const docTemplate = `{
                 }
             }
         },
+        "model.BatchErrorData": {
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "line": {
+                    "type": "integer"
+                },
+                "message": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.BatchErrors": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.BatchErrorData"
+                    }
+                },
+                "object": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.BatchList": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_labring_aiproxy_core_relay_model.Batch"
+                    }
+                },
+                "first_id": {
+                    "type": "string"
+                },
+                "has_more": {
+                    "type": "boolean"
+                },
+                "last_id": {
+                    "type": "string"
+                },
+                "object": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.BatchRequest": {
+            "type": "object",
+            "properties": {
+                "completion_window": {
+                    "type": "string"
+                },
+                "endpoint": {
+                    "type": "string"
+                },
+                "input_file_id": {
+                    "type": "string"
+                },
+                "metadata": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "model.BatchRequestCounts": {
+            "type": "object",
+            "properties": {
+                "completed": {
+                    "type": "integer"
+                },
+                "failed": {
+                    "type": "integer"
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
         "model.Channel": {
             "type": "object",
             "properties": {
@@ -9745,6 +10939,9 @@ const docTemplate = `{
                 "configs": {
                     "$ref": "#/definitions/model.ChannelConfigs"
                 },
+                "cost": {
+                    "type": "number"
+                },
                 "created_at": {
                     "type": "string"
                 },
@@ -9760,12 +10957,36 @@ const docTemplate = `{
                 "last_test_error_at": {
                     "type": "string"
                 },
+                "max_concurrency": {
+                    "type": "integer"
+                },
                 "model_mapping": {
                     "type": "object",
                     "additionalProperties": {
                         "type": "string"
                     }
                 },
+                "model_max_concurrency": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "model_rpm": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "model_tpm": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
                 "models": {
                     "type": "array",
                     "items": {
@@ -9784,6 +11005,9 @@ const docTemplate = `{
                 "retry_count": {
                     "type": "integer"
                 },
+                "rpm": {
+                    "type": "integer"
+                },
                 "sets": {
                     "type": "array",
                     "items": {
@@ -9793,6 +11017,9 @@ const docTemplate = `{
                 "status": {
                     "type": "integer"
                 },
+                "tpm": {
+                    "type": "integer"
+                },
                 "type": {
                     "$ref": "#/definitions/model.ChannelType"
                 },
@@ -9805,6 +11032,23 @@ const docTemplate = `{
             "type": "object",
             "additionalProperties": {}
         },
+        "model.ChannelStrategy": {
+            "type": "string",
+            "enum": [
+                "priority",
+                "cheapest",
+                "latency",
+                "round_robin",
+                "least_in_flight"
+            ],
+            "x-enum-varnames": [
+                "ChannelStrategyPriority",
+                "ChannelStrategyCheapest",
+                "ChannelStrategyLatency",
+                "ChannelStrategyRoundRobin",
+                "ChannelStrategyLeastInFlight"
+            ]
+        },
         "model.ChannelTest": {
             "type": "object",
             "properties": {
@@ -10350,6 +11594,43 @@ const docTemplate = `{
                 }
             }
         },
+        "model.FileDeleted": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "type": "boolean"
+                },
+                "id": {
+                    "type": "string"
+                },
+                "object": {
+                    "type": "string"
+                }
+            }
+        },
+        "model.FileList": {
+            "type": "object",
+            "properties": {
+                "data": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/github_com_labring_aiproxy_core_relay_model.File"
+                    }
+                },
+                "first_id": {
+                    "type": "string"
+                },
+                "has_more": {
+                    "type": "boolean"
+                },
+                "last_id": {
+                    "type": "string"
+                },
+                "object": {
+                    "type": "string"
+                }
+            }
+        },
         "model.FinishReason": {
             "type": "string",
             "enum": [
@@ -10559,6 +11840,33 @@ const docTemplate = `{
                 "id": {
                     "type": "string"
                 },
+                "mcp_allow_tools": {
+                    "description": "MCPAllowTools, MCPDenyTools and MCPToolsRPM limit the mcp tools of all tokens of\nthe group, see the same fields of the token",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_deny_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_tools_rpm": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "queue_max_depth": {
+                    "type": "integer"
+                },
+                "queue_max_wait": {
+                    "description": "Queue mode, requests exceeding the rpm/tpm wait up to QueueMaxWait seconds\ninstead of being rejected, disabled when QueueMaxWait is 0",
+                    "type": "integer"
+                },
                 "request_count": {
                     "type": "integer"
                 },
@@ -10769,6 +12077,9 @@ const docTemplate = `{
         "model.GroupModelConfig": {
             "type": "object",
             "properties": {
+                "fallback_model": {
+                    "type": "string"
+                },
                 "force_save_detail": {
                     "type": "boolean"
                 },
@@ -10785,18 +12096,32 @@ const docTemplate = `{
                 "model": {
                     "type": "string"
                 },
+                "override_fallback_model": {
+                    "type": "boolean"
+                },
                 "override_force_save_detail": {
                     "type": "boolean"
                 },
                 "override_limit": {
                     "type": "boolean"
                 },
+                "override_plugin": {
+                    "description": "OverridePlugin replaces the configs of the plugins set in Plugin, the other plugins keep the model configs",
+                    "type": "boolean"
+                },
                 "override_price": {
                     "type": "boolean"
                 },
                 "override_retry_times": {
                     "type": "boolean"
                 },
+                "plugin": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "object",
+                        "additionalProperties": {}
+                    }
+                },
                 "price": {
                     "$ref": "#/definitions/model.Price"
                 },
@@ -10811,6 +12136,25 @@ const docTemplate = `{
                 }
             }
         },
+        "model.HedgeConfig": {
+            "type": "object",
+            "properties": {
+                "enabled": {
+                    "type": "boolean"
+                },
+                "max_delay": {
+                    "type": "integer"
+                },
+                "min_delay": {
+                    "description": "MinDelay and MaxDelay bound the delay in milliseconds,\nMaxDelay is also the delay until enough latencies are collected",
+                    "type": "integer"
+                },
+                "percentile": {
+                    "description": "Percentile (0-100] of the recent latencies used as the delay, default 95",
+                    "type": "number"
+                }
+            }
+        },
         "model.ImageData": {
             "type": "object",
             "properties": {
@@ -11069,6 +12413,9 @@ const docTemplate = `{
                 "channel": {
                     "type": "integer"
                 },
+                "channel_strategy": {
+                    "type": "string"
+                },
                 "code": {
                     "type": "integer"
                 },
@@ -11102,6 +12449,9 @@ const docTemplate = `{
                 "model": {
                     "type": "string"
                 },
+                "model_alias": {
+                    "type": "string"
+                },
                 "price": {
                     "$ref": "#/definitions/model.Price"
                 },
@@ -11126,6 +12476,9 @@ const docTemplate = `{
                 "token_name": {
                     "type": "string"
                 },
+                "trace_id": {
+                    "type": "string"
+                },
                 "ttfb_milliseconds": {
                     "type": "integer"
                 },
@@ -11193,6 +12546,37 @@ const docTemplate = `{
                 }
             }
         },
+        "model.MCPStdioConfig": {
+            "type": "object",
+            "properties": {
+                "args": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "command": {
+                    "type": "string"
+                },
+                "env": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "string"
+                    }
+                },
+                "idle_timeout": {
+                    "description": "idle timeout of the process in seconds, 0 uses the global config",
+                    "type": "integer"
+                },
+                "reusing": {
+                    "description": "the reusing params are passed to the process as env",
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/model.ReusingParam"
+                    }
+                }
+            }
+        },
         "model.Message": {
             "type": "object",
             "properties": {
@@ -11220,9 +12604,51 @@ const docTemplate = `{
                 }
             }
         },
+        "model.ModelAlias": {
+            "type": "object",
+            "properties": {
+                "sticky": {
+                    "description": "Sticky assigns the same target to the same user,\nthe user is the ` + "`" + `user` + "`" + ` of the request or the token name if it is empty",
+                    "type": "boolean"
+                },
+                "targets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.ModelAliasTarget"
+                    }
+                }
+            }
+        },
+        "model.ModelAliasTarget": {
+            "type": "object",
+            "properties": {
+                "model": {
+                    "type": "string"
+                },
+                "rollout": {
+                    "description": "Rollout is the percentage (0-100] of the traffic sent to the target before the weights\napply, raising it keeps the sticky users already assigned to the target",
+                    "type": "number"
+                },
+                "weight": {
+                    "description": "Weight splits the traffic left by the rollout targets",
+                    "type": "integer"
+                }
+            }
+        },
         "model.ModelConfig": {
             "type": "object",
             "properties": {
+                "alias": {
+                    "description": "Alias makes the model a virtual model name resolved to the target models",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.ModelAlias"
+                        }
+                    ]
+                },
+                "channel_strategy": {
+                    "$ref": "#/definitions/model.ChannelStrategy"
+                },
                 "config": {
                     "type": "object",
                     "additionalProperties": {}
@@ -11233,9 +12659,21 @@ const docTemplate = `{
                 "exclude_from_tests": {
                     "type": "boolean"
                 },
+                "fallback_model": {
+                    "description": "FallbackModel answers the request when all the channels of the model are exhausted",
+                    "type": "string"
+                },
                 "force_save_detail": {
                     "type": "boolean"
                 },
+                "hedge": {
+                    "description": "Hedge is only applied to the non-stream requests",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/model.HedgeConfig"
+                        }
+                    ]
+                },
                 "image_prices": {
                     "description": "map[size]price_per_image",
                     "type": "object",
@@ -11462,6 +12900,10 @@ const docTemplate = `{
                 "audio_input_price_unit": {
                     "type": "integer"
                 },
+                "batch_discount": {
+                    "description": "BatchDiscount is the ratio of the price charged for requests of a batch,\ne.g. 0.5 means half price, 0 means the BatchDiscount option is used",
+                    "type": "number"
+                },
                 "cache_creation_price": {
                     "type": "number"
                 },
@@ -11628,6 +13070,9 @@ const docTemplate = `{
                 "status": {
                     "$ref": "#/definitions/model.PublicMCPStatus"
                 },
+                "stdio_config": {
+                    "$ref": "#/definitions/model.MCPStdioConfig"
+                },
                 "tags": {
                     "type": "array",
                     "items": {
@@ -11731,7 +13176,8 @@ const docTemplate = `{
                 "mcp_proxy_streamable",
                 "mcp_docs",
                 "mcp_openapi",
-                "mcp_embed"
+                "mcp_embed",
+                "mcp_stdio"
             ],
             "x-enum-comments": {
                 "PublicMCPTypeDocs": "read only"
@@ -11741,6 +13187,7 @@ const docTemplate = `{
                 "",
                 "read only",
                 "",
+                "",
                 ""
             ],
             "x-enum-varnames": [
@@ -11748,7 +13195,8 @@ const docTemplate = `{
                 "PublicMCPTypeProxyStreamable",
                 "PublicMCPTypeDocs",
                 "PublicMCPTypeOpenAPI",
-                "PublicMCPTypeEmbed"
+                "PublicMCPTypeEmbed",
+                "PublicMCPTypeStdio"
             ]
         },
         "model.RequestDetail": {
@@ -12276,6 +13724,25 @@ const docTemplate = `{
                 }
             }
         },
+        "model.TokenModelBudget": {
+            "type": "object",
+            "properties": {
+                "amount": {
+                    "type": "number"
+                },
+                "model": {
+                    "type": "string"
+                },
+                "period_type": {
+                    "description": "PeriodType is hourly, daily, weekly, monthly or rolling, default is daily,\nthe calendar periods start at the beginning of the hour, day, week (monday) or month",
+                    "type": "string"
+                },
+                "rolling_window": {
+                    "description": "RollingWindow is the window of the rolling period in minutes",
+                    "type": "integer"
+                }
+            }
+        },
         "model.Tool": {
             "type": "object",
             "properties": {
@@ -12322,6 +13789,31 @@ const docTemplate = `{
                 "balance_alert_threshold": {
                     "type": "number"
                 },
+                "mcp_allow_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_deny_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_tools_rpm": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "queue_max_depth": {
+                    "type": "integer"
+                },
+                "queue_max_wait": {
+                    "type": "integer"
+                },
                 "rpm_ratio": {
                     "type": "number"
                 },
@@ -12336,12 +13828,47 @@ const docTemplate = `{
         "model.UpdateTokenRequest": {
             "type": "object",
             "properties": {
+                "expires_at": {
+                    "description": "ExpiresAt is the expiry in milliseconds, 0 never expires",
+                    "type": "integer"
+                },
+                "mcp_allow_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_deny_tools": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "mcp_tools_rpm": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "model_budgets": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/model.TokenModelBudget"
+                    }
+                },
                 "models": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
+                "modes": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/mode.Mode"
+                    }
+                },
                 "name": {
                     "type": "string"
                 },
@@ -12354,6 +13881,12 @@ const docTemplate = `{
                 "period_type": {
                     "type": "string"
                 },
+                "queue_max_depth": {
+                    "type": "integer"
+                },
+                "queue_max_wait": {
+                    "type": "integer"
+                },
                 "quota": {
                     "description": "Quota system",
                     "type": "number"
@@ -12521,6 +14054,21 @@ const docTemplate = `{
                 }
             }
         },
+        "monitor.InFlight": {
+            "type": "object",
+            "properties": {
+                "models": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "type": "integer",
+                        "format": "int64"
+                    }
+                },
+                "total": {
+                    "type": "integer"
+                }
+            }
+        },
         "openai.SubscriptionResponse": {
             "type": "object",
             "properties": {
@@ -12,7 +12,9 @@ import (
 	"github.com/labring/aiproxy/core/common"
 	"github.com/labring/aiproxy/core/common/config"
 	"github.com/labring/aiproxy/core/common/consume"
+	"github.com/labring/aiproxy/core/common/tracing"
 	"github.com/labring/aiproxy/core/controller"
+	mcp "github.com/labring/aiproxy/core/controller/mcp"
 	"github.com/labring/aiproxy/core/model"
 	"github.com/labring/aiproxy/core/task"
 	log "github.com/sirupsen/logrus"
@@ -54,6 +56,11 @@ func main() {
 		log.Fatal("failed to initialize services: " + err.Error())
 	}
 
+	shutdownTracing, err := tracing.Init(context.Background())
+	if err != nil {
+		log.Fatal("failed to initialize tracing: " + err.Error())
+	}
+
 	defer func() {
 		if err := model.CloseDB(); err != nil {
 			log.Fatal("failed to close database: " + err.Error())
@@ -84,6 +91,12 @@ func main() {
 
 	go task.UsageAlertTask(ctx)
 
+	log.Info("batch task started")
+
+	wg.Add(1)
+
+	go task.BatchTask(ctx, &wg)
+
 	log.Info("update channels balance task started")
 
 	go controller.UpdateChannelsBalance(time.Minute * 10)
@@ -113,6 +126,9 @@ func main() {
 		log.Info("server shutdown successfully")
 	}
 
+	log.Info("shutting down stdio mcp...")
+	mcp.CloseStdioMCPs()
+
 	log.Info("shutting down consumer...")
 	consume.Wait()
 
@@ -129,5 +145,11 @@ func main() {
 
 	model.CleanBatchUpdatesSummary(cleanCtx)
 
+	log.Info("shutting down tracing...")
+
+	if err := shutdownTracing(cleanCtx); err != nil {
+		log.Error("failed to shutdown tracing: " + err.Error())
+	}
+
 	log.Info("server exiting")
 }
@@ -0,0 +1,178 @@
+package mcpproxy
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+
+	"github.com/bytedance/sonic"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/mcp"
+)
+
+// ToolNameSeparator joins the mcp id and the tool name, the tool policies
+// match the tools by the joined name like `mcp_id__tool`
+const ToolNameSeparator = "__"
+
+// ToolName returns the name of the tool of the mcp matched by the tool policies
+func ToolName(mcpID, tool string) string {
+	return mcpID + ToolNameSeparator + tool
+}
+
+// The JSON-RPC error codes of the rejected tool calls, in the range of the
+// implementation defined server errors
+const (
+	ToolCallDeniedCode      = -32001
+	ToolCallRateLimitedCode = -32002
+	// BatchRejectedCode answers the requests of a batch that is not sent
+	// because another request of the batch is rejected
+	BatchRejectedCode = -32003
+)
+
+// ToolCallError is returned to the client as a JSON-RPC error when a tool call is rejected
+type ToolCallError struct {
+	Code    int
+	Message string
+	Data    any
+}
+
+// ToolCallGuard checks a tools/call request before it is handled, a non-nil
+// error rejects the call
+type ToolCallGuard func(ctx context.Context, tool string) *ToolCallError
+
+type toolCallRequest struct {
+	ID     any    `json:"id"`
+	Method string `json:"method"`
+	Params struct {
+		Name string `json:"name"`
+	} `json:"params"`
+}
+
+// check returns the reject of the message, nil when the message is not a
+// rejected tools/call request
+func (g ToolCallGuard) check(ctx context.Context, message []byte) mcp.JSONRPCMessage {
+	var req toolCallRequest
+	if err := sonic.Unmarshal(message, &req); err != nil ||
+		req.Method != string(mcp.MethodToolsCall) {
+		return nil
+	}
+
+	err := g(ctx, req.Params.Name)
+	if err == nil {
+		return nil
+	}
+
+	return mcpservers.CreateMCPErrorResponse(req.ID, err.Code, err.Message, err.Data)
+}
+
+// GuardServer checks the tool calls handled by the server, the server is
+// returned as is when the guard is nil
+func GuardServer(s mcpservers.Server, guard ToolCallGuard) mcpservers.Server {
+	if guard == nil {
+		return s
+	}
+	return &guardedServer{Server: s, guard: guard}
+}
+
+type guardedServer struct {
+	mcpservers.Server
+	guard ToolCallGuard
+}
+
+func (s *guardedServer) HandleMessage(
+	ctx context.Context,
+	message json.RawMessage,
+) mcp.JSONRPCMessage {
+	if reject := s.guard.check(ctx, message); reject != nil {
+		return reject
+	}
+	return s.Server.HandleMessage(ctx, message)
+}
+
+// GuardObserver checks the tool calls posted through a streamable proxy before
+// they are passed to next, a batch is rejected as a whole when one of its
+// calls is rejected since the posted body is forwarded as is
+func GuardObserver(guard ToolCallGuard, next MessageObserver) MessageObserver {
+	if guard == nil {
+		return next
+	}
+
+	return func(ctx context.Context, request []byte) (mcp.JSONRPCMessage, func([]byte)) {
+		messages := SplitMessages(request)
+
+		rejects := make(map[int]mcp.JSONRPCMessage)
+
+		for i, message := range messages {
+			if reject := guard.check(ctx, message); reject != nil {
+				rejects[i] = reject
+			}
+		}
+
+		if len(rejects) > 0 {
+			return RejectBatch(messages, rejects), nil
+		}
+
+		if next == nil {
+			return nil, nil
+		}
+
+		return next(ctx, request)
+	}
+}
+
+// SplitMessages splits a JSON-RPC batch into its messages
+func SplitMessages(data []byte) [][]byte {
+	trimmed := strings.TrimSpace(string(data))
+	if !strings.HasPrefix(trimmed, "[") {
+		return [][]byte{data}
+	}
+
+	var batch []json.RawMessage
+	if err := sonic.Unmarshal(data, &batch); err != nil {
+		return [][]byte{data}
+	}
+
+	messages := make([][]byte, len(batch))
+	for i, m := range batch {
+		messages[i] = m
+	}
+
+	return messages
+}
+
+type batchRequest struct {
+	ID     any    `json:"id"`
+	Method string `json:"method"`
+}
+
+// RejectBatch returns the response of the messages that are not sent because
+// of the rejects by the index of their messages, the other requests of a
+// batch are answered with BatchRejectedCode so that every request gets a
+// response, the notifications and the responses of the client are not answered
+func RejectBatch(messages [][]byte, rejects map[int]mcp.JSONRPCMessage) mcp.JSONRPCMessage {
+	if len(messages) == 1 {
+		return rejects[0]
+	}
+
+	responses := make([]mcp.JSONRPCMessage, 0, len(messages))
+
+	for i, message := range messages {
+		if reject, ok := rejects[i]; ok {
+			responses = append(responses, reject)
+			continue
+		}
+
+		var req batchRequest
+		if err := sonic.Unmarshal(message, &req); err != nil || req.ID == nil || req.Method == "" {
+			continue
+		}
+
+		responses = append(responses, mcpservers.CreateMCPErrorResponse(
+			req.ID,
+			BatchRejectedCode,
+			"the request is not sent because another request of the batch is rejected",
+		))
+	}
+
+	return responses
+}
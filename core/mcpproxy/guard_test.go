@@ -0,0 +1,116 @@
+package mcpproxy_test
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type okServer struct{}
+
+func (okServer) HandleMessage(_ context.Context, _ json.RawMessage) mcp.JSONRPCMessage {
+	return mcpservers.CreateMCPResultResponse(1, json.RawMessage("{}"))
+}
+
+func denyTool(denied string) mcpproxy.ToolCallGuard {
+	return func(_ context.Context, tool string) *mcpproxy.ToolCallError {
+		if tool != denied {
+			return nil
+		}
+
+		return &mcpproxy.ToolCallError{
+			Code:    mcpproxy.ToolCallDeniedCode,
+			Message: "tool " + tool + " is not allowed",
+		}
+	}
+}
+
+func marshal(t *testing.T, msg mcp.JSONRPCMessage) string {
+	t.Helper()
+
+	data, err := sonic.Marshal(msg)
+	require.NoError(t, err)
+
+	return string(data)
+}
+
+func TestGuardServer(t *testing.T) {
+	var s mcpservers.Server = okServer{}
+	assert.Equal(t, s, mcpproxy.GuardServer(s, nil))
+
+	s = mcpproxy.GuardServer(s, denyTool("delete"))
+
+	resp := s.HandleMessage(
+		t.Context(),
+		json.RawMessage(
+			`{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"delete"}}`,
+		),
+	)
+	assert.JSONEq(
+		t,
+		`{"jsonrpc":"2.0","id":7,"error":{"code":-32001,"message":"tool delete is not allowed"}}`,
+		marshal(t, resp),
+	)
+
+	resp = s.HandleMessage(
+		t.Context(),
+		json.RawMessage(
+			`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`,
+		),
+	)
+	assert.Contains(t, marshal(t, resp), `"result"`)
+
+	resp = s.HandleMessage(
+		t.Context(),
+		json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"tools/list"}`),
+	)
+	assert.Contains(t, marshal(t, resp), `"result"`)
+}
+
+func TestGuardObserver(t *testing.T) {
+	var passed int
+
+	next := func(context.Context, []byte) (mcp.JSONRPCMessage, func([]byte)) {
+		passed++
+		return nil, nil
+	}
+
+	observer := mcpproxy.GuardObserver(denyTool("delete"), next)
+
+	reject, _ := observer(
+		t.Context(),
+		[]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"delete"}}`),
+	)
+	require.NotNil(t, reject)
+	assert.Contains(t, marshal(t, reject), `"code":-32001`)
+
+	reject, _ = observer(t.Context(), []byte(`[
+		{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}},
+		{"jsonrpc":"2.0","id":2,"method":"tools/call","params":{"name":"delete"}}
+	]`))
+	rejects, ok := reject.([]mcp.JSONRPCMessage)
+	require.True(t, ok)
+	require.Len(t, rejects, 2)
+	assert.JSONEq(
+		t,
+		`{"jsonrpc":"2.0","id":1,"error":{"code":-32003,`+
+			`"message":"the request is not sent because another request of the batch is rejected"}}`,
+		marshal(t, rejects[0]),
+	)
+	assert.Contains(t, marshal(t, rejects[1]), `"id":2`)
+	assert.Equal(t, 0, passed)
+
+	reject, _ = observer(
+		t.Context(),
+		[]byte(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"search"}}`),
+	)
+	assert.Nil(t, reject)
+	assert.Equal(t, 1, passed)
+}
@@ -0,0 +1,236 @@
+package mcpproxy
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/bytedance/sonic"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+)
+
+// ErrSamplingNotSupported is returned to the mcp server when there is no sampling handler
+var ErrSamplingNotSupported = errors.New("sampling is not supported")
+
+// SamplingHandler answers the sampling/createMessage requests of the mcp servers
+type SamplingHandler func(
+	ctx context.Context,
+	params mcp.CreateMessageParams,
+) (*mcp.CreateMessageResult, error)
+
+type samplingHandlerKey struct{}
+
+// samplingSessionID is the session of the embedded servers that are called without one
+const samplingSessionID = "aiproxy-sampling"
+
+// contextServer only attaches the sampling sessions to the contexts
+var contextServer = server.NewMCPServer(samplingSessionID, "1.0.0")
+
+// CreateMessage answers the sampling requests of the embedded servers
+func (h SamplingHandler) CreateMessage(
+	ctx context.Context,
+	request mcp.CreateMessageRequest,
+) (*mcp.CreateMessageResult, error) {
+	return h(ctx, request.CreateMessageParams)
+}
+
+// WithSamplingHandler returns a context whose mcp requests are sampled by the
+// handler, the embedded servers get a session that answers their sampling
+// requests with the handler when the context has no session
+func WithSamplingHandler(ctx context.Context, handler SamplingHandler) context.Context {
+	ctx = context.WithValue(ctx, samplingHandlerKey{}, handler)
+	if server.ClientSessionFromContext(ctx) == nil {
+		ctx = contextServer.WithContext(ctx, server.NewInProcessSession(samplingSessionID, handler))
+	}
+
+	return server.WithInProcessSamplingHandler(ctx, handler)
+}
+
+// SamplingHandlerFromContext returns the sampling handler of the context, nil when there is none
+func SamplingHandlerFromContext(ctx context.Context) SamplingHandler {
+	handler, _ := ctx.Value(samplingHandlerKey{}).(SamplingHandler)
+	return handler
+}
+
+// sample answers the params of a sampling/createMessage request with the handler
+func sample(ctx context.Context, handler SamplingHandler, params []byte) (json.RawMessage, error) {
+	if handler == nil {
+		return nil, ErrSamplingNotSupported
+	}
+
+	var p mcp.CreateMessageParams
+	if err := sonic.Unmarshal(params, &p); err != nil {
+		return nil, err
+	}
+
+	result, err := handler(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+
+	return sonic.Marshal(result)
+}
+
+// requestHandler answers the requests sent by the mcp server through a
+// bidirectional transport with the sampling handler of the request context,
+// or of fallback when the context has none
+func requestHandler(fallback func() SamplingHandler) transport.RequestHandler {
+	return func(
+		ctx context.Context,
+		request transport.JSONRPCRequest,
+	) (*transport.JSONRPCResponse, error) {
+		if request.Method != string(mcp.MethodSamplingCreateMessage) {
+			return transport.NewJSONRPCErrorResponse(
+				request.ID,
+				mcp.METHOD_NOT_FOUND,
+				"method "+request.Method+" is not supported",
+				nil,
+			), nil
+		}
+
+		handler := SamplingHandlerFromContext(ctx)
+		if handler == nil && fallback != nil {
+			handler = fallback()
+		}
+
+		params, err := sonic.Marshal(request.Params)
+		if err != nil {
+			return nil, err
+		}
+
+		result, err := sample(ctx, handler, params)
+		if err != nil {
+			return nil, err
+		}
+
+		return transport.NewJSONRPCResultResponse(request.ID, result), nil
+	}
+}
+
+// EnableSampling answers the sampling requests of the mcp server behind the
+// client with the sampling handler of the request context, the clients that
+// can not receive requests are not changed
+func EnableSampling(client transport.Interface) {
+	if c, ok := client.(transport.BidirectionalInterface); ok {
+		c.SetRequestHandler(requestHandler(nil))
+	}
+}
+
+// SamplingServer answers the sampling requests of the server with the
+// handler, the server is returned as is when the handler is nil
+func SamplingServer(s mcpservers.Server, handler SamplingHandler) mcpservers.Server {
+	if handler == nil {
+		return s
+	}
+	return &samplingServer{Server: s, handler: handler}
+}
+
+type samplingServer struct {
+	mcpservers.Server
+	handler SamplingHandler
+}
+
+func (s *samplingServer) HandleMessage(
+	ctx context.Context,
+	message json.RawMessage,
+) mcp.JSONRPCMessage {
+	return s.Server.HandleMessage(
+		WithSamplingHandler(ctx, s.handler),
+		WithSamplingCapability(message),
+	)
+}
+
+// WithSamplingCapability declares the sampling capability in the initialize
+// request, so the mcp server knows it can send sampling requests, the other
+// messages are returned as is
+func WithSamplingCapability(message []byte) []byte {
+	var req map[string]json.RawMessage
+	if err := sonic.Unmarshal(message, &req); err != nil {
+		return message
+	}
+
+	var method string
+	if err := sonic.Unmarshal(req["method"], &method); err != nil ||
+		method != string(mcp.MethodInitialize) {
+		return message
+	}
+
+	var params map[string]json.RawMessage
+	if len(req["params"]) > 0 {
+		if err := sonic.Unmarshal(req["params"], &params); err != nil {
+			return message
+		}
+	}
+
+	if params == nil {
+		params = make(map[string]json.RawMessage, 1)
+	}
+
+	var capabilities map[string]json.RawMessage
+	if len(params["capabilities"]) > 0 {
+		if err := sonic.Unmarshal(params["capabilities"], &capabilities); err != nil {
+			return message
+		}
+	}
+
+	if _, ok := capabilities["sampling"]; ok {
+		return message
+	}
+
+	if capabilities == nil {
+		capabilities = make(map[string]json.RawMessage, 1)
+	}
+
+	capabilities["sampling"] = json.RawMessage("{}")
+
+	var err error
+
+	params["capabilities"], err = sonic.Marshal(capabilities)
+	if err != nil {
+		return message
+	}
+
+	req["params"], err = sonic.Marshal(params)
+	if err != nil {
+		return message
+	}
+
+	data, err := sonic.Marshal(req)
+	if err != nil {
+		return message
+	}
+
+	return data
+}
+
+type samplingRequest struct {
+	ID     mcp.RequestId   `json:"id"`
+	Method string          `json:"method"`
+	Params json.RawMessage `json:"params"`
+}
+
+// parseSamplingRequest returns the request when the message is a
+// sampling/createMessage request of the mcp server
+func parseSamplingRequest(message []byte) (*samplingRequest, bool) {
+	var req samplingRequest
+	if err := sonic.Unmarshal(message, &req); err != nil ||
+		req.Method != string(mcp.MethodSamplingCreateMessage) ||
+		req.ID.IsNil() {
+		return nil, false
+	}
+
+	return &req, true
+}
+
+// answer returns the response of the sampling request
+func (r *samplingRequest) answer(ctx context.Context, handler SamplingHandler) mcp.JSONRPCMessage {
+	result, err := sample(ctx, handler, r.Params)
+	if err != nil {
+		return mcpservers.CreateMCPErrorResponse(r.ID.Value(), mcp.INTERNAL_ERROR, err.Error())
+	}
+
+	return mcpservers.CreateMCPResultResponse(r.ID.Value(), result)
+}
@@ -0,0 +1,239 @@
+package mcpproxy_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const sampleCall = `{"jsonrpc":"2.0","id":7,"method":"tools/call","params":{"name":"sample","arguments":{"text":"hi"}}}`
+
+// addSampleTool adds the sample tool that returns the sampled text of its text
+func addSampleTool(s *server.MCPServer) {
+	s.AddTool(
+		mcp.NewTool("sample", mcp.WithString("text")),
+		func(ctx context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			result, err := server.ServerFromContext(ctx).RequestSampling(
+				ctx,
+				mcp.CreateMessageRequest{
+					CreateMessageParams: mcp.CreateMessageParams{
+						Messages: []mcp.SamplingMessage{{
+							Role:    mcp.RoleUser,
+							Content: mcp.NewTextContent(req.GetString("text", "")),
+						}},
+						MaxTokens: 16,
+					},
+				},
+			)
+			if err != nil {
+				return mcp.NewToolResultError(err.Error()), nil
+			}
+
+			return mcp.NewToolResultText(contentText(result.Content)), nil
+		},
+	)
+}
+
+func contentText(content any) string {
+	data, _ := sonic.Marshal(content)
+
+	var c struct {
+		Text string `json:"text"`
+	}
+
+	_ = sonic.Unmarshal(data, &c)
+
+	return c.Text
+}
+
+func sampled(_ context.Context, params mcp.CreateMessageParams) (*mcp.CreateMessageResult, error) {
+	if len(params.Messages) != 1 {
+		return nil, errors.New("unexpected messages")
+	}
+
+	return &mcp.CreateMessageResult{
+		SamplingMessage: mcp.SamplingMessage{
+			Role:    mcp.RoleAssistant,
+			Content: mcp.NewTextContent("sampled " + contentText(params.Messages[0].Content)),
+		},
+		Model:      "test",
+		StopReason: "endTurn",
+	}, nil
+}
+
+func newSampleServer() *server.MCPServer {
+	s := server.NewMCPServer("sample-test", "1.0.0")
+	addSampleTool(s)
+
+	return s
+}
+
+// toolResultText returns the text of the tool result in the JSON-RPC response
+func toolResultText(t *testing.T, data []byte) string {
+	t.Helper()
+
+	var resp struct {
+		Result struct {
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+			IsError bool `json:"isError"`
+		} `json:"result"`
+	}
+	require.NoError(t, sonic.Unmarshal(data, &resp), string(data))
+	require.Len(t, resp.Result.Content, 1, string(data))
+	assert.False(t, resp.Result.IsError, string(data))
+
+	return resp.Result.Content[0].Text
+}
+
+func TestWithSamplingCapability(t *testing.T) {
+	assert.JSONEq(
+		t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{"sampling":{}}}}`,
+		string(mcpproxy.WithSamplingCapability(
+			[]byte(`{"jsonrpc":"2.0","id":1,"method":"initialize"}`),
+		)),
+	)
+	assert.JSONEq(
+		t,
+		`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{"roots":{},"sampling":{}}}}`,
+		string(mcpproxy.WithSamplingCapability(
+			[]byte(
+				`{"jsonrpc":"2.0","id":1,"method":"initialize","params":{"capabilities":{"roots":{}}}}`,
+			),
+		)),
+	)
+	assert.Equal(t, sampleCall, string(mcpproxy.WithSamplingCapability([]byte(sampleCall))))
+}
+
+func TestSamplingServer(t *testing.T) {
+	s := newSampleServer()
+
+	data, err := sonic.Marshal(
+		mcpproxy.SamplingServer(s, sampled).HandleMessage(t.Context(), json.RawMessage(sampleCall)),
+	)
+	require.NoError(t, err)
+	assert.Equal(t, "sampled hi", toolResultText(t, data))
+
+	assert.Same(t, mcpservers.Server(s), mcpproxy.SamplingServer(s, nil))
+}
+
+func TestStdioProcessSampling(t *testing.T) {
+	m := mcpproxy.NewStdioManager(mcpproxy.NewMemStore())
+	t.Cleanup(m.Close)
+
+	p, err := m.Get(t.Context(), "sample", "g1", stdioConfig(""), mcpproxy.StdioLimits{})
+	require.NoError(t, err)
+
+	ctx := mcpproxy.WithSamplingHandler(t.Context(), sampled)
+
+	data, err := sonic.Marshal(p.HandleMessage(ctx, json.RawMessage(sampleCall)))
+	require.NoError(t, err)
+	assert.Equal(t, "sampled hi", toolResultText(t, data))
+}
+
+func TestStdioProcessSamplingSharedCalls(t *testing.T) {
+	m := mcpproxy.NewStdioManager(mcpproxy.NewMemStore())
+	t.Cleanup(m.Close)
+
+	p, err := m.Get(t.Context(), "sample", "g1", stdioConfig(""), mcpproxy.StdioLimits{})
+	require.NoError(t, err)
+
+	// the call of another session is in flight while the tool samples
+	slept := make(chan struct{})
+	go func() {
+		defer close(slept)
+
+		p.HandleMessage(
+			mcpproxy.WithSamplingHandler(t.Context(), sampled),
+			json.RawMessage(
+				`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"sleep","arguments":{"ms":1000}}}`,
+			),
+		)
+	}()
+	time.Sleep(200 * time.Millisecond)
+
+	data, err := sonic.Marshal(
+		p.HandleMessage(mcpproxy.WithSamplingHandler(t.Context(), sampled), json.RawMessage(sampleCall)),
+	)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), mcpproxy.ErrStdioSamplingAmbiguous.Error())
+
+	<-slept
+}
+
+func TestStreamableProxySampling(t *testing.T) {
+	backend := httptest.NewServer(server.NewStreamableHTTPServer(newSampleServer()))
+	defer backend.Close()
+
+	proxy := httptest.NewServer(mcpproxy.NewStreamableProxy(
+		backend.URL,
+		nil,
+		mcpproxy.NewMemStore(),
+		mcpproxy.WithStreamableSamplingHandler(sampled),
+	))
+	defer proxy.Close()
+
+	post := func(sessionID, body string) *http.Response {
+		req, err := http.NewRequestWithContext(
+			t.Context(),
+			http.MethodPost,
+			proxy.URL,
+			strings.NewReader(body),
+		)
+		require.NoError(t, err)
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Accept", "application/json, text/event-stream")
+
+		if sessionID != "" {
+			req.Header.Set("Mcp-Session-Id", sessionID)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+
+		return resp
+	}
+
+	resp := post("", `{"jsonrpc":"2.0","id":1,"method":"initialize","params":{}}`)
+	_ = resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	sessionID := resp.Header.Get("Mcp-Session-Id")
+	require.NotEmpty(t, sessionID)
+
+	// the backend sends the sampling requests through the stream of the session
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, proxy.URL, nil)
+	require.NoError(t, err)
+	req.Header.Set("Accept", "text/event-stream")
+	req.Header.Set("Mcp-Session-Id", sessionID)
+
+	stream, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+
+	defer stream.Body.Close()
+
+	require.Equal(t, http.StatusOK, stream.StatusCode)
+
+	resp = post(sessionID, sampleCall)
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.Equal(t, "sampled hi", toolResultText(t, body))
+}
@@ -0,0 +1,733 @@
+package mcpproxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/bytedance/sonic"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/client/transport"
+	"github.com/mark3labs/mcp-go/mcp"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	stdioStartTimeout = time.Minute
+	stdioStopTimeout  = 5 * time.Second
+	stdioReapInterval = 30 * time.Second
+)
+
+var (
+	// ErrStdioProcessLimit is returned when the process limits are reached and
+	// no process can be evicted
+	ErrStdioProcessLimit = errors.New("stdio mcp process limit reached")
+	// ErrStdioSamplingAmbiguous is returned to the process that samples while
+	// several calls are in flight, the calling session can not be told
+	ErrStdioSamplingAmbiguous = errors.New(
+		"sampling is not supported while the stdio mcp serves several calls",
+	)
+)
+
+// stdioBaseEnv are the env of the proxy passed to the processes,
+// the other env of the proxy like the secrets are never inherited
+var stdioBaseEnv = []string{"PATH", "HOME", "USER", "LANG", "TMPDIR", "SYSTEMROOT"}
+
+// StdioConfig is the command of a stdio mcp server
+type StdioConfig struct {
+	Command string
+	Args    []string
+	// Env is appended to the base env of the process
+	Env []string
+	// IdleTimeout closes the process that is not used for the duration, 0 never closes it
+	IdleTimeout time.Duration
+}
+
+func (c StdioConfig) hash() string {
+	h := sha256.New()
+	h.Write([]byte(c.Command))
+
+	for _, arg := range c.Args {
+		h.Write([]byte{0})
+		h.Write([]byte(arg))
+	}
+
+	h.Write([]byte{1})
+
+	for _, env := range c.Env {
+		h.Write([]byte{0})
+		h.Write([]byte(env))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// StdioLimits limits the processes of a StdioManager, 0 means no limit
+type StdioLimits struct {
+	MaxProcesses      int
+	MaxGroupProcesses int
+}
+
+// StdioManager spawns and supervises the processes of the stdio mcp servers,
+// one process is shared by all sessions of a group for each mcp, the
+// processes that exit or idle out are removed and their sessions are deleted
+// from the session manager
+type StdioManager struct {
+	store SessionManager
+
+	mu        sync.Mutex
+	processes map[string]*StdioProcess
+
+	reapOnce sync.Once
+}
+
+func NewStdioManager(store SessionManager) *StdioManager {
+	return &StdioManager{
+		store:     store,
+		processes: make(map[string]*StdioProcess),
+	}
+}
+
+func stdioKey(mcpID, group string) string {
+	return mcpID + ":" + group
+}
+
+// Get returns the running process of the mcp for the group, the process is
+// spawned when there is none or its config has changed
+func (m *StdioManager) Get(
+	ctx context.Context,
+	mcpID, group string,
+	config StdioConfig,
+	limits StdioLimits,
+) (*StdioProcess, error) {
+	m.reapOnce.Do(func() {
+		go m.reap()
+	})
+
+	key := stdioKey(mcpID, group)
+	hash := config.hash()
+
+	m.mu.Lock()
+
+	p, ok := m.processes[key]
+	if ok && p.hash != hash {
+		delete(m.processes, key)
+
+		go p.Close()
+
+		ok = false
+	}
+
+	if !ok {
+		if err := m.makeRoomLocked(group, limits); err != nil {
+			m.mu.Unlock()
+			return nil, err
+		}
+
+		p = newStdioProcess(m, key, group, hash, config.IdleTimeout)
+		m.processes[key] = p
+
+		go p.start(config)
+	}
+
+	m.mu.Unlock()
+
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-p.ready:
+	}
+
+	if p.err != nil {
+		return nil, p.err
+	}
+
+	p.touch()
+
+	return p, nil
+}
+
+// makeRoomLocked evicts the least recently used idle processes until a new
+// process of the group fits the limits
+func (m *StdioManager) makeRoomLocked(group string, limits StdioLimits) error {
+	for {
+		var total, groupTotal int
+
+		for _, p := range m.processes {
+			total++
+
+			if p.group == group {
+				groupTotal++
+			}
+		}
+
+		var evictGroup bool
+
+		switch {
+		case limits.MaxGroupProcesses > 0 && groupTotal >= limits.MaxGroupProcesses:
+			evictGroup = true
+		case limits.MaxProcesses > 0 && total >= limits.MaxProcesses:
+		default:
+			return nil
+		}
+
+		var lru *StdioProcess
+
+		for _, p := range m.processes {
+			if (evictGroup && p.group != group) || p.busy() {
+				continue
+			}
+
+			if lru == nil || p.lastUsed.Load() < lru.lastUsed.Load() {
+				lru = p
+			}
+		}
+
+		if lru == nil {
+			return ErrStdioProcessLimit
+		}
+
+		delete(m.processes, lru.key)
+
+		go lru.Close()
+	}
+}
+
+func (m *StdioManager) remove(p *StdioProcess) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.processes[p.key] == p {
+		delete(m.processes, p.key)
+	}
+}
+
+func (m *StdioManager) reap() {
+	ticker := time.NewTicker(stdioReapInterval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		m.CloseIdle()
+	}
+}
+
+// CloseIdle closes the processes that have idled out
+func (m *StdioManager) CloseIdle() {
+	now := time.Now()
+
+	m.mu.Lock()
+
+	idle := make([]*StdioProcess, 0, len(m.processes))
+	for key, p := range m.processes {
+		if p.idleTimeout <= 0 || p.busy() ||
+			now.Sub(time.Unix(0, p.lastUsed.Load())) < p.idleTimeout {
+			continue
+		}
+
+		delete(m.processes, key)
+
+		idle = append(idle, p)
+	}
+
+	m.mu.Unlock()
+
+	for _, p := range idle {
+		log.Infof("stdio mcp %s idled out", p.key)
+		p.Close()
+	}
+}
+
+// Close closes all processes
+func (m *StdioManager) Close() {
+	m.mu.Lock()
+
+	processes := make([]*StdioProcess, 0, len(m.processes))
+	for key, p := range m.processes {
+		delete(m.processes, key)
+
+		processes = append(processes, p)
+	}
+
+	m.mu.Unlock()
+
+	var wg sync.WaitGroup
+	for _, p := range processes {
+		wg.Go(p.Close)
+	}
+
+	wg.Wait()
+}
+
+// Len returns the number of processes
+func (m *StdioManager) Len() int {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return len(m.processes)
+}
+
+// StdioProcess is a running stdio mcp server, it is initialized once when it
+// is spawned, and the ids of the requests are rewritten so that the sessions
+// sharing the process do not collide
+type StdioProcess struct {
+	manager     *StdioManager
+	key         string
+	group       string
+	hash        string
+	idleTimeout time.Duration
+
+	// ready is closed when the process is started or failed to start
+	ready chan struct{}
+	err   error
+
+	cmd        *exec.Cmd
+	client     *transport.Stdio
+	server     mcpservers.Server
+	initResult json.RawMessage
+
+	// done is closed when the process exits or failed to start
+	done       chan struct{}
+	closeOnce  sync.Once
+	clientOnce sync.Once
+
+	nextID   atomic.Int64
+	lastUsed atomic.Int64
+	inflight atomic.Int64
+
+	// calls are the sampling handlers of the requests in flight by their ids
+	// of the process
+	callsMu sync.Mutex
+	calls   map[int64]SamplingHandler
+
+	sessionsMu sync.Mutex
+	sessions   map[string]struct{}
+}
+
+func newStdioProcess(
+	m *StdioManager,
+	key, group, hash string,
+	idleTimeout time.Duration,
+) *StdioProcess {
+	p := &StdioProcess{
+		manager:     m,
+		key:         key,
+		group:       group,
+		hash:        hash,
+		idleTimeout: idleTimeout,
+		ready:       make(chan struct{}),
+		done:        make(chan struct{}),
+		sessions:    make(map[string]struct{}),
+		calls:       make(map[int64]SamplingHandler),
+	}
+	p.touch()
+
+	return p
+}
+
+func (p *StdioProcess) touch() {
+	p.lastUsed.Store(time.Now().UnixNano())
+}
+
+// busy reports whether the process has sessions or requests in flight
+func (p *StdioProcess) busy() bool {
+	select {
+	case <-p.ready:
+	default:
+		return true
+	}
+
+	if p.inflight.Load() > 0 {
+		return true
+	}
+
+	p.sessionsMu.Lock()
+	defer p.sessionsMu.Unlock()
+
+	return len(p.sessions) > 0
+}
+
+func stdioEnv(env []string) []string {
+	base := make([]string, 0, len(stdioBaseEnv)+len(env))
+	for _, key := range stdioBaseEnv {
+		if value, ok := os.LookupEnv(key); ok {
+			base = append(base, key+"="+value)
+		}
+	}
+
+	return append(base, env...)
+}
+
+func (p *StdioProcess) start(config StdioConfig) {
+	defer close(p.ready)
+
+	if err := p.spawn(config); err != nil {
+		p.err = fmt.Errorf("start stdio mcp %s error: %w", p.key, err)
+		p.manager.remove(p)
+		close(p.done)
+
+		return
+	}
+
+	ctx, cancel := p.withDone(context.Background())
+	defer cancel()
+
+	ctx, cancelTimeout := context.WithTimeout(ctx, stdioStartTimeout)
+	defer cancelTimeout()
+
+	if err := p.initialize(ctx); err != nil {
+		p.err = fmt.Errorf("initialize stdio mcp %s error: %w", p.key, err)
+		p.stop()
+
+		return
+	}
+
+	log.Infof("stdio mcp %s started, pid: %d", p.key, p.cmd.Process.Pid)
+}
+
+// spawn starts the command with its own pipes, so the process is waited by
+// the supervisor without closing the pipes that the client is reading
+func (p *StdioProcess) spawn(config StdioConfig) error {
+	if config.Command == "" {
+		return errors.New("command is empty")
+	}
+
+	stdinR, stdinW, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+
+	stdoutR, stdoutW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+
+		return err
+	}
+
+	stderrR, stderrW, err := os.Pipe()
+	if err != nil {
+		stdinR.Close()
+		stdinW.Close()
+		stdoutR.Close()
+		stdoutW.Close()
+
+		return err
+	}
+
+	//nolint:gosec,noctx // the command is configured by the admin and outlives the request
+	cmd := exec.Command(config.Command, config.Args...)
+	cmd.Env = stdioEnv(config.Env)
+	cmd.Stdin = stdinR
+	cmd.Stdout = stdoutW
+	cmd.Stderr = stderrW
+
+	err = cmd.Start()
+
+	// the child has its own copies of the pipes
+	stdinR.Close()
+	stdoutW.Close()
+	stderrW.Close()
+
+	if err != nil {
+		stdinW.Close()
+		stdoutR.Close()
+		stderrR.Close()
+
+		return err
+	}
+
+	client := transport.NewIO(stdoutR, stdinW, nil)
+	client.SetRequestHandler(requestHandler(p.samplingHandler))
+
+	if err := client.Start(context.Background()); err != nil {
+		_ = cmd.Process.Kill()
+		_ = cmd.Wait()
+
+		stdinW.Close()
+		stdoutR.Close()
+		stderrR.Close()
+
+		return err
+	}
+
+	p.cmd = cmd
+	p.client = client
+	p.server = mcpservers.WrapMCPClient2Server(p.client)
+
+	go p.logStderr(stderrR)
+	go p.supervise(stdoutR)
+
+	return nil
+}
+
+// samplingHandler returns the handler of the sampling requests of the process,
+// the requests carry no id of the call that issued them, so they are answered
+// by the handler of the call in flight only when it is the only one, and
+// refused when the calls of several sessions share the process
+func (p *StdioProcess) samplingHandler() SamplingHandler {
+	p.callsMu.Lock()
+	defer p.callsMu.Unlock()
+
+	switch len(p.calls) {
+	case 0:
+		return nil
+	case 1:
+		for _, handler := range p.calls {
+			return handler
+		}
+	}
+
+	return func(context.Context, mcp.CreateMessageParams) (*mcp.CreateMessageResult, error) {
+		return nil, ErrStdioSamplingAmbiguous
+	}
+}
+
+// track records the sampling handler of the call until the returned func is called
+func (p *StdioProcess) track(id int64, handler SamplingHandler) func() {
+	p.callsMu.Lock()
+	p.calls[id] = handler
+	p.callsMu.Unlock()
+
+	return func() {
+		p.callsMu.Lock()
+		delete(p.calls, id)
+		p.callsMu.Unlock()
+	}
+}
+
+func (p *StdioProcess) logStderr(stderr *os.File) {
+	defer stderr.Close()
+
+	scanner := bufio.NewScanner(stderr)
+	for scanner.Scan() {
+		log.Debugf("stdio mcp %s: %s", p.key, scanner.Text())
+	}
+}
+
+// supervise waits for the process to exit, then removes it from the manager
+// and deletes its sessions
+func (p *StdioProcess) supervise(stdout *os.File) {
+	err := p.cmd.Wait()
+
+	close(p.done)
+	p.manager.remove(p)
+
+	p.closeClient()
+	stdout.Close()
+
+	p.sessionsMu.Lock()
+
+	for session := range p.sessions {
+		p.manager.store.Delete(session)
+	}
+
+	clear(p.sessions)
+	p.sessionsMu.Unlock()
+
+	if err != nil {
+		log.Warnf("stdio mcp %s exited: %v", p.key, err)
+	} else {
+		log.Infof("stdio mcp %s exited", p.key)
+	}
+}
+
+func (p *StdioProcess) initialize(ctx context.Context) error {
+	params, err := sonic.Marshal(mcp.InitializeParams{
+		ProtocolVersion: mcp.LATEST_PROTOCOL_VERSION,
+		ClientInfo: mcp.Implementation{
+			Name:    "aiproxy",
+			Version: "1.0.0",
+		},
+		Capabilities: mcp.ClientCapabilities{
+			Sampling: &struct{}{},
+		},
+	})
+	if err != nil {
+		return err
+	}
+
+	resp, err := p.client.SendRequest(ctx, transport.JSONRPCRequest{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		ID:      mcp.NewRequestId(p.nextID.Add(1)),
+		Method:  string(mcp.MethodInitialize),
+		Params:  json.RawMessage(params),
+	})
+	if err != nil {
+		return err
+	}
+
+	if resp.Error != nil {
+		return errors.New(resp.Error.Message)
+	}
+
+	p.initResult = resp.Result
+
+	return p.client.SendNotification(ctx, mcp.JSONRPCNotification{
+		JSONRPC: mcp.JSONRPC_VERSION,
+		Notification: mcp.Notification{
+			Method: "notifications/initialized",
+		},
+	})
+}
+
+// closeClient closes the stdin of the process, the client is closed by both
+// the supervisor and Close, and it can not be closed concurrently
+func (p *StdioProcess) closeClient() {
+	p.clientOnce.Do(func() {
+		_ = p.client.Close()
+	})
+}
+
+// Close stops the process, it is killed when it does not exit after its stdin is closed
+func (p *StdioProcess) Close() {
+	<-p.ready
+	p.stop()
+}
+
+func (p *StdioProcess) stop() {
+	p.closeOnce.Do(func() {
+		p.manager.remove(p)
+
+		if p.cmd == nil {
+			return
+		}
+
+		p.closeClient()
+
+		select {
+		case <-p.done:
+		case <-time.After(stdioStopTimeout):
+			_ = p.cmd.Process.Kill()
+
+			<-p.done
+		}
+	})
+}
+
+// Done is closed when the process exits
+func (p *StdioProcess) Done() <-chan struct{} {
+	return p.done
+}
+
+// withDone returns a ctx that is also canceled when the process exits
+func (p *StdioProcess) withDone(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	go func() {
+		select {
+		case <-p.done:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	return ctx, cancel
+}
+
+// Attach binds a session to the process, the process does not idle out while
+// it has sessions, and the sessions are deleted when the process exits
+func (p *StdioProcess) Attach(session string) {
+	p.sessionsMu.Lock()
+	defer p.sessionsMu.Unlock()
+
+	select {
+	case <-p.done:
+		p.manager.store.Delete(session)
+	default:
+		p.sessions[session] = struct{}{}
+	}
+}
+
+func (p *StdioProcess) Detach(session string) {
+	p.sessionsMu.Lock()
+	delete(p.sessions, session)
+	p.sessionsMu.Unlock()
+
+	p.touch()
+}
+
+type stdioRequest struct {
+	ID     mcp.RequestId `json:"id"`
+	Method string        `json:"method"`
+}
+
+// HandleMessage answers the initialize with the result of the process, drops
+// the notifications since the process is shared, and forwards the requests
+// with the ids of the process
+func (p *StdioProcess) HandleMessage(
+	ctx context.Context,
+	message json.RawMessage,
+) mcp.JSONRPCMessage {
+	p.inflight.Add(1)
+	defer func() {
+		p.touch()
+		p.inflight.Add(-1)
+	}()
+
+	var req stdioRequest
+	if err := sonic.Unmarshal(message, &req); err != nil {
+		return mcpservers.CreateMCPErrorResponse(nil, mcp.PARSE_ERROR, err.Error())
+	}
+
+	if req.ID.IsNil() || strings.HasPrefix(req.Method, "notifications/") {
+		return nil
+	}
+
+	if mcp.MCPMethod(req.Method) == mcp.MethodInitialize {
+		return mcpservers.CreateMCPResultResponse(req.ID.Value(), p.initResult)
+	}
+
+	var raw map[string]json.RawMessage
+	if err := sonic.Unmarshal(message, &raw); err != nil {
+		return mcpservers.CreateMCPErrorResponse(req.ID.Value(), mcp.PARSE_ERROR, err.Error())
+	}
+
+	processID := p.nextID.Add(1)
+
+	id, err := sonic.Marshal(processID)
+	if err != nil {
+		return mcpservers.CreateMCPErrorResponse(
+			req.ID.Value(),
+			mcp.INTERNAL_ERROR,
+			err.Error(),
+		)
+	}
+
+	raw["id"] = id
+
+	message, err = sonic.Marshal(raw)
+	if err != nil {
+		return mcpservers.CreateMCPErrorResponse(
+			req.ID.Value(),
+			mcp.INTERNAL_ERROR,
+			err.Error(),
+		)
+	}
+
+	defer p.track(processID, SamplingHandlerFromContext(ctx))()
+
+	// the requests in flight fail as soon as the process exits
+	ctx, cancel := p.withDone(ctx)
+	defer cancel()
+
+	switch resp := p.server.HandleMessage(ctx, message).(type) {
+	case *mcpservers.JSONRPCNoErrorResponse:
+		resp.ID = req.ID
+		return resp
+	case mcp.JSONRPCError:
+		resp.ID = req.ID
+		return resp
+	default:
+		return resp
+	}
+}
@@ -0,0 +1,200 @@
+package mcpproxy_test
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/labring/aiproxy/core/mcpproxy"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/mark3labs/mcp-go/server"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const stdioServerEnv = "MCPPROXY_STDIO_TEST_SERVER"
+
+// TestMain runs the test binary as a stdio mcp server when it is spawned by the tests
+func TestMain(m *testing.M) {
+	if os.Getenv(stdioServerEnv) != "" {
+		serveStdio()
+		return
+	}
+
+	os.Exit(m.Run())
+}
+
+func serveStdio() {
+	s := server.NewMCPServer("stdio-test", "1.0.0")
+	s.AddTool(
+		mcp.NewTool("echo", mcp.WithString("text")),
+		func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			return mcp.NewToolResultText(req.GetString("text", "") + os.Getenv("SUFFIX")), nil
+		},
+	)
+	s.AddTool(
+		mcp.NewTool("sleep", mcp.WithNumber("ms")),
+		func(_ context.Context, req mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			time.Sleep(time.Duration(req.GetFloat("ms", 0)) * time.Millisecond)
+			return mcp.NewToolResultText("slept"), nil
+		},
+	)
+	s.AddTool(
+		mcp.NewTool("exit"),
+		func(_ context.Context, _ mcp.CallToolRequest) (*mcp.CallToolResult, error) {
+			os.Exit(1)
+			return nil, nil
+		},
+	)
+	addSampleTool(s)
+
+	_ = server.ServeStdio(s)
+}
+
+func stdioConfig(suffix string) mcpproxy.StdioConfig {
+	return mcpproxy.StdioConfig{
+		Command: os.Args[0],
+		Env:     []string{stdioServerEnv + "=1", "SUFFIX=" + suffix},
+	}
+}
+
+func callEcho(t *testing.T, p *mcpproxy.StdioProcess, id any, text string) string {
+	t.Helper()
+
+	req, err := sonic.Marshal(map[string]any{
+		"jsonrpc": "2.0",
+		"id":      id,
+		"method":  "tools/call",
+		"params": map[string]any{
+			"name":      "echo",
+			"arguments": map[string]any{"text": text},
+		},
+	})
+	require.NoError(t, err)
+
+	data, err := sonic.Marshal(p.HandleMessage(t.Context(), req))
+	require.NoError(t, err)
+
+	var resp struct {
+		ID     json.RawMessage `json:"id"`
+		Result struct {
+			Content []struct {
+				Text string `json:"text"`
+			} `json:"content"`
+		} `json:"result"`
+	}
+	require.NoError(t, sonic.Unmarshal(data, &resp))
+
+	wantID, err := sonic.Marshal(id)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(wantID), string(resp.ID))
+	require.Len(t, resp.Result.Content, 1, string(data))
+
+	return resp.Result.Content[0].Text
+}
+
+func TestStdioProcess(t *testing.T) {
+	m := mcpproxy.NewStdioManager(mcpproxy.NewMemStore())
+	t.Cleanup(m.Close)
+
+	p, err := m.Get(t.Context(), "test", "g1", stdioConfig("!"), mcpproxy.StdioLimits{})
+	require.NoError(t, err)
+
+	init := p.HandleMessage(
+		t.Context(),
+		json.RawMessage(`{"jsonrpc":"2.0","id":"init","method":"initialize","params":{}}`),
+	)
+	data, err := sonic.Marshal(init)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `"id":"init"`)
+	assert.Contains(t, string(data), `"stdio-test"`)
+
+	assert.Nil(t, p.HandleMessage(
+		t.Context(),
+		json.RawMessage(`{"jsonrpc":"2.0","method":"notifications/initialized"}`),
+	))
+
+	assert.Equal(t, "hi!", callEcho(t, p, 1, "hi"))
+	assert.Equal(t, "hey!", callEcho(t, p, "1", "hey"))
+
+	same, err := m.Get(t.Context(), "test", "g1", stdioConfig("!"), mcpproxy.StdioLimits{})
+	require.NoError(t, err)
+	assert.Same(t, p, same)
+
+	changed, err := m.Get(t.Context(), "test", "g1", stdioConfig("?"), mcpproxy.StdioLimits{})
+	require.NoError(t, err)
+	assert.NotSame(t, p, changed)
+	assert.Equal(t, "hi?", callEcho(t, changed, 1, "hi"))
+	assert.Equal(t, 1, m.Len())
+}
+
+func TestStdioProcessLimits(t *testing.T) {
+	m := mcpproxy.NewStdioManager(mcpproxy.NewMemStore())
+	t.Cleanup(m.Close)
+
+	limits := mcpproxy.StdioLimits{MaxGroupProcesses: 1}
+
+	p1, err := m.Get(t.Context(), "a", "g1", stdioConfig(""), limits)
+	require.NoError(t, err)
+	p1.Attach("session")
+
+	_, err = m.Get(t.Context(), "b", "g1", stdioConfig(""), limits)
+	require.ErrorIs(t, err, mcpproxy.ErrStdioProcessLimit)
+
+	_, err = m.Get(t.Context(), "b", "g2", stdioConfig(""), limits)
+	require.NoError(t, err)
+
+	p1.Detach("session")
+
+	_, err = m.Get(t.Context(), "b", "g1", stdioConfig(""), limits)
+	require.NoError(t, err)
+	assert.Equal(t, 2, m.Len())
+}
+
+func TestStdioProcessExit(t *testing.T) {
+	store := mcpproxy.NewMemStore()
+	m := mcpproxy.NewStdioManager(store)
+	t.Cleanup(m.Close)
+
+	p, err := m.Get(t.Context(), "test", "g1", stdioConfig(""), mcpproxy.StdioLimits{})
+	require.NoError(t, err)
+
+	store.Set("session", "test")
+	p.Attach("session")
+
+	resp := p.HandleMessage(
+		t.Context(),
+		json.RawMessage(`{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"exit"}}`),
+	)
+	_, ok := resp.(mcp.JSONRPCError)
+	assert.True(t, ok)
+
+	require.Eventually(t, func() bool {
+		_, ok := store.Get("session")
+		return !ok && m.Len() == 0
+	}, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestStdioProcessIdle(t *testing.T) {
+	m := mcpproxy.NewStdioManager(mcpproxy.NewMemStore())
+	t.Cleanup(m.Close)
+
+	config := stdioConfig("")
+	config.IdleTimeout = time.Millisecond
+
+	p, err := m.Get(t.Context(), "test", "g1", config, mcpproxy.StdioLimits{})
+	require.NoError(t, err)
+	p.Attach("session")
+
+	time.Sleep(10 * time.Millisecond)
+	m.CloseIdle()
+	assert.Equal(t, 1, m.Len())
+
+	p.Detach("session")
+	time.Sleep(10 * time.Millisecond)
+	m.CloseIdle()
+	assert.Equal(t, 0, m.Len())
+}
@@ -2,23 +2,57 @@ package mcpproxy
 
 import (
 	"bufio"
+	"bytes"
 	"context"
 	"fmt"
 	"io"
 	"net/http"
 	"strings"
 	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/mark3labs/mcp-go/mcp"
 )
 
 const (
 	headerKeySessionID = "Mcp-Session-Id"
 )
 
+// MessageObserver observes the JSON-RPC messages posted through the proxy,
+// it is called with the request body before it is sent to the backend, a
+// non-nil reject is returned to the client instead of sending the request.
+// onResponse is called with each response message of the backend, and with
+// nil when the response ends
+type MessageObserver func(
+	ctx context.Context,
+	request []byte,
+) (reject mcp.JSONRPCMessage, onResponse func(message []byte))
+
 // StreamableProxy represents a proxy for the MCP Streamable HTTP transport
 type StreamableProxy struct {
-	store   SessionManager
-	backend string
-	headers map[string]string
+	store    SessionManager
+	backend  string
+	headers  map[string]string
+	observer MessageObserver
+	sampling SamplingHandler
+}
+
+type StreamableProxyOption func(*StreamableProxy)
+
+// WithMessageObserver sets the observer of the posted messages
+func WithMessageObserver(observer MessageObserver) StreamableProxyOption {
+	return func(p *StreamableProxy) {
+		p.observer = observer
+	}
+}
+
+// WithStreamableSamplingHandler answers the sampling requests of the backend
+// with the handler instead of passing them to the client
+func WithStreamableSamplingHandler(handler SamplingHandler) StreamableProxyOption {
+	return func(p *StreamableProxy) {
+		p.sampling = handler
+	}
 }
 
 // NewStreamableProxy creates a new proxy for the Streamable HTTP transport
@@ -26,12 +60,93 @@ func NewStreamableProxy(
 	backend string,
 	headers map[string]string,
 	store SessionManager,
+	opts ...StreamableProxyOption,
 ) *StreamableProxy {
-	return &StreamableProxy{
+	p := &StreamableProxy{
 		store:   store,
 		backend: backend,
 		headers: headers,
 	}
+
+	for _, opt := range opts {
+		opt(p)
+	}
+
+	return p
+}
+
+// observe reads the posted body and calls the observer, false means the
+// request is rejected and the reject message has been written
+func (p *StreamableProxy) observe(
+	w http.ResponseWriter,
+	r *http.Request,
+) (io.Reader, func([]byte), bool) {
+	if (p.observer == nil && p.sampling == nil) || r.Method != http.MethodPost {
+		return r.Body, nil, true
+	}
+
+	body, err := common.GetRequestBody(r)
+	if err != nil {
+		http.Error(w, "Failed to read request body", http.StatusBadRequest)
+		return nil, nil, false
+	}
+
+	if p.sampling != nil {
+		body = WithSamplingCapability(body)
+	}
+
+	if p.observer == nil {
+		return bytes.NewReader(body), nil, true
+	}
+
+	reject, onResponse := p.observer(r.Context(), body)
+	if reject != nil {
+		jsonBody, err := sonic.Marshal(reject)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return nil, nil, false
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write(jsonBody)
+
+		return nil, nil, false
+	}
+
+	return bytes.NewReader(body), onResponse, true
+}
+
+// observeLine passes the data of a SSE line to onResponse
+func observeLine(onResponse func([]byte), line string) {
+	if onResponse == nil {
+		return
+	}
+
+	data, ok := strings.CutPrefix(line, "data:")
+	if !ok {
+		return
+	}
+
+	data = strings.TrimSpace(data)
+	if data != "" {
+		onResponse([]byte(data))
+	}
+}
+
+// copyResponse copies the non-SSE response body and passes it to onResponse
+func copyResponse(w io.Writer, body io.Reader, onResponse func([]byte)) {
+	if onResponse == nil {
+		_, _ = io.Copy(w, body)
+		return
+	}
+
+	data, _ := io.ReadAll(body)
+	_, _ = w.Write(data)
+
+	if len(data) > 0 {
+		onResponse(data)
+	}
 }
 
 // ServeHTTP handles both GET and POST requests for the Streamable HTTP transport
@@ -84,15 +199,16 @@ func (p *StreamableProxy) handleGetRequest(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
+	// Extract the real backend session ID from the stored URL
+	parts := strings.Split(backendInfo, "|sessionId=")
+
 	// Create a request to the backend
-	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, backendInfo, nil)
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodGet, parts[0], nil)
 	if err != nil {
 		http.Error(w, "Failed to create backend request", http.StatusInternalServerError)
 		return
 	}
 
-	// Extract the real backend session ID from the stored URL
-	parts := strings.Split(backendInfo, "|sessionId=")
 	if len(parts) > 1 {
 		req.Header.Set(headerKeySessionID, parts[1])
 	}
@@ -139,28 +255,18 @@ func (p *StreamableProxy) handleGetRequest(w http.ResponseWriter, r *http.Reques
 		resp.Body.Close()
 	}()
 
-	// Stream the SSE events to the client
-	reader := bufio.NewReader(resp.Body)
-
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
 		return
 	}
 
-	for {
-		line, err := reader.ReadString('\n')
-		if err != nil {
-			if err == io.EOF {
-				break
-			}
-			return
-		}
+	// the stream may stay idle until the backend has something to send
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
 
-		// Write the line to the client
-		fmt.Fprint(w, line)
-		flusher.Flush()
-	}
+	// Stream the SSE events to the client
+	p.copyEvents(ctx, w, flusher, resp.Body, nil, parts[0], req.Header.Get(headerKeySessionID))
 }
 
 // handlePostRequest handles POST requests for JSON-RPC messages
@@ -189,8 +295,17 @@ func (p *StreamableProxy) handlePostRequest(w http.ResponseWriter, r *http.Reque
 	backend := parts[0]
 	sessionID := parts[1]
 
+	body, onResponse, ok := p.observe(w, r)
+	if !ok {
+		return
+	}
+
+	if onResponse != nil {
+		defer onResponse(nil)
+	}
+
 	// Create a request to the backend
-	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, backend, r.Body)
+	req, err := http.NewRequestWithContext(r.Context(), http.MethodPost, backend, body)
 	if err != nil {
 		http.Error(w, "Failed to create backend request", http.StatusInternalServerError)
 		return
@@ -226,9 +341,6 @@ func (p *StreamableProxy) handlePostRequest(w http.ResponseWriter, r *http.Reque
 
 	// Check if the response is an SSE stream
 	if strings.Contains(contentType, "text/event-stream") {
-		// Handle SSE response
-		reader := bufio.NewReader(resp.Body)
-
 		flusher, ok := w.(http.Flusher)
 		if !ok {
 			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
@@ -245,23 +357,10 @@ func (p *StreamableProxy) handlePostRequest(w http.ResponseWriter, r *http.Reque
 			resp.Body.Close()
 		}()
 
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return
-			}
-
-			// Write the line to the client
-			_, _ = fmt.Fprint(w, line)
-
-			flusher.Flush()
-		}
+		p.copyEvents(ctx, w, flusher, resp.Body, onResponse, backend, sessionID)
 	} else {
 		// Copy regular response body
-		_, _ = io.Copy(w, resp.Body)
+		copyResponse(w, resp.Body, onResponse)
 	}
 }
 
@@ -326,8 +425,17 @@ func (p *StreamableProxy) handleDeleteRequest(w http.ResponseWriter, r *http.Req
 
 // proxyInitialOrNoSessionRequest handles the initial request that doesn't have a session ID yet
 func (p *StreamableProxy) proxyInitialOrNoSessionRequest(w http.ResponseWriter, r *http.Request) {
+	body, onResponse, ok := p.observe(w, r)
+	if !ok {
+		return
+	}
+
+	if onResponse != nil {
+		defer onResponse(nil)
+	}
+
 	// Create a request to the backend
-	req, err := http.NewRequestWithContext(r.Context(), r.Method, p.backend, r.Body)
+	req, err := http.NewRequestWithContext(r.Context(), r.Method, p.backend, body)
 	if err != nil {
 		http.Error(w, "Failed to create backend request", http.StatusInternalServerError)
 		return
@@ -374,9 +482,6 @@ func (p *StreamableProxy) proxyInitialOrNoSessionRequest(w http.ResponseWriter,
 
 	// Check if the response is an SSE stream
 	if strings.Contains(contentType, "text/event-stream") {
-		// Handle SSE response
-		reader := bufio.NewReader(resp.Body)
-
 		flusher, ok := w.(http.Flusher)
 		if !ok {
 			http.Error(w, "Streaming not supported", http.StatusInternalServerError)
@@ -393,21 +498,131 @@ func (p *StreamableProxy) proxyInitialOrNoSessionRequest(w http.ResponseWriter,
 			resp.Body.Close()
 		}()
 
-		for {
-			line, err := reader.ReadString('\n')
-			if err != nil {
-				if err == io.EOF {
-					break
-				}
-				return
+		p.copyEvents(ctx, w, flusher, resp.Body, onResponse, p.backend, backendSessionID)
+	} else {
+		// Copy regular response body
+		copyResponse(w, resp.Body, onResponse)
+	}
+}
+
+// copyEvents copies the SSE stream of the backend to the client, the sampling
+// requests of the backend are answered by the proxy instead of the client
+func (p *StreamableProxy) copyEvents(
+	ctx context.Context,
+	w io.Writer,
+	flusher http.Flusher,
+	body io.Reader,
+	onResponse func([]byte),
+	backend, sessionID string,
+) {
+	reader := bufio.NewReader(body)
+	event := make([]string, 0, 4)
+
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			if err == io.EOF && len(event) > 0 {
+				p.writeEvent(w, flusher, event, onResponse)
 			}
 
+			return
+		}
+
+		if p.sampling == nil {
 			// Write the line to the client
-			fmt.Fprint(w, line)
+			_, _ = fmt.Fprint(w, line)
+
 			flusher.Flush()
+
+			observeLine(onResponse, line)
+
+			continue
 		}
-	} else {
-		// Copy regular response body
-		_, _ = io.Copy(w, resp.Body)
+
+		// the events are passed as a whole, so the sampling requests can be dropped
+		event = append(event, line)
+		if strings.TrimSpace(line) != "" {
+			continue
+		}
+
+		if !p.answerSampling(ctx, event, backend, sessionID) {
+			p.writeEvent(w, flusher, event, onResponse)
+		}
+
+		event = event[:0]
+	}
+}
+
+func (p *StreamableProxy) writeEvent(
+	w io.Writer,
+	flusher http.Flusher,
+	event []string,
+	onResponse func([]byte),
+) {
+	for _, line := range event {
+		_, _ = fmt.Fprint(w, line)
+
+		observeLine(onResponse, line)
+	}
+
+	flusher.Flush()
+}
+
+// answerSampling answers the event when it is a sampling request, the
+// response is posted to the backend in the background since the backend
+// waits for it before ending the stream
+func (p *StreamableProxy) answerSampling(
+	ctx context.Context,
+	event []string,
+	backend, sessionID string,
+) bool {
+	var data []string
+
+	for _, line := range event {
+		if d, ok := strings.CutPrefix(strings.TrimRight(line, "\r\n"), "data:"); ok {
+			data = append(data, strings.TrimSpace(d))
+		}
+	}
+
+	req, ok := parseSamplingRequest([]byte(strings.Join(data, "\n")))
+	if !ok {
+		return false
 	}
+
+	go func() {
+		body, err := sonic.Marshal(req.answer(ctx, p.sampling))
+		if err != nil {
+			return
+		}
+
+		httpReq, err := http.NewRequestWithContext(
+			ctx,
+			http.MethodPost,
+			backend,
+			bytes.NewReader(body),
+		)
+		if err != nil {
+			return
+		}
+
+		for name, value := range p.headers {
+			httpReq.Header.Set(name, value)
+		}
+
+		if sessionID != "" {
+			httpReq.Header.Set(headerKeySessionID, sessionID)
+		}
+
+		httpReq.Header.Set("Accept", "application/json, text/event-stream")
+		httpReq.Header.Set("Content-Type", "application/json")
+
+		resp, err := http.DefaultClient.Do(httpReq)
+		if err != nil {
+			return
+		}
+
+		_ = resp.Body.Close()
+	}()
+
+	return true
 }
@@ -0,0 +1,105 @@
+package mcpproxy_test
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/labring/aiproxy/core/mcpproxy"
+	mcpservers "github.com/labring/aiproxy/mcp-servers"
+	"github.com/mark3labs/mcp-go/mcp"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const toolsCall = `{"jsonrpc":"2.0","id":1,"method":"tools/call","params":{"name":"echo"}}`
+
+func newBackend(t *testing.T, sse bool) *httptest.Server {
+	t.Helper()
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		assert.JSONEq(t, toolsCall, string(body))
+
+		result := `{"jsonrpc":"2.0","id":1,"result":{"content":[]}}`
+		if !sse {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(result))
+
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = w.Write([]byte("event: message\ndata: " + result + "\n\n"))
+	}))
+}
+
+func TestStreamableProxyObserver(t *testing.T) {
+	for _, sse := range []bool{false, true} {
+		backend := newBackend(t, sse)
+		defer backend.Close()
+
+		var observed []string
+
+		proxy := mcpproxy.NewStreamableProxy(
+			backend.URL,
+			nil,
+			mcpproxy.NewMemStore(),
+			mcpproxy.WithMessageObserver(
+				func(_ context.Context, request []byte) (mcp.JSONRPCMessage, func([]byte)) {
+					assert.JSONEq(t, toolsCall, string(request))
+
+					return nil, func(message []byte) {
+						if message == nil {
+							observed = append(observed, "end")
+							return
+						}
+
+						observed = append(observed, string(message))
+					}
+				},
+			),
+		)
+
+		req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(toolsCall))
+		req.Header.Set("Content-Type", "application/json")
+
+		w := httptest.NewRecorder()
+		proxy.ServeHTTP(w, req)
+
+		assert.Equal(t, http.StatusOK, w.Code)
+		require.Len(t, observed, 2)
+		assert.JSONEq(t, `{"jsonrpc":"2.0","id":1,"result":{"content":[]}}`, observed[0])
+		assert.Equal(t, "end", observed[1])
+	}
+}
+
+func TestStreamableProxyObserverReject(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(_ http.ResponseWriter, _ *http.Request) {
+		t.Error("the rejected request must not reach the backend")
+	}))
+	defer backend.Close()
+
+	proxy := mcpproxy.NewStreamableProxy(
+		backend.URL,
+		nil,
+		mcpproxy.NewMemStore(),
+		mcpproxy.WithMessageObserver(
+			func(_ context.Context, _ []byte) (mcp.JSONRPCMessage, func([]byte)) {
+				return mcpservers.CreateMCPErrorResponse(1, mcp.INVALID_REQUEST, "rejected"), nil
+			},
+		),
+	)
+
+	req := httptest.NewRequest(http.MethodPost, "/mcp", strings.NewReader(toolsCall))
+	req.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	proxy.ServeHTTP(w, req)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Contains(t, w.Body.String(), "rejected")
+}
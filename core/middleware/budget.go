@@ -0,0 +1,117 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/patrickmn/go-cache"
+)
+
+const (
+	XAiproxyBudgetLimit     = "X-Aiproxy-Budget-Limit"
+	XAiproxyBudgetRemaining = "X-Aiproxy-Budget-Remaining"
+	XAiproxyBudgetReset     = "X-Aiproxy-Budget-Reset"
+
+	TokenModelBudgetExceeded = "token_model_budget_exceeded"
+)
+
+// the summaries are flushed every few seconds, so the used amounts are cached as long
+var tokenModelUsedAmountCache = cache.New(5*time.Second, time.Minute)
+
+func getTokenModelUsedAmount(
+	token model.TokenCache,
+	modelName string,
+	start time.Time,
+) (float64, error) {
+	key := fmt.Sprintf(
+		"%s:%s:%s:%d",
+		token.Group,
+		token.Name,
+		modelName,
+		start.Truncate(time.Minute).Unix(),
+	)
+	if usedAmount, ok := tokenModelUsedAmountCache.Get(key); ok {
+		if amount, ok := usedAmount.(float64); ok {
+			return amount, nil
+		}
+	}
+
+	usedAmount, err := model.GetTokenModelUsedAmount(token.Group, token.Name, modelName, start)
+	if err != nil {
+		return 0, err
+	}
+
+	tokenModelUsedAmountCache.SetDefault(key, usedAmount)
+
+	return usedAmount, nil
+}
+
+func formatBudgetAmount(amount float64) string {
+	return strconv.FormatFloat(math.Round(amount*1e6)/1e6, 'f', -1, 64)
+}
+
+// CheckTokenModelBudget checks the budgets of the token on the model, the budget with
+// the least remaining amount is set to the response headers, the budgets are soft limits
+// that the requests in progress can exceed
+func CheckTokenModelBudget(c *gin.Context, modelName string) error {
+	token := GetToken(c)
+
+	budgets := token.GetModelBudgets(modelName)
+	if len(budgets) == 0 {
+		return nil
+	}
+
+	now := time.Now()
+
+	var (
+		tightest  *model.TokenModelBudget
+		remaining float64
+		reset     time.Time
+	)
+
+	for _, budget := range budgets {
+		start, budgetReset := budget.Period(now)
+
+		usedAmount, err := getTokenModelUsedAmount(token, modelName, start)
+		if err != nil {
+			common.GetLogger(c).Errorf("get token model used amount failed: %v", err)
+			continue
+		}
+
+		if tightest == nil || budget.Amount-usedAmount < remaining {
+			tightest = &budget
+			remaining = budget.Amount - usedAmount
+			reset = budgetReset
+		}
+	}
+
+	if tightest == nil {
+		return nil
+	}
+
+	resetIn := reset.Sub(now).Round(time.Second)
+
+	c.Header(XAiproxyBudgetLimit, formatBudgetAmount(tightest.Amount))
+	c.Header(XAiproxyBudgetRemaining, formatBudgetAmount(max(remaining, 0)))
+	c.Header(XAiproxyBudgetReset, resetIn.String())
+
+	if remaining > 0 {
+		return nil
+	}
+
+	return fmt.Errorf(
+		"token (%s[%d]) %s budget of model `%s` is exhausted, used %s of %s, resets in %s",
+		token.Name,
+		token.ID,
+		tightest.GetPeriodType(),
+		modelName,
+		formatBudgetAmount(tightest.Amount-remaining),
+		formatBudgetAmount(tightest.Amount),
+		resetIn,
+	)
+}
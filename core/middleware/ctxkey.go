@@ -1,19 +1,26 @@
 package middleware
 
 const (
-	ChannelID       = "channel_id"
-	Group           = "group"
-	Token           = "token"
-	GroupBalance    = "group_balance"
-	RequestModel    = "request_model"
-	RequestUser     = "request_user"
-	RequestMetadata = "request_metadata"
-	RequestAt       = "request_at"
-	RequestID       = "request_id"
-	ModelCaches     = "model_caches"
-	ModelConfig     = "model_config"
-	Mode            = "mode"
-	JobID           = "job_id"
-	GenerationID    = "generation_id"
-	ResponseID      = "response_id"
+	ChannelID         = "channel_id"
+	Group             = "group"
+	Token             = "token"
+	GroupBalance      = "group_balance"
+	RequestModel      = "request_model"
+	RequestModelAlias = "request_model_alias"
+	ChannelStrategy   = "channel_strategy"
+	FallbackFrom      = "fallback_from"
+	RequestUser       = "request_user"
+	RequestMetadata   = "request_metadata"
+	RequestAt         = "request_at"
+	RequestID         = "request_id"
+	ModelCaches       = "model_caches"
+	ModelConfig       = "model_config"
+	Mode              = "mode"
+	JobID             = "job_id"
+	GenerationID      = "generation_id"
+	ResponseID        = "response_id"
+	FileID            = "file_id"
+	LocalFile         = "local_file"
+	BatchID           = "batch_id"
+	Reservation       = "reservation"
 )
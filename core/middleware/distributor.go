@@ -1,6 +1,7 @@
 package middleware
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"net/http"
@@ -15,6 +16,7 @@ import (
 	"github.com/labring/aiproxy/core/common/balance"
 	"github.com/labring/aiproxy/core/common/config"
 	"github.com/labring/aiproxy/core/common/consume"
+	"github.com/labring/aiproxy/core/common/metrics"
 	"github.com/labring/aiproxy/core/common/notify"
 	"github.com/labring/aiproxy/core/common/reqlimit"
 	"github.com/labring/aiproxy/core/model"
@@ -115,8 +117,6 @@ func checkGroupModelRPMAndTPM(
 	mc model.ModelConfig,
 	tokenName string,
 ) error {
-	log := common.GetLogger(c)
-
 	groupModelCount, groupModelOverLimitCount, groupModelSecondCount := reqlimit.PushGroupModelRequest(
 		c.Request.Context(),
 		group.ID,
@@ -142,15 +142,8 @@ func checkGroupModelRPMAndTPM(
 		groupModelTokenSecondCount,
 	)
 
-	if group.Status != model.GroupStatusInternal &&
-		mc.RPM > 0 {
-		log.Data["group_rpm_limit"] = strconv.FormatInt(mc.RPM, 10)
-		if groupModelCount > mc.RPM {
-			setRpmHeaders(c, mc.RPM, 0)
-			return ErrRequestRateLimitExceeded
-		}
-
-		setRpmHeaders(c, mc.RPM, mc.RPM-groupModelCount)
+	if err := checkGroupModelRPM(c, group, mc, groupModelCount); err != nil {
+		return err
 	}
 
 	groupModelCountTPM, groupModelCountTPS := reqlimit.GetGroupModelTokensRequest(
@@ -172,20 +165,68 @@ func checkGroupModelRPMAndTPM(
 		groupModelTokenCountTPS,
 	)
 
-	if group.Status != model.GroupStatusInternal &&
-		mc.TPM > 0 {
-		log.Data["group_tpm_limit"] = strconv.FormatInt(mc.TPM, 10)
-		if groupModelCountTPM >= mc.TPM {
-			setTpmHeaders(c, mc.TPM, 0)
-			return ErrRequestTpmLimitExceeded
-		}
+	return checkGroupModelTPM(c, group, mc, groupModelCountTPM)
+}
 
-		setTpmHeaders(c, mc.TPM, mc.TPM-groupModelCountTPM)
+func checkGroupModelRPM(
+	c *gin.Context,
+	group model.GroupCache,
+	mc model.ModelConfig,
+	groupModelCount int64,
+) error {
+	if group.Status == model.GroupStatusInternal || mc.RPM <= 0 {
+		return nil
 	}
 
+	common.GetLogger(c).Data["group_rpm_limit"] = strconv.FormatInt(mc.RPM, 10)
+	if groupModelCount > mc.RPM {
+		setRpmHeaders(c, mc.RPM, 0)
+		return ErrRequestRateLimitExceeded
+	}
+
+	setRpmHeaders(c, mc.RPM, mc.RPM-groupModelCount)
+
 	return nil
 }
 
+func checkGroupModelTPM(
+	c *gin.Context,
+	group model.GroupCache,
+	mc model.ModelConfig,
+	groupModelCountTPM int64,
+) error {
+	if group.Status == model.GroupStatusInternal || mc.TPM <= 0 {
+		return nil
+	}
+
+	common.GetLogger(c).Data["group_tpm_limit"] = strconv.FormatInt(mc.TPM, 10)
+	if groupModelCountTPM >= mc.TPM {
+		setTpmHeaders(c, mc.TPM, 0)
+		return ErrRequestTpmLimitExceeded
+	}
+
+	setTpmHeaders(c, mc.TPM, mc.TPM-groupModelCountTPM)
+
+	return nil
+}
+
+func rateLimitRejectReason(err error) string {
+	switch {
+	case errors.Is(err, ErrRequestRateLimitExceeded):
+		return metrics.RejectReasonRPM
+	case errors.Is(err, ErrRequestTpmLimitExceeded):
+		return metrics.RejectReasonTPM
+	case errors.Is(err, ErrRequestQueueFull):
+		return metrics.RejectReasonQueueFull
+	case errors.Is(err, ErrRequestQueueTimeout):
+		return metrics.RejectReasonQueueTimeout
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return metrics.RejectReasonCanceled
+	default:
+		return metrics.RejectReasonOther
+	}
+}
+
 type GroupBalanceConsumer struct {
 	Group        string
 	balance      float64
@@ -312,7 +353,7 @@ func checkGroupBalance(c *gin.Context, group model.GroupCache) bool {
 
 func NewDistribute(mode mode.Mode) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		distribute(c, mode)
+		traceDistribute(c, mode)
 	}
 }
 
@@ -340,11 +381,34 @@ func CheckRelayMode(requestMode, modelMode mode.Mode) bool {
 		return modelMode == mode.VideoGenerationsJobs ||
 			modelMode == mode.VideoGenerationsGetJobs ||
 			modelMode == mode.VideoGenerationsContent
+	case mode.FilesUpload, mode.FilesGet, mode.FilesDelete, mode.FilesContent:
+		// files are not bound to a model type, the model is only used to pick a channel
+		return true
 	default:
 		return requestMode == modelMode
 	}
 }
 
+// checkTokenMode aborts the request when the token is not allowed to call the endpoint of the mode
+func checkTokenMode(c *gin.Context, token model.TokenCache, m mode.Mode) bool {
+	if token.AllowsMode(m) {
+		return true
+	}
+
+	AbortLogWithMessage(
+		c,
+		http.StatusForbidden,
+		fmt.Sprintf(
+			"token (%s[%d]) is not allowed to call the %s endpoint",
+			token.Name,
+			token.ID,
+			m,
+		),
+	)
+
+	return false
+}
+
 func distribute(c *gin.Context, mode mode.Mode) {
 	c.Set(Mode, mode)
 
@@ -358,6 +422,10 @@ func distribute(c *gin.Context, mode mode.Mode) {
 	group := GetGroup(c)
 	token := GetToken(c)
 
+	if !checkTokenMode(c, token, mode) {
+		return
+	}
+
 	if !checkGroupBalance(c, group) {
 		return
 	}
@@ -409,6 +477,40 @@ func distribute(c *gin.Context, mode mode.Mode) {
 		return
 	}
 
+	user, err := getRequestUser(c, mode)
+	if err != nil {
+		AbortLogWithMessage(
+			c,
+			http.StatusInternalServerError,
+			err.Error(),
+		)
+
+		return
+	}
+
+	c.Set(RequestUser, user)
+
+	if mc.IsAlias() {
+		alias := findModel
+
+		mc, ok = resolveModelAlias(c, token, mc, user)
+		if !ok {
+			AbortLogWithMessage(
+				c,
+				http.StatusServiceUnavailable,
+				fmt.Sprintf("The model alias `%s` has no available target model.", alias),
+			)
+
+			return
+		}
+
+		findModel = mc.Model
+
+		c.Set(RequestModelAlias, alias)
+		SetLogModelFields(log.Data, findModel)
+		log.Data["model_alias"] = alias
+	}
+
 	mc = GetGroupAdjustedModelConfig(group, mc)
 
 	c.Set(RequestModel, findModel)
@@ -427,19 +529,17 @@ func distribute(c *gin.Context, mode mode.Mode) {
 		return
 	}
 
-	user, err := getRequestUser(c, mode)
-	if err != nil {
+	if err := CheckTokenModelBudget(c, findModel); err != nil {
 		AbortLogWithMessage(
 			c,
-			http.StatusInternalServerError,
+			http.StatusTooManyRequests,
 			err.Error(),
+			relaymodel.WithType(TokenModelBudgetExceeded),
 		)
 
 		return
 	}
 
-	c.Set(RequestUser, user)
-
 	metadata, err := getRequestMetadata(c, mode)
 	if err != nil {
 		AbortLogWithMessage(
@@ -453,7 +553,21 @@ func distribute(c *gin.Context, mode mode.Mode) {
 
 	c.Set(RequestMetadata, metadata)
 
-	if err := checkGroupModelRPMAndTPM(c, group, mc, token.Name); err != nil {
+	// requests of a batch are throttled by the batch worker pool instead
+	if GetBatchID(c) != "" {
+		return
+	}
+
+	err = checkGroupModelRPMAndTPM(c, group, mc, token.Name)
+	if err != nil {
+		if queue, ok := getQueueConfig(group, token); ok {
+			err = waitGroupModelRPMAndTPM(c, group, mc, token, queue, err)
+		}
+	}
+
+	if err != nil {
+		metrics.AddRateLimitRejection(mc.Model, group.ID, rateLimitRejectReason(err))
+
 		errMsg := err.Error()
 
 		consume.Summary(
@@ -468,14 +582,51 @@ func distribute(c *gin.Context, mode mode.Mode) {
 
 		return
 	}
-
-	c.Next()
 }
 
 func GetRequestModel(c *gin.Context) string {
 	return c.GetString(RequestModel)
 }
 
+// resolveModelAlias resolves the alias to the config of one of its targets
+// available to the token, the user or the token name keeps a sticky target
+func resolveModelAlias(
+	c *gin.Context,
+	token model.TokenCache,
+	alias model.ModelConfig,
+	user string,
+) (model.ModelConfig, bool) {
+	modelConfigs := GetModelCaches(c).ModelConfig
+
+	key := user
+	if key == "" {
+		key = token.Name
+	}
+
+	target, ok := alias.Alias.Resolve(alias.Model, key, func(target string) bool {
+		if token.FindAliasTarget(target) == "" {
+			return false
+		}
+
+		mc, ok := modelConfigs.GetModelConfig(target)
+
+		return ok && !mc.IsAlias()
+	})
+	if !ok {
+		return model.ModelConfig{}, false
+	}
+
+	return modelConfigs.GetModelConfig(target)
+}
+
+func GetRequestModelAlias(c *gin.Context) string {
+	return c.GetString(RequestModelAlias)
+}
+
+func GetChannelStrategy(c *gin.Context) string {
+	return c.GetString(ChannelStrategy)
+}
+
 func GetRequestUser(c *gin.Context) string {
 	return c.GetString(RequestUser)
 }
@@ -496,6 +647,14 @@ func GetResponseID(c *gin.Context) string {
 	return c.GetString(ResponseID)
 }
 
+func GetFileID(c *gin.Context) string {
+	return c.GetString(FileID)
+}
+
+func GetBatchID(c *gin.Context) string {
+	return c.GetString(BatchID)
+}
+
 func GetRequestMetadata(c *gin.Context) map[string]string {
 	return c.GetStringMapString(RequestMetadata)
 }
@@ -523,9 +682,14 @@ func NewMetaByContext(c *gin.Context,
 	jobID := GetJobID(c)
 	generationID := GetGenerationID(c)
 	responseID := GetResponseID(c)
+	fileID := GetFileID(c)
+	modelAlias := GetRequestModelAlias(c)
+	channelStrategy := GetChannelStrategy(c)
 
 	opts = append(
 		opts,
+		meta.WithModelAlias(modelAlias),
+		meta.WithChannelStrategy(channelStrategy),
 		meta.WithRequestAt(requestAt),
 		meta.WithRequestID(requestID),
 		meta.WithGroup(group),
@@ -534,6 +698,7 @@ func NewMetaByContext(c *gin.Context,
 		meta.WithJobID(jobID),
 		meta.WithGenerationID(generationID),
 		meta.WithResponseID(responseID),
+		meta.WithFileID(fileID),
 	)
 
 	return meta.NewMeta(
@@ -560,7 +725,8 @@ func getRequestModel(c *gin.Context, m mode.Mode, group string, tokenID int) (st
 		fallthrough
 	case m == mode.AudioTranscription,
 		m == mode.AudioTranslation,
-		m == mode.ImagesEdits:
+		m == mode.ImagesEdits,
+		m == mode.FilesUpload:
 		return c.Request.FormValue("model"), nil
 
 	case strings.HasPrefix(path, "/v1/engines") && strings.HasSuffix(path, "/embeddings"):
@@ -603,6 +769,18 @@ func getRequestModel(c *gin.Context, m mode.Mode, group string, tokenID int) (st
 		c.Set(ResponseID, store.ID)
 		c.Set(ChannelID, store.ChannelID)
 
+		return store.Model, nil
+	case m == mode.FilesGet || m == mode.FilesDelete || m == mode.FilesContent:
+		fileID := c.Param("id")
+
+		store, err := model.CacheGetStore(group, tokenID, fileID)
+		if err != nil {
+			return "", fmt.Errorf("get request model failed: %w", err)
+		}
+
+		c.Set(FileID, store.ID)
+		c.Set(ChannelID, store.ChannelID)
+
 		return store.Model, nil
 	case m == mode.Responses:
 		body, err := common.GetRequestBodyReusable(c.Request)
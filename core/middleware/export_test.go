@@ -0,0 +1,30 @@
+package middleware
+
+type (
+	RequestQueue = requestQueue
+	QueueWaiter  = queueWaiter
+)
+
+var (
+	GetQueueConfig          = getQueueConfig
+	QueuePollInterval       = queuePollInterval
+	GetRequestQueue         = getRequestQueue
+	ReleaseRequestQueue     = releaseRequestQueue
+	WaitGroupModelRPMAndTPM = waitGroupModelRPMAndTPM
+	Enqueue                 = (*requestQueue).enqueue
+	Leave                   = (*requestQueue).leave
+	RateLimitRejectReason   = rateLimitRejectReason
+)
+
+// Ready returns the channel closed when the waiter becomes the head
+func (w *queueWaiter) Ready() <-chan struct{} {
+	return w.ready
+}
+
+// Depth returns the number of the waiters of the queue
+func (q *requestQueue) Depth() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	return q.depth
+}
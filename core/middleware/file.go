@@ -0,0 +1,106 @@
+package middleware
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"gorm.io/gorm"
+)
+
+// fileUploadOverhead is the room of the form fields and the multipart boundaries
+// of an upload besides the file
+const fileUploadOverhead = 1 << 20
+
+// NewFilesDistribute decides whether a files request is served by the local
+// file storage or passed through to a channel, uploads carrying a model and
+// files that were uploaded to a channel go through the normal distribute
+func NewFilesDistribute(m mode.Mode) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		distributeFiles(c, m)
+	}
+}
+
+func distributeFiles(c *gin.Context, m mode.Mode) {
+	c.Set(Mode, m)
+
+	// the local files requests do not reach distribute, so the mode is checked here
+	if !checkTokenMode(c, GetToken(c), m) {
+		return
+	}
+
+	switch m {
+	case mode.FilesUpload:
+		// the body is limited before the form is parsed, so an oversized
+		// upload is rejected without being spooled to the disk
+		if config.FileMaxSize > 0 {
+			c.Request.Body = http.MaxBytesReader(
+				c.Writer,
+				c.Request.Body,
+				config.FileMaxSize+fileUploadOverhead,
+			)
+		}
+
+		if c.Request.FormValue("model") != "" {
+			distribute(c, m)
+			return
+		}
+
+		c.Set(LocalFile, (*model.File)(nil))
+	case mode.FilesList:
+		c.Set(LocalFile, (*model.File)(nil))
+	default:
+		group := GetGroup(c)
+		token := GetToken(c)
+		fileID := c.Param("id")
+
+		file, err := model.GetFile(group.ID, token.ID, fileID)
+		if err == nil {
+			c.Set(LocalFile, file)
+			break
+		}
+
+		if !errors.Is(err, gorm.ErrRecordNotFound) {
+			AbortLogWithMessage(c, http.StatusInternalServerError, err.Error())
+			return
+		}
+
+		if _, err := model.CacheGetStore(group.ID, token.ID, fileID); err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				AbortLogWithMessage(
+					c,
+					http.StatusNotFound,
+					"No such File object: "+fileID,
+				)
+			} else {
+				AbortLogWithMessage(c, http.StatusInternalServerError, err.Error())
+			}
+
+			return
+		}
+
+		distribute(c, m)
+
+		return
+	}
+
+	c.Next()
+}
+
+func IsLocalFileRequest(c *gin.Context) bool {
+	_, ok := c.Get(LocalFile)
+	return ok
+}
+
+func GetLocalFile(c *gin.Context) *model.File {
+	v, ok := c.MustGet(LocalFile).(*model.File)
+	if !ok {
+		panic(fmt.Sprintf("local file type error: %T, %v", v, v))
+	}
+
+	return v
+}
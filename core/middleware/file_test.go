@@ -0,0 +1,54 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilesDistributeTokenMode(t *testing.T) {
+	testCases := []struct {
+		name  string
+		modes []mode.Mode
+		code  int
+		local bool
+	}{
+		{
+			name:  "all modes",
+			code:  http.StatusOK,
+			local: true,
+		},
+		{
+			name:  "files allowed",
+			modes: []mode.Mode{mode.FilesList},
+			code:  http.StatusOK,
+			local: true,
+		},
+		{
+			name:  "embeddings only",
+			modes: []mode.Mode{mode.Embeddings},
+			code:  http.StatusForbidden,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			c, _ := gin.CreateTestContext(w)
+			c.Request = httptest.NewRequest(http.MethodGet, "/v1/files", nil)
+			c.Set(middleware.Group, model.GroupCache{ID: "g1"})
+			c.Set(middleware.Token, model.TokenCache{ID: 1, Name: "t1", Modes: tc.modes})
+
+			middleware.NewFilesDistribute(mode.FilesList)(c)
+
+			assert.Equal(t, tc.code, w.Code)
+			assert.Equal(t, tc.local, middleware.IsLocalFileRequest(c))
+		})
+	}
+}
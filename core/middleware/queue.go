@@ -0,0 +1,334 @@
+package middleware
+
+import (
+	"errors"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/common/reqlimit"
+	"github.com/labring/aiproxy/core/model"
+)
+
+const (
+	XAiproxyQueuePosition = "X-Aiproxy-Queue-Position"
+	XAiproxyQueueWait     = "X-Aiproxy-Queue-Wait"
+
+	defaultQueueMaxDepth = 100
+	// the head of a queue polls the limits at the interval a request leaves
+	// the rpm window, each poll of an exceeded rpm is counted as an over limit
+	// request, so the interval is bounded
+	queueMinPollInterval = 50 * time.Millisecond
+	queueMaxPollInterval = time.Second
+)
+
+var (
+	ErrRequestQueueFull    = errors.New("request queue is full, please try again later")
+	ErrRequestQueueTimeout = errors.New("request queue wait timeout, please try again later")
+)
+
+// queueConfig is the queue mode of a request, the token overrides the group
+type queueConfig struct {
+	maxWait  time.Duration
+	maxDepth int
+}
+
+func getQueueConfig(group model.GroupCache, token model.TokenCache) (queueConfig, bool) {
+	maxWait, maxDepth := group.QueueMaxWait, group.QueueMaxDepth
+	if token.QueueMaxWait > 0 {
+		maxWait, maxDepth = token.QueueMaxWait, token.QueueMaxDepth
+	}
+
+	if maxWait <= 0 {
+		return queueConfig{}, false
+	}
+
+	if maxDepth <= 0 {
+		maxDepth = defaultQueueMaxDepth
+	}
+
+	return queueConfig{
+		maxWait:  time.Duration(maxWait) * time.Second,
+		maxDepth: int(maxDepth),
+	}, true
+}
+
+// queuePollInterval returns the poll interval of the head of a queue, on
+// average a request of the rpm leaves the one minute window every minute / rpm
+func queuePollInterval(rpm int64) time.Duration {
+	if rpm <= 0 {
+		return queueMaxPollInterval
+	}
+
+	return min(max(time.Minute/time.Duration(rpm), queueMinPollInterval), queueMaxPollInterval)
+}
+
+// requestQueue queues the requests of a group model that exceeded the rpm/tpm
+// on this instance, only the head of the queue polls the limits, and the head is
+// taken in round robin over the tokens so one token can't starve the others.
+// The queues are per instance, the limits are shared through redis but the
+// order and the fairness among the tokens only hold for the requests queued on
+// the same instance
+type requestQueue struct {
+	mu      sync.Mutex
+	tokens  []string
+	waiters map[string][]*queueWaiter
+	head    *queueWaiter
+	next    int
+	depth   int
+	// refs is guarded by requestQueuesMu
+	refs int
+}
+
+type queueWaiter struct {
+	token string
+	ready chan struct{}
+}
+
+var (
+	requestQueuesMu sync.Mutex
+	// map[group:model]*requestQueue
+	requestQueues = make(map[string]*requestQueue)
+)
+
+func getRequestQueue(group, model string) *requestQueue {
+	requestQueuesMu.Lock()
+	defer requestQueuesMu.Unlock()
+
+	key := group + ":" + model
+
+	q, ok := requestQueues[key]
+	if !ok {
+		q = &requestQueue{waiters: make(map[string][]*queueWaiter)}
+		requestQueues[key] = q
+	}
+
+	q.refs++
+
+	return q
+}
+
+// releaseRequestQueue deletes the queue once no request uses it
+func releaseRequestQueue(group, model string, q *requestQueue) {
+	requestQueuesMu.Lock()
+	defer requestQueuesMu.Unlock()
+
+	q.refs--
+	if q.refs == 0 {
+		delete(requestQueues, group+":"+model)
+	}
+}
+
+// enqueue adds a waiter of the token, it returns the position of the waiter
+func (q *requestQueue) enqueue(token string, maxDepth int) (*queueWaiter, int, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if q.depth >= maxDepth {
+		return nil, 0, ErrRequestQueueFull
+	}
+
+	w := &queueWaiter{
+		token: token,
+		ready: make(chan struct{}),
+	}
+
+	if _, ok := q.waiters[token]; !ok {
+		q.tokens = append(q.tokens, token)
+	}
+
+	q.waiters[token] = append(q.waiters[token], w)
+	q.depth++
+	position := q.positionLocked(token)
+
+	if q.head == nil {
+		q.promoteLocked()
+	}
+
+	return w, position, nil
+}
+
+// positionLocked returns the position of the last waiter of the token in the
+// round robin order, the head is the first, then each round promotes one
+// waiter of every token starting from the next token
+func (q *requestQueue) positionLocked(token string) int {
+	// the waiters of the token ahead of the last one
+	rounds := len(q.waiters[token]) - 1
+
+	position := rounds + 1
+	if q.head != nil {
+		position++
+	}
+
+	n := len(q.tokens)
+	turn := func(i int) int {
+		return (i - q.next%n + n) % n
+	}
+	own := turn(slices.Index(q.tokens, token))
+
+	for i, t := range q.tokens {
+		if t == token {
+			continue
+		}
+
+		// the tokens taking their turn before the token in a round have one
+		// more waiter promoted ahead of it
+		ahead := rounds
+		if turn(i) < own {
+			ahead++
+		}
+
+		position += min(len(q.waiters[t]), ahead)
+	}
+
+	return position
+}
+
+// leave removes the waiter, and promotes the next head if the waiter was the head
+func (q *requestQueue) leave(w *queueWaiter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.depth--
+
+	if q.head == w {
+		q.head = nil
+		q.promoteLocked()
+
+		return
+	}
+
+	waiters := q.waiters[w.token]
+	for i, waiter := range waiters {
+		if waiter == w {
+			q.removeLocked(w.token, i)
+			break
+		}
+	}
+}
+
+func (q *requestQueue) promoteLocked() {
+	if len(q.tokens) == 0 {
+		return
+	}
+
+	q.next %= len(q.tokens)
+	token := q.tokens[q.next]
+
+	w := q.waiters[token][0]
+	q.removeLocked(token, 0)
+
+	// the removal of the token shifts the next token to the current index
+	if _, ok := q.waiters[token]; ok {
+		q.next++
+	}
+
+	q.head = w
+	close(w.ready)
+}
+
+func (q *requestQueue) removeLocked(token string, i int) {
+	waiters := slices.Delete(q.waiters[token], i, i+1)
+	if len(waiters) > 0 {
+		q.waiters[token] = waiters
+		return
+	}
+
+	delete(q.waiters, token)
+
+	for j, t := range q.tokens {
+		if t == token {
+			q.tokens = slices.Delete(q.tokens, j, j+1)
+			if j < q.next {
+				q.next--
+			}
+
+			break
+		}
+	}
+}
+
+// waitGroupModelRPMAndTPM waits in the queue of the group model until the
+// rpm/tpm of the group model allows the request, or the max wait is reached
+func waitGroupModelRPMAndTPM(
+	c *gin.Context,
+	group model.GroupCache,
+	mc model.ModelConfig,
+	token model.TokenCache,
+	queue queueConfig,
+	limitErr error,
+) error {
+	log := common.GetLogger(c)
+	start := time.Now()
+
+	q := getRequestQueue(group.ID, mc.Model)
+	defer releaseRequestQueue(group.ID, mc.Model, q)
+
+	w, position, err := q.enqueue(token.Name, queue.maxDepth)
+	if err != nil {
+		return err
+	}
+	defer q.leave(w)
+
+	log.Data["queue_pos"] = strconv.Itoa(position)
+	c.Header(XAiproxyQueuePosition, strconv.Itoa(position))
+
+	defer func() {
+		wait := common.TruncateDuration(time.Since(start))
+		log.Data["queue_wait"] = wait.String()
+		c.Header(XAiproxyQueueWait, wait.String())
+	}()
+
+	timer := time.NewTimer(queue.maxWait)
+	defer timer.Stop()
+
+	select {
+	case <-w.ready:
+	case <-timer.C:
+		return ErrRequestQueueTimeout
+	case <-c.Request.Context().Done():
+		return c.Request.Context().Err()
+	}
+
+	ticker := time.NewTicker(queuePollInterval(mc.RPM))
+	defer ticker.Stop()
+
+	// a waiter promoted after the previous head checks the limits at once, the
+	// first waiter of an empty queue has just exceeded them and waits a poll
+	for recheck := position > 1; ; recheck = false {
+		if !recheck {
+			select {
+			case <-ticker.C:
+			case <-timer.C:
+				return ErrRequestQueueTimeout
+			case <-c.Request.Context().Done():
+				return c.Request.Context().Err()
+			}
+		}
+
+		// the rpm is pushed again only if it was the rpm that was exceeded
+		if errors.Is(limitErr, ErrRequestRateLimitExceeded) {
+			count, _, _ := reqlimit.PushGroupModelRequest(
+				c.Request.Context(),
+				group.ID,
+				mc.Model,
+				mc.RPM,
+			)
+
+			limitErr = checkGroupModelRPM(c, group, mc, count)
+			if limitErr != nil {
+				continue
+			}
+		}
+
+		tpm, _ := reqlimit.GetGroupModelTokensRequest(c.Request.Context(), group.ID, mc.Model)
+
+		limitErr = checkGroupModelTPM(c, group, mc, tpm)
+		if limitErr == nil {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package middleware_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/metrics"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetQueueConfig(t *testing.T) {
+	_, ok := middleware.GetQueueConfig(model.GroupCache{}, model.TokenCache{})
+	assert.False(t, ok)
+
+	// the token overrides the group, the depth defaults to 100
+	_, ok = middleware.GetQueueConfig(
+		model.GroupCache{QueueMaxWait: 10, QueueMaxDepth: 5},
+		model.TokenCache{QueueMaxWait: 3},
+	)
+	assert.True(t, ok)
+}
+
+func TestQueuePollInterval(t *testing.T) {
+	assert.Equal(t, time.Second, middleware.QueuePollInterval(0))
+	assert.Equal(t, time.Second, middleware.QueuePollInterval(30))
+	assert.Equal(t, time.Second, middleware.QueuePollInterval(60))
+	assert.Equal(t, 100*time.Millisecond, middleware.QueuePollInterval(600))
+	assert.Equal(t, 50*time.Millisecond, middleware.QueuePollInterval(100000))
+}
+
+func isReady(w *middleware.QueueWaiter) bool {
+	select {
+	case <-w.Ready():
+		return true
+	default:
+		return false
+	}
+}
+
+func TestRequestQueueRoundRobin(t *testing.T) {
+	q := middleware.GetRequestQueue(t.Name(), "model")
+	defer middleware.ReleaseRequestQueue(t.Name(), "model", q)
+
+	enqueue := func(token string, want int) *middleware.QueueWaiter {
+		w, position, err := middleware.Enqueue(q, token, 10)
+		require.NoError(t, err)
+		assert.Equal(t, want, position, token)
+
+		return w
+	}
+
+	a1 := enqueue("a", 1)
+	a2 := enqueue("a", 2)
+	a3 := enqueue("a", 3)
+	// b1 is promoted before a3 although it queued after it
+	b1 := enqueue("b", 3)
+
+	// the first waiter of an empty queue is the head
+	assert.True(t, isReady(a1))
+	assert.False(t, isReady(a2))
+
+	// the head is taken in turn over the tokens in the order they queued, a
+	// alone was queued when a1 became the head
+	for _, next := range []struct {
+		leave, head *middleware.QueueWaiter
+	}{
+		{a1, a2},
+		{a2, b1},
+		{b1, a3},
+	} {
+		middleware.Leave(q, next.leave)
+		assert.True(t, isReady(next.head))
+	}
+
+	middleware.Leave(q, a3)
+	assert.Equal(t, 0, q.Depth())
+}
+
+func TestRequestQueuePosition(t *testing.T) {
+	q := middleware.GetRequestQueue(t.Name(), "model")
+	defer middleware.ReleaseRequestQueue(t.Name(), "model", q)
+
+	enqueue := func(token string, want int) *middleware.QueueWaiter {
+		w, position, err := middleware.Enqueue(q, token, 10)
+		require.NoError(t, err)
+		assert.Equal(t, want, position, token)
+
+		return w
+	}
+
+	a1 := enqueue("a", 1)
+	a2 := enqueue("a", 2)
+	b1 := enqueue("b", 3)
+
+	// a2 is the head and the turn is at b
+	middleware.Leave(q, a1)
+
+	c1 := enqueue("c", 3)
+	a3 := enqueue("a", 4)
+	b2 := enqueue("b", 5)
+
+	// the waiters are promoted in the order of their positions
+	for _, next := range []struct {
+		leave, head *middleware.QueueWaiter
+	}{
+		{a2, b1},
+		{b1, c1},
+		{c1, a3},
+		{a3, b2},
+	} {
+		middleware.Leave(q, next.leave)
+		assert.True(t, isReady(next.head))
+	}
+
+	middleware.Leave(q, b2)
+	assert.Equal(t, 0, q.Depth())
+}
+
+func TestRequestQueueLeaveWaiting(t *testing.T) {
+	q := middleware.GetRequestQueue(t.Name(), "model")
+	defer middleware.ReleaseRequestQueue(t.Name(), "model", q)
+
+	head, _, err := middleware.Enqueue(q, "a", 10)
+	require.NoError(t, err)
+	canceled, _, err := middleware.Enqueue(q, "b", 10)
+	require.NoError(t, err)
+	next, _, err := middleware.Enqueue(q, "c", 10)
+	require.NoError(t, err)
+
+	// a waiter that leaves before its turn is never promoted
+	middleware.Leave(q, canceled)
+	middleware.Leave(q, head)
+
+	assert.False(t, isReady(canceled))
+	assert.True(t, isReady(next))
+
+	middleware.Leave(q, next)
+	assert.Equal(t, 0, q.Depth())
+}
+
+func TestRequestQueueDepth(t *testing.T) {
+	q := middleware.GetRequestQueue(t.Name(), "model")
+	defer middleware.ReleaseRequestQueue(t.Name(), "model", q)
+
+	w, position, err := middleware.Enqueue(q, "a", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, position)
+
+	_, _, err = middleware.Enqueue(q, "b", 1)
+	require.ErrorIs(t, err, middleware.ErrRequestQueueFull)
+
+	middleware.Leave(q, w)
+
+	w, position, err = middleware.Enqueue(q, "b", 1)
+	require.NoError(t, err)
+	assert.Equal(t, 1, position)
+	middleware.Leave(q, w)
+}
+
+func TestWaitGroupModelRPMAndTPMPromoted(t *testing.T) {
+	group := model.GroupCache{ID: t.Name(), QueueMaxWait: 10}
+	// a poll interval of one second
+	mc := model.ModelConfig{Model: "model", RPM: 60}
+	token := model.TokenCache{Name: "b"}
+
+	queue, ok := middleware.GetQueueConfig(group, token)
+	require.True(t, ok)
+
+	q := middleware.GetRequestQueue(group.ID, mc.Model)
+	defer middleware.ReleaseRequestQueue(group.ID, mc.Model, q)
+
+	head, _, err := middleware.Enqueue(q, "a", 10)
+	require.NoError(t, err)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+
+	done := make(chan error, 1)
+
+	go func() {
+		done <- middleware.WaitGroupModelRPMAndTPM(
+			c,
+			group,
+			mc,
+			token,
+			queue,
+			middleware.ErrRequestRateLimitExceeded,
+		)
+	}()
+
+	require.Eventually(t, func() bool {
+		return q.Depth() == 2
+	}, time.Second, time.Millisecond)
+
+	// the promoted waiter checks the limits at once instead of after a poll
+	middleware.Leave(q, head)
+
+	select {
+	case err := <-done:
+		require.NoError(t, err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("the promoted waiter did not check the limits at once")
+	}
+}
+
+func TestRateLimitRejectReason(t *testing.T) {
+	for err, reason := range map[error]string{
+		middleware.ErrRequestRateLimitExceeded: metrics.RejectReasonRPM,
+		middleware.ErrRequestTpmLimitExceeded:  metrics.RejectReasonTPM,
+		middleware.ErrRequestQueueFull:         metrics.RejectReasonQueueFull,
+		middleware.ErrRequestQueueTimeout:      metrics.RejectReasonQueueTimeout,
+		context.Canceled:                       metrics.RejectReasonCanceled,
+		errors.New("unknown"):                  metrics.RejectReasonOther,
+	} {
+		assert.Equal(t, reason, middleware.RateLimitRejectReason(err), err.Error())
+	}
+}
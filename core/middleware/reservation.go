@@ -0,0 +1,161 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/reserve"
+)
+
+const (
+	TokenQuotaNotEnough = "token_quota_not_enough"
+
+	// reservationTTL bounds the reservations of the requests that never settle,
+	// like the ones of a crashed instance
+	reservationTTL = time.Hour
+	// reservationSettleTTL keeps the consumed amount reserved until the
+	// batched used amounts and the balance caches are updated
+	reservationSettleTTL = 15 * time.Second
+)
+
+var (
+	ErrGroupBalanceNotEnough = errors.New("balance not enough")
+	ErrTokenQuotaNotEnough   = errors.New("quota not enough")
+)
+
+// QuotaReservation is the estimated cost of a request held against the group balance
+// and the token quota while the request is in progress, the reserved amounts of the
+// concurrent requests are subtracted from the remaining ones before a request starts
+type QuotaReservation struct {
+	id       string
+	keys     []string
+	mu       sync.Mutex
+	consumed float64
+}
+
+func groupReservationKey(group string) string {
+	return "group:" + group
+}
+
+func tokenReservationKey(group string, tokenID int) string {
+	return "token:" + group + ":" + strconv.Itoa(tokenID)
+}
+
+// ReserveQuota reserves the estimated amount of the request, the returned reservation
+// must be settled when the request is done, it is nil when nothing is reserved,
+// a fallback hop of the request replaces the amount of the reservation it carries
+func ReserveQuota(c *gin.Context, amount float64) (*QuotaReservation, error) {
+	if amount <= 0 {
+		return nil, nil
+	}
+
+	ctx := c.Request.Context()
+	id := GetRequestID(c)
+
+	// the reservation keeps the amounts consumed by the previous hops, so
+	// settling any hop settles the consumed amount of the whole request
+	r := GetQuotaReservation(c)
+	if r == nil {
+		r = &QuotaReservation{id: id}
+	}
+
+	r.keys = nil
+
+	// the internal groups have no balance to reserve
+	gbc := GetGroupBalanceConsumerFromContext(c)
+	if gbc != nil && gbc.Consumer != nil {
+		key := groupReservationKey(gbc.Group)
+
+		reserved := reserve.Reserve(ctx, key, id, amount, reservationTTL)
+		r.keys = append(r.keys, key)
+
+		if !gbc.CheckBalance(reserved) {
+			r.release(ctx)
+
+			return nil, fmt.Errorf(
+				"group (%s) %w, %s reserved by the requests in progress of balance %s",
+				gbc.Group,
+				ErrGroupBalanceNotEnough,
+				formatBudgetAmount(reserved-amount),
+				formatBudgetAmount(gbc.balance),
+			)
+		}
+	}
+
+	token := GetToken(c)
+	if remain, ok := token.RemainQuota(); ok {
+		key := tokenReservationKey(token.Group, token.ID)
+
+		reserved := reserve.Reserve(ctx, key, id, amount, reservationTTL)
+		r.keys = append(r.keys, key)
+
+		if reserved > remain {
+			r.release(ctx)
+
+			return nil, fmt.Errorf(
+				"token (%s[%d]) %w, %s reserved by the requests in progress of remaining %s",
+				token.Name,
+				token.ID,
+				ErrTokenQuotaNotEnough,
+				formatBudgetAmount(reserved-amount),
+				formatBudgetAmount(max(remain, 0)),
+			)
+		}
+	}
+
+	if len(r.keys) == 0 {
+		return nil, nil
+	}
+
+	c.Set(Reservation, r)
+
+	return r, nil
+}
+
+func GetQuotaReservation(c *gin.Context) *QuotaReservation {
+	r, _ := c.Value(Reservation).(*QuotaReservation)
+	return r
+}
+
+// AddConsumed adds the actual amount consumed by an attempt of the request
+func (r *QuotaReservation) AddConsumed(amount float64) {
+	if r == nil || amount <= 0 {
+		return
+	}
+
+	r.mu.Lock()
+	r.consumed += amount
+	r.mu.Unlock()
+}
+
+// Settle reconciles the reservation with the actual amount consumed by the request,
+// the consumed amount stays reserved for a while because it is posted asynchronously
+func (r *QuotaReservation) Settle(ctx context.Context) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	consumed := r.consumed
+	r.mu.Unlock()
+
+	if consumed <= 0 {
+		r.release(ctx)
+		return
+	}
+
+	for _, key := range r.keys {
+		reserve.Reserve(ctx, key, r.id, consumed, reservationSettleTTL)
+	}
+}
+
+func (r *QuotaReservation) release(ctx context.Context) {
+	for _, key := range r.keys {
+		reserve.Release(ctx, key, r.id)
+	}
+}
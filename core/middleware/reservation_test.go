@@ -0,0 +1,60 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/reserve"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func reservedTokenQuota(t *testing.T, c *gin.Context) float64 {
+	t.Helper()
+
+	token := middleware.GetToken(c)
+
+	// reserving nothing returns the amount reserved by the others
+	return reserve.Reserve(
+		t.Context(),
+		"token:"+token.Group+":1",
+		"probe",
+		0,
+		time.Minute,
+	)
+}
+
+func TestReserveQuotaFallbackHop(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	c.Set(middleware.RequestID, "reservation-fallback")
+	c.Set(middleware.Token, model.TokenCache{ID: 1, Group: t.Name(), Quota: 10})
+
+	first, err := middleware.ReserveQuota(c, 4)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+	assert.InDelta(t, 4, reservedTokenQuota(t, c), 1e-9)
+
+	first.AddConsumed(1)
+
+	// the fallback hop replaces the amount of the request instead of adding to it
+	second, err := middleware.ReserveQuota(c, 6)
+	require.NoError(t, err)
+	assert.Same(t, first, second)
+	assert.InDelta(t, 6, reservedTokenQuota(t, c), 1e-9)
+
+	second.AddConsumed(2)
+
+	// the hops are settled in turn, the consumed amount of the whole request stays
+	second.Settle(t.Context())
+	first.Settle(t.Context())
+	assert.InDelta(t, 3, reservedTokenQuota(t, c), 1e-9)
+
+	_, err = middleware.ReserveQuota(c, 11)
+	assert.ErrorIs(t, err, middleware.ErrTokenQuotaNotEnough)
+}
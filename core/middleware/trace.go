@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/common/tracing"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// Trace starts the root span of a relay request, continuing the w3c trace
+// context of the downstream if any
+func Trace(c *gin.Context) {
+	if !config.TracingEnabled {
+		c.Next()
+		return
+	}
+
+	ctx := tracing.Extract(c.Request.Context(), c.Request.Header)
+
+	ctx, span := tracing.Tracer().Start(
+		ctx,
+		c.Request.Method+" "+c.FullPath(),
+		trace.WithSpanKind(trace.SpanKindServer),
+		trace.WithAttributes(
+			attribute.String("http.request.method", c.Request.Method),
+			attribute.String("url.path", c.Request.URL.Path),
+		),
+	)
+	defer span.End()
+
+	c.Request = c.Request.WithContext(ctx)
+
+	if traceID := tracing.TraceID(ctx); traceID != "" {
+		common.GetLogger(c).Data["trace_id"] = traceID
+	}
+
+	c.Next()
+
+	status := c.Writer.Status()
+	span.SetAttributes(
+		attribute.Int("http.response.status_code", status),
+		attribute.String("aiproxy.request_id", GetRequestID(c)),
+		attribute.String("aiproxy.model", GetRequestModel(c)),
+	)
+
+	if group, ok := c.Get(Group); ok {
+		if group, ok := group.(model.GroupCache); ok {
+			span.SetAttributes(attribute.String("aiproxy.group", group.ID))
+		}
+	}
+
+	if status >= http.StatusInternalServerError {
+		span.SetStatus(codes.Error, http.StatusText(status))
+	}
+}
+
+// traceDistribute runs the distribute in its own span
+func traceDistribute(c *gin.Context, mode mode.Mode) {
+	parent := c.Request.Context()
+
+	ctx, span := tracing.Start(parent, "distribute")
+	defer span.End()
+
+	c.Request = c.Request.WithContext(ctx)
+
+	distribute(c, mode)
+
+	if c.IsAborted() {
+		span.SetStatus(codes.Error, http.StatusText(c.Writer.Status()))
+	}
+
+	c.Request = c.Request.WithContext(parent)
+}
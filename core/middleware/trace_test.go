@@ -0,0 +1,131 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/middleware"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+)
+
+func setupTracing(t *testing.T) *tracetest.SpanRecorder {
+	t.Helper()
+
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	oldEnabled := config.TracingEnabled
+	oldProvider := otel.GetTracerProvider()
+	oldPropagator := otel.GetTextMapPropagator()
+
+	config.TracingEnabled = true
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	t.Cleanup(func() {
+		config.TracingEnabled = oldEnabled
+
+		otel.SetTracerProvider(oldProvider)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+
+	return recorder
+}
+
+func TestTrace(t *testing.T) {
+	recorder := setupTracing(t)
+
+	traceID := "4bf92f3577b34da6a3ce929d0e0e4736"
+	parentID := "00f067aa0ba902b7"
+
+	var handlerSpan trace.SpanContext
+
+	router := gin.New()
+	router.Use(middleware.Trace)
+	router.POST("/v1/chat/completions", func(c *gin.Context) {
+		handlerSpan = trace.SpanContextFromContext(c.Request.Context())
+		c.Status(http.StatusBadGateway)
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+	req.Header.Set("Traceparent", "00-"+traceID+"-"+parentID+"-01")
+	router.ServeHTTP(w, req)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+
+	span := spans[0]
+	assert.Equal(t, "POST /v1/chat/completions", span.Name())
+	assert.Equal(t, trace.SpanKindServer, span.SpanKind())
+	// the span continues the trace of the downstream
+	assert.Equal(t, traceID, span.SpanContext().TraceID().String())
+	assert.Equal(t, parentID, span.Parent().SpanID().String())
+	assert.Equal(t, span.SpanContext().SpanID(), handlerSpan.SpanID())
+	assert.Equal(t, codes.Error, span.Status().Code)
+}
+
+func TestTraceDisabled(t *testing.T) {
+	recorder := setupTracing(t)
+	config.TracingEnabled = false
+
+	router := gin.New()
+	router.Use(middleware.Trace)
+	router.GET("/", func(c *gin.Context) {
+		assert.False(t, trace.SpanContextFromContext(c.Request.Context()).IsValid())
+		c.Status(http.StatusOK)
+	})
+
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	assert.Empty(t, recorder.Ended())
+}
+
+func TestTraceDistribute(t *testing.T) {
+	recorder := setupTracing(t)
+
+	config.SetDisableServe(true)
+	t.Cleanup(func() {
+		config.SetDisableServe(false)
+	})
+
+	var afterDistribute trace.SpanContext
+
+	router := gin.New()
+	router.Use(middleware.Trace)
+	router.POST(
+		"/v1/chat/completions",
+		middleware.NewDistribute(mode.ChatCompletions),
+		func(c *gin.Context) {
+			afterDistribute = trace.SpanContextFromContext(c.Request.Context())
+		},
+	)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil))
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	distribute, root := spans[0], spans[1]
+	assert.Equal(t, "distribute", distribute.Name())
+	assert.Equal(t, root.SpanContext().SpanID(), distribute.Parent().SpanID())
+	assert.Equal(t, codes.Error, distribute.Status().Code)
+	assert.Equal(t, codes.Error, root.Status().Code)
+
+	// the aborted distribute doesn't run the next handlers
+	assert.False(t, afterDistribute.IsValid())
+}
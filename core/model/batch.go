@@ -11,6 +11,7 @@ import (
 	"github.com/labring/aiproxy/core/common/config"
 	"github.com/labring/aiproxy/core/common/notify"
 	"github.com/labring/aiproxy/core/common/oncall"
+	"github.com/labring/aiproxy/core/relay/mode"
 	"github.com/shopspring/decimal"
 	"golang.org/x/sync/errgroup"
 )
@@ -43,6 +44,33 @@ func (b *batchUpdateData) isCleanLocked() bool {
 		len(b.GroupSummariesMinute) == 0
 }
 
+// BatchUpdateSizes is the number of pending updates of each kind
+// waiting for the next batch processing
+type BatchUpdateSizes struct {
+	Groups               int
+	Tokens               int
+	Channels             int
+	Summaries            int
+	GroupSummaries       int
+	SummariesMinute      int
+	GroupSummariesMinute int
+}
+
+func GetBatchUpdateSizes() BatchUpdateSizes {
+	batchData.Lock()
+	defer batchData.Unlock()
+
+	return BatchUpdateSizes{
+		Groups:               len(batchData.Groups),
+		Tokens:               len(batchData.Tokens),
+		Channels:             len(batchData.Channels),
+		Summaries:            len(batchData.Summaries),
+		GroupSummaries:       len(batchData.GroupSummaries),
+		SummariesMinute:      len(batchData.SummariesMinute),
+		GroupSummariesMinute: len(batchData.GroupSummariesMinute),
+	}
+}
+
 type GroupUpdate struct {
 	Amount decimal.Decimal
 	Count  int
@@ -341,6 +369,7 @@ func processSummaryMinuteUpdates(errs *batchErrors) {
 func BatchRecordLogs(
 	now time.Time,
 	requestID string,
+	traceID string,
 	requestAt time.Time,
 	retryAt time.Time,
 	firstByteAt time.Time,
@@ -348,6 +377,8 @@ func BatchRecordLogs(
 	code int,
 	channelID int,
 	modelName string,
+	modelAlias string,
+	channelStrategy string,
 	tokenID int,
 	tokenName string,
 	endpoint string,
@@ -355,6 +386,7 @@ func BatchRecordLogs(
 	mode int,
 	ip string,
 	retryTimes int,
+	fallbackModel string,
 	requestDetail *RequestDetail,
 	downstreamResult bool,
 	usage Usage,
@@ -377,6 +409,7 @@ func BatchRecordLogs(
 		if config.GetLogStorageHours() >= 0 {
 			err = RecordConsumeLog(
 				requestID,
+				traceID,
 				now,
 				requestAt,
 				retryAt,
@@ -385,6 +418,8 @@ func BatchRecordLogs(
 				code,
 				channelID,
 				modelName,
+				modelAlias,
+				channelStrategy,
 				tokenID,
 				tokenName,
 				endpoint,
@@ -415,6 +450,7 @@ func BatchRecordLogs(
 				modelName,
 				mode,
 				retryTimes,
+				fallbackModel,
 				requestDetail,
 			)
 		}
@@ -430,6 +466,7 @@ func BatchRecordLogs(
 		modelName,
 		tokenID,
 		tokenName,
+		mode,
 		downstreamResult,
 		usage,
 		amount,
@@ -448,6 +485,7 @@ func BatchUpdateSummary(
 	modelName string,
 	tokenID int,
 	tokenName string,
+	requestMode int,
 	downstreamResult bool,
 	usage Usage,
 	amount float64,
@@ -463,7 +501,8 @@ func BatchUpdateSummary(
 
 	updateChannelData(channelID, amount, amountDecimal, !downstreamResult)
 
-	if channelID != 0 {
+	// the mcp tools calls have no channel, they are summarized with the channel id 0
+	if channelID != 0 || requestMode == int(mode.MCPToolsCall) {
 		updateSummaryData(
 			channelID,
 			modelName,
@@ -0,0 +1,332 @@
+package model
+
+import (
+	"errors"
+	"time"
+
+	"github.com/labring/aiproxy/core/common"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ErrBatchNotFound = "batch"
+)
+
+// ErrBatchLeaseLost is returned when the lease of the batch has been claimed by another worker
+var ErrBatchLeaseLost = errors.New("batch lease is claimed by another worker")
+
+const (
+	BatchStatusValidating = "validating"
+	BatchStatusFailed     = "failed"
+	BatchStatusInProgress = "in_progress"
+	BatchStatusFinalizing = "finalizing"
+	BatchStatusCompleted  = "completed"
+	BatchStatusExpired    = "expired"
+	BatchStatusCancelling = "cancelling"
+	BatchStatusCancelled  = "cancelled"
+)
+
+// Batch is a batch of requests executed offline by the batch workers,
+// the input, output and error files are files stored by the proxy,
+// LeaseUntil is renewed by the worker processing the batch and a batch
+// with an expired lease can be claimed by another worker, WorkerID is set
+// by each claim so that only the latest claim can renew the lease
+type Batch struct {
+	ID               string            `gorm:"size:64;primaryKey"                  json:"id"`
+	CreatedAt        time.Time         `gorm:"autoCreateTime;index"                json:"created_at"`
+	GroupID          string            `gorm:"size:64;index:idx_batch_group_token" json:"group"`
+	TokenID          int               `gorm:"index:idx_batch_group_token"         json:"token_id"`
+	Endpoint         string            `gorm:"size:64"                             json:"endpoint"`
+	InputFileID      string            `gorm:"size:64"                             json:"input_file_id"`
+	CompletionWindow string            `gorm:"size:16"                             json:"completion_window"`
+	Status           string            `gorm:"size:16;index"                       json:"status"`
+	OutputFileID     string            `gorm:"size:64"                             json:"output_file_id"`
+	ErrorFileID      string            `gorm:"size:64"                             json:"error_file_id"`
+	Errors           string            `gorm:"type:text"                           json:"errors"`
+	Metadata         map[string]string `gorm:"serializer:fastjson;type:text"       json:"metadata"`
+	Total            int               `                                           json:"total"`
+	Completed        int               `                                           json:"completed"`
+	Failed           int               `                                           json:"failed"`
+	InProgressAt     time.Time         `                                           json:"in_progress_at"`
+	ExpiresAt        time.Time         `                                           json:"expires_at"`
+	FinalizingAt     time.Time         `                                           json:"finalizing_at"`
+	CompletedAt      time.Time         `                                           json:"completed_at"`
+	FailedAt         time.Time         `                                           json:"failed_at"`
+	ExpiredAt        time.Time         `                                           json:"expired_at"`
+	CancellingAt     time.Time         `                                           json:"cancelling_at"`
+	CancelledAt      time.Time         `                                           json:"cancelled_at"`
+	LeaseUntil       time.Time         `gorm:"index"                               json:"-"`
+	WorkerID         string            `gorm:"size:64"                             json:"-"`
+}
+
+func NewBatchID() string {
+	return "batch_" + common.ShortUUID()
+}
+
+func (b *Batch) BeforeCreate(_ *gorm.DB) error {
+	if b.GroupID == "" || b.TokenID == 0 {
+		return errors.New("group and token id are required")
+	}
+
+	if b.ID == "" {
+		b.ID = NewBatchID()
+	}
+
+	if b.Status == "" {
+		b.Status = BatchStatusValidating
+	}
+
+	return nil
+}
+
+func CreateBatch(b *Batch) error {
+	return LogDB.Create(b).Error
+}
+
+func GetBatch(group string, tokenID int, id string) (*Batch, error) {
+	var b Batch
+
+	err := LogDB.
+		Where("group_id = ? and token_id = ? and id = ?", group, tokenID, id).
+		First(&b).
+		Error
+
+	return &b, HandleNotFound(err, ErrBatchNotFound)
+}
+
+// ListBatches lists the batches of a token, newest first,
+// after is the id of the last batch of the previous page
+func ListBatches(
+	group string,
+	tokenID int,
+	after string,
+	limit int,
+) (batches []*Batch, hasMore bool, err error) {
+	tx := LogDB.Model(&Batch{}).
+		Where("group_id = ? and token_id = ?", group, tokenID)
+
+	if after != "" {
+		cursor, err := GetBatch(group, tokenID, after)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// the batches created at the same time are ordered by id
+		tx = tx.Where(
+			"created_at < ? OR (created_at = ? AND id < ?)",
+			cursor.CreatedAt,
+			cursor.CreatedAt,
+			cursor.ID,
+		)
+	}
+
+	if limit <= 0 {
+		limit = 20
+	}
+
+	err = tx.Order("created_at desc").Order("id desc").Limit(limit + 1).Find(&batches).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(batches) > limit {
+		return batches[:limit], true, nil
+	}
+
+	return batches, false, nil
+}
+
+// CancelBatch marks a batch as cancelling, the worker stops sending new
+// requests of the batch and finalizes it with the finished results
+func CancelBatch(group string, tokenID int, id string) (*Batch, error) {
+	result := LogDB.Model(&Batch{}).
+		Where("group_id = ? and token_id = ? and id = ?", group, tokenID, id).
+		Where("status IN ?", []string{BatchStatusValidating, BatchStatusInProgress}).
+		Updates(map[string]any{
+			"status":        BatchStatusCancelling,
+			"cancelling_at": time.Now(),
+		})
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	return GetBatch(group, tokenID, id)
+}
+
+// UpdateLeasedBatch updates the batch held by the worker, ErrBatchLeaseLost
+// is returned when another worker has claimed the batch
+func UpdateLeasedBatch(id, workerID string, values map[string]any) error {
+	result := LogDB.Model(&Batch{}).
+		Where("id = ? and worker_id = ?", id, workerID).
+		Updates(values)
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return ErrBatchLeaseLost
+	}
+
+	return nil
+}
+
+// ClaimBatch takes the lease of the oldest unfinished batch whose lease
+// has expired, it returns nil when there is no batch to process
+func ClaimBatch(lease time.Duration) (*Batch, error) {
+	var candidates []*Batch
+
+	now := time.Now()
+
+	err := LogDB.
+		Where("status IN ?", []string{
+			BatchStatusValidating,
+			BatchStatusInProgress,
+			BatchStatusFinalizing,
+			BatchStatusCancelling,
+		}).
+		Where("lease_until < ?", now).
+		Order("created_at asc").
+		Limit(10).
+		Find(&candidates).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	for _, b := range candidates {
+		leaseUntil := now.Add(lease)
+		workerID := common.ShortUUID()
+
+		result := LogDB.Model(&Batch{}).
+			Where("id = ? and lease_until = ?", b.ID, b.LeaseUntil).
+			Updates(map[string]any{
+				"lease_until": leaseUntil,
+				"worker_id":   workerID,
+			})
+		if result.Error != nil {
+			return nil, result.Error
+		}
+
+		if result.RowsAffected == 1 {
+			b.LeaseUntil = leaseUntil
+			b.WorkerID = workerID
+
+			return b, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// RenewBatchLease extends the lease of the batch held by the worker and
+// returns its status, so that the worker can notice a cancellation,
+// ErrBatchLeaseLost is returned when another worker has claimed the batch
+func RenewBatchLease(id, workerID string, lease time.Duration) (string, error) {
+	result := LogDB.Model(&Batch{}).
+		Where("id = ? and worker_id = ?", id, workerID).
+		Update("lease_until", time.Now().Add(lease))
+	if result.Error != nil {
+		return "", result.Error
+	}
+
+	if result.RowsAffected == 0 {
+		return "", ErrBatchLeaseLost
+	}
+
+	var status string
+
+	err := LogDB.Model(&Batch{}).
+		Where("id = ?", id).
+		Select("status").
+		Scan(&status).
+		Error
+
+	return status, err
+}
+
+// ReleaseBatchLease releases the lease held by the worker, a lease claimed
+// by another worker is kept
+func ReleaseBatchLease(id, workerID string) error {
+	return LogDB.Model(&Batch{}).
+		Where("id = ? and worker_id = ?", id, workerID).
+		Update("lease_until", time.Time{}).
+		Error
+}
+
+// BatchOutput is the result line of a single request of a batch, results are
+// kept until the batch is finalized so that an interrupted batch can resume
+type BatchOutput struct {
+	BatchID string `gorm:"size:64;primaryKey"`
+	Line    int    `gorm:"primaryKey;autoIncrement:false"`
+	Success bool
+	Content string `gorm:"type:text"`
+}
+
+func SaveBatchOutput(o *BatchOutput) error {
+	return LogDB.
+		Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(o).
+		Error
+}
+
+// GetBatchOutputLines returns the finished lines of a batch
+func GetBatchOutputLines(batchID string) (map[int]bool, error) {
+	var outputs []*BatchOutput
+
+	err := LogDB.
+		Select("line", "success").
+		Where("batch_id = ?", batchID).
+		Find(&outputs).
+		Error
+	if err != nil {
+		return nil, err
+	}
+
+	lines := make(map[int]bool, len(outputs))
+	for _, o := range outputs {
+		lines[o.Line] = o.Success
+	}
+
+	return lines, nil
+}
+
+// RangeBatchOutputs calls fn with the results of a batch in line order
+func RangeBatchOutputs(batchID string, success bool, fn func(o *BatchOutput) error) error {
+	const pageSize = 500
+
+	lastLine := -1
+
+	for {
+		var outputs []*BatchOutput
+
+		err := LogDB.
+			Where("batch_id = ? and success = ? and line > ?", batchID, success, lastLine).
+			Order("line asc").
+			Limit(pageSize).
+			Find(&outputs).
+			Error
+		if err != nil {
+			return err
+		}
+
+		for _, o := range outputs {
+			if err := fn(o); err != nil {
+				return err
+			}
+
+			lastLine = o.Line
+		}
+
+		if len(outputs) < pageSize {
+			return nil
+		}
+	}
+}
+
+func DeleteBatchOutputs(batchID string) error {
+	return LogDB.
+		Where("batch_id = ?", batchID).
+		Delete(&BatchOutput{}).
+		Error
+}
@@ -0,0 +1,98 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBatchLeaseOwner(t *testing.T) {
+	db := useTestDB(t, &model.Batch{})
+
+	require.NoError(t, model.CreateBatch(&model.Batch{
+		ID:      "batch_1",
+		GroupID: "g1",
+		TokenID: 1,
+		Status:  model.BatchStatusInProgress,
+	}))
+
+	first, err := model.ClaimBatch(time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, first)
+
+	_, err = model.RenewBatchLease(first.ID, first.WorkerID, time.Minute)
+	require.NoError(t, err)
+
+	// the lease of the first worker expires and the batch is claimed again
+	require.NoError(t, db.Model(&model.Batch{}).
+		Where("id = ?", first.ID).
+		Update("lease_until", time.Now().Add(-time.Second)).
+		Error)
+
+	second, err := model.ClaimBatch(time.Minute)
+	require.NoError(t, err)
+	require.NotNil(t, second)
+	assert.NotEqual(t, first.WorkerID, second.WorkerID)
+
+	_, err = model.RenewBatchLease(first.ID, first.WorkerID, time.Minute)
+	require.ErrorIs(t, err, model.ErrBatchLeaseLost)
+
+	require.NoError(t, model.ReleaseBatchLease(first.ID, first.WorkerID))
+
+	claimed, err := model.ClaimBatch(time.Minute)
+	require.NoError(t, err)
+	assert.Nil(t, claimed, "the lease of the second worker must be kept")
+
+	status, err := model.RenewBatchLease(second.ID, second.WorkerID, time.Minute)
+	require.NoError(t, err)
+	assert.Equal(t, model.BatchStatusInProgress, status)
+
+	// the first worker can no longer finalize the batch
+	err = model.UpdateLeasedBatch(first.ID, first.WorkerID, map[string]any{
+		"status": model.BatchStatusFinalizing,
+	})
+	require.ErrorIs(t, err, model.ErrBatchLeaseLost)
+
+	require.NoError(t, model.UpdateLeasedBatch(second.ID, second.WorkerID, map[string]any{
+		"status": model.BatchStatusFinalizing,
+	}))
+}
+
+func TestListBatchesSameCreatedAt(t *testing.T) {
+	useTestDB(t, &model.Batch{})
+
+	createdAt := time.Now().Truncate(time.Second)
+	for _, id := range []string{"batch_a", "batch_b", "batch_c", "batch_d", "batch_e"} {
+		require.NoError(t, model.CreateBatch(&model.Batch{
+			ID:        id,
+			CreatedAt: createdAt,
+			GroupID:   "g1",
+			TokenID:   1,
+		}))
+	}
+
+	var (
+		ids   []string
+		after string
+	)
+
+	for {
+		batches, hasMore, err := model.ListBatches("g1", 1, after, 2)
+		require.NoError(t, err)
+
+		for _, b := range batches {
+			ids = append(ids, b.ID)
+		}
+
+		if !hasMore {
+			break
+		}
+
+		after = batches[len(batches)-1].ID
+	}
+
+	assert.Equal(t, []string{"batch_e", "batch_d", "batch_c", "batch_b", "batch_a"}, ids)
+}
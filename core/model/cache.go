@@ -18,6 +18,7 @@ import (
 	"github.com/labring/aiproxy/core/common/conv"
 	"github.com/labring/aiproxy/core/common/notify"
 	"github.com/labring/aiproxy/core/common/oncall"
+	"github.com/labring/aiproxy/core/relay/mode"
 	"github.com/maruel/natural"
 	"github.com/redis/go-redis/v9"
 	log "github.com/sirupsen/logrus"
@@ -65,6 +66,7 @@ func (t redisTime) MarshalBinary() ([]byte, error) {
 type TokenCache struct {
 	Group      string           `json:"group"       redis:"g"`
 	Key        string           `json:"-"           redis:"-"`
+	KeyHash    string           `json:"-"           redis:"-"`
 	Name       string           `json:"name"        redis:"n"`
 	Subnets    redisStringSlice `json:"subnets"     redis:"s"`
 	Models     redisStringSlice `json:"models"      redis:"m"`
@@ -79,6 +81,17 @@ type TokenCache struct {
 	PeriodLastUpdateTime   redisTime `json:"period_last_update_time"   redis:"plut"`
 	PeriodLastUpdateAmount float64   `json:"period_last_update_amount" redis:"plua"`
 
+	QueueMaxWait  int64 `json:"queue_max_wait"  redis:"qmw"`
+	QueueMaxDepth int64 `json:"queue_max_depth" redis:"qmd"`
+
+	ModelBudgets redisTokenModelBudgets `json:"model_budgets" redis:"mb"`
+	ExpiresAt    redisTime              `json:"expires_at"    redis:"ea"`
+	Modes        redisModes             `json:"modes"         redis:"md"`
+
+	MCPAllowTools redisStringSlice    `json:"mcp_allow_tools" redis:"mat"`
+	MCPDenyTools  redisStringSlice    `json:"mcp_deny_tools"  redis:"mdt"`
+	MCPToolsRPM   redisMCPToolsRPMMap `json:"mcp_tools_rpm"   redis:"mtr"`
+
 	availableSets []string
 	modelsBySet   map[string][]string
 }
@@ -108,6 +121,24 @@ func (t *TokenCache) FindModel(model string) string {
 	return containsModel(model, t.availableSets, t.modelsBySet)
 }
 
+// GetModelBudgets returns the budgets of the token on the model
+func (t *TokenCache) GetModelBudgets(model string) []TokenModelBudget {
+	var budgets []TokenModelBudget
+	for _, budget := range t.ModelBudgets {
+		if budget.Model == model {
+			budgets = append(budgets, budget)
+		}
+	}
+
+	return budgets
+}
+
+// FindAliasTarget finds the target model of an alias in the available sets,
+// the token models are not checked as the token already has access to the alias
+func (t *TokenCache) FindAliasTarget(model string) string {
+	return containsModel(model, t.availableSets, t.modelsBySet)
+}
+
 func containsModel(model string, sets []string, modelsBySet map[string][]string) string {
 	var findModel string
 	for _, set := range sets {
@@ -165,6 +196,7 @@ func (t *Token) ToTokenCache() *TokenCache {
 		ID:         t.ID,
 		Group:      t.GroupID,
 		Key:        t.Key,
+		KeyHash:    t.KeyHash,
 		Name:       string(t.Name),
 		Models:     t.Models,
 		Subnets:    t.Subnets,
@@ -176,7 +208,67 @@ func (t *Token) ToTokenCache() *TokenCache {
 		PeriodType:             string(t.PeriodType),
 		PeriodLastUpdateTime:   redisTime(t.PeriodLastUpdateTime),
 		PeriodLastUpdateAmount: t.PeriodLastUpdateAmount,
+
+		QueueMaxWait:  t.QueueMaxWait,
+		QueueMaxDepth: t.QueueMaxDepth,
+
+		ModelBudgets: t.ModelBudgets,
+		ExpiresAt:    redisTime(t.ExpiresAt),
+		Modes:        t.Modes,
+
+		MCPAllowTools: t.MCPAllowTools,
+		MCPDenyTools:  t.MCPDenyTools,
+		MCPToolsRPM:   t.MCPToolsRPM,
+	}
+}
+
+func (t *TokenCache) IsExpired() bool {
+	expiresAt := time.Time(t.ExpiresAt)
+	return !expiresAt.IsZero() && !time.Now().Before(expiresAt)
+}
+
+// RemainQuota returns the least remaining amount of the total and the period quota,
+// false if the token has no quota
+func (t *TokenCache) RemainQuota() (float64, bool) {
+	var (
+		remain  float64
+		limited bool
+	)
+
+	if t.Quota > 0 {
+		remain = t.Quota - t.UsedAmount
+		limited = true
+	}
+
+	if t.PeriodQuota > 0 {
+		periodUsed := t.UsedAmount - t.PeriodLastUpdateAmount
+
+		token := Token{
+			PeriodType:           EmptyNullString(t.PeriodType),
+			PeriodLastUpdateTime: time.Time(t.PeriodLastUpdateTime),
+		}
+		if needsReset, err := token.NeedsPeriodReset(); err == nil && needsReset {
+			periodUsed = 0
+		}
+
+		if periodRemain := t.PeriodQuota - periodUsed; !limited || periodRemain < remain {
+			remain = periodRemain
+		}
+
+		limited = true
 	}
+
+	return remain, limited
+}
+
+// AllowsMode reports whether the token can call the relay mode
+func (t *TokenCache) AllowsMode(m mode.Mode) bool {
+	return len(t.Modes) == 0 || slices.Contains(t.Modes, m)
+}
+
+// AllowsMCPTool reports whether the token can call the mcp tool named `mcp_id__tool`
+func (t *TokenCache) AllowsMCPTool(tool string) bool {
+	return allowsMCPTool(t.MCPAllowTools, t.MCPDenyTools, tool)
 }
 
 func CacheDeleteToken(key string) error {
@@ -198,7 +290,7 @@ func CacheSetToken(token *TokenCache) error {
 	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
 	defer cancel()
 
-	key := common.RedisKeyf(TokenCacheKey, token.Key)
+	key := common.RedisKeyf(TokenCacheKey, token.KeyHash)
 	pipe := common.RDB.Pipeline()
 	pipe.HSet(ctx, key, token)
 
@@ -209,9 +301,9 @@ func CacheSetToken(token *TokenCache) error {
 	return err
 }
 
-func CacheGetTokenByKey(key string) (*TokenCache, error) {
+func CacheGetTokenByKeyHash(keyHash string) (*TokenCache, error) {
 	if !common.RedisEnabled {
-		token, err := GetTokenByKey(key)
+		token, err := GetTokenByKeyHash(keyHash)
 		if err != nil {
 			return nil, err
 		}
@@ -222,24 +314,29 @@ func CacheGetTokenByKey(key string) (*TokenCache, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), redisTimeout)
 	defer cancel()
 
-	cacheKey := common.RedisKeyf(TokenCacheKey, key)
+	cacheKey := common.RedisKeyf(TokenCacheKey, keyHash)
 	tokenCache := &TokenCache{}
 
 	err := common.RDB.HGetAll(ctx, cacheKey).Scan(tokenCache)
 	if err == nil && tokenCache.ID != 0 {
-		tokenCache.Key = key
+		tokenCache.KeyHash = keyHash
 		return tokenCache, nil
 	} else if err != nil && !errors.Is(err, redis.Nil) {
-		log.Errorf("get token (%s) from redis error: %s", key, err.Error())
+		log.Errorf("get token (%s) from redis error: %s", keyHash, err.Error())
 	}
 
-	token, err := GetTokenByKey(key)
+	token, err := GetTokenByKeyHash(keyHash)
 	if err != nil {
 		return nil, err
 	}
 
 	tc := token.ToTokenCache()
 
+	// the previous key of a rotated token is checked in the database until the grace period ends
+	if token.KeyHash != keyHash {
+		return tc, nil
+	}
+
 	if err := CacheSetToken(tc); err != nil {
 		log.Error("redis set token error: " + err.Error())
 	}
@@ -349,6 +446,27 @@ func (r redisMap[K, V]) MarshalBinary() ([]byte, error) {
 
 type (
 	redisGroupModelConfigMap = redisMap[string, GroupModelConfig]
+	redisMCPToolsRPMMap      = redisMap[string, int64]
+)
+
+type redisSlice[T any] []T
+
+var (
+	_ redis.Scanner            = (*redisSlice[any])(nil)
+	_ encoding.BinaryMarshaler = (*redisSlice[any])(nil)
+)
+
+func (r *redisSlice[T]) ScanRedis(value string) error {
+	return sonic.UnmarshalString(value, r)
+}
+
+func (r redisSlice[T]) MarshalBinary() ([]byte, error) {
+	return sonic.Marshal(r)
+}
+
+type (
+	redisTokenModelBudgets = redisSlice[TokenModelBudget]
+	redisModes             = redisSlice[mode.Mode]
 )
 
 type GroupCache struct {
@@ -362,6 +480,18 @@ type GroupCache struct {
 
 	BalanceAlertEnabled   bool    `json:"balance_alert_enabled"   redis:"bae"`
 	BalanceAlertThreshold float64 `json:"balance_alert_threshold" redis:"bat"`
+
+	QueueMaxWait  int64 `json:"queue_max_wait"  redis:"qmw"`
+	QueueMaxDepth int64 `json:"queue_max_depth" redis:"qmd"`
+
+	MCPAllowTools redisStringSlice    `json:"mcp_allow_tools" redis:"mat"`
+	MCPDenyTools  redisStringSlice    `json:"mcp_deny_tools"  redis:"mdt"`
+	MCPToolsRPM   redisMCPToolsRPMMap `json:"mcp_tools_rpm"   redis:"mtr"`
+}
+
+// AllowsMCPTool reports whether the group can call the mcp tool named `mcp_id__tool`
+func (g *GroupCache) AllowsMCPTool(tool string) bool {
+	return allowsMCPTool(g.MCPAllowTools, g.MCPDenyTools, tool)
 }
 
 func (g *GroupCache) GetAvailableSets() []string {
@@ -388,6 +518,13 @@ func (g *Group) ToGroupCache() *GroupCache {
 
 		BalanceAlertEnabled:   g.BalanceAlertEnabled,
 		BalanceAlertThreshold: g.BalanceAlertThreshold,
+
+		QueueMaxWait:  g.QueueMaxWait,
+		QueueMaxDepth: g.QueueMaxDepth,
+
+		MCPAllowTools: g.MCPAllowTools,
+		MCPDenyTools:  g.MCPDenyTools,
+		MCPToolsRPM:   g.MCPToolsRPM,
 	}
 }
 
@@ -659,6 +796,7 @@ type PublicMCPCache struct {
 	ProxyConfig   *PublicMCPProxyConfig `json:"proxy_config"   redis:"pc"`
 	OpenAPIConfig *MCPOpenAPIConfig     `json:"openapi_config" redis:"oc"`
 	EmbedConfig   *MCPEmbeddingConfig   `json:"embed_config"   redis:"ec"`
+	StdioConfig   *MCPStdioConfig       `json:"stdio_config"   redis:"sc"`
 }
 
 func (p *PublicMCP) ToPublicMCPCache() *PublicMCPCache {
@@ -670,6 +808,7 @@ func (p *PublicMCP) ToPublicMCPCache() *PublicMCPCache {
 		ProxyConfig:   p.ProxyConfig,
 		OpenAPIConfig: p.OpenAPIConfig,
 		EmbedConfig:   p.EmbedConfig,
+		StdioConfig:   p.StdioConfig,
 	}
 }
 
@@ -942,6 +1081,7 @@ func CacheGetStore(group string, tokenID int, id string) (*StoreCache, error) {
 
 type ModelConfigCache interface {
 	GetModelConfig(model string) (ModelConfig, bool)
+	GetModelAliases() []ModelConfig
 }
 
 // read-only cache
@@ -1131,6 +1271,17 @@ func (m *modelConfigMapCache) GetModelConfig(model string) (ModelConfig, bool) {
 	return config, ok
 }
 
+func (m *modelConfigMapCache) GetModelAliases() []ModelConfig {
+	aliases := make([]ModelConfig, 0)
+	for _, config := range m.modelConfigMap {
+		if config.IsAlias() {
+			aliases = append(aliases, config)
+		}
+	}
+
+	return aliases
+}
+
 var _ ModelConfigCache = (*disabledModelConfigCache)(nil)
 
 type disabledModelConfigCache struct {
@@ -1144,6 +1295,10 @@ func (d *disabledModelConfigCache) GetModelConfig(model string) (ModelConfig, bo
 	return NewDefaultModelConfig(model), true
 }
 
+func (d *disabledModelConfigCache) GetModelAliases() []ModelConfig {
+	return d.modelConfigs.GetModelAliases()
+}
+
 func initializeModelConfigCache() (ModelConfigCache, error) {
 	modelConfigs, err := GetAllModelConfigs()
 	if err != nil {
@@ -1248,6 +1403,25 @@ func buildEnabledModelsBySet(
 			}
 		}
 
+		// an alias is enabled in the set when any of its targets is enabled
+		for _, alias := range modelConfigCache.GetModelAliases() {
+			if _, ok := appended[alias.Model]; ok {
+				continue
+			}
+
+			if !slices.ContainsFunc(alias.Alias.Targets, func(target ModelAliasTarget) bool {
+				_, ok := appended[target.Model]
+				return ok
+			}) {
+				continue
+			}
+
+			models = append(models, alias.Model)
+			configs = append(configs, alias)
+			appended[alias.Model] = struct{}{}
+			modelConfigsMap[alias.Model] = alias
+		}
+
 		slices.Sort(models)
 		slices.SortStableFunc(configs, SortModelConfigsFunc)
 
@@ -31,28 +31,35 @@ const (
 )
 
 type Channel struct {
-	DeletedAt               gorm.DeletedAt    `gorm:"index"                              json:"-"                          yaml:"-"`
-	CreatedAt               time.Time         `gorm:"index"                              json:"created_at"                 yaml:"-"`
-	LastTestErrorAt         time.Time         `                                          json:"last_test_error_at"         yaml:"-"`
-	ChannelTests            []*ChannelTest    `gorm:"foreignKey:ChannelID;references:ID" json:"channel_tests,omitempty"    yaml:"-"`
-	BalanceUpdatedAt        time.Time         `                                          json:"balance_updated_at"         yaml:"-"`
-	ModelMapping            map[string]string `gorm:"serializer:fastjson;type:text"      json:"model_mapping"              yaml:"model_mapping,omitempty"`
-	Key                     string            `gorm:"type:text;index:,length:191"        json:"key"                        yaml:"key,omitempty"`
-	Name                    string            `gorm:"size:64;index"                      json:"name"                       yaml:"name,omitempty"`
-	BaseURL                 string            `gorm:"size:128;index"                     json:"base_url"                   yaml:"base_url,omitempty"`
-	Models                  []string          `gorm:"serializer:fastjson;type:text"      json:"models"                     yaml:"models,omitempty"`
-	Balance                 float64           `                                          json:"balance"                    yaml:"balance,omitempty"`
-	ID                      int               `gorm:"primaryKey"                         json:"id"                         yaml:"id,omitempty"`
-	UsedAmount              float64           `gorm:"index"                              json:"used_amount"                yaml:"-"`
-	RequestCount            int               `gorm:"index"                              json:"request_count"              yaml:"-"`
-	RetryCount              int               `gorm:"index"                              json:"retry_count"                yaml:"-"`
-	Status                  int               `gorm:"default:1;index"                    json:"status"                     yaml:"status,omitempty"`
-	Type                    ChannelType       `gorm:"default:0;index"                    json:"type"                       yaml:"type,omitempty"`
-	Priority                int32             `                                          json:"priority"                   yaml:"priority,omitempty"`
-	EnabledAutoBalanceCheck bool              `                                          json:"enabled_auto_balance_check" yaml:"enabled_auto_balance_check,omitempty"`
-	BalanceThreshold        float64           `                                          json:"balance_threshold"          yaml:"balance_threshold,omitempty"`
-	Configs                 ChannelConfigs    `gorm:"serializer:fastjson;type:text"      json:"configs,omitempty"          yaml:"configs,omitempty"`
-	Sets                    []string          `gorm:"serializer:fastjson;type:text"      json:"sets,omitempty"             yaml:"sets,omitempty"`
+	DeletedAt               gorm.DeletedAt    `gorm:"index"                              json:"-"                               yaml:"-"`
+	CreatedAt               time.Time         `gorm:"index"                              json:"created_at"                      yaml:"-"`
+	LastTestErrorAt         time.Time         `                                          json:"last_test_error_at"              yaml:"-"`
+	ChannelTests            []*ChannelTest    `gorm:"foreignKey:ChannelID;references:ID" json:"channel_tests,omitempty"         yaml:"-"`
+	BalanceUpdatedAt        time.Time         `                                          json:"balance_updated_at"              yaml:"-"`
+	ModelMapping            map[string]string `gorm:"serializer:fastjson;type:text"      json:"model_mapping"                   yaml:"model_mapping,omitempty"`
+	Key                     string            `gorm:"type:text;index:,length:191"        json:"key"                             yaml:"key,omitempty"`
+	Name                    string            `gorm:"size:64;index"                      json:"name"                            yaml:"name,omitempty"`
+	BaseURL                 string            `gorm:"size:128;index"                     json:"base_url"                        yaml:"base_url,omitempty"`
+	Models                  []string          `gorm:"serializer:fastjson;type:text"      json:"models"                          yaml:"models,omitempty"`
+	Balance                 float64           `                                          json:"balance"                         yaml:"balance,omitempty"`
+	ID                      int               `gorm:"primaryKey"                         json:"id"                              yaml:"id,omitempty"`
+	UsedAmount              float64           `gorm:"index"                              json:"used_amount"                     yaml:"-"`
+	RequestCount            int               `gorm:"index"                              json:"request_count"                   yaml:"-"`
+	RetryCount              int               `gorm:"index"                              json:"retry_count"                     yaml:"-"`
+	Status                  int               `gorm:"default:1;index"                    json:"status"                          yaml:"status,omitempty"`
+	Type                    ChannelType       `gorm:"default:0;index"                    json:"type"                            yaml:"type,omitempty"`
+	Priority                int32             `                                          json:"priority"                        yaml:"priority,omitempty"`
+	Cost                    float64           `                                          json:"cost,omitempty"                  yaml:"cost,omitempty"`
+	MaxConcurrency          int64             `                                          json:"max_concurrency,omitempty"       yaml:"max_concurrency,omitempty"`
+	ModelMaxConcurrency     map[string]int64  `gorm:"serializer:fastjson;type:text"      json:"model_max_concurrency,omitempty" yaml:"model_max_concurrency,omitempty"`
+	RPM                     int64             `                                          json:"rpm,omitempty"                   yaml:"rpm,omitempty"`
+	TPM                     int64             `                                          json:"tpm,omitempty"                   yaml:"tpm,omitempty"`
+	ModelRPM                map[string]int64  `gorm:"serializer:fastjson;type:text"      json:"model_rpm,omitempty"             yaml:"model_rpm,omitempty"`
+	ModelTPM                map[string]int64  `gorm:"serializer:fastjson;type:text"      json:"model_tpm,omitempty"             yaml:"model_tpm,omitempty"`
+	EnabledAutoBalanceCheck bool              `                                          json:"enabled_auto_balance_check"      yaml:"enabled_auto_balance_check,omitempty"`
+	BalanceThreshold        float64           `                                          json:"balance_threshold"               yaml:"balance_threshold,omitempty"`
+	Configs                 ChannelConfigs    `gorm:"serializer:fastjson;type:text"      json:"configs,omitempty"               yaml:"configs,omitempty"`
+	Sets                    []string          `gorm:"serializer:fastjson;type:text"      json:"sets,omitempty"                  yaml:"sets,omitempty"`
 }
 
 func (c *Channel) GetSets() []string {
@@ -84,6 +91,38 @@ func (c *Channel) GetPriority() int32 {
 	return c.Priority
 }
 
+// HasConcurrencyLimit reports whether the channel limits the concurrency of the model
+func (c *Channel) HasConcurrencyLimit(model string) bool {
+	return c.MaxConcurrency > 0 || c.ModelMaxConcurrency[model] > 0
+}
+
+// IsSaturated reports whether the in flight requests of the channel, or of the
+// model on the channel, reached the max concurrency
+func (c *Channel) IsSaturated(model string, total, modelTotal int64) bool {
+	if c.MaxConcurrency > 0 && total >= c.MaxConcurrency {
+		return true
+	}
+
+	maxConcurrency := c.ModelMaxConcurrency[model]
+
+	return maxConcurrency > 0 && modelTotal >= maxConcurrency
+}
+
+// HasRateLimit reports whether the channel limits the requests or tokens
+// per minute of the channel, or of the model on the channel
+func (c *Channel) HasRateLimit(model string) bool {
+	return c.RPM > 0 || c.TPM > 0 || c.ModelRPM[model] > 0 || c.ModelTPM[model] > 0
+}
+
+// GetCost returns the relative cost of the channel used by the cheapest
+// channel strategy, channels without a cost override cost 1
+func (c *Channel) GetCost() float64 {
+	if c.Cost <= 0 {
+		return 1
+	}
+	return c.Cost
+}
+
 type ChannelConfigs map[string]any
 
 func (c ChannelConfigs) LoadConfig(config any) error {
@@ -404,6 +443,13 @@ func UpdateChannel(channel *Channel) (err error) {
 		"base_url",
 		"models",
 		"priority",
+		"cost",
+		"max_concurrency",
+		"model_max_concurrency",
+		"rpm",
+		"tpm",
+		"model_rpm",
+		"model_tpm",
 		"config",
 		"enabled_auto_balance_check",
 		"balance_threshold",
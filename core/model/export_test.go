@@ -2,3 +2,5 @@ package model
 
 // Export for testing
 var ToLimitOffset = toLimitOffset
+
+var MigrateTokenKeys = migrateTokenKeys
@@ -0,0 +1,157 @@
+package model
+
+import (
+	"errors"
+	"path"
+	"time"
+
+	"github.com/labring/aiproxy/core/common"
+	"gorm.io/gorm"
+)
+
+const (
+	ErrFileNotFound = "file"
+)
+
+const (
+	FileStatusUploaded  = "uploaded"
+	FileStatusProcessed = "processed"
+	FileStatusError     = "error"
+)
+
+// File records the ownership of a file stored by the proxy itself,
+// files passed through to a channel are tracked by StoreV2 instead
+type File struct {
+	ID         string    `gorm:"size:64;primaryKey"                 json:"id"`
+	CreatedAt  time.Time `gorm:"autoCreateTime;index"               json:"created_at"`
+	ExpiresAt  time.Time `                                          json:"expires_at"`
+	GroupID    string    `gorm:"size:64;index:idx_file_group_token" json:"group"`
+	TokenID    int       `gorm:"index:idx_file_group_token"         json:"token_id"`
+	Filename   string    `gorm:"size:255"                           json:"filename"`
+	Purpose    string    `gorm:"size:32;index"                      json:"purpose"`
+	Bytes      int64     `                                          json:"bytes"`
+	Status     string    `gorm:"size:16"                            json:"status"`
+	StorageKey string    `gorm:"size:255"                           json:"-"`
+}
+
+func NewFileID() string {
+	return "file-" + common.ShortUUID()
+}
+
+func FileStorageKey(group, id string) string {
+	return path.Join(group, id)
+}
+
+func (f *File) BeforeCreate(_ *gorm.DB) error {
+	if f.GroupID != "" && f.TokenID == 0 {
+		return errors.New("token id is required")
+	}
+
+	if f.ID == "" {
+		f.ID = NewFileID()
+	}
+
+	if f.StorageKey == "" {
+		f.StorageKey = FileStorageKey(f.GroupID, f.ID)
+	}
+
+	if f.Status == "" {
+		f.Status = FileStatusProcessed
+	}
+
+	return nil
+}
+
+func CreateFile(f *File) error {
+	return LogDB.Create(f).Error
+}
+
+func GetFile(group string, tokenID int, id string) (*File, error) {
+	var f File
+
+	err := LogDB.
+		Where("group_id = ? and token_id = ? and id = ?", group, tokenID, id).
+		First(&f).
+		Error
+	if err != nil {
+		return nil, HandleNotFound(err, ErrFileNotFound)
+	}
+
+	if !f.ExpiresAt.IsZero() && f.ExpiresAt.Before(time.Now()) {
+		return nil, NotFoundError(ErrFileNotFound)
+	}
+
+	return &f, nil
+}
+
+// ListFiles lists the files of a token ordered by creation time,
+// after is the id of the last file of the previous page
+func ListFiles(
+	group string,
+	tokenID int,
+	purpose string,
+	after string,
+	limit int,
+	asc bool,
+) (files []*File, hasMore bool, err error) {
+	tx := LogDB.Model(&File{}).
+		Where("group_id = ? and token_id = ?", group, tokenID).
+		Where("expires_at IS NULL OR expires_at = ? OR expires_at > ?", time.Time{}, time.Now())
+
+	if purpose != "" {
+		tx = tx.Where("purpose = ?", purpose)
+	}
+
+	if after != "" {
+		cursor, err := GetFile(group, tokenID, after)
+		if err != nil {
+			return nil, false, err
+		}
+
+		// the files created at the same time are ordered by id
+		if asc {
+			tx = tx.Where(
+				"created_at > ? OR (created_at = ? AND id > ?)",
+				cursor.CreatedAt,
+				cursor.CreatedAt,
+				cursor.ID,
+			)
+		} else {
+			tx = tx.Where(
+				"created_at < ? OR (created_at = ? AND id < ?)",
+				cursor.CreatedAt,
+				cursor.CreatedAt,
+				cursor.ID,
+			)
+		}
+	}
+
+	if asc {
+		tx = tx.Order("created_at asc").Order("id asc")
+	} else {
+		tx = tx.Order("created_at desc").Order("id desc")
+	}
+
+	if limit <= 0 {
+		limit = 10000
+	}
+
+	err = tx.Limit(limit + 1).Find(&files).Error
+	if err != nil {
+		return nil, false, err
+	}
+
+	if len(files) > limit {
+		return files[:limit], true, nil
+	}
+
+	return files, false, nil
+}
+
+func DeleteFile(group string, tokenID int, id string) error {
+	result := LogDB.
+		Where("group_id = ? and token_id = ? and id = ?", group, tokenID, id).
+		Delete(&File{})
+
+	return HandleUpdateResult(result, ErrFileNotFound)
+}
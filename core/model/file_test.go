@@ -0,0 +1,77 @@
+package model_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// useTestDB points DB and LogDB to a new sqlite database with the tables of models
+func useTestDB(t *testing.T, models ...any) *gorm.DB {
+	t.Helper()
+
+	db, err := model.OpenSQLite(filepath.Join(t.TempDir(), "aiproxy.db"))
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(models...))
+
+	oldDB, oldLogDB := model.DB, model.LogDB
+	model.DB, model.LogDB = db, db
+
+	t.Cleanup(func() {
+		model.DB, model.LogDB = oldDB, oldLogDB
+
+		if sqlDB, err := db.DB(); err == nil {
+			_ = sqlDB.Close()
+		}
+	})
+
+	return db
+}
+
+func TestListFilesSameCreatedAt(t *testing.T) {
+	useTestDB(t, &model.File{})
+
+	createdAt := time.Now().Truncate(time.Second)
+	for _, id := range []string{"file-a", "file-b", "file-c", "file-d", "file-e"} {
+		require.NoError(t, model.CreateFile(&model.File{
+			ID:        id,
+			CreatedAt: createdAt,
+			GroupID:   "g1",
+			TokenID:   1,
+		}))
+	}
+
+	for _, asc := range []bool{true, false} {
+		var (
+			ids   []string
+			after string
+		)
+
+		for {
+			files, hasMore, err := model.ListFiles("g1", 1, "", after, 2, asc)
+			require.NoError(t, err)
+
+			for _, f := range files {
+				ids = append(ids, f.ID)
+			}
+
+			if !hasMore {
+				break
+			}
+
+			after = files[len(files)-1].ID
+		}
+
+		want := []string{"file-a", "file-b", "file-c", "file-d", "file-e"}
+		if !asc {
+			want = []string{"file-e", "file-d", "file-c", "file-b", "file-a"}
+		}
+
+		assert.Equal(t, want, ids)
+	}
+}
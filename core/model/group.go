@@ -37,13 +37,34 @@ type Group struct {
 
 	BalanceAlertEnabled   bool    `gorm:"default:false" json:"balance_alert_enabled"`
 	BalanceAlertThreshold float64 `gorm:"default:0"     json:"balance_alert_threshold"`
+
+	// Queue mode, requests exceeding the rpm/tpm wait up to QueueMaxWait seconds
+	// instead of being rejected, disabled when QueueMaxWait is 0. The queue is
+	// per instance, QueueMaxDepth and the fairness among tokens are per instance
+	QueueMaxWait  int64 `json:"queue_max_wait,omitempty"`
+	QueueMaxDepth int64 `json:"queue_max_depth,omitempty"`
+
+	// MCPAllowTools, MCPDenyTools and MCPToolsRPM limit the mcp tools of all tokens of
+	// the group, see the same fields of the token
+	MCPAllowTools []string         `json:"mcp_allow_tools,omitempty" gorm:"serializer:fastjson;type:text"`
+	MCPDenyTools  []string         `json:"mcp_deny_tools,omitempty"  gorm:"serializer:fastjson;type:text"`
+	MCPToolsRPM   map[string]int64 `json:"mcp_tools_rpm,omitempty"   gorm:"serializer:fastjson;type:text"`
 }
 
 func (g *Group) BeforeSave(_ *gorm.DB) error {
 	if len(g.ID) > 64 {
 		return errors.New("group id length too long")
 	}
-	return nil
+
+	if err := validateMCPToolPatterns(g.MCPAllowTools); err != nil {
+		return err
+	}
+
+	if err := validateMCPToolPatterns(g.MCPDenyTools); err != nil {
+		return err
+	}
+
+	return validateMCPToolsRPM(g.MCPToolsRPM)
 }
 
 func (g *Group) BeforeDelete(tx *gorm.DB) (err error) {
@@ -194,6 +215,12 @@ type UpdateGroupRequest struct {
 	AvailableSets         *[]string `json:"available_sets,omitempty"`
 	BalanceAlertEnabled   *bool     `json:"balance_alert_enabled"`
 	BalanceAlertThreshold *float64  `json:"balance_alert_threshold"`
+	QueueMaxWait          *int64    `json:"queue_max_wait,omitempty"`
+	QueueMaxDepth         *int64    `json:"queue_max_depth,omitempty"`
+
+	MCPAllowTools *[]string         `json:"mcp_allow_tools"`
+	MCPDenyTools  *[]string         `json:"mcp_deny_tools"`
+	MCPToolsRPM   *map[string]int64 `json:"mcp_tools_rpm"`
 }
 
 func UpdateGroup(id string, update UpdateGroupRequest) (group *Group, err error) {
@@ -245,6 +272,36 @@ func UpdateGroup(id string, update UpdateGroupRequest) (group *Group, err error)
 		selects = append(selects, "balance_alert_threshold")
 	}
 
+	if update.QueueMaxWait != nil {
+		group.QueueMaxWait = *update.QueueMaxWait
+
+		selects = append(selects, "queue_max_wait")
+	}
+
+	if update.QueueMaxDepth != nil {
+		group.QueueMaxDepth = *update.QueueMaxDepth
+
+		selects = append(selects, "queue_max_depth")
+	}
+
+	if update.MCPAllowTools != nil {
+		group.MCPAllowTools = *update.MCPAllowTools
+
+		selects = append(selects, "mcp_allow_tools")
+	}
+
+	if update.MCPDenyTools != nil {
+		group.MCPDenyTools = *update.MCPDenyTools
+
+		selects = append(selects, "mcp_deny_tools")
+	}
+
+	if update.MCPToolsRPM != nil {
+		group.MCPToolsRPM = *update.MCPToolsRPM
+
+		selects = append(selects, "mcp_tools_rpm")
+	}
+
 	if group.Status != 0 {
 		selects = append(selects, "status")
 	}
@@ -159,6 +159,22 @@ func GetAllGroupMCPs(status GroupMCPStatus) ([]GroupMCP, error) {
 	return mcps, err
 }
 
+// GetEnabledGroupMCPs retrieves all enabled GroupMCPs of the group
+func GetEnabledGroupMCPs(groupID string) ([]GroupMCP, error) {
+	var mcps []GroupMCP
+	if groupID == "" {
+		return mcps, errors.New("group id is empty")
+	}
+
+	err := DB.Model(&GroupMCP{}).
+		Where("group_id = ? AND status = ?", groupID, GroupMCPStatusEnabled).
+		Order("id").
+		Find(&mcps).
+		Error
+
+	return mcps, err
+}
+
 // DeleteGroupMCP deletes a GroupMCP by ID and GroupID
 func DeleteGroupMCP(id, groupID string) (err error) {
 	defer func() {
@@ -29,6 +29,13 @@ type GroupModelConfig struct {
 
 	OverrideForceSaveDetail bool `json:"override_force_save_detail"`
 	ForceSaveDetail         bool `json:"force_save_detail"`
+
+	OverrideFallbackModel bool   `json:"override_fallback_model"`
+	FallbackModel         string `json:"fallback_model"          gorm:"size:64"`
+
+	// OverridePlugin replaces the configs of the plugins set in Plugin, the other plugins keep the model configs
+	OverridePlugin bool                      `json:"override_plugin"`
+	Plugin         map[string]map[string]any `json:"plugin,omitempty" gorm:"serializer:fastjson;type:text"`
 }
 
 func (g *GroupModelConfig) BeforeSave(_ *gorm.DB) (err error) {
@@ -40,6 +47,10 @@ func (g *GroupModelConfig) BeforeSave(_ *gorm.DB) (err error) {
 		return err
 	}
 
+	if g.FallbackModel == g.Model {
+		return errors.New("fallback model cannot be the model itself")
+	}
+
 	return nil
 }
 
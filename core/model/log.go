@@ -56,7 +56,10 @@ type Log struct {
 	Content          EmptyNullString `gorm:"type:text"                                                      json:"content,omitempty"`
 	GroupID          string          `gorm:"size:64"                                                        json:"group,omitempty"`
 	Model            string          `gorm:"size:64"                                                        json:"model"`
+	ModelAlias       EmptyNullString `gorm:"size:64"                                                        json:"model_alias,omitempty"`
+	ChannelStrategy  EmptyNullString `gorm:"size:32"                                                        json:"channel_strategy,omitempty"`
 	RequestID        EmptyNullString `gorm:"type:char(16);index:,where:request_id is not null"              json:"request_id"`
+	TraceID          EmptyNullString `gorm:"type:char(32);index:,where:trace_id is not null"                json:"trace_id,omitempty"`
 	ID               int             `gorm:"primaryKey"                                                     json:"id"`
 	TokenID          int             `gorm:"index"                                                          json:"token_id,omitempty"`
 	ChannelID        int             `                                                                      json:"channel,omitempty"`
@@ -317,6 +320,7 @@ func cleanLogDetail(batchSize int) error {
 
 func RecordConsumeLog(
 	requestID string,
+	traceID string,
 	createAt time.Time,
 	requestAt time.Time,
 	retryAt time.Time,
@@ -325,6 +329,8 @@ func RecordConsumeLog(
 	code int,
 	channelID int,
 	modelName string,
+	modelAlias string,
+	channelStrategy string,
 	tokenID int,
 	tokenName string,
 	endpoint string,
@@ -353,6 +359,7 @@ func RecordConsumeLog(
 
 	log := &Log{
 		RequestID:        EmptyNullString(requestID),
+		TraceID:          EmptyNullString(traceID),
 		RequestAt:        requestAt,
 		CreatedAt:        createAt,
 		RetryAt:          retryAt,
@@ -362,6 +369,8 @@ func RecordConsumeLog(
 		TokenID:          tokenID,
 		TokenName:        tokenName,
 		Model:            modelName,
+		ModelAlias:       EmptyNullString(modelAlias),
+		ChannelStrategy:  EmptyNullString(channelStrategy),
 		Mode:             mode,
 		IP:               EmptyNullString(ip),
 		ChannelID:        channelID,
@@ -0,0 +1,56 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordConsumeLogChannelStrategy(t *testing.T) {
+	db := useTestDB(t, &model.Log{}, &model.RequestDetail{})
+
+	now := time.Now()
+	for i, strategy := range []string{"latency", ""} {
+		require.NoError(t, model.RecordConsumeLog(
+			"req",
+			"",
+			now,
+			now,
+			time.Time{},
+			now,
+			"g1",
+			200,
+			i+1,
+			"gpt-4o-mini",
+			"",
+			strategy,
+			1,
+			"t1",
+			"/v1/chat/completions",
+			"",
+			1,
+			"",
+			0,
+			nil,
+			model.Usage{},
+			model.Price{},
+			0,
+			"",
+			nil,
+		))
+	}
+
+	var logs []model.Log
+	require.NoError(t, db.Order("channel_id").Find(&logs).Error)
+	require.Len(t, logs, 2)
+
+	assert.Equal(t, model.EmptyNullString("latency"), logs[0].ChannelStrategy)
+	assert.Empty(t, logs[1].ChannelStrategy)
+
+	var nulls int64
+	require.NoError(t, db.Model(&model.Log{}).Where("channel_strategy IS NULL").Count(&nulls).Error)
+	assert.Equal(t, int64(1), nulls)
+}
@@ -123,7 +123,7 @@ func InitDB() error {
 	setDBConns(DB)
 
 	if config.DisableAutoMigrateDB {
-		return nil
+		return initTokenKeys()
 	}
 
 	log.Info("database migration started")
@@ -155,7 +155,11 @@ func migrateDB() error {
 		return err
 	}
 
-	return nil
+	if err := initTokenKeySalt(); err != nil {
+		return err
+	}
+
+	return migrateTokenKeys()
 }
 
 func InitLogDB(batchSize int) error {
@@ -228,6 +232,9 @@ func migrateLogDB(batchSize int) error {
 		&Summary{},
 		&ConsumeError{},
 		&StoreV2{},
+		&File{},
+		&Batch{},
+		&BatchOutput{},
 		&SummaryMinute{},
 		&GroupSummaryMinute{},
 	)
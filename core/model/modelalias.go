@@ -0,0 +1,162 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"math/rand/v2"
+)
+
+// aliasBuckets is the resolution of the rollout percentages
+const aliasBuckets = 10000
+
+// ModelAlias resolves a virtual model name to one or more real models
+type ModelAlias struct {
+	Targets []ModelAliasTarget `json:"targets"          yaml:"targets"`
+	// Sticky assigns the same target to the same user,
+	// the user is the `user` of the request or the token name if it is empty
+	Sticky bool `json:"sticky,omitempty" yaml:"sticky,omitempty"`
+}
+
+type ModelAliasTarget struct {
+	Model string `json:"model"             yaml:"model"`
+	// Weight splits the traffic left by the rollout targets
+	Weight int64 `json:"weight,omitempty"  yaml:"weight,omitempty"`
+	// Rollout is the percentage (0-100] of the traffic sent to the target before the weights
+	// apply, raising it keeps the sticky users already assigned to the target
+	Rollout float64 `json:"rollout,omitempty" yaml:"rollout,omitempty"`
+}
+
+func (a *ModelAlias) Validate(alias string) error {
+	if len(a.Targets) == 0 {
+		return errors.New("alias targets is required")
+	}
+
+	var (
+		rollout float64
+		weight  int64
+	)
+
+	seen := make(map[string]struct{}, len(a.Targets))
+	for _, target := range a.Targets {
+		if target.Model == "" {
+			return errors.New("alias target model is required")
+		}
+
+		if target.Model == alias {
+			return fmt.Errorf("alias target cannot be the alias itself: %s", alias)
+		}
+
+		if _, ok := seen[target.Model]; ok {
+			return fmt.Errorf("duplicate alias target: %s", target.Model)
+		}
+
+		seen[target.Model] = struct{}{}
+
+		if target.Weight < 0 {
+			return fmt.Errorf("invalid alias target weight: %s", target.Model)
+		}
+
+		if target.Rollout < 0 || target.Rollout > 100 {
+			return fmt.Errorf("invalid alias target rollout: %s", target.Model)
+		}
+
+		rollout += target.Rollout
+		if target.Rollout == 0 {
+			weight += target.Weight
+		}
+	}
+
+	if rollout > 100 {
+		return errors.New("sum of alias target rollouts exceeds 100")
+	}
+
+	if rollout < 100 && weight == 0 {
+		return errors.New("alias targets without rollout must have a weight")
+	}
+
+	return nil
+}
+
+// Resolve picks the target model of the alias among the available targets,
+// the key keeps the target of a user when the alias is sticky
+func (a *ModelAlias) Resolve(alias, key string, available func(model string) bool) (string, bool) {
+	availables := make([]bool, len(a.Targets))
+	first := -1
+
+	for i, target := range a.Targets {
+		availables[i] = available(target.Model)
+		if availables[i] && first == -1 {
+			first = i
+		}
+	}
+
+	if first == -1 {
+		return "", false
+	}
+
+	var bucket int64
+	if a.Sticky && key != "" {
+		h := fnv.New64a()
+		_, _ = h.Write([]byte(alias))
+		_, _ = h.Write([]byte{0})
+		_, _ = h.Write([]byte(key))
+		//nolint:gosec
+		bucket = int64(h.Sum64() % aliasBuckets)
+	} else {
+		bucket = rand.Int64N(aliasBuckets)
+	}
+
+	// the rollout ranges are laid out over all the targets, so an unavailable
+	// target doesn't move the sticky users of the other rollout targets
+	var rolloutBuckets int64
+	for i, target := range a.Targets {
+		if target.Rollout <= 0 {
+			continue
+		}
+
+		start := rolloutBuckets
+		rolloutBuckets += int64(target.Rollout * aliasBuckets / 100)
+
+		if availables[i] && bucket >= start && bucket < rolloutBuckets {
+			return target.Model, true
+		}
+	}
+
+	var totalWeight int64
+	for i, target := range a.Targets {
+		if availables[i] && target.Rollout <= 0 {
+			totalWeight += target.Weight
+		}
+	}
+
+	// only rollout targets are available, the traffic left goes to the first one
+	if totalWeight == 0 {
+		return a.Targets[first].Model, true
+	}
+
+	// the traffic of an unavailable rollout target spreads over the weights
+	var point int64
+	if bucket < rolloutBuckets || rolloutBuckets >= aliasBuckets {
+		point = bucket * totalWeight / aliasBuckets
+	} else {
+		point = (bucket - rolloutBuckets) * totalWeight / (aliasBuckets - rolloutBuckets)
+	}
+
+	last := first
+
+	for i, target := range a.Targets {
+		if !availables[i] || target.Rollout > 0 {
+			continue
+		}
+
+		if point < target.Weight {
+			return target.Model, true
+		}
+
+		point -= target.Weight
+		last = i
+	}
+
+	return a.Targets[last].Model, true
+}
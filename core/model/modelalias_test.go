@@ -0,0 +1,179 @@
+package model_test
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/labring/aiproxy/core/model"
+)
+
+func allAvailable(string) bool { return true }
+
+func TestModelAliasResolveSticky(t *testing.T) {
+	alias := model.ModelAlias{
+		Targets: []model.ModelAliasTarget{
+			{Model: "gpt-4o", Weight: 1},
+			{Model: "claude-sonnet", Weight: 1},
+		},
+		Sticky: true,
+	}
+
+	for i := range 100 {
+		user := "user-" + strconv.Itoa(i)
+
+		first, ok := alias.Resolve("team-default", user, allAvailable)
+		if !ok {
+			t.Fatal("expected a target")
+		}
+
+		for range 10 {
+			target, _ := alias.Resolve("team-default", user, allAvailable)
+			if target != first {
+				t.Fatalf("user %s moved from %s to %s", user, first, target)
+			}
+		}
+	}
+}
+
+func TestModelAliasResolveRollout(t *testing.T) {
+	alias := model.ModelAlias{
+		Targets: []model.ModelAliasTarget{
+			{Model: "claude-sonnet", Rollout: 10},
+			{Model: "gpt-4o", Weight: 1},
+		},
+		Sticky: true,
+	}
+
+	canary := make(map[string]struct{})
+
+	for i := range 1000 {
+		user := "user-" + strconv.Itoa(i)
+		if target, _ := alias.Resolve("team-default", user, allAvailable); target == "claude-sonnet" {
+			canary[user] = struct{}{}
+		}
+	}
+
+	if len(canary) < 50 || len(canary) > 150 {
+		t.Fatalf("expected about 10%% of the users on the rollout target, got %d", len(canary))
+	}
+
+	alias.Targets[0].Rollout = 50
+
+	for user := range canary {
+		if target, _ := alias.Resolve("team-default", user, allAvailable); target != "claude-sonnet" {
+			t.Fatalf("user %s left the rollout target after raising the rollout", user)
+		}
+	}
+}
+
+func TestModelAliasResolveRolloutUnavailable(t *testing.T) {
+	alias := model.ModelAlias{
+		Targets: []model.ModelAliasTarget{
+			{Model: "claude-sonnet", Rollout: 20},
+			{Model: "gemini-pro", Rollout: 20},
+			{Model: "gpt-4o", Weight: 1},
+		},
+		Sticky: true,
+	}
+
+	withoutSonnet := func(model string) bool {
+		return model != "claude-sonnet"
+	}
+
+	for i := range 1000 {
+		user := "user-" + strconv.Itoa(i)
+
+		target, _ := alias.Resolve("team-default", user, allAvailable)
+
+		after, ok := alias.Resolve("team-default", user, withoutSonnet)
+		if !ok {
+			t.Fatal("expected a target")
+		}
+
+		switch target {
+		case "claude-sonnet":
+			if after != "gpt-4o" {
+				t.Fatalf("user %s of the unavailable target moved to %s", user, after)
+			}
+		default:
+			if after != target {
+				t.Fatalf("user %s moved from %s to %s", user, target, after)
+			}
+		}
+	}
+}
+
+func TestModelAliasResolveUnavailable(t *testing.T) {
+	alias := model.ModelAlias{
+		Targets: []model.ModelAliasTarget{
+			{Model: "claude-sonnet", Rollout: 100},
+			{Model: "gpt-4o", Weight: 1},
+		},
+	}
+
+	target, ok := alias.Resolve("team-default", "", func(model string) bool {
+		return model == "gpt-4o"
+	})
+	if !ok || target != "gpt-4o" {
+		t.Fatalf("expected gpt-4o, got %q", target)
+	}
+
+	_, ok = alias.Resolve("team-default", "", func(string) bool { return false })
+	if ok {
+		t.Fatal("expected no target")
+	}
+}
+
+func TestModelAliasValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		alias   model.ModelAlias
+		wantErr bool
+	}{
+		{
+			name:    "no targets",
+			alias:   model.ModelAlias{},
+			wantErr: true,
+		},
+		{
+			name: "self target",
+			alias: model.ModelAlias{Targets: []model.ModelAliasTarget{
+				{Model: "team-default", Weight: 1},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "rollout exceeds 100",
+			alias: model.ModelAlias{Targets: []model.ModelAliasTarget{
+				{Model: "a", Rollout: 60},
+				{Model: "b", Rollout: 60},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "no weight left",
+			alias: model.ModelAlias{Targets: []model.ModelAliasTarget{
+				{Model: "a", Rollout: 60},
+				{Model: "b"},
+			}},
+			wantErr: true,
+		},
+		{
+			name: "valid",
+			alias: model.ModelAlias{Targets: []model.ModelAliasTarget{
+				{Model: "a", Rollout: 20},
+				{Model: "b", Weight: 3},
+				{Model: "c", Weight: 1},
+			}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.alias.Validate("team-default")
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"maps"
 	"reflect"
 	"strings"
 	"time"
@@ -25,6 +26,34 @@ type TimeoutConfig struct {
 	StreamRequestTimeout int64 `json:"stream_request_timeout,omitempty" yaml:"stream_request_timeout,omitempty"`
 }
 
+// HedgeConfig fires a second request to another channel when the first one has not
+// received the response headers after a delay derived from the recent latencies of the model
+type HedgeConfig struct {
+	Enabled bool `json:"enabled,omitempty"    yaml:"enabled,omitempty"`
+	// Percentile (0-100] of the recent latencies used as the delay, default 95
+	Percentile float64 `json:"percentile,omitempty" yaml:"percentile,omitempty"`
+	// MinDelay and MaxDelay bound the delay in milliseconds,
+	// MaxDelay is also the delay until enough latencies are collected
+	MinDelay int64 `json:"min_delay,omitempty"  yaml:"min_delay,omitempty"`
+	MaxDelay int64 `json:"max_delay,omitempty"  yaml:"max_delay,omitempty"`
+}
+
+func (h *HedgeConfig) Validate() error {
+	if h.Percentile < 0 || h.Percentile > 100 {
+		return fmt.Errorf("invalid hedge percentile: %v", h.Percentile)
+	}
+
+	if h.MinDelay < 0 || h.MaxDelay < 0 {
+		return errors.New("hedge delay cannot be negative")
+	}
+
+	if h.MaxDelay != 0 && h.MinDelay > h.MaxDelay {
+		return errors.New("hedge min delay cannot be greater than max delay")
+	}
+
+	return nil
+}
+
 type ModelConfig struct {
 	CreatedAt        time.Time                 `gorm:"index;autoCreateTime"          json:"created_at"                   yaml:"-"`
 	UpdatedAt        time.Time                 `gorm:"index;autoUpdateTime"          json:"updated_at"                   yaml:"-"`
@@ -46,6 +75,39 @@ type ModelConfig struct {
 	WarnErrorRate   float64            `                                     json:"warn_error_rate,omitempty"   yaml:"warn_error_rate,omitempty"`
 	MaxErrorRate    float64            `                                     json:"max_error_rate,omitempty"    yaml:"max_error_rate,omitempty"`
 	ForceSaveDetail bool               `                                     json:"force_save_detail,omitempty" yaml:"force_save_detail,omitempty"`
+	ChannelStrategy ChannelStrategy    `gorm:"size:32"                       json:"channel_strategy,omitempty"  yaml:"channel_strategy,omitempty"`
+	// FallbackModel answers the request when all the channels of the model are exhausted
+	FallbackModel string `gorm:"size:64"                       json:"fallback_model,omitempty"       yaml:"fallback_model,omitempty"`
+	// Hedge is only applied to the non-stream requests
+	Hedge *HedgeConfig `gorm:"serializer:fastjson;type:text" json:"hedge,omitempty"                yaml:"hedge,omitempty"`
+	// Alias makes the model a virtual model name resolved to the target models
+	Alias *ModelAlias `gorm:"serializer:fastjson;type:text" json:"alias,omitempty"                yaml:"alias,omitempty"`
+}
+
+// ChannelStrategy selects the channel of a request among the available channels of the model
+type ChannelStrategy string
+
+const (
+	// ChannelStrategyPriority is the default, a weighted random by the channel priority and error rate
+	ChannelStrategyPriority      ChannelStrategy = "priority"
+	ChannelStrategyCheapest      ChannelStrategy = "cheapest"
+	ChannelStrategyLatency       ChannelStrategy = "latency"
+	ChannelStrategyRoundRobin    ChannelStrategy = "round_robin"
+	ChannelStrategyLeastInFlight ChannelStrategy = "least_in_flight"
+)
+
+func (s ChannelStrategy) IsValid() bool {
+	switch s {
+	case "",
+		ChannelStrategyPriority,
+		ChannelStrategyCheapest,
+		ChannelStrategyLatency,
+		ChannelStrategyRoundRobin,
+		ChannelStrategyLeastInFlight:
+		return true
+	default:
+		return false
+	}
 }
 
 func (c *ModelConfig) BeforeSave(_ *gorm.DB) (err error) {
@@ -57,9 +119,33 @@ func (c *ModelConfig) BeforeSave(_ *gorm.DB) (err error) {
 		return err
 	}
 
+	if !c.ChannelStrategy.IsValid() {
+		return fmt.Errorf("invalid channel strategy: %s", c.ChannelStrategy)
+	}
+
+	if c.FallbackModel == c.Model {
+		return errors.New("fallback model cannot be the model itself")
+	}
+
+	if c.Hedge != nil {
+		if err := c.Hedge.Validate(); err != nil {
+			return err
+		}
+	}
+
+	if c.Alias != nil {
+		if err := c.Alias.Validate(c.Model); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
+func (c *ModelConfig) IsAlias() bool {
+	return c.Alias != nil && len(c.Alias.Targets) != 0
+}
+
 func NewDefaultModelConfig(model string) ModelConfig {
 	return ModelConfig{
 		Model: model,
@@ -133,6 +219,19 @@ func (c *ModelConfig) LoadFromGroupModelConfig(groupModelConfig GroupModelConfig
 		newC.ForceSaveDetail = groupModelConfig.ForceSaveDetail
 	}
 
+	if groupModelConfig.OverrideFallbackModel {
+		newC.FallbackModel = groupModelConfig.FallbackModel
+	}
+
+	if groupModelConfig.OverridePlugin && len(groupModelConfig.Plugin) > 0 {
+		newC.Plugin = maps.Clone(c.Plugin)
+		if newC.Plugin == nil {
+			newC.Plugin = make(map[string]map[string]any, len(groupModelConfig.Plugin))
+		}
+
+		maps.Copy(newC.Plugin, groupModelConfig.Plugin)
+	}
+
 	return newC
 }
 
@@ -125,6 +125,16 @@ func initOptionMap() error {
 		10,
 	)
 	optionMap["FuzzyTokenThreshold"] = strconv.FormatInt(config.GetFuzzyTokenThreshold(), 10)
+	optionMap["BatchDiscount"] = strconv.FormatFloat(config.GetBatchDiscount(), 'f', -1, 64)
+	optionMap["BatchWorkers"] = strconv.FormatInt(config.GetBatchWorkers(), 10)
+	optionMap["BatchConcurrency"] = strconv.FormatInt(config.GetBatchConcurrency(), 10)
+	optionMap["MCPStdioMaxProcesses"] = strconv.FormatInt(config.GetMCPStdioMaxProcesses(), 10)
+	optionMap["MCPStdioMaxGroupProcesses"] = strconv.FormatInt(
+		config.GetMCPStdioMaxGroupProcesses(),
+		10,
+	)
+	optionMap["MCPStdioIdleTimeout"] = strconv.FormatInt(config.GetMCPStdioIdleTimeout(), 10)
+	optionMap["MCPSamplingModel"] = config.GetMCPSamplingModel()
 
 	optionKeys = make([]string, 0, len(optionMap))
 	for key := range optionMap {
@@ -476,6 +486,74 @@ func updateOption(key, value string, isInit bool) (err error) {
 		}
 
 		config.SetFuzzyTokenThreshold(threshold)
+	case "BatchDiscount":
+		discount, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+
+		if discount <= 0 || discount > 1 {
+			return errors.New("batch discount must be in (0, 1]")
+		}
+
+		config.SetBatchDiscount(discount)
+	case "BatchWorkers":
+		workers, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if workers < 0 {
+			return errors.New("batch workers must be greater than or equal to 0")
+		}
+
+		config.SetBatchWorkers(workers)
+	case "BatchConcurrency":
+		concurrency, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if concurrency < 1 {
+			return errors.New("batch concurrency must be greater than 0")
+		}
+
+		config.SetBatchConcurrency(concurrency)
+	case "MCPStdioMaxProcesses":
+		processes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if processes < 0 {
+			return errors.New("mcp stdio max processes must be greater than or equal to 0")
+		}
+
+		config.SetMCPStdioMaxProcesses(processes)
+	case "MCPStdioMaxGroupProcesses":
+		processes, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if processes < 0 {
+			return errors.New("mcp stdio max group processes must be greater than or equal to 0")
+		}
+
+		config.SetMCPStdioMaxGroupProcesses(processes)
+	case "MCPStdioIdleTimeout":
+		timeout, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+
+		if timeout < 0 {
+			return errors.New("mcp stdio idle timeout must be greater than or equal to 0")
+		}
+
+		config.SetMCPStdioIdleTimeout(timeout)
+	case "MCPSamplingModel":
+		config.SetMCPSamplingModel(value)
 	default:
 		return ErrUnknownOptionKey
 	}
@@ -34,6 +34,7 @@ const (
 	PublicMCPTypeDocs            PublicMCPType = "mcp_docs" // read only
 	PublicMCPTypeOpenAPI         PublicMCPType = "mcp_openapi"
 	PublicMCPTypeEmbed           PublicMCPType = "mcp_embed"
+	PublicMCPTypeStdio           PublicMCPType = "mcp_stdio"
 )
 
 type ProxyParamType string
@@ -119,6 +120,18 @@ type MCPEmbeddingConfig struct {
 	Reusing map[string]ReusingParam `json:"reusing"`
 }
 
+// MCPStdioConfig is the command of a stdio mcp server, the command runs on the
+// proxy host, one process is spawned for each group with the reusing params as env
+type MCPStdioConfig struct {
+	Command string            `json:"command"`
+	Args    []string          `json:"args,omitempty"`
+	Env     map[string]string `json:"env,omitempty"`
+	// the reusing params are passed to the process as env
+	Reusing map[string]ReusingParam `json:"reusing,omitempty"`
+	// idle timeout of the process in seconds, 0 uses the global config
+	IdleTimeout int64 `json:"idle_timeout,omitempty"`
+}
+
 var validateMCPIDRegex = regexp.MustCompile(`^[a-zA-Z0-9_-]+$`)
 
 func validateMCPID(id string) error {
@@ -162,6 +175,7 @@ type PublicMCP struct {
 	ProxyConfig   *PublicMCPProxyConfig `gorm:"serializer:fastjson;type:text" json:"proxy_config,omitempty"`
 	OpenAPIConfig *MCPOpenAPIConfig     `gorm:"serializer:fastjson;type:text" json:"openapi_config,omitempty"`
 	EmbedConfig   *MCPEmbeddingConfig   `gorm:"serializer:fastjson;type:text" json:"embed_config,omitempty"`
+	StdioConfig   *MCPStdioConfig       `gorm:"serializer:fastjson;type:text" json:"stdio_config,omitempty"`
 	// only used by list tools
 	TestConfig *TestConfig `gorm:"serializer:fastjson;type:text" json:"test_config,omitempty"`
 }
@@ -197,6 +211,17 @@ func (p *PublicMCP) BeforeSave(_ *gorm.DB) error {
 		return validateHTTPURL(config.URL)
 	}
 
+	if p.StdioConfig != nil {
+		config := p.StdioConfig
+		if config.Command == "" {
+			return errors.New("stdio command is empty")
+		}
+
+		if config.IdleTimeout < 0 {
+			return errors.New("stdio idle timeout is invalid")
+		}
+	}
+
 	return nil
 }
 
@@ -293,6 +318,7 @@ func UpdatePublicMCP(mcp *PublicMCP) (err error) {
 		"proxy_config",
 		"openapi_config",
 		"embed_config",
+		"stdio_config",
 		"test_config",
 	}
 	if mcp.Status != 0 {
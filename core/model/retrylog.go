@@ -25,6 +25,8 @@ type RetryLog struct {
 	Code                  int             `gorm:"index"                                             json:"code,omitempty"`
 	Mode                  int             `                                                         json:"mode,omitempty"`
 	RetryTimes            ZeroNullInt64   `                                                         json:"retry_times,omitempty"`
+	// FallbackModel is the model the request fell back to after this attempt
+	FallbackModel EmptyNullString `gorm:"size:64"                                           json:"fallback_model,omitempty"`
 }
 
 func (r *RetryLog) BeforeSave(_ *gorm.DB) (err error) {
@@ -80,6 +82,7 @@ func RecordRetryLog(
 	modelName string,
 	mode int,
 	retryTimes int,
+	fallbackModel string,
 	requestDetail *RequestDetail,
 ) error {
 	if createAt.IsZero() {
@@ -105,6 +108,7 @@ func RecordRetryLog(
 		Mode:             mode,
 		ChannelID:        channelID,
 		RetryTimes:       ZeroNullInt64(retryTimes),
+		FallbackModel:    EmptyNullString(fallbackModel),
 		RequestBody:      requestDetail.RequestBody,
 		ResponseBody:     requestDetail.ResponseBody,
 	}
@@ -4,12 +4,15 @@ import (
 	"errors"
 	"fmt"
 	"math/rand/v2"
+	"path"
+	"slices"
 	"strings"
 	"time"
 
 	"github.com/labring/aiproxy/core/common"
 	"github.com/labring/aiproxy/core/common/config"
 	"github.com/labring/aiproxy/core/common/conv"
+	"github.com/labring/aiproxy/core/relay/mode"
 	log "github.com/sirupsen/logrus"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
@@ -31,15 +34,18 @@ const (
 )
 
 type Token struct {
-	CreatedAt time.Time       `json:"created_at"`
-	Group     *Group          `json:"-"          gorm:"foreignKey:GroupID"`
-	Key       string          `json:"key"        gorm:"type:char(48);uniqueIndex"`
-	Name      EmptyNullString `json:"name"       gorm:"size:32;index;uniqueIndex:idx_group_name;not null"`
-	GroupID   string          `json:"group"      gorm:"size:64;index;uniqueIndex:idx_group_name"`
-	Subnets   []string        `json:"subnets"    gorm:"serializer:fastjson;type:text"`
-	Models    []string        `json:"models"     gorm:"serializer:fastjson;type:text"`
-	Status    int             `json:"status"     gorm:"default:1;index"`
-	ID        int             `json:"id"         gorm:"primaryKey"`
+	CreatedAt time.Time `json:"created_at"`
+	Group     *Group    `json:"-"             gorm:"foreignKey:GroupID"`
+	// Key is only set when the token is created or rotated, only the hash is stored
+	Key       string          `json:"key,omitempty" gorm:"-"`
+	KeyHash   string          `json:"-"             gorm:"type:char(64);uniqueIndex"`
+	KeyPrefix string          `json:"key_prefix"    gorm:"size:8"`
+	Name      EmptyNullString `json:"name"          gorm:"size:32;index;uniqueIndex:idx_group_name;not null"`
+	GroupID   string          `json:"group"         gorm:"size:64;index;uniqueIndex:idx_group_name"`
+	Subnets   []string        `json:"subnets"       gorm:"serializer:fastjson;type:text"`
+	Models    []string        `json:"models"        gorm:"serializer:fastjson;type:text"`
+	Status    int             `json:"status"        gorm:"default:1;index"`
+	ID        int             `json:"id"            gorm:"primaryKey"`
 
 	UsedAmount   float64 `json:"used_amount"   gorm:"index"`
 	RequestCount int     `json:"request_count" gorm:"index"`
@@ -49,12 +55,38 @@ type Token struct {
 	PeriodType             EmptyNullString `json:"period_type"               gorm:"size:20"` // daily, weekly, monthly, default is monthly
 	PeriodLastUpdateTime   time.Time       `json:"period_last_update_time"`                  // Last time period was reset
 	PeriodLastUpdateAmount float64         `json:"period_last_update_amount"`                // Total usage at last period reset
+
+	// Queue mode, overrides the queue mode of the group when QueueMaxWait is set
+	QueueMaxWait  int64 `json:"queue_max_wait,omitempty"`
+	QueueMaxDepth int64 `json:"queue_max_depth,omitempty"`
+
+	// ModelBudgets limits the amount spent on the models in a period
+	ModelBudgets []TokenModelBudget `json:"model_budgets,omitempty" gorm:"serializer:fastjson;type:text"`
+
+	// ExpiresAt is the time the token is disabled, zero never expires
+	ExpiresAt time.Time `json:"expires_at"`
+	// Modes limits the relay modes the token can call, empty allows all modes
+	Modes []mode.Mode `json:"modes,omitempty"           gorm:"serializer:fastjson;type:text"`
+	// PreviousKeyHash is the key before the last rotation, it still works until PreviousKeyExpiresAt
+	PreviousKeyHash      string    `json:"-"                         gorm:"type:char(64);index"`
+	PreviousKeyExpiresAt time.Time `json:"previous_key_expires_at"`
+	// MCPAllowTools and MCPDenyTools limit the tools the token can call on all mcp endpoints,
+	// the patterns match the prefixed tool names like `mcp_id__tool` and support the *
+	// wildcard, empty allows all tools and the deny list wins
+	MCPAllowTools []string `json:"mcp_allow_tools,omitempty" gorm:"serializer:fastjson;type:text"`
+	MCPDenyTools  []string `json:"mcp_deny_tools,omitempty"  gorm:"serializer:fastjson;type:text"`
+	// MCPToolsRPM limits the calls per minute of the tools matching the patterns
+	MCPToolsRPM map[string]int64 `json:"mcp_tools_rpm,omitempty"   gorm:"serializer:fastjson;type:text"`
 }
 
 func (t *Token) BeforeCreate(_ *gorm.DB) error {
 	if t.Key == "" || len(t.Key) != 48 {
 		t.Key = generateKey()
 	}
+
+	t.KeyHash = HashTokenKey(t.Key)
+	t.KeyPrefix = tokenKeyPrefix(t.Key)
+
 	return nil
 }
 
@@ -62,9 +94,62 @@ func (t *Token) BeforeSave(_ *gorm.DB) error {
 	if len(t.Name) > 32 {
 		return errors.New("token name is too long")
 	}
+
+	if err := validateMCPToolPatterns(t.MCPAllowTools); err != nil {
+		return err
+	}
+
+	if err := validateMCPToolPatterns(t.MCPDenyTools); err != nil {
+		return err
+	}
+
+	if err := validateMCPToolsRPM(t.MCPToolsRPM); err != nil {
+		return err
+	}
+
+	return ValidateTokenModelBudgets(t.ModelBudgets)
+}
+
+func validateMCPToolPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid mcp tool pattern %q: %w", pattern, err)
+		}
+	}
+
+	return nil
+}
+
+func validateMCPToolsRPM(rpm map[string]int64) error {
+	for pattern, limit := range rpm {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid mcp tool pattern %q: %w", pattern, err)
+		}
+
+		if limit <= 0 {
+			return fmt.Errorf("mcp tool rpm of %q must be greater than 0", pattern)
+		}
+	}
+
 	return nil
 }
 
+// MatchMCPTool reports whether the tool name matches one of the patterns
+func MatchMCPTool(patterns []string, tool string) bool {
+	return slices.ContainsFunc(patterns, func(pattern string) bool {
+		ok, _ := path.Match(pattern, tool)
+		return ok
+	})
+}
+
+func allowsMCPTool(allow, deny []string, tool string) bool {
+	if MatchMCPTool(deny, tool) {
+		return false
+	}
+
+	return len(allow) == 0 || MatchMCPTool(allow, tool)
+}
+
 // GetEffectiveQuotaStatus returns the effective quota status for token
 func (t *Token) GetEffectiveQuotaStatus() (totalExceeded, periodExceeded bool, err error) {
 	// Check total quota (if set)
@@ -268,7 +353,7 @@ func SearchTokens(
 	}
 
 	if key != "" {
-		tx = tx.Where("key = ?", key)
+		tx = tx.Where("key_hash = ?", HashTokenKey(key))
 	}
 
 	if keyword != "" {
@@ -299,9 +384,9 @@ func SearchTokens(
 
 		if key == "" {
 			if !common.UsingSQLite {
-				conditions = append(conditions, "key ILIKE ?")
+				conditions = append(conditions, "key_prefix ILIKE ?")
 			} else {
-				conditions = append(conditions, "key LIKE ?")
+				conditions = append(conditions, "key_prefix LIKE ?")
 			}
 
 			values = append(values, "%"+keyword+"%")
@@ -353,7 +438,7 @@ func SearchGroupTokens(
 	}
 
 	if key != "" {
-		tx = tx.Where("key = ?", key)
+		tx = tx.Where("key_hash = ?", HashTokenKey(key))
 	}
 
 	if status != 0 {
@@ -378,9 +463,9 @@ func SearchGroupTokens(
 
 		if key == "" {
 			if !common.UsingSQLite {
-				conditions = append(conditions, "key ILIKE ?")
+				conditions = append(conditions, "key_prefix ILIKE ?")
 			} else {
-				conditions = append(conditions, "key LIKE ?")
+				conditions = append(conditions, "key_prefix LIKE ?")
 			}
 
 			values = append(values, "%"+keyword+"%")
@@ -414,26 +499,49 @@ func SearchGroupTokens(
 	return tokens, total, err
 }
 
-func GetTokenByKey(key string) (*Token, error) {
-	if key == "" {
+func GetTokenByKeyHash(keyHash string) (*Token, error) {
+	if keyHash == "" {
 		return nil, errors.New("key is empty")
 	}
 
 	var token Token
 
-	err := DB.Where("key = ?", key).First(&token).Error
+	err := DB.Where("key_hash = ?", keyHash).First(&token).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		// the previous key of a rotated token works until the grace period ends
+		err = DB.
+			Where("previous_key_hash = ? AND previous_key_expires_at > ?", keyHash, time.Now()).
+			First(&token).Error
+	}
 
 	return &token, HandleNotFound(err, ErrTokenNotFound)
 }
 
 // GetAndValidateToken validates a token and checks quota limits
 // This function is safe for concurrent use and handles period resets atomically
-func GetAndValidateToken(key string) (token *TokenCache, err error) {
+func GetAndValidateToken(key string) (*TokenCache, error) {
 	if key == "" {
 		return nil, errors.New("no token provided")
 	}
 
-	token, err = CacheGetTokenByKey(key)
+	token, err := GetAndValidateTokenByKeyHash(HashTokenKey(key))
+	if err != nil {
+		return nil, err
+	}
+
+	token.Key = key
+
+	return token, nil
+}
+
+// GetAndValidateTokenByKeyHash validates the token by the hash of the key,
+// it is used when the key itself is not known such as the batch worker
+func GetAndValidateTokenByKeyHash(keyHash string) (token *TokenCache, err error) {
+	if keyHash == "" {
+		return nil, errors.New("no token provided")
+	}
+
+	token, err = CacheGetTokenByKeyHash(keyHash)
 	if err != nil {
 		if errors.Is(err, gorm.ErrRecordNotFound) {
 			return nil, errors.New("invalid token")
@@ -448,6 +556,12 @@ func GetAndValidateToken(key string) (token *TokenCache, err error) {
 		return nil, fmt.Errorf("token (%s[%d]) is disabled", token.Name, token.ID)
 	}
 
+	// the status of an expired token is kept, so it is usable again once
+	// the expiration is moved into the future
+	if token.IsExpired() {
+		return nil, fmt.Errorf("token (%s[%d]) has expired", token.Name, token.ID)
+	}
+
 	// Convert TokenCache to Token for quota checking
 	tokenModel := Token{
 		ID:                     token.ID,
@@ -503,7 +617,7 @@ func UpdateTokenStatus(id, status int) (err error) {
 	token := Token{ID: id}
 	defer func() {
 		if err == nil {
-			if err := CacheUpdateTokenStatus(token.Key, status); err != nil {
+			if err := CacheUpdateTokenStatus(token.KeyHash, status); err != nil {
 				log.Error("update token status in cache failed: " + err.Error())
 			}
 		}
@@ -513,7 +627,7 @@ func UpdateTokenStatus(id, status int) (err error) {
 		Model(&token).
 		Clauses(clause.Returning{
 			Columns: []clause.Column{
-				{Name: "key"},
+				{Name: "key_hash"},
 			},
 		}).
 		Where("id = ?", id).
@@ -534,7 +648,7 @@ func UpdateGroupTokenStatus(group string, id, status int) (err error) {
 	token := Token{}
 	defer func() {
 		if err == nil {
-			if err := CacheUpdateTokenStatus(token.Key, status); err != nil {
+			if err := CacheUpdateTokenStatus(token.KeyHash, status); err != nil {
 				log.Error("update token status in cache failed: " + err.Error())
 			}
 		}
@@ -544,7 +658,7 @@ func UpdateGroupTokenStatus(group string, id, status int) (err error) {
 		Model(&token).
 		Clauses(clause.Returning{
 			Columns: []clause.Column{
-				{Name: "key"},
+				{Name: "key_hash"},
 			},
 		}).
 		Where("id = ? and group_id = ?", id, group).
@@ -565,7 +679,7 @@ func DeleteGroupTokenByID(groupID string, id int) (err error) {
 	token := Token{ID: id, GroupID: groupID}
 	defer func() {
 		if err == nil {
-			if err := CacheDeleteToken(token.Key); err != nil {
+			if err := CacheDeleteToken(token.KeyHash); err != nil {
 				log.Error("delete token from cache failed: " + err.Error())
 			}
 		}
@@ -574,7 +688,7 @@ func DeleteGroupTokenByID(groupID string, id int) (err error) {
 	result := DB.
 		Clauses(clause.Returning{
 			Columns: []clause.Column{
-				{Name: "key"},
+				{Name: "key_hash"},
 			},
 		}).
 		Where(token).
@@ -596,7 +710,7 @@ func DeleteGroupTokensByIDs(group string, ids []int) (err error) {
 	defer func() {
 		if err == nil {
 			for _, token := range tokens {
-				if err := CacheDeleteToken(token.Key); err != nil {
+				if err := CacheDeleteToken(token.KeyHash); err != nil {
 					log.Error("delete token from cache failed: " + err.Error())
 				}
 			}
@@ -607,7 +721,7 @@ func DeleteGroupTokensByIDs(group string, ids []int) (err error) {
 		return tx.
 			Clauses(clause.Returning{
 				Columns: []clause.Column{
-					{Name: "key"},
+					{Name: "key_hash"},
 				},
 			}).
 			Where("group_id = ?", group).
@@ -625,7 +739,7 @@ func DeleteTokenByID(id int) (err error) {
 	token := Token{ID: id}
 	defer func() {
 		if err == nil {
-			if err := CacheDeleteToken(token.Key); err != nil {
+			if err := CacheDeleteToken(token.KeyHash); err != nil {
 				log.Error("delete token from cache failed: " + err.Error())
 			}
 		}
@@ -634,7 +748,7 @@ func DeleteTokenByID(id int) (err error) {
 	result := DB.
 		Clauses(clause.Returning{
 			Columns: []clause.Column{
-				{Name: "key"},
+				{Name: "key_hash"},
 			},
 		}).
 		Where(token).
@@ -652,7 +766,7 @@ func DeleteTokensByIDs(ids []int) (err error) {
 	defer func() {
 		if err == nil {
 			for _, token := range tokens {
-				if err := CacheDeleteToken(token.Key); err != nil {
+				if err := CacheDeleteToken(token.KeyHash); err != nil {
 					log.Error("delete token from cache failed: " + err.Error())
 				}
 			}
@@ -663,7 +777,7 @@ func DeleteTokensByIDs(ids []int) (err error) {
 		return tx.
 			Clauses(clause.Returning{
 				Columns: []clause.Column{
-					{Name: "key"},
+					{Name: "key_hash"},
 				},
 			}).
 			Where("id IN (?)", ids).
@@ -678,10 +792,20 @@ type UpdateTokenRequest struct {
 	Models  *[]string `json:"models"`
 	Status  int       `json:"status"`
 	// Quota system
-	Quota                *float64 `json:"quota"`
-	PeriodQuota          *float64 `json:"period_quota"`
-	PeriodType           *string  `json:"period_type"`
-	PeriodLastUpdateTime *int64   `json:"period_last_update_time"`
+	Quota                *float64            `json:"quota"`
+	PeriodQuota          *float64            `json:"period_quota"`
+	PeriodType           *string             `json:"period_type"`
+	PeriodLastUpdateTime *int64              `json:"period_last_update_time"`
+	QueueMaxWait         *int64              `json:"queue_max_wait"`
+	QueueMaxDepth        *int64              `json:"queue_max_depth"`
+	ModelBudgets         *[]TokenModelBudget `json:"model_budgets"`
+	// ExpiresAt is the expiry in milliseconds, 0 never expires
+	ExpiresAt *int64       `json:"expires_at"`
+	Modes     *[]mode.Mode `json:"modes"`
+
+	MCPAllowTools *[]string         `json:"mcp_allow_tools"`
+	MCPDenyTools  *[]string         `json:"mcp_deny_tools"`
+	MCPToolsRPM   *map[string]int64 `json:"mcp_tools_rpm"`
 }
 
 func UpdateToken(id int, update UpdateTokenRequest) (token *Token, err error) {
@@ -696,7 +820,7 @@ func UpdateToken(id int, update UpdateTokenRequest) (token *Token, err error) {
 
 	defer func() {
 		if err == nil {
-			if err := CacheDeleteToken(token.Key); err != nil {
+			if err := CacheDeleteToken(token.KeyHash); err != nil {
 				log.Error("delete token from cache failed: " + err.Error())
 			}
 		}
@@ -739,6 +863,56 @@ func UpdateToken(id int, update UpdateTokenRequest) (token *Token, err error) {
 		selects = append(selects, "subnets")
 	}
 
+	if update.QueueMaxWait != nil {
+		token.QueueMaxWait = *update.QueueMaxWait
+
+		selects = append(selects, "queue_max_wait")
+	}
+
+	if update.QueueMaxDepth != nil {
+		token.QueueMaxDepth = *update.QueueMaxDepth
+
+		selects = append(selects, "queue_max_depth")
+	}
+
+	if update.ModelBudgets != nil {
+		token.ModelBudgets = *update.ModelBudgets
+
+		selects = append(selects, "model_budgets")
+	}
+
+	if update.ExpiresAt != nil {
+		if *update.ExpiresAt > 0 {
+			token.ExpiresAt = time.UnixMilli(*update.ExpiresAt)
+		}
+
+		selects = append(selects, "expires_at")
+	}
+
+	if update.Modes != nil {
+		token.Modes = *update.Modes
+
+		selects = append(selects, "modes")
+	}
+
+	if update.MCPAllowTools != nil {
+		token.MCPAllowTools = *update.MCPAllowTools
+
+		selects = append(selects, "mcp_allow_tools")
+	}
+
+	if update.MCPDenyTools != nil {
+		token.MCPDenyTools = *update.MCPDenyTools
+
+		selects = append(selects, "mcp_deny_tools")
+	}
+
+	if update.MCPToolsRPM != nil {
+		token.MCPToolsRPM = *update.MCPToolsRPM
+
+		selects = append(selects, "mcp_tools_rpm")
+	}
+
 	if update.Models != nil {
 		token.Models = *update.Models
 
@@ -784,7 +958,7 @@ func UpdateGroupToken(
 
 	defer func() {
 		if err == nil {
-			if err := CacheDeleteToken(token.Key); err != nil {
+			if err := CacheDeleteToken(token.KeyHash); err != nil {
 				log.Error("delete token from cache failed: " + err.Error())
 			}
 		}
@@ -827,6 +1001,56 @@ func UpdateGroupToken(
 		selects = append(selects, "subnets")
 	}
 
+	if update.QueueMaxWait != nil {
+		token.QueueMaxWait = *update.QueueMaxWait
+
+		selects = append(selects, "queue_max_wait")
+	}
+
+	if update.QueueMaxDepth != nil {
+		token.QueueMaxDepth = *update.QueueMaxDepth
+
+		selects = append(selects, "queue_max_depth")
+	}
+
+	if update.ModelBudgets != nil {
+		token.ModelBudgets = *update.ModelBudgets
+
+		selects = append(selects, "model_budgets")
+	}
+
+	if update.ExpiresAt != nil {
+		if *update.ExpiresAt > 0 {
+			token.ExpiresAt = time.UnixMilli(*update.ExpiresAt)
+		}
+
+		selects = append(selects, "expires_at")
+	}
+
+	if update.Modes != nil {
+		token.Modes = *update.Modes
+
+		selects = append(selects, "modes")
+	}
+
+	if update.MCPAllowTools != nil {
+		token.MCPAllowTools = *update.MCPAllowTools
+
+		selects = append(selects, "mcp_allow_tools")
+	}
+
+	if update.MCPDenyTools != nil {
+		token.MCPDenyTools = *update.MCPDenyTools
+
+		selects = append(selects, "mcp_deny_tools")
+	}
+
+	if update.MCPToolsRPM != nil {
+		token.MCPToolsRPM = *update.MCPToolsRPM
+
+		selects = append(selects, "mcp_tools_rpm")
+	}
+
 	if update.Models != nil {
 		token.Models = *update.Models
 
@@ -860,7 +1084,7 @@ func UpdateTokenUsedAmount(id int, amount float64, requestCount int) (err error)
 	defer func() {
 		if amount > 0 && err == nil && (token.Quota > 0 || token.PeriodQuota > 0) {
 			if err := CacheUpdateTokenUsedAmountOnlyIncrease(
-				token.Key,
+				token.KeyHash,
 				token.UsedAmount,
 			); err != nil {
 				log.Error("update token used amount in cache failed: " + err.Error())
@@ -872,7 +1096,7 @@ func UpdateTokenUsedAmount(id int, amount float64, requestCount int) (err error)
 		Model(token).
 		Clauses(clause.Returning{
 			Columns: []clause.Column{
-				{Name: "key"},
+				{Name: "key_hash"},
 				{Name: "quota"},
 				{Name: "used_amount"},
 				{Name: "period_quota"},
@@ -1005,7 +1229,7 @@ func ResetTokenPeriodUsage(id int) error {
 			Model(token).
 			Clauses(clause.Returning{
 				Columns: []clause.Column{
-					{Name: "key"},
+					{Name: "key_hash"},
 				},
 			}).
 			Where("id = ?", id).
@@ -1022,9 +1246,9 @@ func ResetTokenPeriodUsage(id int) error {
 	})
 
 	// Update cache only if database update succeeded
-	if err == nil && token.Key != "" && !newPeriodStartTime.IsZero() {
+	if err == nil && token.KeyHash != "" && !newPeriodStartTime.IsZero() {
 		if cacheErr := CacheResetTokenPeriodUsage(
-			token.Key,
+			token.KeyHash,
 			newPeriodStartTime,
 			token.UsedAmount,
 		); cacheErr != nil {
@@ -1039,7 +1263,7 @@ func UpdateTokenName(id int, name string) (err error) {
 	token := &Token{ID: id}
 	defer func() {
 		if err == nil {
-			if err := CacheUpdateTokenName(token.Key, name); err != nil {
+			if err := CacheUpdateTokenName(token.KeyHash, name); err != nil {
 				log.Error("update token name in cache failed: " + err.Error())
 			}
 		}
@@ -1049,7 +1273,7 @@ func UpdateTokenName(id int, name string) (err error) {
 		Model(token).
 		Clauses(clause.Returning{
 			Columns: []clause.Column{
-				{Name: "key"},
+				{Name: "key_hash"},
 			},
 		}).
 		Where("id = ?", id).
@@ -1065,7 +1289,7 @@ func UpdateGroupTokenName(group string, id int, name string) (err error) {
 	token := &Token{ID: id, GroupID: group}
 	defer func() {
 		if err == nil {
-			if err := CacheUpdateTokenName(token.Key, name); err != nil {
+			if err := CacheUpdateTokenName(token.KeyHash, name); err != nil {
 				log.Error("update token name in cache failed: " + err.Error())
 			}
 		}
@@ -1075,7 +1299,7 @@ func UpdateGroupTokenName(group string, id int, name string) (err error) {
 		Model(token).
 		Clauses(clause.Returning{
 			Columns: []clause.Column{
-				{Name: "key"},
+				{Name: "key_hash"},
 			},
 		}).
 		Where("id = ? and group_id = ?", id, group).
@@ -1086,3 +1310,63 @@ func UpdateGroupTokenName(group string, id int, name string) (err error) {
 
 	return HandleUpdateResult(result, ErrTokenNotFound)
 }
+
+// RotateToken issues a new key for the token, the previous key still works
+// during the grace period, zero grace revokes the previous key at once
+func RotateToken(id int, grace time.Duration) (*Token, error) {
+	return rotateToken("", id, grace)
+}
+
+func RotateGroupToken(group string, id int, grace time.Duration) (*Token, error) {
+	if group == "" {
+		return nil, errors.New("group is empty")
+	}
+	return rotateToken(group, id, grace)
+}
+
+func rotateToken(group string, id int, grace time.Duration) (token *Token, err error) {
+	if id == 0 {
+		return nil, errors.New("id is empty")
+	}
+
+	token = &Token{}
+
+	var oldKeyHash string
+	defer func() {
+		if err == nil {
+			if err := CacheDeleteToken(oldKeyHash); err != nil {
+				log.Error("delete token from cache failed: " + err.Error())
+			}
+		}
+	}()
+
+	err = DB.Transaction(func(tx *gorm.DB) error {
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Where("id = ?", id)
+		if group != "" {
+			query = query.Where("group_id = ?", group)
+		}
+
+		if err := query.First(token).Error; err != nil {
+			return err
+		}
+
+		oldKeyHash = token.KeyHash
+		token.Key = generateKey()
+		token.KeyHash = HashTokenKey(token.Key)
+		token.KeyPrefix = tokenKeyPrefix(token.Key)
+		token.PreviousKeyHash = ""
+		token.PreviousKeyExpiresAt = time.Time{}
+
+		if grace > 0 {
+			token.PreviousKeyHash = oldKeyHash
+			token.PreviousKeyExpiresAt = time.Now().Add(grace)
+		}
+
+		return tx.
+			Model(token).
+			Select("key_hash", "key_prefix", "previous_key_hash", "previous_key_expires_at").
+			Updates(token).Error
+	})
+
+	return token, HandleNotFound(err, ErrTokenNotFound)
+}
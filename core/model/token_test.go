@@ -0,0 +1,101 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestTokenAllowsMCPTool(t *testing.T) {
+	tests := []struct {
+		name  string
+		token model.TokenCache
+		tool  string
+		want  bool
+	}{
+		{
+			name: "no lists",
+			tool: "github__create_issue",
+			want: true,
+		},
+		{
+			name:  "allowed by wildcard",
+			token: model.TokenCache{MCPAllowTools: []string{"github__*"}},
+			tool:  "github__create_issue",
+			want:  true,
+		},
+		{
+			name:  "not in allow list",
+			token: model.TokenCache{MCPAllowTools: []string{"github__*"}},
+			tool:  "slack__post_message",
+			want:  false,
+		},
+		{
+			name:  "denied",
+			token: model.TokenCache{MCPDenyTools: []string{"*__delete_*"}},
+			tool:  "github__delete_repo",
+			want:  false,
+		},
+		{
+			name: "deny wins over allow",
+			token: model.TokenCache{
+				MCPAllowTools: []string{"github__*"},
+				MCPDenyTools:  []string{"github__delete_repo"},
+			},
+			tool: "github__delete_repo",
+			want: false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.token.AllowsMCPTool(tt.tool))
+		})
+	}
+}
+
+func TestValidateMCPToolsRPM(t *testing.T) {
+	token := model.Token{MCPToolsRPM: map[string]int64{"github__*": 10}}
+	assert.NoError(t, token.BeforeSave(nil))
+
+	token.MCPToolsRPM = map[string]int64{"github__[": 10}
+	assert.Error(t, token.BeforeSave(nil))
+
+	group := model.Group{MCPToolsRPM: map[string]int64{"github__*": 0}}
+	assert.Error(t, group.BeforeSave(nil))
+
+	group.MCPToolsRPM = nil
+	group.MCPDenyTools = []string{"[", "*"}
+	assert.Error(t, group.BeforeSave(nil))
+}
+
+func TestValidateExpiredToken(t *testing.T) {
+	useTestDB(t, &model.Group{}, &model.Token{})
+
+	token := &model.Token{
+		GroupID:   "g1",
+		Name:      "t1",
+		Status:    model.TokenStatusEnabled,
+		ExpiresAt: time.Now().Add(-time.Hour),
+	}
+	require.NoError(t, model.InsertToken(token, true, false))
+
+	_, err := model.GetAndValidateToken(token.Key)
+	require.ErrorContains(t, err, "expired")
+
+	// the expired token is not disabled
+	got, err := model.GetTokenByKeyHash(token.KeyHash)
+	require.NoError(t, err)
+	assert.Equal(t, model.TokenStatusEnabled, got.Status)
+
+	// and is usable again once the expiration is moved into the future
+	expiresAt := time.Now().Add(time.Hour).UnixMilli()
+	_, err = model.UpdateToken(token.ID, model.UpdateTokenRequest{ExpiresAt: &expiresAt})
+	require.NoError(t, err)
+
+	_, err = model.GetAndValidateToken(token.Key)
+	require.NoError(t, err)
+}
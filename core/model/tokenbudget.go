@@ -0,0 +1,148 @@
+package model
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	PeriodTypeHourly  = "hourly"
+	PeriodTypeRolling = "rolling"
+)
+
+// TokenModelBudget limits the amount spent by a token on a model in a period,
+// the models without a budget are only limited by the quota of the token
+type TokenModelBudget struct {
+	Model  string  `json:"model"`
+	Amount float64 `json:"amount"`
+	// PeriodType is hourly, daily, weekly, monthly or rolling, default is daily,
+	// the calendar periods start at the beginning of the hour, day, week (monday) or month
+	PeriodType string `json:"period_type,omitempty"`
+	// RollingWindow is the window of the rolling period in minutes
+	RollingWindow int64 `json:"rolling_window,omitempty"`
+}
+
+func (b *TokenModelBudget) Validate() error {
+	if b.Model == "" {
+		return errors.New("budget model is required")
+	}
+
+	if b.Amount <= 0 {
+		return fmt.Errorf("invalid budget amount of model %s", b.Model)
+	}
+
+	switch b.PeriodType {
+	case "", PeriodTypeHourly, PeriodTypeDaily, PeriodTypeWeekly, PeriodTypeMonthly:
+	case PeriodTypeRolling:
+		if b.RollingWindow <= 0 {
+			return fmt.Errorf("budget rolling window of model %s is required", b.Model)
+		}
+	default:
+		return fmt.Errorf("invalid budget period type of model %s: %s", b.Model, b.PeriodType)
+	}
+
+	return nil
+}
+
+func (b *TokenModelBudget) GetPeriodType() string {
+	if b.PeriodType == "" {
+		return PeriodTypeDaily
+	}
+	return b.PeriodType
+}
+
+// Period returns the start of the current period and the time the budget resets,
+// the rolling period never resets at once so the reset is the end of the window
+func (b *TokenModelBudget) Period(now time.Time) (start, reset time.Time) {
+	year, month, day := now.Date()
+	today := time.Date(year, month, day, 0, 0, 0, 0, now.Location())
+
+	switch b.GetPeriodType() {
+	case PeriodTypeHourly:
+		start = now.Truncate(time.Hour)
+		return start, start.Add(time.Hour)
+	case PeriodTypeWeekly:
+		weekday := (int(today.Weekday()) + 6) % 7
+		start = today.AddDate(0, 0, -weekday)
+
+		return start, start.AddDate(0, 0, 7)
+	case PeriodTypeMonthly:
+		start = time.Date(year, month, 1, 0, 0, 0, 0, now.Location())
+		return start, start.AddDate(0, 1, 0)
+	case PeriodTypeRolling:
+		window := time.Duration(b.RollingWindow) * time.Minute
+		return now.Add(-window), now.Add(window)
+	default:
+		return today, today.AddDate(0, 0, 1)
+	}
+}
+
+func ValidateTokenModelBudgets(budgets []TokenModelBudget) error {
+	type budgetPeriod struct {
+		model  string
+		period string
+		window int64
+	}
+
+	seen := make(map[budgetPeriod]struct{}, len(budgets))
+	for _, budget := range budgets {
+		if err := budget.Validate(); err != nil {
+			return err
+		}
+
+		period := budgetPeriod{
+			model:  budget.Model,
+			period: budget.GetPeriodType(),
+			window: budget.RollingWindow,
+		}
+		if _, ok := seen[period]; ok {
+			return fmt.Errorf(
+				"duplicate %s budget of model %s",
+				budget.GetPeriodType(),
+				budget.Model,
+			)
+		}
+
+		seen[period] = struct{}{}
+	}
+
+	return nil
+}
+
+// GetTokenModelUsedAmount returns the amount spent by the token on the model since start,
+// the amount comes from the summaries, so the requests of the last few seconds are not counted
+func GetTokenModelUsedAmount(group, tokenName, model string, start time.Time) (float64, error) {
+	var usedAmount float64
+
+	// the summaries of the hours are used when the start is an exact hour,
+	// otherwise the minutes, the minute in progress is counted as a whole
+	var err error
+	if start.Unix()%hourTimestampDivisor == 0 {
+		err = LogDB.
+			Model(&GroupSummary{}).
+			Select("COALESCE(SUM(used_amount), 0)").
+			Where(
+				"group_id = ? AND token_name = ? AND model = ? AND hour_timestamp >= ?",
+				group,
+				tokenName,
+				model,
+				start.Unix(),
+			).
+			Scan(&usedAmount).Error
+	} else {
+		err = LogDB.
+			Model(&GroupSummaryMinute{}).
+			Select("COALESCE(SUM(used_amount), 0)").
+			Where(
+				"group_id = ? AND token_name = ? AND model = ? AND minute_timestamp >= ?",
+				group,
+				tokenName,
+				model,
+				start.Truncate(time.Minute).Unix(),
+			).
+			Scan(&usedAmount).Error
+	}
+
+	return usedAmount, err
+}
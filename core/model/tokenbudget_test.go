@@ -0,0 +1,115 @@
+package model_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/model"
+)
+
+func TestTokenModelBudgetPeriod(t *testing.T) {
+	// wednesday
+	now := time.Date(2025, time.January, 15, 13, 45, 30, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		budget    model.TokenModelBudget
+		wantStart time.Time
+		wantReset time.Time
+	}{
+		{
+			name:      "default daily",
+			budget:    model.TokenModelBudget{},
+			wantStart: time.Date(2025, time.January, 15, 0, 0, 0, 0, time.UTC),
+			wantReset: time.Date(2025, time.January, 16, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "hourly",
+			budget:    model.TokenModelBudget{PeriodType: model.PeriodTypeHourly},
+			wantStart: time.Date(2025, time.January, 15, 13, 0, 0, 0, time.UTC),
+			wantReset: time.Date(2025, time.January, 15, 14, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "weekly",
+			budget:    model.TokenModelBudget{PeriodType: model.PeriodTypeWeekly},
+			wantStart: time.Date(2025, time.January, 13, 0, 0, 0, 0, time.UTC),
+			wantReset: time.Date(2025, time.January, 20, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name:      "monthly",
+			budget:    model.TokenModelBudget{PeriodType: model.PeriodTypeMonthly},
+			wantStart: time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC),
+			wantReset: time.Date(2025, time.February, 1, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "rolling",
+			budget: model.TokenModelBudget{
+				PeriodType:    model.PeriodTypeRolling,
+				RollingWindow: 90,
+			},
+			wantStart: now.Add(-90 * time.Minute),
+			wantReset: now.Add(90 * time.Minute),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			start, reset := tt.budget.Period(now)
+			if !start.Equal(tt.wantStart) || !reset.Equal(tt.wantReset) {
+				t.Fatalf(
+					"Period() = %v, %v, want %v, %v",
+					start,
+					reset,
+					tt.wantStart,
+					tt.wantReset,
+				)
+			}
+		})
+	}
+}
+
+func TestValidateTokenModelBudgets(t *testing.T) {
+	tests := []struct {
+		name    string
+		budgets []model.TokenModelBudget
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			budgets: []model.TokenModelBudget{
+				{Model: "o1", Amount: 20},
+				{Model: "o1", Amount: 100, PeriodType: model.PeriodTypeMonthly},
+				{Model: "o1", Amount: 5, PeriodType: model.PeriodTypeRolling, RollingWindow: 30},
+			},
+		},
+		{
+			name:    "no amount",
+			budgets: []model.TokenModelBudget{{Model: "o1"}},
+			wantErr: true,
+		},
+		{
+			name: "rolling without window",
+			budgets: []model.TokenModelBudget{
+				{Model: "o1", Amount: 5, PeriodType: model.PeriodTypeRolling},
+			},
+			wantErr: true,
+		},
+		{
+			name: "duplicate period",
+			budgets: []model.TokenModelBudget{
+				{Model: "o1", Amount: 20},
+				{Model: "o1", Amount: 30, PeriodType: model.PeriodTypeDaily},
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := model.ValidateTokenModelBudgets(tt.budgets)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ValidateTokenModelBudgets() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
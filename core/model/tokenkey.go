@@ -0,0 +1,209 @@
+package model
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+
+	"github.com/labring/aiproxy/core/common/config"
+	log "github.com/sirupsen/logrus"
+	"gorm.io/gorm"
+)
+
+const (
+	// tokenKeySaltOptionKey is not in the option keys, so it is never returned by the option api
+	tokenKeySaltOptionKey = "TokenKeySalt"
+	tokenKeyPrefixLength  = 8
+)
+
+var tokenKeySalt []byte
+
+// initTokenKeySalt loads the salt of the token key hashes, the salt in the
+// database is generated by the first instance and shared by the others
+func initTokenKeySalt() error {
+	if config.TokenKeySalt != "" {
+		tokenKeySalt = []byte(config.TokenKeySalt)
+		return nil
+	}
+
+	salt := make([]byte, 32)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+
+	err := OnConflictDoNothing().Create(&Option{
+		Key:   tokenKeySaltOptionKey,
+		Value: hex.EncodeToString(salt),
+	}).Error
+	if err != nil {
+		return fmt.Errorf("failed to create token key salt: %w", err)
+	}
+
+	var option Option
+	if err := DB.Where("key = ?", tokenKeySaltOptionKey).First(&option).Error; err != nil {
+		return fmt.Errorf("failed to get token key salt: %w", err)
+	}
+
+	if option.Value == "" {
+		return errors.New("token key salt is empty")
+	}
+
+	tokenKeySalt = []byte(option.Value)
+
+	return nil
+}
+
+// HashTokenKey returns the salted hash of the token key, the tokens are
+// stored and cached by the hash, the key itself is only shown once
+func HashTokenKey(key string) string {
+	h := hmac.New(sha256.New, tokenKeySalt)
+	_, _ = h.Write([]byte(key))
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func tokenKeyPrefix(key string) string {
+	if len(key) <= tokenKeyPrefixLength {
+		return key
+	}
+	return key[:tokenKeyPrefixLength]
+}
+
+// tokenColumns returns the columns of the tokens table
+func tokenColumns() (map[string]struct{}, error) {
+	columnTypes, err := DB.Migrator().ColumnTypes(&Token{})
+	if err != nil {
+		return nil, err
+	}
+
+	// HasColumn of sqlite matches the sql of the table, so `key` matches `PRIMARY KEY`
+	columns := make(map[string]struct{}, len(columnTypes))
+	for _, columnType := range columnTypes {
+		columns[columnType.Name()] = struct{}{}
+	}
+
+	return columns, nil
+}
+
+// hashTokenKeys hashes the plaintext keys of the tokens created before the
+// keys were hashed, it also runs when the auto migration is disabled, since
+// the hashes can only be computed with the salt of the options
+func hashTokenKeys(columns map[string]struct{}) error {
+	if _, ok := columns["key"]; !ok {
+		return nil
+	}
+
+	if _, ok := columns["key_hash"]; !ok {
+		return errors.New(
+			"the tokens have plaintext keys but no key_hash column, " +
+				"add the key_hash, previous_key_hash and key_prefix columns of the tokens",
+		)
+	}
+
+	type plaintextKeys struct {
+		ID          int
+		Key         string
+		PreviousKey string
+	}
+
+	var tokens []plaintextKeys
+
+	selects := []string{"id", "key"}
+	if _, ok := columns["previous_key"]; ok {
+		selects = append(selects, "previous_key")
+	}
+
+	err := DB.
+		Model(&Token{}).
+		Select(selects).
+		Where("key_hash IS NULL OR key_hash = ''").
+		Find(&tokens).Error
+	if err != nil {
+		return err
+	}
+
+	if len(tokens) == 0 {
+		return nil
+	}
+
+	log.Infof("hashing the keys of %d tokens", len(tokens))
+
+	return DB.Transaction(func(tx *gorm.DB) error {
+		for _, token := range tokens {
+			updates := map[string]any{
+				"key_hash":   HashTokenKey(token.Key),
+				"key_prefix": tokenKeyPrefix(token.Key),
+			}
+			if token.PreviousKey != "" {
+				updates["previous_key_hash"] = HashTokenKey(token.PreviousKey)
+			}
+
+			err := tx.
+				Model(&Token{}).
+				Where("id = ?", token.ID).
+				Updates(updates).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// initTokenKeys loads the salt and hashes the plaintext keys without
+// changing the schema, it is used when the auto migration is disabled
+func initTokenKeys() error {
+	if err := initTokenKeySalt(); err != nil {
+		return err
+	}
+
+	columns, err := tokenColumns()
+	if err != nil {
+		return err
+	}
+
+	return hashTokenKeys(columns)
+}
+
+// migrateTokenKeys hashes the plaintext keys of the tokens created before
+// the keys were hashed, then drops the plaintext key columns
+func migrateTokenKeys() error {
+	columns, err := tokenColumns()
+	if err != nil {
+		return err
+	}
+
+	if err := hashTokenKeys(columns); err != nil {
+		return err
+	}
+
+	if _, ok := columns["key"]; !ok {
+		return nil
+	}
+
+	migrator := DB.Migrator()
+
+	for _, column := range []string{"key", "previous_key"} {
+		if _, ok := columns[column]; !ok {
+			continue
+		}
+
+		index := "idx_tokens_" + column
+		if migrator.HasIndex(&Token{}, index) {
+			if err := migrator.DropIndex(&Token{}, index); err != nil {
+				return err
+			}
+		}
+
+		if err := migrator.DropColumn(&Token{}, column); err != nil {
+			return err
+		}
+	}
+
+	// dropping a column recreates the table on sqlite without the indexes
+	return DB.AutoMigrate(&Token{})
+}
@@ -0,0 +1,145 @@
+package model_test
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+)
+
+// legacyToken is the token table before the keys were hashed
+type legacyToken struct {
+	ID                   int    `gorm:"primaryKey"`
+	Key                  string `gorm:"type:char(48);uniqueIndex"`
+	PreviousKey          string `gorm:"type:char(48);index"`
+	PreviousKeyExpiresAt time.Time
+	Name                 string `gorm:"size:32"`
+	GroupID              string `gorm:"size:64"`
+}
+
+func (legacyToken) TableName() string {
+	return "tokens"
+}
+
+func TestMigrateTokenKeys(t *testing.T) {
+	db := useTestDB(t, &legacyToken{})
+
+	key := "legacy" + strings.Repeat("0", 42)
+	previousKey := "previous" + strings.Repeat("0", 40)
+
+	require.NoError(t, db.Create(&legacyToken{
+		ID:                   1,
+		Key:                  key,
+		PreviousKey:          previousKey,
+		PreviousKeyExpiresAt: time.Now().Add(time.Hour),
+		Name:                 "legacy",
+		GroupID:              "g1",
+	}).Error)
+
+	require.NoError(t, db.AutoMigrate(&model.Token{}))
+	require.NoError(t, model.MigrateTokenKeys())
+
+	// the plaintext key columns are dropped
+	columnTypes, err := db.Migrator().ColumnTypes(&model.Token{})
+	require.NoError(t, err)
+
+	for _, columnType := range columnTypes {
+		assert.NotContains(t, []string{"key", "previous_key"}, columnType.Name())
+	}
+
+	token, err := model.GetTokenByKeyHash(model.HashTokenKey(key))
+	require.NoError(t, err)
+	assert.Equal(t, 1, token.ID)
+	assert.Equal(t, key[:8], token.KeyPrefix)
+
+	// the previous key still works until the grace period ends
+	token, err = model.GetTokenByKeyHash(model.HashTokenKey(previousKey))
+	require.NoError(t, err)
+	assert.Equal(t, 1, token.ID)
+
+	// the migration is done once
+	require.NoError(t, model.MigrateTokenKeys())
+}
+
+// useLegacyDB creates the sqlite database of InitDB with a legacy token, the
+// tables are changed by migrate before InitDB runs without the auto migration
+func useLegacyDB(t *testing.T, key string, migrate func(db *gorm.DB) error) {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "aiproxy.db")
+
+	db, err := model.OpenSQLite(path)
+	require.NoError(t, err)
+	require.NoError(t, db.AutoMigrate(&legacyToken{}, &model.Option{}))
+	require.NoError(t, db.Create(&legacyToken{ID: 1, Key: key, Name: "legacy", GroupID: "g1"}).Error)
+	require.NoError(t, migrate(db))
+
+	sqlDB, err := db.DB()
+	require.NoError(t, err)
+	require.NoError(t, sqlDB.Close())
+
+	t.Setenv("SQL_DSN", "")
+
+	oldPath, oldUsingSQLite := common.SQLitePath, common.UsingSQLite
+	oldDisable := config.DisableAutoMigrateDB
+	oldDB := model.DB
+
+	common.SQLitePath = path
+	config.DisableAutoMigrateDB = true
+
+	t.Cleanup(func() {
+		if model.DB != oldDB {
+			if sqlDB, err := model.DB.DB(); err == nil {
+				_ = sqlDB.Close()
+			}
+		}
+
+		common.SQLitePath, common.UsingSQLite = oldPath, oldUsingSQLite
+		config.DisableAutoMigrateDB = oldDisable
+		model.DB = oldDB
+	})
+}
+
+func TestInitDBWithoutAutoMigrate(t *testing.T) {
+	key := "legacy" + strings.Repeat("1", 42)
+
+	// the operator has added the columns of the hashed keys
+	useLegacyDB(t, key, func(db *gorm.DB) error {
+		return db.AutoMigrate(&model.Token{})
+	})
+
+	require.NoError(t, model.InitDB())
+
+	token, err := model.GetTokenByKeyHash(model.HashTokenKey(key))
+	require.NoError(t, err)
+	assert.Equal(t, 1, token.ID)
+
+	// the plaintext key columns are kept
+	columnTypes, err := model.DB.Migrator().ColumnTypes(&model.Token{})
+	require.NoError(t, err)
+
+	columns := make([]string, 0, len(columnTypes))
+	for _, columnType := range columnTypes {
+		columns = append(columns, columnType.Name())
+	}
+
+	assert.Contains(t, columns, "key")
+	assert.Contains(t, columns, "previous_key")
+}
+
+func TestInitDBWithoutAutoMigrateNoKeyHash(t *testing.T) {
+	useLegacyDB(t, "legacy"+strings.Repeat("2", 42), func(*gorm.DB) error {
+		return nil
+	})
+
+	err := model.InitDB()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "key_hash")
+}
@@ -4,6 +4,8 @@ import (
 	"fmt"
 	"math"
 	"time"
+
+	"github.com/shopspring/decimal"
 )
 
 type PriceCondition struct {
@@ -53,6 +55,10 @@ type Price struct {
 	WebSearchPriceUnit ZeroNullInt64   `json:"web_search_price_unit,omitempty"`
 
 	ConditionalPrices []ConditionalPrice `gorm:"serializer:fastjson;type:text" json:"conditional_prices,omitempty"`
+
+	// BatchDiscount is the ratio of the price charged for requests of a batch,
+	// e.g. 0.5 means half price, 0 means the BatchDiscount option is used
+	BatchDiscount ZeroNullFloat64 `json:"batch_discount,omitempty"`
 }
 
 func (p *Price) ValidateConditionalPrices() error {
@@ -288,6 +294,52 @@ func (p *Price) SelectConditionalPrice(usage Usage) Price {
 	return *p
 }
 
+// WithBatchDiscount returns the price charged for a request of a batch
+func (p Price) WithBatchDiscount(defaultDiscount float64) Price {
+	discount := float64(p.BatchDiscount)
+	if discount <= 0 {
+		discount = defaultDiscount
+	}
+
+	if discount <= 0 || discount >= 1 {
+		return p
+	}
+
+	scale := func(price ZeroNullFloat64) ZeroNullFloat64 {
+		return ZeroNullFloat64(
+			decimal.NewFromFloat(float64(price)).
+				Mul(decimal.NewFromFloat(discount)).
+				InexactFloat64(),
+		)
+	}
+
+	p.PerRequestPrice = scale(p.PerRequestPrice)
+	p.InputPrice = scale(p.InputPrice)
+	p.ImageInputPrice = scale(p.ImageInputPrice)
+	p.AudioInputPrice = scale(p.AudioInputPrice)
+	p.OutputPrice = scale(p.OutputPrice)
+	p.ImageOutputPrice = scale(p.ImageOutputPrice)
+	p.ThinkingModeOutputPrice = scale(p.ThinkingModeOutputPrice)
+	p.CachedPrice = scale(p.CachedPrice)
+	p.CacheCreationPrice = scale(p.CacheCreationPrice)
+	p.WebSearchPrice = scale(p.WebSearchPrice)
+
+	if len(p.ConditionalPrices) > 0 {
+		conditionalPrices := make([]ConditionalPrice, len(p.ConditionalPrices))
+		for i, conditionalPrice := range p.ConditionalPrices {
+			conditionalPrice.Price.BatchDiscount = ZeroNullFloat64(discount)
+			conditionalPrice.Price = conditionalPrice.Price.WithBatchDiscount(discount)
+			conditionalPrices[i] = conditionalPrice
+		}
+
+		p.ConditionalPrices = conditionalPrices
+	}
+
+	p.BatchDiscount = ZeroNullFloat64(discount)
+
+	return p
+}
+
 func (p *Price) GetInputPriceUnit() int64 {
 	if p.InputPriceUnit > 0 {
 		return int64(p.InputPriceUnit)
@@ -718,3 +718,49 @@ func TestPrice_SelectConditionalPrice_WithTime(t *testing.T) {
 		})
 	}
 }
+
+func TestPrice_WithBatchDiscount(t *testing.T) {
+	price := model.Price{
+		InputPrice:  0.002,
+		OutputPrice: 0.008,
+		ConditionalPrices: []model.ConditionalPrice{
+			{
+				Condition: model.PriceCondition{InputTokenMax: 32000},
+				Price: model.Price{
+					InputPrice:  0.001,
+					OutputPrice: 0.004,
+				},
+			},
+		},
+	}
+
+	discounted := price.WithBatchDiscount(0.5)
+	if discounted.InputPrice != 0.001 || discounted.OutputPrice != 0.004 {
+		t.Errorf("unexpected discounted price: %+v", discounted)
+	}
+
+	if discounted.ConditionalPrices[0].Price.InputPrice != 0.0005 {
+		t.Errorf(
+			"unexpected discounted conditional price: %+v",
+			discounted.ConditionalPrices[0].Price,
+		)
+	}
+
+	if price.ConditionalPrices[0].Price.InputPrice != 0.001 {
+		t.Error("original conditional prices should not be modified")
+	}
+
+	price.BatchDiscount = 0.8
+
+	discounted = price.WithBatchDiscount(0.5)
+	if discounted.InputPrice != 0.0016 {
+		t.Errorf("model batch discount should take precedence, got %v", discounted.InputPrice)
+	}
+
+	price.BatchDiscount = 0
+
+	discounted = price.WithBatchDiscount(1)
+	if discounted.InputPrice != price.InputPrice {
+		t.Errorf("discount 1 should keep the price, got %v", discounted.InputPrice)
+	}
+}
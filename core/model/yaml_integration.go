@@ -292,6 +292,23 @@ func (y *yamlModelConfigCache) GetModelConfig(model string) (ModelConfig, bool)
 	return y.dbCache.GetModelConfig(model)
 }
 
+func (y *yamlModelConfigCache) GetModelAliases() []ModelConfig {
+	aliases := make([]ModelConfig, 0)
+	for _, config := range y.yamlConfigs {
+		if config.IsAlias() {
+			aliases = append(aliases, config)
+		}
+	}
+
+	for _, config := range y.dbCache.GetModelAliases() {
+		if _, ok := y.yamlConfigs[config.Model]; !ok {
+			aliases = append(aliases, config)
+		}
+	}
+
+	return aliases
+}
+
 // NewConfigChannels merges YAML channels with database channels
 // YAML channels are assigned negative IDs to distinguish them from database channels
 // Note: YAML channels are NOT persisted to the database
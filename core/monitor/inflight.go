@@ -0,0 +1,459 @@
+package monitor
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labring/aiproxy/core/common"
+	"github.com/redis/go-redis/v9"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	inFlightKeySuffix = ":inflight"
+	// inFlightLease is the lease of an in flight request in redis, it is renewed
+	// while the request is in flight, so the requests of a crashed instance expire
+	inFlightLease         = time.Minute
+	inFlightRenewInterval = inFlightLease / 3
+)
+
+var (
+	addInFlightScript     = redis.NewScript(addInFlightLuaScript)
+	acquireInFlightScript = redis.NewScript(acquireInFlightLuaScript)
+)
+
+var memInFlightMonitor = NewMemInFlightMonitor()
+
+// InFlight is the number of in flight requests of a channel
+type InFlight struct {
+	Total  int64            `json:"total"`
+	Models map[string]int64 `json:"models,omitempty"`
+}
+
+func (i InFlight) Model(model string) int64 {
+	return i.Models[model]
+}
+
+// AddInFlight marks a request of a model as in flight on a channel,
+// the returned func must be called once the request is finished
+func AddInFlight(ctx context.Context, model string, channelID int64) (done func()) {
+	done, _ = AcquireInFlight(ctx, model, channelID, 0, 0)
+	return done
+}
+
+// AcquireInFlight marks a request of a model as in flight on a channel if the
+// in flight requests of the channel are below maxTotal and the in flight
+// requests of the model on the channel are below maxModel, a limit of zero is
+// unlimited. The count and the add are atomic, so the concurrent requests can
+// not exceed the limits. The returned func must be called once the request is
+// finished, it is nil if the channel is saturated
+func AcquireInFlight(
+	ctx context.Context,
+	model string,
+	channelID, maxTotal, maxModel int64,
+) (done func(), ok bool) {
+	if !common.RedisEnabled {
+		if !memInFlightMonitor.TryAdd(model, channelID, maxTotal, maxModel) {
+			return nil, false
+		}
+
+		var once sync.Once
+
+		return func() {
+			once.Do(func() {
+				memInFlightMonitor.Add(model, channelID, -1)
+			})
+		}, true
+	}
+
+	keys := []string{
+		buildChannelInFlightKey(strconv.FormatInt(channelID, 10)),
+		buildModelInFlightKey(model, strconv.FormatInt(channelID, 10)),
+	}
+	member := common.ShortUUID()
+
+	acquired, err := acquireInFlightScript.Run(
+		ctx,
+		common.RDB,
+		keys,
+		member,
+		time.Now().UnixMilli(),
+		inFlightLease.Milliseconds(),
+		maxTotal,
+		maxModel,
+	).Bool()
+	if err != nil {
+		// the request is not blocked by the unavailable redis
+		log.Errorf("acquire in flight request failed: %+v", err)
+	} else if !acquired {
+		return nil, false
+	}
+
+	stop := make(chan struct{})
+
+	go func() {
+		ticker := time.NewTicker(inFlightRenewInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				if err := renewInFlight(context.Background(), keys, member); err != nil {
+					log.Errorf("renew in flight request failed: %+v", err)
+				}
+			}
+		}
+	}()
+
+	var once sync.Once
+
+	return func() {
+		once.Do(func() {
+			close(stop)
+
+			pipe := common.RDB.Pipeline()
+			for _, key := range keys {
+				pipe.ZRem(context.Background(), key, member)
+			}
+
+			if _, err := pipe.Exec(context.Background()); err != nil {
+				log.Errorf("remove in flight request failed: %+v", err)
+			}
+		})
+	}, true
+}
+
+func renewInFlight(ctx context.Context, keys []string, member string) error {
+	return addInFlightScript.Run(
+		ctx,
+		common.RDB,
+		keys,
+		member,
+		time.Now().UnixMilli(),
+		inFlightLease.Milliseconds(),
+	).Err()
+}
+
+// GetModelChannelsLoad gets the learned rate limits of the channels of a model,
+// and the in flight requests of the channels if inFlight is set, in one round
+// trip to redis
+func GetModelChannelsLoad(
+	ctx context.Context,
+	model string,
+	channelIDs []int64,
+	inFlight bool,
+) (map[int64]InFlight, map[int64]RateLimit, error) {
+	if !common.RedisEnabled {
+		var inFlights map[int64]InFlight
+		if inFlight {
+			inFlights = memInFlightMonitor.GetModelChannels(model, channelIDs)
+		}
+
+		return inFlights, memRateLimitMonitor.GetModelChannels(model), nil
+	}
+
+	now := time.Now()
+	nowMilli := strconv.FormatInt(now.UnixMilli(), 10)
+
+	pipe := common.RDB.Pipeline()
+
+	var totals, models []*redis.IntCmd
+	if inFlight {
+		totals = make([]*redis.IntCmd, len(channelIDs))
+		models = make([]*redis.IntCmd, len(channelIDs))
+
+		for i, channelID := range channelIDs {
+			id := strconv.FormatInt(channelID, 10)
+			totals[i] = pipe.ZCount(ctx, buildChannelInFlightKey(id), nowMilli, "+inf")
+			models[i] = pipe.ZCount(ctx, buildModelInFlightKey(model, id), nowMilli, "+inf")
+		}
+	}
+
+	rateLimits := pipe.HGetAll(ctx, buildRateLimitKey(model))
+
+	if _, err := pipe.Exec(ctx); err != nil && !errors.Is(err, redis.Nil) {
+		return nil, nil, err
+	}
+
+	var inFlights map[int64]InFlight
+	if inFlight {
+		inFlights = make(map[int64]InFlight, len(channelIDs))
+
+		for i, channelID := range channelIDs {
+			total := totals[i].Val()
+			if total == 0 {
+				continue
+			}
+
+			inFlights[channelID] = InFlight{
+				Total:  total,
+				Models: map[string]int64{model: models[i].Val()},
+			}
+		}
+	}
+
+	return inFlights, parseRateLimits(rateLimits.Val(), now), nil
+}
+
+// GetChannelInFlight gets the in flight requests of a channel
+func GetChannelInFlight(ctx context.Context, channelID int64) (InFlight, error) {
+	if !common.RedisEnabled {
+		return memInFlightMonitor.Get(channelID), nil
+	}
+
+	all, err := getRedisInFlight(ctx, strconv.FormatInt(channelID, 10))
+	if err != nil {
+		return InFlight{}, err
+	}
+
+	return all[channelID], nil
+}
+
+// GetAllChannelsInFlight gets the in flight requests of all channels
+func GetAllChannelsInFlight(ctx context.Context) (map[int64]InFlight, error) {
+	if !common.RedisEnabled {
+		return memInFlightMonitor.GetAll(), nil
+	}
+
+	return getRedisInFlight(ctx, "*")
+}
+
+func getRedisInFlight(ctx context.Context, channelID string) (map[int64]InFlight, error) {
+	now := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	result := make(map[int64]InFlight)
+
+	iter := common.RDB.Scan(ctx, 0, buildChannelInFlightKey(channelID), 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		id, err := strconv.ParseInt(
+			strings.TrimSuffix(
+				strings.TrimPrefix(key, channelInFlightKeyPrefix()),
+				inFlightKeySuffix,
+			),
+			10,
+			64,
+		)
+		if err != nil {
+			continue
+		}
+
+		total, err := common.RDB.ZCount(ctx, key, now, "+inf").Result()
+		if err != nil {
+			return nil, err
+		}
+
+		if total > 0 {
+			result[id] = InFlight{Total: total}
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	iter = common.RDB.Scan(ctx, 0, buildModelInFlightKey("*", channelID), 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		content := strings.TrimPrefix(key, modelKeyPrefix())
+		content = strings.TrimSuffix(content, inFlightKeySuffix)
+
+		model, idStr, ok := strings.Cut(content, channelKeyPart)
+		if !ok {
+			continue
+		}
+
+		id, err := strconv.ParseInt(idStr, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		inFlight, ok := result[id]
+		if !ok {
+			continue
+		}
+
+		count, err := common.RDB.ZCount(ctx, key, now, "+inf").Result()
+		if err != nil {
+			return nil, err
+		}
+
+		if count == 0 {
+			continue
+		}
+
+		if inFlight.Models == nil {
+			inFlight.Models = make(map[string]int64)
+		}
+
+		inFlight.Models[model] = count
+		result[id] = inFlight
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+func channelInFlightKeyPrefix() string {
+	return common.RedisKey("channel:")
+}
+
+func buildChannelInFlightKey(channelID string) string {
+	return channelInFlightKeyPrefix() + channelID + inFlightKeySuffix
+}
+
+func buildModelInFlightKey(model, channelID string) string {
+	return modelKeyPrefix() + model + channelKeyPart + channelID + inFlightKeySuffix
+}
+
+type MemInFlightMonitor struct {
+	mu       sync.RWMutex
+	channels map[int64]*InFlight
+}
+
+func NewMemInFlightMonitor() *MemInFlightMonitor {
+	return &MemInFlightMonitor{
+		channels: make(map[int64]*InFlight),
+	}
+}
+
+func (m *MemInFlightMonitor) Add(model string, channelID, delta int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inFlight, ok := m.channels[channelID]
+	if !ok {
+		inFlight = &InFlight{Models: make(map[string]int64)}
+		m.channels[channelID] = inFlight
+	}
+
+	inFlight.Total += delta
+	if inFlight.Total <= 0 {
+		delete(m.channels, channelID)
+		return
+	}
+
+	inFlight.Models[model] += delta
+	if inFlight.Models[model] <= 0 {
+		delete(inFlight.Models, model)
+	}
+}
+
+// TryAdd adds an in flight request if the in flight requests of the channel
+// are below maxTotal and of the model on the channel are below maxModel
+func (m *MemInFlightMonitor) TryAdd(model string, channelID, maxTotal, maxModel int64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inFlight, ok := m.channels[channelID]
+	if ok && ((maxTotal > 0 && inFlight.Total >= maxTotal) ||
+		(maxModel > 0 && inFlight.Models[model] >= maxModel)) {
+		return false
+	}
+
+	if !ok {
+		inFlight = &InFlight{Models: make(map[string]int64)}
+		m.channels[channelID] = inFlight
+	}
+
+	inFlight.Total++
+	inFlight.Models[model]++
+
+	return true
+}
+
+func (m *MemInFlightMonitor) Get(channelID int64) InFlight {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	inFlight, ok := m.channels[channelID]
+	if !ok {
+		return InFlight{}
+	}
+
+	return InFlight{
+		Total:  inFlight.Total,
+		Models: maps.Clone(inFlight.Models),
+	}
+}
+
+func (m *MemInFlightMonitor) GetModelChannels(model string, channelIDs []int64) map[int64]InFlight {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[int64]InFlight, len(channelIDs))
+	for _, channelID := range channelIDs {
+		inFlight, ok := m.channels[channelID]
+		if !ok {
+			continue
+		}
+
+		result[channelID] = InFlight{
+			Total:  inFlight.Total,
+			Models: map[string]int64{model: inFlight.Models[model]},
+		}
+	}
+
+	return result
+}
+
+func (m *MemInFlightMonitor) GetAll() map[int64]InFlight {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	result := make(map[int64]InFlight, len(m.channels))
+	for channelID, inFlight := range m.channels {
+		result[channelID] = InFlight{
+			Total:  inFlight.Total,
+			Models: maps.Clone(inFlight.Models),
+		}
+	}
+
+	return result
+}
+
+const addInFlightLuaScript = `
+local member = ARGV[1]
+local now_ts = tonumber(ARGV[2])
+local lease = tonumber(ARGV[3])
+
+for _, key in ipairs(KEYS) do
+    redis.call("ZREMRANGEBYSCORE", key, "-inf", now_ts)
+    redis.call("ZADD", key, now_ts + lease, member)
+    redis.call("PEXPIRE", key, lease)
+end
+return 1
+`
+
+// acquireInFlightLuaScript adds the member to the in flight requests of the
+// channel and of the model on the channel, only if both are below the limits
+const acquireInFlightLuaScript = `
+local member = ARGV[1]
+local now_ts = tonumber(ARGV[2])
+local lease = tonumber(ARGV[3])
+local limits = {tonumber(ARGV[4]), tonumber(ARGV[5])}
+
+for i, key in ipairs(KEYS) do
+    redis.call("ZREMRANGEBYSCORE", key, "-inf", now_ts)
+    if limits[i] > 0 and redis.call("ZCARD", key) >= limits[i] then
+        return 0
+    end
+end
+
+for _, key in ipairs(KEYS) do
+    redis.call("ZADD", key, now_ts + lease, member)
+    redis.call("PEXPIRE", key, lease)
+end
+return 1
+`
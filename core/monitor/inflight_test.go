@@ -0,0 +1,100 @@
+package monitor_test
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/labring/aiproxy/core/monitor"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemInFlightMonitorTryAdd(t *testing.T) {
+	m := monitor.NewMemInFlightMonitor()
+
+	assert.True(t, m.TryAdd("m1", 1, 2, 1))
+	// the model reached its max concurrency on the channel
+	assert.False(t, m.TryAdd("m1", 1, 2, 1))
+	assert.True(t, m.TryAdd("m2", 1, 2, 1))
+	// the channel reached its max concurrency
+	assert.False(t, m.TryAdd("m3", 1, 2, 1))
+
+	assert.Equal(t, monitor.InFlight{
+		Total:  2,
+		Models: map[string]int64{"m1": 1, "m2": 1},
+	}, m.Get(1))
+
+	m.Add("m1", 1, -1)
+	assert.True(t, m.TryAdd("m3", 1, 2, 1))
+
+	// zero limits are unlimited
+	for range 10 {
+		assert.True(t, m.TryAdd("m1", 2, 0, 0))
+	}
+
+	assert.Equal(t, int64(10), m.Get(2).Total)
+
+	for range 10 {
+		m.Add("m1", 2, -1)
+	}
+
+	assert.Equal(t, map[int64]monitor.InFlight{
+		1: {Total: 2, Models: map[string]int64{"m2": 1, "m3": 1}},
+	}, m.GetAll())
+}
+
+func TestAcquireInFlightConcurrent(t *testing.T) {
+	const (
+		channelID      = 100
+		maxConcurrency = 3
+	)
+
+	var (
+		wg       sync.WaitGroup
+		acquired atomic.Int64
+		dones    = make(chan func(), 50)
+	)
+
+	for range 50 {
+		wg.Go(func() {
+			done, ok := monitor.AcquireInFlight(
+				t.Context(),
+				t.Name(),
+				channelID,
+				maxConcurrency,
+				0,
+			)
+			if !ok {
+				return
+			}
+
+			acquired.Add(1)
+
+			dones <- done
+		})
+	}
+
+	wg.Wait()
+	close(dones)
+
+	assert.Equal(t, int64(maxConcurrency), acquired.Load())
+
+	inFlight, err := monitor.GetChannelInFlight(t.Context(), channelID)
+	require.NoError(t, err)
+	assert.Equal(t, int64(maxConcurrency), inFlight.Total)
+
+	for done := range dones {
+		done()
+		// done is idempotent
+		done()
+	}
+
+	inFlight, err = monitor.GetChannelInFlight(t.Context(), channelID)
+	require.NoError(t, err)
+	assert.Zero(t, inFlight.Total)
+
+	done, ok := monitor.AcquireInFlight(t.Context(), t.Name(), channelID, maxConcurrency, 0)
+	require.True(t, ok)
+	done()
+}
@@ -0,0 +1,307 @@
+package monitor
+
+import (
+	"context"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labring/aiproxy/core/common"
+	"github.com/redis/go-redis/v9"
+)
+
+const latencyKeySuffix = ":latency"
+
+var (
+	addLatencyScript = redis.NewScript(addLatencyLuaScript)
+	getLatencyScript = redis.NewScript(getLatencyLuaScript)
+)
+
+var memLatencyMonitor = NewMemLatencyMonitor()
+
+// AddLatency records the latency of a successful request of a model on a channel,
+// the latency is the time until the response headers are received
+func AddLatency(ctx context.Context, model string, channelID int64, latency time.Duration) error {
+	modelLatencySamples.add(model, latency)
+
+	if !common.RedisEnabled {
+		memLatencyMonitor.AddLatency(model, channelID, latency)
+		return nil
+	}
+
+	return addLatencyScript.Run(
+		ctx,
+		common.RDB,
+		[]string{buildLatencyKey(model, strconv.FormatInt(channelID, 10))},
+		latency.Milliseconds(),
+		time.Now().UnixMilli(),
+	).Err()
+}
+
+// GetModelChannelLatency gets the average latency in milliseconds of the
+// channels of a model over the rolling time window
+func GetModelChannelLatency(ctx context.Context, model string) (map[int64]float64, error) {
+	if !common.RedisEnabled {
+		return memLatencyMonitor.GetModelChannelLatency(model), nil
+	}
+
+	result := make(map[int64]float64)
+	pattern := buildLatencyKey(model, "*")
+	now := time.Now().UnixMilli()
+
+	iter := common.RDB.Scan(ctx, 0, pattern, 0).Iterator()
+	for iter.Next(ctx) {
+		key := iter.Val()
+
+		channelID, ok := getLatencyChannelID(key, model)
+		if !ok {
+			continue
+		}
+
+		latency, err := getLatencyScript.Run(
+			ctx,
+			common.RDB,
+			[]string{key},
+			now,
+		).Float64()
+		if err != nil {
+			return nil, err
+		}
+
+		if latency > 0 {
+			result[channelID] = latency
+		}
+	}
+
+	if err := iter.Err(); err != nil {
+		return nil, err
+	}
+
+	return result, nil
+}
+
+const (
+	maxLatencySamples = 256
+	minLatencySamples = 20
+)
+
+// modelLatencySamples keeps the recent latencies of each model to estimate
+// the percentiles, the samples are kept per instance even when redis is enabled
+var modelLatencySamples = &latencySamples{models: make(map[string]*latencyRing)}
+
+type latencySamples struct {
+	mu     sync.Mutex
+	models map[string]*latencyRing
+}
+
+type latencyRing struct {
+	samples []time.Duration
+	next    int
+}
+
+func (l *latencySamples) add(model string, latency time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	ring, ok := l.models[model]
+	if !ok {
+		ring = &latencyRing{samples: make([]time.Duration, 0, maxLatencySamples)}
+		l.models[model] = ring
+	}
+
+	if len(ring.samples) < maxLatencySamples {
+		ring.samples = append(ring.samples, latency)
+		return
+	}
+
+	ring.samples[ring.next] = latency
+	ring.next = (ring.next + 1) % maxLatencySamples
+}
+
+// GetModelLatencyPercentile gets the percentile (0-100] of the recent latencies
+// of a model on all channels, it is false until enough latencies are collected
+func GetModelLatencyPercentile(model string, percentile float64) (time.Duration, bool) {
+	modelLatencySamples.mu.Lock()
+
+	ring, ok := modelLatencySamples.models[model]
+	if !ok || len(ring.samples) < minLatencySamples {
+		modelLatencySamples.mu.Unlock()
+		return 0, false
+	}
+
+	samples := slices.Clone(ring.samples)
+
+	modelLatencySamples.mu.Unlock()
+
+	slices.Sort(samples)
+
+	index := int(math.Ceil(percentile/100*float64(len(samples)))) - 1
+	index = max(0, min(index, len(samples)-1))
+
+	return samples[index], true
+}
+
+func buildLatencyKey(model, channelID string) string {
+	return modelKeyPrefix() + model + channelKeyPart + channelID + latencyKeySuffix
+}
+
+func getLatencyChannelID(key, model string) (int64, bool) {
+	channelIDStr := strings.TrimPrefix(key, modelKeyPrefix()+model+channelKeyPart)
+	channelIDStr = strings.TrimSuffix(channelIDStr, latencyKeySuffix)
+
+	channelID, err := strconv.ParseInt(channelIDStr, 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return channelID, true
+}
+
+type MemLatencyMonitor struct {
+	mu     sync.RWMutex
+	models map[string]map[int64]*latencyStats
+}
+
+type latencyStats struct {
+	slices []*latencySlice
+}
+
+type latencySlice struct {
+	windowStart time.Time
+	count       int64
+	total       time.Duration
+}
+
+func NewMemLatencyMonitor() *MemLatencyMonitor {
+	return &MemLatencyMonitor{
+		models: make(map[string]map[int64]*latencyStats),
+	}
+}
+
+func (m *MemLatencyMonitor) AddLatency(model string, channelID int64, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	channels, ok := m.models[model]
+	if !ok {
+		channels = make(map[int64]*latencyStats)
+		m.models[model] = channels
+	}
+
+	stats, ok := channels[channelID]
+	if !ok {
+		stats = &latencyStats{}
+		channels[channelID] = stats
+	}
+
+	now := time.Now()
+	stats.cleanup(now)
+
+	currentWindow := now.Truncate(timeWindow)
+	if len(stats.slices) == 0 ||
+		!stats.slices[len(stats.slices)-1].windowStart.Equal(currentWindow) {
+		stats.slices = append(stats.slices, &latencySlice{windowStart: currentWindow})
+	}
+
+	slice := stats.slices[len(stats.slices)-1]
+	slice.count++
+	slice.total += latency
+}
+
+func (m *MemLatencyMonitor) GetModelChannelLatency(model string) map[int64]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[int64]float64)
+
+	now := time.Now()
+	for channelID, stats := range m.models[model] {
+		stats.cleanup(now)
+
+		if len(stats.slices) == 0 {
+			delete(m.models[model], channelID)
+			continue
+		}
+
+		var (
+			count int64
+			total time.Duration
+		)
+		for _, slice := range stats.slices {
+			count += slice.count
+			total += slice.total
+		}
+
+		result[channelID] = float64(total.Milliseconds()) / float64(count)
+	}
+
+	if len(m.models[model]) == 0 {
+		delete(m.models, model)
+	}
+
+	return result
+}
+
+func (s *latencyStats) cleanup(now time.Time) {
+	cutoff := now.Add(-timeWindow * time.Duration(maxSliceCount))
+
+	validSlices := s.slices[:0]
+	for _, slice := range s.slices {
+		if !slice.windowStart.Before(cutoff) {
+			validSlices = append(validSlices, slice)
+		}
+	}
+
+	s.slices = validSlices
+}
+
+const (
+	addLatencyLuaScript = `
+local key = KEYS[1]
+local latency = tonumber(ARGV[1])
+local now_ts = tonumber(ARGV[2])
+local maxSliceCount = 12
+local statsExpiry = maxSliceCount * 10 * 1000
+local current_slice = math.floor(now_ts / 10 / 1000)
+
+local value = redis.call("HGET", key, current_slice)
+local count, total = 0, 0
+if value then
+    local c, t = value:match("^(%d+):(%d+)$")
+    count, total = tonumber(c) or 0, tonumber(t) or 0
+end
+
+redis.call("HSET", key, current_slice, (count + 1) .. ":" .. (total + latency))
+redis.call("PEXPIRE", key, statsExpiry)
+return 1
+`
+
+	getLatencyLuaScript = `
+local key = KEYS[1]
+local now_ts = tonumber(ARGV[1])
+local maxSliceCount = 12
+local current_slice = math.floor(now_ts / 10 / 1000)
+local min_valid_slice = current_slice - maxSliceCount
+
+local count, total = 0, 0
+local all_slices = redis.call("HGETALL", key)
+for i = 1, #all_slices, 2 do
+    local slice = tonumber(all_slices[i])
+    if slice < min_valid_slice then
+        redis.call("HDEL", key, all_slices[i])
+    else
+        local c, t = all_slices[i+1]:match("^(%d+):(%d+)$")
+        count = count + (tonumber(c) or 0)
+        total = total + (tonumber(t) or 0)
+    end
+end
+
+if count == 0 then
+    return "0"
+end
+return tostring(total / count)
+`
+)
@@ -0,0 +1,140 @@
+package monitor
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/labring/aiproxy/core/common"
+)
+
+const (
+	rateLimitKeySuffix = ":ratelimit"
+	// rateLimitTTL is how long a learned rate limit is kept without new responses
+	rateLimitTTL = time.Hour
+)
+
+var memRateLimitMonitor = NewMemRateLimitMonitor()
+
+// RateLimit is the rate limit of a model on a channel learned from the
+// x-ratelimit-* headers of the upstream responses
+type RateLimit struct {
+	RPM int64 `json:"rpm,omitempty"`
+	TPM int64 `json:"tpm,omitempty"`
+	// unix milliseconds until the remaining requests or tokens are reset,
+	// set only when the upstream reported none remaining
+	RequestsExhaustedUntil int64 `json:"requests_exhausted_until,omitempty"`
+	TokensExhaustedUntil   int64 `json:"tokens_exhausted_until,omitempty"`
+	UpdatedAt              int64 `json:"updated_at"`
+}
+
+// Exhausted reports whether the upstream reported no remaining requests or tokens
+func (l RateLimit) Exhausted(now time.Time) bool {
+	nowMilli := now.UnixMilli()
+	return l.RequestsExhaustedUntil > nowMilli || l.TokensExhaustedUntil > nowMilli
+}
+
+func (l RateLimit) expired(now time.Time) bool {
+	return now.UnixMilli()-l.UpdatedAt > rateLimitTTL.Milliseconds()
+}
+
+// SetRateLimit saves the rate limit of a model on a channel learned from an upstream response
+func SetRateLimit(ctx context.Context, model string, channelID int64, limit RateLimit) error {
+	limit.UpdatedAt = time.Now().UnixMilli()
+
+	if !common.RedisEnabled {
+		memRateLimitMonitor.Set(model, channelID, limit)
+		return nil
+	}
+
+	data, err := sonic.Marshal(limit)
+	if err != nil {
+		return err
+	}
+
+	key := buildRateLimitKey(model)
+
+	pipe := common.RDB.Pipeline()
+	pipe.HSet(ctx, key, strconv.FormatInt(channelID, 10), data)
+	pipe.PExpire(ctx, key, rateLimitTTL)
+	_, err = pipe.Exec(ctx)
+
+	return err
+}
+
+func parseRateLimits(values map[string]string, now time.Time) map[int64]RateLimit {
+	result := make(map[int64]RateLimit, len(values))
+
+	for field, value := range values {
+		channelID, err := strconv.ParseInt(field, 10, 64)
+		if err != nil {
+			continue
+		}
+
+		var limit RateLimit
+		if err := sonic.UnmarshalString(value, &limit); err != nil {
+			continue
+		}
+
+		if limit.expired(now) {
+			continue
+		}
+
+		result[channelID] = limit
+	}
+
+	return result
+}
+
+func buildRateLimitKey(model string) string {
+	return modelKeyPrefix() + model + rateLimitKeySuffix
+}
+
+type MemRateLimitMonitor struct {
+	mu     sync.RWMutex
+	models map[string]map[int64]RateLimit
+}
+
+func NewMemRateLimitMonitor() *MemRateLimitMonitor {
+	return &MemRateLimitMonitor{
+		models: make(map[string]map[int64]RateLimit),
+	}
+}
+
+func (m *MemRateLimitMonitor) Set(model string, channelID int64, limit RateLimit) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	channels, ok := m.models[model]
+	if !ok {
+		channels = make(map[int64]RateLimit)
+		m.models[model] = channels
+	}
+
+	channels[channelID] = limit
+}
+
+func (m *MemRateLimitMonitor) GetModelChannels(model string) map[int64]RateLimit {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	result := make(map[int64]RateLimit, len(m.models[model]))
+
+	for channelID, limit := range m.models[model] {
+		if limit.expired(now) {
+			delete(m.models[model], channelID)
+			continue
+		}
+
+		result[channelID] = limit
+	}
+
+	if len(m.models[model]) == 0 {
+		delete(m.models, model)
+	}
+
+	return result
+}
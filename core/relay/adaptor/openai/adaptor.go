@@ -46,7 +46,11 @@ func (a *Adaptor) SupportMode(m mode.Mode) bool {
 		m == mode.ResponsesGet ||
 		m == mode.ResponsesDelete ||
 		m == mode.ResponsesCancel ||
-		m == mode.ResponsesInputItems
+		m == mode.ResponsesInputItems ||
+		m == mode.FilesUpload ||
+		m == mode.FilesGet ||
+		m == mode.FilesDelete ||
+		m == mode.FilesContent
 }
 
 //nolint:gocyclo
@@ -247,6 +251,41 @@ func (a *Adaptor) GetRequestURL(
 			return adaptor.RequestURL{}, err
 		}
 
+		return adaptor.RequestURL{
+			Method: http.MethodGet,
+			URL:    url,
+		}, nil
+	case mode.FilesUpload:
+		url, err := url.JoinPath(u, "/files")
+		if err != nil {
+			return adaptor.RequestURL{}, err
+		}
+
+		return adaptor.RequestURL{
+			Method: http.MethodPost,
+			URL:    url,
+		}, nil
+	case mode.FilesGet, mode.FilesDelete:
+		url, err := url.JoinPath(u, "/files", meta.FileID)
+		if err != nil {
+			return adaptor.RequestURL{}, err
+		}
+
+		method := http.MethodGet
+		if meta.Mode == mode.FilesDelete {
+			method = http.MethodDelete
+		}
+
+		return adaptor.RequestURL{
+			Method: method,
+			URL:    url,
+		}, nil
+	case mode.FilesContent:
+		url, err := url.JoinPath(u, "/files", meta.FileID, "/content")
+		if err != nil {
+			return adaptor.RequestURL{}, err
+		}
+
 		return adaptor.RequestURL{
 			Method: http.MethodGet,
 			URL:    url,
@@ -323,6 +362,10 @@ func ConvertRequest(
 		return ConvertVideoGetJobsRequest(meta, req)
 	case mode.VideoGenerationsContent:
 		return ConvertVideoGetJobsContentRequest(meta, req)
+	case mode.FilesUpload:
+		return ConvertFilesUploadRequest(meta, req)
+	case mode.FilesGet, mode.FilesDelete, mode.FilesContent:
+		return adaptor.ConvertResult{}, nil
 	case mode.Gemini:
 		// Check if model requires Responses API conversion
 		if IsResponsesOnlyModel(&meta.ModelConfig, meta.ActualModel) {
@@ -405,6 +448,10 @@ func DoResponse(
 		usage, err = VideoGetJobsHandler(meta, store, c, resp)
 	case mode.VideoGenerationsContent:
 		usage, err = VideoGetJobsContentHandler(meta, store, c, resp)
+	case mode.FilesUpload:
+		usage, err = FilesUploadHandler(meta, store, c, resp)
+	case mode.FilesGet, mode.FilesDelete, mode.FilesContent:
+		usage, err = FilesPassthroughHandler(meta, c, resp)
 	case mode.Gemini:
 		// Check if model required Responses API conversion
 		if IsResponsesOnlyModel(&meta.ModelConfig, meta.ActualModel) {
@@ -0,0 +1,172 @@
+package openai
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/bytedance/sonic/ast"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/meta"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+)
+
+// channel files are kept in the store for the longest expiry openai allows
+const fileStoreExpires = time.Hour * 24 * 30
+
+// ConvertFilesUploadRequest rebuilds the multipart body without the model
+// field, which is only used by aiproxy to pick the channel
+func ConvertFilesUploadRequest(
+	_ *meta.Meta,
+	request *http.Request,
+) (adaptor.ConvertResult, error) {
+	err := request.ParseMultipartForm(1024 * 1024 * 4)
+	if err != nil {
+		return adaptor.ConvertResult{}, err
+	}
+
+	multipartBody := &bytes.Buffer{}
+	multipartWriter := multipart.NewWriter(multipartBody)
+
+	for key, values := range request.MultipartForm.Value {
+		if len(values) == 0 || key == "model" {
+			continue
+		}
+
+		err = multipartWriter.WriteField(key, values[0])
+		if err != nil {
+			return adaptor.ConvertResult{}, err
+		}
+	}
+
+	for _, files := range request.MultipartForm.File {
+		if len(files) == 0 {
+			continue
+		}
+
+		fileHeader := files[0]
+
+		file, err := fileHeader.Open()
+		if err != nil {
+			return adaptor.ConvertResult{}, err
+		}
+
+		w, err := multipartWriter.CreatePart(fileHeader.Header)
+		if err != nil {
+			file.Close()
+			return adaptor.ConvertResult{}, err
+		}
+
+		_, err = io.Copy(w, file)
+		file.Close()
+
+		if err != nil {
+			return adaptor.ConvertResult{}, err
+		}
+	}
+
+	if err := multipartWriter.Close(); err != nil {
+		return adaptor.ConvertResult{}, err
+	}
+
+	return adaptor.ConvertResult{
+		Header: http.Header{
+			"Content-Type": {multipartWriter.FormDataContentType()},
+		},
+		Body: multipartBody,
+	}, nil
+}
+
+func FilesUploadHandler(
+	meta *meta.Meta,
+	store adaptor.Store,
+	c *gin.Context,
+	resp *http.Response,
+) (model.Usage, adaptor.Error) {
+	if resp.StatusCode != http.StatusOK {
+		return model.Usage{}, ErrorHanlder(resp)
+	}
+
+	defer resp.Body.Close()
+
+	responseBody, err := common.GetResponseBody(resp)
+	if err != nil {
+		return model.Usage{}, relaymodel.WrapperOpenAIError(
+			err,
+			"read_response_body_failed",
+			http.StatusInternalServerError,
+		)
+	}
+
+	idNode, err := sonic.GetWithOptions(responseBody, ast.SearchOptions{}, "id")
+	if err != nil {
+		return model.Usage{}, relaymodel.WrapperOpenAIError(
+			err,
+			"unmarshal_response_body_failed",
+			http.StatusInternalServerError,
+		)
+	}
+
+	id, err := idNode.String()
+	if err != nil {
+		return model.Usage{}, relaymodel.WrapperOpenAIError(
+			err,
+			"unmarshal_response_body_failed",
+			http.StatusInternalServerError,
+		)
+	}
+
+	err = store.SaveStore(adaptor.StoreCache{
+		ID:        id,
+		GroupID:   meta.Group.ID,
+		TokenID:   meta.Token.ID,
+		ChannelID: meta.Channel.ID,
+		Model:     meta.ActualModel,
+		ExpiresAt: time.Now().Add(fileStoreExpires),
+	})
+	if err != nil {
+		log := common.GetLogger(c)
+		log.Errorf("save store failed: %v", err)
+	}
+
+	c.Writer.Header().Set("Content-Type", "application/json")
+	c.Writer.Header().Set("Content-Length", strconv.Itoa(len(responseBody)))
+	_, _ = c.Writer.Write(responseBody)
+
+	return model.Usage{}, nil
+}
+
+// FilesPassthroughHandler copies the response of get, delete and content
+// requests of a channel file to the client as is
+func FilesPassthroughHandler(
+	_ *meta.Meta,
+	c *gin.Context,
+	resp *http.Response,
+) (model.Usage, adaptor.Error) {
+	if resp.StatusCode != http.StatusOK {
+		return model.Usage{}, ErrorHanlder(resp)
+	}
+
+	defer resp.Body.Close()
+
+	c.Writer.Header().Set("Content-Type", resp.Header.Get("Content-Type"))
+
+	if contentLength := resp.Header.Get("Content-Length"); contentLength != "" {
+		c.Writer.Header().Set("Content-Length", contentLength)
+	}
+
+	if disposition := resp.Header.Get("Content-Disposition"); disposition != "" {
+		c.Writer.Header().Set("Content-Disposition", disposition)
+	}
+
+	_, _ = io.Copy(c.Writer, resp.Body)
+
+	return model.Usage{}, nil
+}
@@ -15,6 +15,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/labring/aiproxy/core/common"
 	"github.com/labring/aiproxy/core/common/conv"
+	"github.com/labring/aiproxy/core/common/tracing"
 	"github.com/labring/aiproxy/core/model"
 	"github.com/labring/aiproxy/core/relay/adaptor"
 	"github.com/labring/aiproxy/core/relay/meta"
@@ -100,7 +101,7 @@ func DoHelper(
 	}
 
 	// donot use c.Request.Context() because it will be canceled by the client
-	ctx := context.Background()
+	ctx := meta.UpstreamContext()
 
 	resp, err := prepareAndDoRequest(ctx, a, c, meta, store)
 	if err != nil {
@@ -172,8 +173,18 @@ func prepareAndDoRequest(
 ) (*http.Response, adaptor.Error) {
 	log := common.GetLogger(c)
 
+	end := meta.StartSpan("adaptor ConvertRequest")
+
 	convertResult, err := a.ConvertRequest(meta, store, c.Request)
+	end(err)
+
 	if err != nil {
+		// the plugins reject the requests with their own errors, like the moderation
+		var relayErr adaptor.Error
+		if errors.As(err, &relayErr) {
+			return nil, relayErr
+		}
+
 		return nil, relaymodel.WrapperErrorWithMessage(
 			meta.Mode,
 			http.StatusBadRequest,
@@ -189,7 +200,11 @@ func prepareAndDoRequest(
 		meta.Channel.BaseURL = a.DefaultBaseURL()
 	}
 
+	end = meta.StartSpan("adaptor GetRequestURL")
+
 	fullRequestURL, err := a.GetRequestURL(meta, store, c)
+	end(err)
+
 	if err != nil {
 		return nil, relaymodel.WrapperErrorWithMessage(
 			meta.Mode,
@@ -231,7 +246,12 @@ func setupRequestHeader(
 ) adaptor.Error {
 	maps.Copy(req.Header, header)
 
-	if err := a.SetupRequestHeader(meta, store, c, req); err != nil {
+	end := meta.StartSpan("adaptor SetupRequestHeader")
+
+	err := a.SetupRequestHeader(meta, store, c, req)
+	end(err)
+
+	if err != nil {
 		return relaymodel.WrapperErrorWithMessage(
 			meta.Mode,
 			http.StatusInternalServerError,
@@ -249,7 +269,18 @@ func doRequest(
 	store adaptor.Store,
 	req *http.Request,
 ) (*http.Response, adaptor.Error) {
+	end := meta.StartSpan("adaptor DoRequest")
+
+	// propagate the trace context to the upstream
+	tracing.Inject(meta.TraceContext(), req.Header)
+
 	resp, err := a.DoRequest(meta, store, c, req)
+	end(err)
+
+	if err == nil {
+		meta.NotifyResponseHeader()
+	}
+
 	if err != nil {
 		var adaptorErr adaptor.Error
 
@@ -332,7 +363,11 @@ func handleResponse(
 
 	c.Writer = rw
 
+	end := meta.StartSpan("adaptor DoResponse")
+
 	usage, relayErr := a.DoResponse(meta, store, c, resp)
+	end(relayErr)
+
 	if relayErr != nil {
 		respBody, _ := relayErr.MarshalJSON()
 		detail.ResponseBody = conv.BytesToString(respBody)
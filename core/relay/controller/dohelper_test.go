@@ -0,0 +1,94 @@
+package controller_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/controller"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/propagation"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+// headerAdaptor records the header of the upstream request
+type headerAdaptor struct {
+	adaptor.Adaptor
+	header http.Header
+}
+
+func (a *headerAdaptor) DoRequest(
+	_ *meta.Meta,
+	_ adaptor.Store,
+	_ *gin.Context,
+	req *http.Request,
+) (*http.Response, error) {
+	a.header = req.Header.Clone()
+	return &http.Response{StatusCode: http.StatusOK, Body: http.NoBody}, nil
+}
+
+func TestDoRequestInjectsTraceContext(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+
+	oldProvider := otel.GetTracerProvider()
+	oldPropagator := otel.GetTextMapPropagator()
+
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	t.Cleanup(func() {
+		otel.SetTracerProvider(oldProvider)
+		otel.SetTextMapPropagator(oldPropagator)
+	})
+
+	ctx, root := provider.Tracer("test").Start(context.Background(), "relay")
+
+	m := meta.NewMeta(&model.Channel{}, mode.ChatCompletions, "gpt-4o", model.ModelConfig{})
+	m.SetTraceContext(ctx)
+
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	req, err := http.NewRequestWithContext(
+		context.Background(),
+		http.MethodPost,
+		"http://upstream/v1/chat/completions",
+		http.NoBody,
+	)
+	require.NoError(t, err)
+
+	a := &headerAdaptor{}
+	resp, relayErr := controller.DoRequest(a, c, m, nil, req)
+	require.Nil(t, relayErr)
+	require.NoError(t, resp.Body.Close())
+
+	root.End()
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+
+	doRequestSpan := spans[0]
+	assert.Equal(t, "adaptor DoRequest", doRequestSpan.Name())
+	assert.Equal(t, root.SpanContext().SpanID(), doRequestSpan.Parent().SpanID())
+
+	// the upstream continues the trace from the span of the upstream request
+	sc := doRequestSpan.SpanContext()
+	assert.Equal(
+		t,
+		"00-"+sc.TraceID().String()+"-"+sc.SpanID().String()+"-01",
+		a.header.Get("Traceparent"),
+	)
+
+	// the trace context of the meta is restored after the span
+	assert.Equal(t, ctx, m.TraceContext())
+}
@@ -0,0 +1,3 @@
+package controller
+
+var DoRequest = doRequest
@@ -1,11 +1,14 @@
 package meta
 
 import (
+	"context"
 	"fmt"
 	"time"
 
+	"github.com/labring/aiproxy/core/common/tracing"
 	"github.com/labring/aiproxy/core/model"
 	"github.com/labring/aiproxy/core/relay/mode"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type ChannelMeta struct {
@@ -15,6 +18,14 @@ type ChannelMeta struct {
 	ID           int
 	Type         model.ChannelType
 	ModelMapping map[string]string
+	// MaxConcurrency and ModelMaxConcurrency are the max in flight requests
+	// of the channel, and of the origin model on the channel
+	MaxConcurrency      int64
+	ModelMaxConcurrency int64
+	// RPM and ModelRPM are the max requests per minute of the channel, and of
+	// the origin model on the channel
+	RPM      int64
+	ModelRPM int64
 }
 
 type Meta struct {
@@ -31,7 +42,13 @@ type Meta struct {
 	RequestID   string
 	OriginModel string
 	ActualModel string
-	Mode        mode.Mode
+	// ModelAlias is the alias requested by the client, OriginModel is its resolved target
+	ModelAlias string
+	// ChannelStrategy is the strategy that picked the channel
+	ChannelStrategy string
+	// FallbackModel is set on the last failed attempt before falling back to another model
+	FallbackModel string
+	Mode          mode.Mode
 
 	RequestTimeout time.Duration
 
@@ -40,6 +57,7 @@ type Meta struct {
 	JobID        string
 	GenerationID string
 	ResponseID   string
+	FileID       string
 }
 
 type Option func(meta *Meta)
@@ -104,6 +122,24 @@ func WithResponseID(responseID string) Option {
 	}
 }
 
+func WithModelAlias(modelAlias string) Option {
+	return func(meta *Meta) {
+		meta.ModelAlias = modelAlias
+	}
+}
+
+func WithChannelStrategy(channelStrategy string) Option {
+	return func(meta *Meta) {
+		meta.ChannelStrategy = channelStrategy
+	}
+}
+
+func WithFileID(fileID string) Option {
+	return func(meta *Meta) {
+		meta.FileID = fileID
+	}
+}
+
 func NewMeta(
 	channel *model.Channel,
 	mode mode.Mode,
@@ -142,6 +178,10 @@ func (m *Meta) SetChannel(channel *model.Channel) {
 	m.Channel.Type = channel.Type
 
 	m.Channel.ModelMapping = channel.ModelMapping
+	m.Channel.MaxConcurrency = channel.MaxConcurrency
+	m.Channel.ModelMaxConcurrency = channel.ModelMaxConcurrency[m.OriginModel]
+	m.Channel.RPM = channel.RPM
+	m.Channel.ModelRPM = channel.ModelRPM[m.OriginModel]
 	m.ChannelConfigs = channel.Configs
 
 	m.ActualModel, _ = GetMappedModelName(m.OriginModel, channel.ModelMapping)
@@ -153,6 +193,65 @@ func (m *Meta) CopyChannelFromMeta(meta *Meta) {
 	m.ActualModel, _ = GetMappedModelName(meta.OriginModel, meta.Channel.ModelMapping)
 }
 
+const traceContextKey = "trace_context"
+
+// TraceContext returns the context carrying the current span of the request,
+// the spans of the relay stages and plugin hooks are started from it
+func (m *Meta) TraceContext() context.Context {
+	if ctx, ok := m.values[traceContextKey].(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+func (m *Meta) SetTraceContext(ctx context.Context) {
+	m.values[traceContextKey] = ctx
+}
+
+// StartSpan starts a span as the current span of the request, the returned
+// func ends the span with the error and restores the parent span
+func (m *Meta) StartSpan(name string, attrs ...attribute.KeyValue) (end func(err error)) {
+	parent := m.TraceContext()
+
+	ctx, span := tracing.Start(parent, name, attrs...)
+	m.SetTraceContext(ctx)
+
+	return func(err error) {
+		tracing.End(span, err)
+		m.SetTraceContext(parent)
+	}
+}
+
+const upstreamContextKey = "upstream_context"
+
+// UpstreamContext returns the context of the upstream request, it is not
+// canceled by the client so the usage of the finished request is still billed
+func (m *Meta) UpstreamContext() context.Context {
+	if ctx, ok := m.values[upstreamContextKey].(context.Context); ok {
+		return ctx
+	}
+	return context.Background()
+}
+
+// SetUpstreamContext sets the context of the upstream request, such as the
+// hedged request which is canceled after another channel answered
+func (m *Meta) SetUpstreamContext(ctx context.Context) {
+	m.values[upstreamContextKey] = ctx
+}
+
+const responseHeaderNotifyKey = "response_header_notify"
+
+// SetResponseHeaderNotify sets the func called when the upstream response headers are received
+func (m *Meta) SetResponseHeaderNotify(notify func()) {
+	m.values[responseHeaderNotifyKey] = notify
+}
+
+func (m *Meta) NotifyResponseHeader() {
+	if notify, ok := m.values[responseHeaderNotifyKey].(func()); ok {
+		notify()
+	}
+}
+
 func (m *Meta) ClearValues() {
 	clear(m.values)
 }
@@ -50,6 +50,18 @@ func (m Mode) String() string {
 		return "ResponsesInputItems"
 	case Gemini:
 		return "Gemini"
+	case FilesUpload:
+		return "FilesUpload"
+	case FilesList:
+		return "FilesList"
+	case FilesGet:
+		return "FilesGet"
+	case FilesDelete:
+		return "FilesDelete"
+	case FilesContent:
+		return "FilesContent"
+	case MCPToolsCall:
+		return "MCPToolsCall"
 	default:
 		return fmt.Sprintf("Mode(%d)", m)
 	}
@@ -78,4 +90,11 @@ const (
 	ResponsesCancel
 	ResponsesInputItems
 	Gemini
+	FilesUpload
+	FilesList
+	FilesGet
+	FilesDelete
+	FilesContent
+	// MCPToolsCall is a tools/call message of a public mcp, it is only used to record the calls
+	MCPToolsCall
 )
@@ -0,0 +1,87 @@
+package model
+
+import "encoding/json"
+
+// https://platform.openai.com/docs/api-reference/batch/create
+type BatchRequest struct {
+	InputFileID      string            `json:"input_file_id"`
+	Endpoint         string            `json:"endpoint"`
+	CompletionWindow string            `json:"completion_window"`
+	Metadata         map[string]string `json:"metadata,omitempty"`
+}
+
+type BatchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+type BatchErrorData struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    *int   `json:"line,omitempty"`
+}
+
+type BatchErrors struct {
+	Object string            `json:"object"`
+	Data   []*BatchErrorData `json:"data"`
+}
+
+// https://platform.openai.com/docs/api-reference/batch/object
+type Batch struct {
+	ID               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *BatchErrors       `json:"errors,omitempty"`
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileID     *string            `json:"output_file_id"`
+	ErrorFileID      *string            `json:"error_file_id"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     *int64             `json:"in_progress_at"`
+	ExpiresAt        *int64             `json:"expires_at"`
+	FinalizingAt     *int64             `json:"finalizing_at"`
+	CompletedAt      *int64             `json:"completed_at"`
+	FailedAt         *int64             `json:"failed_at"`
+	ExpiredAt        *int64             `json:"expired_at"`
+	CancellingAt     *int64             `json:"cancelling_at"`
+	CancelledAt      *int64             `json:"cancelled_at"`
+	RequestCounts    BatchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata"`
+}
+
+type BatchList struct {
+	Object  string   `json:"object"`
+	Data    []*Batch `json:"data"`
+	FirstID string   `json:"first_id,omitempty"`
+	LastID  string   `json:"last_id,omitempty"`
+	HasMore bool     `json:"has_more"`
+}
+
+// BatchInput is a line of the batch input file
+type BatchInput struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+type BatchOutputResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+type BatchOutputError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// BatchOutput is a line of the batch output or error file
+type BatchOutput struct {
+	ID       string               `json:"id"`
+	CustomID string               `json:"custom_id"`
+	Response *BatchOutputResponse `json:"response"`
+	Error    *BatchOutputError    `json:"error"`
+}
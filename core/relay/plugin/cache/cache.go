@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"maps"
 	"net/http"
+	"slices"
 	"strconv"
 	"sync"
 	"time"
@@ -16,20 +17,24 @@ import (
 	"github.com/bytedance/sonic"
 	"github.com/gin-gonic/gin"
 	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/middleware"
 	"github.com/labring/aiproxy/core/model"
 	"github.com/labring/aiproxy/core/relay/adaptor"
 	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
 	"github.com/labring/aiproxy/core/relay/plugin"
 	"github.com/labring/aiproxy/core/relay/plugin/noop"
+	"github.com/labring/aiproxy/core/relay/utils"
 	gcache "github.com/patrickmn/go-cache"
 	"github.com/redis/go-redis/v9"
 )
 
 // Constants for cache metadata keys
 const (
-	cacheKey   = "cache_key"
-	cacheHit   = "cache_hit"
-	cacheValue = "cache_value"
+	cacheKey    = "cache_key"
+	cacheHit    = "cache_hit"
+	cacheValue  = "cache_value"
+	cacheStream = "cache_stream"
 )
 
 // Constants for plugin configuration
@@ -45,17 +50,20 @@ const (
 	maxBufferSize     = 4 * defaultBufferSize
 )
 
-// Item represents a cached response
+// Item represents a cached response, Events are the recorded events of a
+// stream response, whose Body is then the aggregated non-stream response
 type Item struct {
 	Body   []byte              `json:"body"`
 	Header map[string][]string `json:"header"`
 	Usage  model.Usage         `json:"usage"`
+	Events [][]byte            `json:"events,omitempty"`
 }
 
 // Cache implements caching functionality for AI requests
 type Cache struct {
 	noop.Noop
-	rdb *redis.Client
+	rdb        *redis.Client
+	getChannel plugin.GetChannel
 }
 
 var (
@@ -70,9 +78,10 @@ var (
 	}
 )
 
-// NewCachePlugin creates a new cache plugin
-func NewCachePlugin(rdb *redis.Client) plugin.Plugin {
-	return &Cache{rdb: rdb}
+// NewCachePlugin creates a new cache plugin, getChannel is used to
+// route the embedding requests of the semantic mode
+func NewCachePlugin(rdb *redis.Client, getChannel plugin.GetChannel) plugin.Plugin {
+	return &Cache{rdb: rdb, getChannel: getChannel}
 }
 
 // Cache metadata helpers
@@ -102,9 +111,14 @@ func getCacheItem(meta *meta.Meta) *Item {
 	return item
 }
 
-func setCacheHit(meta *meta.Meta, item *Item) {
+func isCacheStream(meta *meta.Meta) bool {
+	return meta.GetBool(cacheStream)
+}
+
+func setCacheHit(meta *meta.Meta, item *Item, stream bool) {
 	meta.Set(cacheHit, true)
 	meta.Set(cacheValue, item)
+	meta.Set(cacheStream, stream)
 }
 
 // Buffer pool helpers
@@ -254,10 +268,20 @@ func (c *Cache) ConvertRequest(
 	// Check cache
 	ctx := req.Context()
 	if item, ok := c.getFromCache(ctx, cacheKey); ok {
-		setCacheHit(meta, item)
+		// the same request body, so the item is of the same kind
+		setCacheHit(meta, item, item.isStream())
 		return adaptor.ConvertResult{}, nil
 	}
 
+	if pluginConfig.Semantic.Enable {
+		if item, query, similarity, ok := c.lookupSemantic(meta, store, req, body, pluginConfig); ok {
+			setCacheHit(meta, item, query.Stream)
+			setCacheSimilarity(meta, similarity)
+
+			return adaptor.ConvertResult{}, nil
+		}
+	}
+
 	return do.ConvertRequest(meta, store, req)
 }
 
@@ -282,6 +306,21 @@ type responseWriter struct {
 	cacheBody *bytes.Buffer
 	maxSize   int
 	overflow  bool
+	// the ends of the flushed events of a stream response
+	eventEnds []int
+}
+
+func newResponseWriter(w gin.ResponseWriter, buf *bytes.Buffer, maxSize int) *responseWriter {
+	return &responseWriter{
+		ResponseWriter: w,
+		maxSize:        maxSize,
+		cacheBody:      buf,
+	}
+}
+
+// events returns the captured stream body split at the flushed event ends
+func (rw *responseWriter) events() [][]byte {
+	return splitEvents(rw.cacheBody.Bytes(), rw.eventEnds)
 }
 
 func (rw *responseWriter) Write(b []byte) (int, error) {
@@ -292,6 +331,8 @@ func (rw *responseWriter) Write(b []byte) (int, error) {
 	if rw.maxSize > 0 && rw.cacheBody.Len()+len(b) > rw.maxSize {
 		rw.overflow = true
 		rw.cacheBody.Reset()
+		rw.eventEnds = nil
+
 		return rw.ResponseWriter.Write(b)
 	}
 
@@ -308,6 +349,8 @@ func (rw *responseWriter) WriteString(s string) (int, error) {
 	if rw.maxSize > 0 && rw.cacheBody.Len()+len(s) > rw.maxSize {
 		rw.overflow = true
 		rw.cacheBody.Reset()
+		rw.eventEnds = nil
+
 		return rw.ResponseWriter.WriteString(s)
 	}
 
@@ -316,6 +359,46 @@ func (rw *responseWriter) WriteString(s string) (int, error) {
 	return rw.ResponseWriter.WriteString(s)
 }
 
+// Flush marks the end of an event of a stream response
+func (rw *responseWriter) Flush() {
+	if !rw.overflow {
+		end := rw.cacheBody.Len()
+		if end > 0 && (len(rw.eventEnds) == 0 || rw.eventEnds[len(rw.eventEnds)-1] != end) {
+			rw.eventEnds = append(rw.eventEnds, end)
+		}
+	}
+
+	rw.ResponseWriter.Flush()
+}
+
+// restoreHeaders restores the headers of the cached response, the headers
+// already set for this request, such as the request id, are kept
+func restoreHeaders(ctx *gin.Context, item *Item) {
+	header := ctx.Writer.Header()
+	for k, v := range item.Header {
+		if _, ok := header[k]; ok {
+			continue
+		}
+
+		header[k] = slices.Clone(v)
+	}
+}
+
+// recordCacheHit adds the cache hit to the metadata of the request log
+func recordCacheHit(ctx *gin.Context, kind string, similarity float64) {
+	metadata := maps.Clone(middleware.GetRequestMetadata(ctx))
+	if metadata == nil {
+		metadata = make(map[string]string, 2)
+	}
+
+	metadata["cache_hit"] = kind
+	if kind == "semantic" {
+		metadata["cache_similarity"] = strconv.FormatFloat(similarity, 'f', 4, 64)
+	}
+
+	ctx.Set(middleware.RequestMetadata, metadata)
+}
+
 func (c *Cache) writeCacheHeader(ctx *gin.Context, pluginConfig *Config, value string) {
 	if pluginConfig.AddCacheHitHeader {
 		header := pluginConfig.CacheHitHeader
@@ -347,18 +430,31 @@ func (c *Cache) DoResponse(
 			return do.DoResponse(meta, store, ctx, resp)
 		}
 
-		// Restore headers from cache
-		for k, v := range item.Header {
-			for _, val := range v {
-				ctx.Header(k, val)
-			}
+		restoreHeaders(ctx, item)
+
+		if similarity, ok := getCacheSimilarity(meta); ok {
+			c.writeCacheHeader(
+				ctx,
+				pluginConfig,
+				"hit; similarity="+strconv.FormatFloat(similarity, 'f', 4, 64),
+			)
+			recordCacheHit(ctx, "semantic", similarity)
+		} else {
+			c.writeCacheHeader(ctx, pluginConfig, "hit")
+			recordCacheHit(ctx, "exact", 0)
 		}
 
-		// Override specific headers
-		ctx.Header("Content-Type", item.Header["Content-Type"][0])
-		ctx.Header("Content-Length", strconv.Itoa(len(item.Body)))
-		c.writeCacheHeader(ctx, pluginConfig, "hit")
-		_, _ = ctx.Writer.Write(item.Body)
+		switch {
+		case item.isStream() && isCacheStream(meta):
+			replayEvents(ctx, item, pluginConfig.StreamReplay)
+		case item.isStream():
+			writeAggregated(ctx, item)
+		default:
+			// Override specific headers
+			ctx.Header("Content-Type", item.Header["Content-Type"][0])
+			ctx.Header("Content-Length", strconv.Itoa(len(item.Body)))
+			_, _ = ctx.Writer.Write(item.Body)
+		}
 
 		return item.Usage, nil
 	}
@@ -373,11 +469,7 @@ func (c *Cache) DoResponse(
 	buf := getBuffer()
 	defer putBuffer(buf)
 
-	rw := &responseWriter{
-		ResponseWriter: ctx.Writer,
-		maxSize:        pluginConfig.ItemMaxSize,
-		cacheBody:      buf,
-	}
+	rw := newResponseWriter(ctx.Writer, buf, pluginConfig.ItemMaxSize)
 
 	ctx.Writer = rw
 	defer func() {
@@ -393,13 +485,32 @@ func (c *Cache) DoResponse(
 
 		// Store in cache
 		item := Item{
-			Body:   bytes.Clone(rw.cacheBody.Bytes()),
 			Header: headerMap,
 			Usage:  usage,
 		}
 
+		if utils.IsStreamResponseWithHeader(rw.Header()) {
+			item.Events = rw.events()
+			if meta.Mode == mode.ChatCompletions {
+				item.Body = aggregateEvents(item.Events)
+			}
+		} else {
+			item.Body = bytes.Clone(rw.cacheBody.Bytes())
+		}
+
 		ttl := time.Duration(pluginConfig.TTL) * time.Second
 		c.setToCache(ctx.Request.Context(), getCacheKey(meta), item, ttl)
+
+		if query := getSemanticQuery(meta); query != nil {
+			c.addSemantic(
+				ctx.Request.Context(),
+				query,
+				getCacheKey(meta),
+				item.isStream(),
+				ttl,
+				pluginConfig.Semantic.GetMaxItems(),
+			)
+		}
 	}()
 
 	return do.DoResponse(meta, store, ctx, resp)
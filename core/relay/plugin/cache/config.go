@@ -1,9 +1,56 @@
 package cache
 
 type Config struct {
-	Enable            bool   `json:"enable"`
-	TTL               int    `json:"ttl"`
-	ItemMaxSize       int    `json:"item_max_size"`
-	AddCacheHitHeader bool   `json:"add_cache_hit_header"`
-	CacheHitHeader    string `json:"cache_hit_header"`
+	Enable            bool               `json:"enable"`
+	TTL               int                `json:"ttl"`
+	ItemMaxSize       int                `json:"item_max_size"`
+	AddCacheHitHeader bool               `json:"add_cache_hit_header"`
+	CacheHitHeader    string             `json:"cache_hit_header"`
+	Semantic          SemanticConfig     `json:"semantic"`
+	StreamReplay      StreamReplayConfig `json:"stream_replay"`
+}
+
+// StreamReplayConfig paces the replay of a cached stream response, by
+// default the recorded events are flushed one by one without delay
+type StreamReplayConfig struct {
+	Interval       int `json:"interval"` // milliseconds between chunks
+	EventsPerChunk int `json:"events_per_chunk"`
+}
+
+func (c StreamReplayConfig) GetEventsPerChunk() int {
+	if c.EventsPerChunk <= 0 {
+		return 1
+	}
+
+	return c.EventsPerChunk
+}
+
+// SemanticConfig enables serving cached responses of similar prompts,
+// the last user message is embedded with the embeddings model
+type SemanticConfig struct {
+	Enable    bool    `json:"enable"`
+	ModelName string  `json:"model_name"`
+	Threshold float64 `json:"threshold"`
+	MaxItems  int     `json:"max_items"`
+}
+
+const (
+	defaultSemanticThreshold = 0.95
+	defaultSemanticMaxItems  = 1000
+)
+
+func (c SemanticConfig) GetThreshold() float64 {
+	if c.Threshold <= 0 || c.Threshold > 1 {
+		return defaultSemanticThreshold
+	}
+
+	return c.Threshold
+}
+
+func (c SemanticConfig) GetMaxItems() int {
+	if c.MaxItems <= 0 {
+		return defaultSemanticMaxItems
+	}
+
+	return c.MaxItems
 }
@@ -0,0 +1,15 @@
+package cache
+
+type SemanticQuery = semanticQuery
+
+var (
+	CosineSimilarity  = cosineSimilarity
+	AddSemantic       = (*Cache).addSemantic
+	SearchSemantic    = (*Cache).searchSemantic
+	SetToCache        = (*Cache).setToCache
+	NewResponseWriter = newResponseWriter
+	RecordedEvents    = (*responseWriter).events
+	AggregateEvents   = aggregateEvents
+	ReplayEvents      = replayEvents
+	ItemCanServe      = (*Item).canServe
+)
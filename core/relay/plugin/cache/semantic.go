@@ -0,0 +1,538 @@
+package cache
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+	"github.com/labring/aiproxy/core/relay/plugin"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	cacheSemantic            = "cache_semantic"
+	cacheSimilarity          = "cache_similarity"
+	redisSemanticCachePrefix = "cache:semantic:"
+)
+
+// semanticEntry points from the embedding of a prompt to the cached item
+type semanticEntry struct {
+	Key       string    `json:"key"`
+	Vector    []float64 `json:"vector"`
+	ExpiresAt int64     `json:"expires_at"`
+	Stream    bool      `json:"stream,omitempty"`
+}
+
+func (e *semanticEntry) expired(now int64) bool {
+	return e.ExpiresAt != 0 && e.ExpiresAt <= now
+}
+
+// semanticQuery is the embedded last user message of a request, prompts are
+// only compared within the same partition, which covers everything else in
+// the request but the stream option, so that only the last user message may
+// differ, a stream request is only served by a stream response
+type semanticQuery struct {
+	Partition string
+	Vector    []float64
+	Stream    bool
+}
+
+// semanticIndex is the in-memory index of the embedded prompts
+type semanticIndex struct {
+	mu         sync.RWMutex
+	partitions map[string][]*semanticEntry
+}
+
+var semanticCache = &semanticIndex{
+	partitions: make(map[string][]*semanticEntry),
+}
+
+func (i *semanticIndex) search(query *semanticQuery) (*semanticEntry, float64) {
+	now := time.Now().Unix()
+
+	i.mu.RLock()
+	defer i.mu.RUnlock()
+
+	return mostSimilar(i.partitions[query.Partition], query, now)
+}
+
+func (i *semanticIndex) add(partition string, entry *semanticEntry, maxItems int) {
+	now := time.Now().Unix()
+
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entries := make([]*semanticEntry, 0, len(i.partitions[partition])+1)
+	for _, e := range i.partitions[partition] {
+		if e.Key == entry.Key || e.expired(now) {
+			continue
+		}
+
+		entries = append(entries, e)
+	}
+
+	entries = append(entries, entry)
+	if len(entries) > maxItems {
+		entries = entries[len(entries)-maxItems:]
+	}
+
+	i.partitions[partition] = entries
+}
+
+func (i *semanticIndex) remove(partition, key string) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	entries := i.partitions[partition]
+	for idx, e := range entries {
+		if e.Key == key {
+			entries = append(entries[:idx:idx], entries[idx+1:]...)
+			break
+		}
+	}
+
+	if len(entries) == 0 {
+		delete(i.partitions, partition)
+		return
+	}
+
+	i.partitions[partition] = entries
+}
+
+func mostSimilar(
+	entries []*semanticEntry,
+	query *semanticQuery,
+	now int64,
+) (*semanticEntry, float64) {
+	var (
+		best       *semanticEntry
+		similarity float64
+	)
+
+	for _, e := range entries {
+		if e.expired(now) || (query.Stream && !e.Stream) {
+			continue
+		}
+
+		s := cosineSimilarity(e.Vector, query.Vector)
+		if best == nil || s > similarity {
+			best = e
+			similarity = s
+		}
+	}
+
+	return best, similarity
+}
+
+func cosineSimilarity(a, b []float64) float64 {
+	if len(a) != len(b) || len(a) == 0 {
+		return 0
+	}
+
+	var dot, normA, normB float64
+	for i := range a {
+		dot += a[i] * b[i]
+		normA += a[i] * a[i]
+		normB += b[i] * b[i]
+	}
+
+	if normA == 0 || normB == 0 {
+		return 0
+	}
+
+	return dot / (math.Sqrt(normA) * math.Sqrt(normB))
+}
+
+// Semantic metadata helpers
+func getSemanticQuery(meta *meta.Meta) *semanticQuery {
+	v, ok := meta.Get(cacheSemantic)
+	if !ok {
+		return nil
+	}
+
+	query, ok := v.(*semanticQuery)
+	if !ok {
+		return nil
+	}
+
+	return query
+}
+
+func setSemanticQuery(meta *meta.Meta, query *semanticQuery) {
+	meta.Set(cacheSemantic, query)
+}
+
+func getCacheSimilarity(meta *meta.Meta) (float64, bool) {
+	v, ok := meta.Get(cacheSimilarity)
+	if !ok {
+		return 0, false
+	}
+
+	similarity, ok := v.(float64)
+
+	return similarity, ok
+}
+
+func setCacheSimilarity(meta *meta.Meta, similarity float64) {
+	meta.Set(cacheSimilarity, similarity)
+}
+
+// semanticPartition extracts the last user message of a chat request and
+// hashes the rest of the request as the partition
+func semanticPartition(m mode.Mode, body []byte) (partition, query string, stream, ok bool) {
+	var request map[string]any
+	if err := sonic.Unmarshal(body, &request); err != nil {
+		return "", "", false, false
+	}
+
+	messages, ok := request["messages"].([]any)
+	if !ok {
+		return "", "", false, false
+	}
+
+	for i := len(messages) - 1; i >= 0; i-- {
+		msg, ok := messages[i].(map[string]any)
+		if !ok || msg["role"] != "user" {
+			continue
+		}
+
+		query = messageText(msg["content"])
+		if query == "" {
+			return "", "", false, false
+		}
+
+		rest := make(map[string]any, len(msg))
+		for k, v := range msg {
+			if k != "content" {
+				rest[k] = v
+			}
+		}
+
+		messages[i] = rest
+
+		break
+	}
+
+	if query == "" {
+		return "", "", false, false
+	}
+
+	stream, _ = request["stream"].(bool)
+
+	// fields that do not change the response, a non-stream request can be
+	// served by the aggregated result of a stream response
+	delete(request, "user")
+	delete(request, "metadata")
+	delete(request, "stream")
+	delete(request, "stream_options")
+
+	data, err := sonic.ConfigStd.Marshal(request)
+	if err != nil {
+		return "", "", false, false
+	}
+
+	hash := sha256.Sum256(data)
+
+	return strconv.Itoa(int(m)) + ":" + hex.EncodeToString(hash[:]), query, stream, true
+}
+
+// messageText returns the text of a message content, contents with
+// non-text parts are not cached semantically
+func messageText(content any) string {
+	switch content := content.(type) {
+	case string:
+		return content
+	case []any:
+		texts := make([]string, 0, len(content))
+		for _, part := range content {
+			p, ok := part.(map[string]any)
+			if !ok || p["type"] != "text" {
+				return ""
+			}
+
+			text, _ := p["text"].(string)
+			texts = append(texts, text)
+		}
+
+		return strings.Join(texts, "\n")
+	default:
+		return ""
+	}
+}
+
+// embed gets the embedding of the input through the embeddings model
+func (c *Cache) embed(
+	ctx context.Context,
+	meta *meta.Meta,
+	store adaptor.Store,
+	modelName, input string,
+) ([]float64, error) {
+	embeddingBody, err := sonic.Marshal(relaymodel.EmbeddingRequest{
+		Input: input,
+		Model: modelName,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	body, err := plugin.CallModel(
+		ctx,
+		meta,
+		store,
+		c.getChannel,
+		mode.Embeddings,
+		modelName,
+		embeddingBody,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var resp relaymodel.EmbeddingResponse
+	if err := sonic.Unmarshal(body, &resp); err != nil {
+		return nil, err
+	}
+
+	if len(resp.Data) == 0 || len(resp.Data[0].Embedding) == 0 {
+		return nil, errors.New("empty embedding response")
+	}
+
+	return resp.Data[0].Embedding, nil
+}
+
+// Redis semantic index operations, the entries of a partition are kept
+// in a hash keyed by the cache key of the item
+func (c *Cache) searchRedis(
+	ctx context.Context,
+	query *semanticQuery,
+) (*semanticEntry, float64, error) {
+	values, err := c.rdb.HGetAll(ctx, common.RedisKey(redisSemanticCachePrefix, query.Partition)).
+		Result()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	entries := make([]*semanticEntry, 0, len(values))
+	for _, v := range values {
+		var e semanticEntry
+		if err := sonic.UnmarshalString(v, &e); err != nil {
+			continue
+		}
+
+		entries = append(entries, &e)
+	}
+
+	entry, similarity := mostSimilar(entries, query, time.Now().Unix())
+
+	return entry, similarity, nil
+}
+
+// addSemanticScript indexes an entry in the hash of the partition atomically,
+// the stale entries are dropped and the entries that expire first are evicted
+// to keep at most max items, an expiry of 0 never expires
+//
+// KEYS[1]: the hash of the partition
+// ARGV: field, entry, now, max items, ttl in seconds
+var addSemanticScript = redis.NewScript(`
+	local now = tonumber(ARGV[3])
+	local max_items = tonumber(ARGV[4])
+	local ttl = tonumber(ARGV[5])
+
+	local values = redis.call("HGetAll", KEYS[1])
+	local stale = {}
+	local fields = {}
+	local expires = {}
+
+	for i = 1, #values, 2 do
+		local field = values[i]
+		local expires_at = tonumber(string.match(values[i + 1], '"expires_at":(%-?%d+)'))
+		if expires_at == nil or (expires_at ~= 0 and expires_at <= now) then
+			table.insert(stale, field)
+		elseif field ~= ARGV[1] then
+			table.insert(fields, field)
+			if expires_at == 0 then
+				expires_at = math.huge
+			end
+			table.insert(expires, expires_at)
+		end
+	end
+
+	while #fields >= max_items and #fields > 0 do
+		local oldest = 1
+		for i = 2, #fields do
+			if expires[i] < expires[oldest] then
+				oldest = i
+			end
+		end
+		table.insert(stale, fields[oldest])
+		table.remove(fields, oldest)
+		table.remove(expires, oldest)
+	end
+
+	for i = 1, #stale, 1000 do
+		redis.call("HDel", KEYS[1], unpack(stale, i, math.min(i + 999, #stale)))
+	end
+
+	redis.call("HSet", KEYS[1], ARGV[1], ARGV[2])
+	if ttl > 0 then
+		redis.call("Expire", KEYS[1], ttl)
+	end
+	return redis.status_reply("ok")
+`)
+
+func (c *Cache) addToRedis(
+	ctx context.Context,
+	partition string,
+	entry *semanticEntry,
+	ttl time.Duration,
+	maxItems int,
+) error {
+	data, err := sonic.MarshalString(entry)
+	if err != nil {
+		return err
+	}
+
+	return addSemanticScript.Run(
+		ctx,
+		c.rdb,
+		[]string{common.RedisKey(redisSemanticCachePrefix, partition)},
+		entry.Key,
+		data,
+		time.Now().Unix(),
+		maxItems,
+		int64(ttl.Seconds()),
+	).Err()
+}
+
+func (c *Cache) removeFromRedis(ctx context.Context, partition, key string) {
+	_ = c.rdb.HDel(ctx, common.RedisKey(redisSemanticCachePrefix, partition), key).Err()
+}
+
+// searchSemantic finds the most similar prompt of the partition and
+// returns its cached item if the similarity exceeds the threshold
+func (c *Cache) searchSemantic(
+	ctx context.Context,
+	query *semanticQuery,
+	threshold float64,
+) (*Item, float64, bool) {
+	var (
+		entry      *semanticEntry
+		similarity float64
+		fromRedis  bool
+	)
+
+	if c.rdb != nil {
+		var err error
+
+		entry, similarity, err = c.searchRedis(ctx, query)
+		fromRedis = err == nil
+		// If Redis fails, fallback to memory index
+	}
+
+	if !fromRedis {
+		entry, similarity = semanticCache.search(query)
+	}
+
+	if entry == nil || similarity < threshold {
+		return nil, 0, false
+	}
+
+	item, ok := c.getFromCache(ctx, entry.Key)
+	if ok && !item.canServe(query.Stream) {
+		return nil, 0, false
+	}
+
+	if !ok {
+		// the item has expired, drop its entry
+		if fromRedis {
+			c.removeFromRedis(ctx, query.Partition, entry.Key)
+		}
+
+		semanticCache.remove(query.Partition, entry.Key)
+
+		return nil, 0, false
+	}
+
+	return item, similarity, true
+}
+
+// addSemantic indexes the prompt of a cached item
+func (c *Cache) addSemantic(
+	ctx context.Context,
+	query *semanticQuery,
+	key string,
+	stream bool,
+	ttl time.Duration,
+	maxItems int,
+) {
+	entry := &semanticEntry{
+		Key:    key,
+		Vector: query.Vector,
+		Stream: stream,
+	}
+	if ttl > 0 {
+		entry.ExpiresAt = time.Now().Add(ttl).Unix()
+	}
+
+	if c.rdb != nil {
+		_ = c.addToRedis(ctx, query.Partition, entry, ttl, maxItems)
+	}
+
+	semanticCache.add(query.Partition, entry, maxItems)
+}
+
+// lookupSemantic embeds the last user message of the request and searches
+// the semantic index, the query is kept in meta to index the response on
+// a miss
+func (c *Cache) lookupSemantic(
+	meta *meta.Meta,
+	store adaptor.Store,
+	req *http.Request,
+	body []byte,
+	pluginConfig *Config,
+) (*Item, *semanticQuery, float64, bool) {
+	if meta.Mode != mode.ChatCompletions || pluginConfig.Semantic.ModelName == "" {
+		return nil, nil, 0, false
+	}
+
+	partition, input, stream, ok := semanticPartition(meta.Mode, body)
+	if !ok {
+		return nil, nil, 0, false
+	}
+
+	log := common.GetLoggerFromReq(req)
+
+	vector, err := c.embed(req.Context(), meta, store, pluginConfig.Semantic.ModelName, input)
+	if err != nil {
+		log.Warnf("cache: semantic embedding failed: %v", err)
+		return nil, nil, 0, false
+	}
+
+	query := &semanticQuery{
+		Partition: partition,
+		Vector:    vector,
+		Stream:    stream,
+	}
+	setSemanticQuery(meta, query)
+
+	item, similarity, ok := c.searchSemantic(
+		req.Context(),
+		query,
+		pluginConfig.Semantic.GetThreshold(),
+	)
+
+	return item, query, similarity, ok
+}
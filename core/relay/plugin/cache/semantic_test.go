@@ -0,0 +1,146 @@
+package cache_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/labring/aiproxy/core/relay/plugin/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCosineSimilarity(t *testing.T) {
+	assert.InDelta(t, 1, cache.CosineSimilarity([]float64{1, 2}, []float64{2, 4}), 1e-9)
+	assert.InDelta(t, 0, cache.CosineSimilarity([]float64{1, 0}, []float64{0, 1}), 1e-9)
+	assert.InDelta(t, -1, cache.CosineSimilarity([]float64{1, 0}, []float64{-1, 0}), 1e-9)
+	assert.Zero(t, cache.CosineSimilarity([]float64{1, 0}, []float64{1, 0, 0}))
+	assert.Zero(t, cache.CosineSimilarity([]float64{0, 0}, []float64{1, 0}))
+	assert.Zero(t, cache.CosineSimilarity(nil, nil))
+}
+
+func TestSemanticThreshold(t *testing.T) {
+	c := &cache.Cache{}
+	ctx := t.Context()
+	partition := t.Name()
+
+	cache.SetToCache(c, ctx, partition+"-k1", cache.Item{Body: []byte("hello")}, time.Minute)
+	cache.AddSemantic(
+		c,
+		ctx,
+		&cache.SemanticQuery{Partition: partition, Vector: []float64{1, 0}},
+		partition+"-k1",
+		false,
+		time.Minute,
+		10,
+	)
+
+	// the similarity of the query is 1/sqrt(1.01) ≈ 0.995
+	query := &cache.SemanticQuery{Partition: partition, Vector: []float64{1, 0.1}}
+
+	item, similarity, ok := cache.SearchSemantic(c, ctx, query, 0.99)
+	require.True(t, ok)
+	assert.Equal(t, "hello", string(item.Body))
+	assert.InDelta(t, 0.995, similarity, 0.001)
+
+	_, _, ok = cache.SearchSemantic(c, ctx, query, 0.999)
+	assert.False(t, ok)
+
+	// a stream request is not served by a non-stream response
+	_, _, ok = cache.SearchSemantic(
+		c,
+		ctx,
+		&cache.SemanticQuery{Partition: partition, Vector: []float64{1, 0}, Stream: true},
+		0.99,
+	)
+	assert.False(t, ok)
+
+	// prompts of other partitions are not compared
+	_, _, ok = cache.SearchSemantic(
+		c,
+		ctx,
+		&cache.SemanticQuery{Partition: partition + "-other", Vector: []float64{1, 0}},
+		0.5,
+	)
+	assert.False(t, ok)
+}
+
+func TestSemanticIndexEviction(t *testing.T) {
+	c := &cache.Cache{}
+	ctx := t.Context()
+	partition := t.Name()
+
+	vectors := map[string][]float64{
+		"k1": {1, 0, 0},
+		"k2": {0, 1, 0},
+		"k3": {0, 0, 1},
+	}
+
+	for _, key := range []string{"k1", "k2", "k3"} {
+		cache.SetToCache(c, ctx, partition+key, cache.Item{Body: []byte(key)}, time.Minute)
+		cache.AddSemantic(
+			c,
+			ctx,
+			&cache.SemanticQuery{Partition: partition, Vector: vectors[key]},
+			partition+key,
+			false,
+			time.Minute,
+			2,
+		)
+	}
+
+	// k1 is evicted from the index even though its item is still cached
+	_, _, ok := cache.SearchSemantic(
+		c,
+		ctx,
+		&cache.SemanticQuery{Partition: partition, Vector: vectors["k1"]},
+		0.9,
+	)
+	assert.False(t, ok)
+
+	for _, key := range []string{"k2", "k3"} {
+		item, _, ok := cache.SearchSemantic(
+			c,
+			ctx,
+			&cache.SemanticQuery{Partition: partition, Vector: vectors[key]},
+			0.9,
+		)
+		require.True(t, ok, key)
+		assert.Equal(t, key, string(item.Body))
+	}
+}
+
+func TestSemanticIndexMissingItem(t *testing.T) {
+	c := &cache.Cache{}
+	ctx := t.Context()
+	partition := t.Name()
+
+	// the entry of an item that is no longer cached is dropped on search
+	cache.AddSemantic(
+		c,
+		ctx,
+		&cache.SemanticQuery{Partition: partition, Vector: []float64{1, 0}},
+		partition+"-gone",
+		false,
+		time.Minute,
+		10,
+	)
+	cache.SetToCache(c, ctx, partition+"-k", cache.Item{Body: []byte("kept")}, time.Minute)
+	cache.AddSemantic(
+		c,
+		ctx,
+		&cache.SemanticQuery{Partition: partition, Vector: []float64{1, 1}},
+		partition+"-k",
+		false,
+		time.Minute,
+		10,
+	)
+
+	query := &cache.SemanticQuery{Partition: partition, Vector: []float64{1, 0}}
+
+	_, _, ok := cache.SearchSemantic(c, ctx, query, 0.5)
+	assert.False(t, ok)
+
+	item, _, ok := cache.SearchSemantic(c, ctx, query, 0.5)
+	require.True(t, ok)
+	assert.Equal(t, "kept", string(item.Body))
+}
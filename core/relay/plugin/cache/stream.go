@@ -0,0 +1,111 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/relay/plugin/streamfake"
+	"github.com/labring/aiproxy/core/relay/render"
+)
+
+// isStream reports whether the item is a recorded stream response
+func (i *Item) isStream() bool {
+	return len(i.Events) > 0
+}
+
+// canServe reports whether the item can be served to a stream or non-stream
+// request, a stream response serves non-stream requests by its aggregated body
+func (i *Item) canServe(stream bool) bool {
+	if stream {
+		return i.isStream()
+	}
+
+	return len(i.Body) > 0
+}
+
+// splitEvents splits the captured stream body at the flushed event ends
+func splitEvents(body []byte, eventEnds []int) [][]byte {
+	events := make([][]byte, 0, len(eventEnds)+1)
+
+	start := 0
+	for _, end := range eventEnds {
+		events = append(events, bytes.Clone(body[start:end]))
+		start = end
+	}
+
+	if start < len(body) {
+		events = append(events, bytes.Clone(body[start:]))
+	}
+
+	return events
+}
+
+// aggregateEvents builds the non-stream chat completion of the events of
+// a stream chat completion, it returns nil if the events can't be aggregated
+func aggregateEvents(events [][]byte) []byte {
+	var aggregator streamfake.Aggregator
+
+	for _, event := range events {
+		for line := range bytes.SplitSeq(event, []byte("\n")) {
+			if !render.IsValidSSEData(line) {
+				continue
+			}
+
+			data := render.ExtractSSEData(line)
+			if render.IsSSEDone(data) {
+				continue
+			}
+
+			_ = aggregator.Add(data)
+		}
+	}
+
+	body, err := aggregator.NonStream()
+	if err != nil {
+		return nil
+	}
+
+	return body
+}
+
+// replayEvents writes the events of a cached stream response, grouped and
+// paced by the stream replay config
+func replayEvents(ctx *gin.Context, item *Item, replay StreamReplayConfig) {
+	render.WriteSSEContentType(ctx.Writer)
+	ctx.Status(http.StatusOK)
+
+	eventsPerChunk := replay.GetEventsPerChunk()
+	interval := time.Duration(replay.Interval) * time.Millisecond
+
+	for i := 0; i < len(item.Events); i += eventsPerChunk {
+		if i > 0 && interval > 0 {
+			select {
+			case <-ctx.Request.Context().Done():
+				return
+			case <-time.After(interval):
+			}
+		}
+
+		for _, event := range item.Events[i:min(i+eventsPerChunk, len(item.Events))] {
+			_, _ = ctx.Writer.Write(event)
+		}
+
+		ctx.Writer.Flush()
+	}
+}
+
+// writeAggregated writes the aggregated body of a cached stream response
+func writeAggregated(ctx *gin.Context, item *Item) {
+	// Remove streaming-specific headers
+	ctx.Header("Cache-Control", "")
+	ctx.Header("Connection", "")
+	ctx.Header("Transfer-Encoding", "")
+	ctx.Header("X-Accel-Buffering", "")
+
+	ctx.Header("Content-Type", "application/json")
+	ctx.Header("Content-Length", strconv.Itoa(len(item.Body)))
+	_, _ = ctx.Writer.Write(item.Body)
+}
@@ -0,0 +1,174 @@
+package cache_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/relay/model"
+	"github.com/labring/aiproxy/core/relay/plugin/cache"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var openAIEvents = []string{
+	`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[{"index":0,"delta":{"role":"assistant","content":""}}]}` + "\n\n",
+	`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[{"index":0,"delta":{"content":"Hello"}}]}` + "\n\n",
+	`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[{"index":0,"delta":{"content":", world"}}]}` + "\n\n",
+	`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}` + "\n\n",
+	`data: {"id":"chatcmpl-1","object":"chat.completion.chunk","created":1700000000,"model":"gpt-4o-mini","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":3,"total_tokens":8}}` + "\n\n",
+	"data: [DONE]\n\n",
+}
+
+var anthropicEvents = []string{
+	"event: message_start\n" +
+		`data: {"type":"message_start","message":{"id":"msg_1","type":"message","role":"assistant","content":[],"model":"claude-3-5-haiku","stop_reason":null,"usage":{"input_tokens":5,"output_tokens":1}}}` + "\n\n",
+	"event: content_block_start\n" +
+		`data: {"type":"content_block_start","index":0,"content_block":{"type":"text","text":""}}` + "\n\n",
+	"event: ping\n" +
+		`data: {"type":"ping"}` + "\n\n",
+	"event: content_block_delta\n" +
+		`data: {"type":"content_block_delta","index":0,"delta":{"type":"text_delta","text":"Hello"}}` + "\n\n",
+	"event: content_block_stop\n" +
+		`data: {"type":"content_block_stop","index":0}` + "\n\n",
+	"event: message_delta\n" +
+		`data: {"type":"message_delta","delta":{"stop_reason":"end_turn"},"usage":{"output_tokens":3}}` + "\n\n",
+	"event: message_stop\n" +
+		`data: {"type":"message_stop"}` + "\n\n",
+}
+
+var geminiEvents = []string{
+	`data: {"candidates":[{"content":{"parts":[{"text":"Hello"}],"role":"model"},"index":0}],"modelVersion":"gemini-2.0-flash"}` + "\r\n\r\n",
+	`data: {"candidates":[{"content":{"parts":[{"text":", world"}],"role":"model"},"finishReason":"STOP","index":0}],"usageMetadata":{"promptTokenCount":5,"candidatesTokenCount":3,"totalTokenCount":8},"modelVersion":"gemini-2.0-flash"}` + "\r\n\r\n",
+}
+
+func newTestContext() (*gin.Context, *httptest.ResponseRecorder) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/", nil)
+
+	return c, w
+}
+
+// record writes the events through the cache response writer like an
+// adaptor does, flushing after each event
+func record(t *testing.T, events []string) [][]byte {
+	t.Helper()
+
+	c, w := newTestContext()
+	rw := cache.NewResponseWriter(c.Writer, &bytes.Buffer{}, 0)
+
+	for _, event := range events {
+		// split the event over two writes, only the flush ends it
+		half := len(event) / 2
+
+		_, err := rw.WriteString(event[:half])
+		require.NoError(t, err)
+		_, err = rw.Write([]byte(event[half:]))
+		require.NoError(t, err)
+
+		rw.Flush()
+		// a flush without a write doesn't record an empty event
+		rw.Flush()
+	}
+
+	require.Equal(t, strings.Join(events, ""), w.Body.String())
+
+	return cache.RecordedEvents(rw)
+}
+
+func replay(item *cache.Item, config cache.StreamReplayConfig) *httptest.ResponseRecorder {
+	c, w := newTestContext()
+	cache.ReplayEvents(c, item, config)
+
+	return w
+}
+
+func TestStreamRoundTrip(t *testing.T) {
+	streams := map[string][]string{
+		"openai":    openAIEvents,
+		"anthropic": anthropicEvents,
+		"gemini":    geminiEvents,
+	}
+
+	for name, events := range streams {
+		t.Run(name, func(t *testing.T) {
+			recorded := record(t, events)
+			require.Len(t, recorded, len(events))
+
+			for i, event := range events {
+				assert.Equal(t, event, string(recorded[i]))
+			}
+
+			item := &cache.Item{Events: recorded}
+			assert.True(t, cache.ItemCanServe(item, true))
+
+			for _, eventsPerChunk := range []int{0, 1, 2, len(events) + 1} {
+				w := replay(item, cache.StreamReplayConfig{EventsPerChunk: eventsPerChunk})
+
+				assert.Equal(t, http.StatusOK, w.Code)
+				assert.Equal(t, "text/event-stream", w.Header().Get("Content-Type"))
+				assert.Equal(t, strings.Join(events, ""), w.Body.String())
+			}
+		})
+	}
+}
+
+func TestStreamUnflushedTail(t *testing.T) {
+	c, _ := newTestContext()
+	rw := cache.NewResponseWriter(c.Writer, &bytes.Buffer{}, 0)
+
+	_, _ = rw.WriteString(openAIEvents[0])
+	rw.Flush()
+	_, _ = rw.WriteString(openAIEvents[1])
+
+	recorded := cache.RecordedEvents(rw)
+	require.Len(t, recorded, 2)
+	assert.Equal(t, openAIEvents[1], string(recorded[1]))
+}
+
+func TestStreamOverflow(t *testing.T) {
+	c, w := newTestContext()
+	rw := cache.NewResponseWriter(c.Writer, &bytes.Buffer{}, len(openAIEvents[0])+1)
+
+	_, _ = rw.WriteString(openAIEvents[0])
+	rw.Flush()
+	_, _ = rw.WriteString(openAIEvents[1])
+	rw.Flush()
+
+	assert.Empty(t, cache.RecordedEvents(rw))
+	assert.Equal(t, openAIEvents[0]+openAIEvents[1], w.Body.String())
+}
+
+func TestStreamAggregate(t *testing.T) {
+	item := &cache.Item{Events: record(t, openAIEvents)}
+	item.Body = cache.AggregateEvents(item.Events)
+	require.NotEmpty(t, item.Body)
+	assert.True(t, cache.ItemCanServe(item, false))
+
+	var resp model.TextResponse
+	require.NoError(t, sonic.Unmarshal(item.Body, &resp))
+
+	assert.Equal(t, "chatcmpl-1", resp.ID)
+	assert.Equal(t, "chat.completion", resp.Object)
+	assert.Equal(t, "gpt-4o-mini", resp.Model)
+	require.Len(t, resp.Choices, 1)
+	assert.Equal(t, "assistant", resp.Choices[0].Message.Role)
+	assert.Equal(t, "Hello, world", resp.Choices[0].Message.StringContent())
+	assert.Equal(t, model.FinishReasonStop, resp.Choices[0].FinishReason)
+	assert.Equal(t, int64(8), resp.Usage.TotalTokens)
+}
+
+func TestStreamAggregateInvalid(t *testing.T) {
+	assert.Nil(t, cache.AggregateEvents(nil))
+	assert.Nil(t, cache.AggregateEvents([][]byte{[]byte("data: [DONE]\n\n")}))
+
+	// a stream without an aggregated body only serves stream requests
+	item := &cache.Item{Events: record(t, anthropicEvents)}
+	assert.True(t, cache.ItemCanServe(item, true))
+	assert.False(t, cache.ItemCanServe(item, false))
+}
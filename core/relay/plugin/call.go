@@ -0,0 +1,144 @@
+package plugin
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/reqlimit"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/monitor"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/adaptors"
+	"github.com/labring/aiproxy/core/relay/controller"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+)
+
+// GetChannel returns a channel of the model for the mode, it is used by the
+// plugins calling a model through the proxy
+type GetChannel func(modelName string, mode mode.Mode) (*model.Channel, error)
+
+// ModelCall is a call of a plugin to a model through the proxy, it is billed
+// and logged with the request the plugin made it for
+type ModelCall struct {
+	Meta  *meta.Meta
+	Usage model.Usage
+}
+
+const modelCallsKey = "plugin_model_calls"
+
+// GetModelCalls returns the calls of the plugins to the models for the request
+func GetModelCalls(m *meta.Meta) []ModelCall {
+	calls, _ := m.Get(modelCallsKey)
+	v, _ := calls.([]ModelCall)
+	return v
+}
+
+func addModelCall(m *meta.Meta, call ModelCall) {
+	m.Set(modelCallsKey, append(GetModelCalls(m), call))
+}
+
+// CallModel calls the model of the mode with the body through a channel of
+// getChannel, the channel concurrency and rpm limits are applied like to the
+// relayed requests, the response body is returned and the usage is added to
+// the model calls of the parent request
+func CallModel(
+	ctx context.Context,
+	parent *meta.Meta,
+	store adaptor.Store,
+	getChannel GetChannel,
+	m mode.Mode,
+	modelName string,
+	body []byte,
+) ([]byte, error) {
+	if getChannel == nil {
+		return nil, errors.New("channel getter is not set")
+	}
+
+	if modelName == "" {
+		return nil, errors.New("model name is required")
+	}
+
+	channel, err := getChannel(modelName, m)
+	if err != nil {
+		return nil, err
+	}
+
+	callMeta := meta.NewMeta(
+		channel,
+		m,
+		modelName,
+		model.ModelConfig{
+			Model: modelName,
+			Type:  m,
+		},
+		meta.WithRequestID(parent.RequestID),
+		meta.WithGroup(parent.Group),
+		meta.WithToken(parent.Token),
+	)
+
+	// the request body is cached in the request context, so the call must not
+	// inherit the values of the parent request context
+	callCtx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stop := context.AfterFunc(ctx, cancel)
+	defer stop()
+
+	done, ok := monitor.AcquireInFlight(
+		callCtx,
+		modelName,
+		int64(callMeta.Channel.ID),
+		callMeta.Channel.MaxConcurrency,
+		callMeta.Channel.ModelMaxConcurrency,
+	)
+	if !ok {
+		return nil, errors.New("the channel reached its max concurrency")
+	}
+	defer done()
+
+	_, _, reserved := reqlimit.ReserveChannelModelRequest(
+		context.Background(),
+		strconv.Itoa(callMeta.Channel.ID),
+		modelName,
+		callMeta.Channel.RPM,
+		callMeta.Channel.ModelRPM,
+	)
+	if !reserved {
+		return nil, errors.New("the channel reached its max requests per minute")
+	}
+
+	adaptor, ok := adaptors.GetAdaptor(callMeta.Channel.Type)
+	if !ok {
+		return nil, errors.New("adaptor not found")
+	}
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	c.Request, err = http.NewRequestWithContext(
+		callCtx,
+		http.MethodPost,
+		"",
+		bytes.NewReader(body),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	result := controller.Handle(adaptor, c, callMeta, store)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	addModelCall(parent, ModelCall{Meta: callMeta, Usage: result.Usage})
+
+	return w.Body.Bytes(), nil
+}
@@ -0,0 +1,92 @@
+package plugin_test
+
+import (
+	"testing"
+
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/monitor"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/labring/aiproxy/core/relay/plugin"
+	"github.com/labring/aiproxy/core/relay/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const embeddingResponse = `{"object":"list","model":"text-embedding",` +
+	`"data":[{"object":"embedding","index":0,"embedding":[0.1,0.2]}],` +
+	`"usage":{"prompt_tokens":7,"total_tokens":7}}`
+
+func TestCallModel(t *testing.T) {
+	channel := plugintest.NewChannel(t, 1000001, embeddingResponse)
+	getChannel := func(string, mode.Mode) (*model.Channel, error) {
+		return channel, nil
+	}
+
+	parent := meta.NewMeta(nil, mode.ChatCompletions, "gpt-4o", model.ModelConfig{},
+		meta.WithRequestID("req-1"),
+		meta.WithGroup(model.GroupCache{ID: "g1"}),
+	)
+
+	body, err := plugin.CallModel(
+		t.Context(),
+		parent,
+		nil,
+		getChannel,
+		mode.Embeddings,
+		"text-embedding",
+		[]byte(`{"model":"text-embedding","input":"hello"}`),
+	)
+	require.NoError(t, err)
+	assert.Contains(t, string(body), `"embedding":[0.1,0.2]`)
+
+	// the usage is billed with the request the call was made for
+	calls := plugin.GetModelCalls(parent)
+	require.Len(t, calls, 1)
+	assert.Equal(t, "text-embedding", calls[0].Meta.OriginModel)
+	assert.Equal(t, mode.Embeddings, calls[0].Meta.Mode)
+	assert.Equal(t, "req-1", calls[0].Meta.RequestID)
+	assert.Equal(t, "g1", calls[0].Meta.Group.ID)
+	assert.Equal(t, channel.ID, calls[0].Meta.Channel.ID)
+	assert.Equal(t, model.ZeroNullInt64(7), calls[0].Usage.InputTokens)
+}
+
+func TestCallModelMaxConcurrency(t *testing.T) {
+	channel := plugintest.NewChannel(t, 1000002, embeddingResponse)
+	channel.MaxConcurrency = 1
+	getChannel := func(string, mode.Mode) (*model.Channel, error) {
+		return channel, nil
+	}
+
+	done, ok := monitor.AcquireInFlight(t.Context(), "text-embedding", int64(channel.ID), 1, 0)
+	require.True(t, ok)
+
+	parent := meta.NewMeta(nil, mode.ChatCompletions, "gpt-4o", model.ModelConfig{})
+
+	// the call takes a slot of the channel like the relayed requests
+	_, err := plugin.CallModel(
+		t.Context(),
+		parent,
+		nil,
+		getChannel,
+		mode.Embeddings,
+		"text-embedding",
+		[]byte(`{"model":"text-embedding","input":"hello"}`),
+	)
+	require.ErrorContains(t, err, "max concurrency")
+	assert.Empty(t, plugin.GetModelCalls(parent))
+
+	done()
+
+	_, err = plugin.CallModel(
+		t.Context(),
+		parent,
+		nil,
+		getChannel,
+		mode.Embeddings,
+		"text-embedding",
+		[]byte(`{"model":"text-embedding","input":"hello"}`),
+	)
+	require.NoError(t, err)
+	assert.Len(t, plugin.GetModelCalls(parent), 1)
+}
@@ -0,0 +1,65 @@
+package contextoverflow
+
+const (
+	ActionReject    = "reject"
+	ActionTruncate  = "truncate"
+	ActionSummarize = "summarize"
+)
+
+const (
+	defaultHeader           = "X-Aiproxy-Context-Overflow"
+	defaultSummaryMaxTokens = 1024
+	defaultSummaryPrompt    = "Summarize the following conversation between a user and an assistant. " +
+		"Keep the facts, the decisions, the open questions and the names that later messages may refer to. " +
+		"Reply with the summary only."
+)
+
+type Config struct {
+	Enable bool `json:"enable"`
+	// Action is reject, truncate or summarize, default is reject
+	Action string `json:"action"`
+	// MaxInputTokens overrides the limit of the model config, the limit of the
+	// model config is max_input_tokens, or max_context_tokens minus the max
+	// output tokens of the request
+	MaxInputTokens int64         `json:"max_input_tokens"`
+	Summary        SummaryConfig `json:"summary"`
+	// Header reports the action in the response, default is X-Aiproxy-Context-Overflow
+	Header string `json:"header"`
+}
+
+// SummaryConfig is the model that summarizes the dropped messages of the summarize action
+type SummaryConfig struct {
+	ModelName string `json:"model_name"`
+	// Prompt is the system prompt of the summary request
+	Prompt string `json:"prompt"`
+	// MaxTokens limits the summary, its room is reserved in the input, default is 1024
+	MaxTokens int64 `json:"max_tokens"`
+}
+
+func (c *Config) GetAction() string {
+	if c.Action == "" {
+		return ActionReject
+	}
+	return c.Action
+}
+
+func (c *Config) GetHeader() string {
+	if c.Header == "" {
+		return defaultHeader
+	}
+	return c.Header
+}
+
+func (c *SummaryConfig) GetPrompt() string {
+	if c.Prompt == "" {
+		return defaultSummaryPrompt
+	}
+	return c.Prompt
+}
+
+func (c *SummaryConfig) GetMaxTokens() int64 {
+	if c.MaxTokens <= 0 {
+		return defaultSummaryMaxTokens
+	}
+	return c.MaxTokens
+}
@@ -0,0 +1,330 @@
+package contextoverflow
+
+import (
+	"slices"
+	"strings"
+
+	"github.com/bytedance/sonic"
+	"github.com/labring/aiproxy/core/relay/adaptor/openai"
+	"github.com/labring/aiproxy/core/relay/mode"
+)
+
+// tokensPerMessage is the overhead of the role and the delimiters of a message,
+// tokensPerReply primes the reply of the assistant
+const (
+	tokensPerMessage = 3
+	tokensPerReply   = 3
+)
+
+// format describes the conversation of a request format
+type format struct {
+	// messagesKey holds the messages of the conversation
+	messagesKey string
+	// fixedKeys hold the parts of the request that are always sent, like the
+	// system prompt and the tools
+	fixedKeys []string
+	// contentKey holds the content of a message
+	contentKey string
+	// pinned messages are never dropped
+	pinned func(msg map[string]any) bool
+	// turnStart reports whether a message starts a turn of the user,
+	// the kept messages always start with a turn
+	turnStart func(msg map[string]any) bool
+	textPart  func(text string) any
+}
+
+var formats = map[mode.Mode]format{
+	mode.ChatCompletions: {
+		messagesKey: "messages",
+		fixedKeys:   []string{"tools", "functions"},
+		contentKey:  "content",
+		pinned: func(msg map[string]any) bool {
+			role, _ := msg["role"].(string)
+			return role == "system" || role == "developer"
+		},
+		turnStart: func(msg map[string]any) bool {
+			return msg["role"] == "user"
+		},
+		textPart: func(text string) any {
+			return map[string]any{"type": "text", "text": text}
+		},
+	},
+	mode.Anthropic: {
+		messagesKey: "messages",
+		fixedKeys:   []string{"system", "tools"},
+		contentKey:  "content",
+		turnStart: func(msg map[string]any) bool {
+			return msg["role"] == "user" && !hasPart(msg["content"], "type", "tool_result")
+		},
+		textPart: func(text string) any {
+			return map[string]any{"type": "text", "text": text}
+		},
+	},
+	mode.Gemini: {
+		messagesKey: "contents",
+		fixedKeys:   []string{"systemInstruction", "system_instruction", "tools"},
+		contentKey:  "parts",
+		turnStart: func(msg map[string]any) bool {
+			role, _ := msg["role"].(string)
+			return (role == "" || role == "user") &&
+				!hasPart(msg["parts"], "functionResponse", nil) &&
+				!hasPart(msg["parts"], "function_response", nil)
+		},
+		textPart: func(text string) any {
+			return map[string]any{"text": text}
+		},
+	},
+}
+
+// hasPart reports whether a part of the content has the key,
+// and the value when it is not nil
+func hasPart(content any, key string, value any) bool {
+	parts, ok := content.([]any)
+	if !ok {
+		return false
+	}
+
+	return slices.ContainsFunc(parts, func(p any) bool {
+		part, ok := p.(map[string]any)
+		if !ok {
+			return false
+		}
+
+		v, ok := part[key]
+
+		return ok && (value == nil || v == value)
+	})
+}
+
+// skipKeys hold the values that are not texts of the prompt,
+// the images, the audios and the files are not counted
+var skipKeys = map[string]struct{}{
+	"type":          {},
+	"role":          {},
+	"id":            {},
+	"tool_call_id":  {},
+	"tool_use_id":   {},
+	"cache_control": {},
+	"image_url":     {},
+	"input_audio":   {},
+	"file":          {},
+	"source":        {},
+	"inline_data":   {},
+	"inlineData":    {},
+	"file_data":     {},
+	"fileData":      {},
+}
+
+// walkTexts calls fn with the texts of the value
+func walkTexts(v any, fn func(string)) {
+	switch v := v.(type) {
+	case string:
+		fn(v)
+	case []any:
+		for _, e := range v {
+			walkTexts(e, fn)
+		}
+	case map[string]any:
+		for k, e := range v {
+			if _, ok := skipKeys[k]; !ok {
+				walkTexts(e, fn)
+			}
+		}
+	}
+}
+
+func messageText(msg any) string {
+	var b strings.Builder
+
+	walkTexts(msg, func(text string) {
+		if b.Len() > 0 {
+			b.WriteString("\n")
+		}
+
+		b.WriteString(text)
+	})
+
+	return b.String()
+}
+
+func countMessage(msg any, model string) int64 {
+	return tokensPerMessage + openai.CountTokenText(messageText(msg), model)
+}
+
+// countFixed counts the parts of the request that are always sent, the tools
+// are counted by their json since their schemas are sent to the model as well
+func countFixed(f format, body map[string]any, model string) int64 {
+	var tokens int64
+
+	for _, key := range f.fixedKeys {
+		v, ok := body[key]
+		if !ok {
+			continue
+		}
+
+		if key == "tools" || key == "functions" {
+			raw, err := sonic.MarshalString(v)
+			if err == nil {
+				tokens += openai.CountTokenText(raw, model)
+			}
+
+			continue
+		}
+
+		tokens += openai.CountTokenText(messageText(v), model)
+	}
+
+	return tokens
+}
+
+// conversation is the counted messages of a request
+type conversation struct {
+	format   format
+	model    string
+	messages []any
+	tokens   []int64
+	// base is the tokens that are always sent, the fixed parts, the pinned
+	// messages and the reply priming
+	base int64
+}
+
+func newConversation(f format, body map[string]any, model string) (*conversation, bool) {
+	messages, ok := body[f.messagesKey].([]any)
+	if !ok {
+		return nil, false
+	}
+
+	c := &conversation{
+		format:   f,
+		model:    model,
+		messages: messages,
+		tokens:   make([]int64, len(messages)),
+		base:     countFixed(f, body, model) + tokensPerReply,
+	}
+
+	for i, msg := range messages {
+		c.tokens[i] = countMessage(msg, model)
+		if c.pinned(i) {
+			c.base += c.tokens[i]
+		}
+	}
+
+	return c, true
+}
+
+func (c *conversation) pinned(i int) bool {
+	msg, ok := c.messages[i].(map[string]any)
+	return ok && c.format.pinned != nil && c.format.pinned(msg)
+}
+
+func (c *conversation) turnStart(i int) bool {
+	msg, ok := c.messages[i].(map[string]any)
+	return ok && !c.pinned(i) && c.format.turnStart(msg)
+}
+
+// total is the input tokens of the whole conversation
+func (c *conversation) total() int64 {
+	return c.keptTokens(0)
+}
+
+// keptTokens is the input tokens when the messages before start are dropped,
+// the pinned messages are always kept
+func (c *conversation) keptTokens(start int) int64 {
+	tokens := c.base
+	for i := start; i < len(c.messages); i++ {
+		if !c.pinned(i) {
+			tokens += c.tokens[i]
+		}
+	}
+
+	return tokens
+}
+
+// cut returns the first turn start from which the kept messages and the
+// reserved tokens fit the limit, false when even the last turn does not fit
+func (c *conversation) cut(limit, reserved int64) (int, bool) {
+	for i := range c.messages {
+		if i > 0 && c.turnStart(i) && c.keptTokens(i)+reserved <= limit {
+			return i, true
+		}
+	}
+
+	return 0, false
+}
+
+// dropped returns the messages before start that are not pinned
+func (c *conversation) dropped(start int) []any {
+	var dropped []any
+
+	for i := range start {
+		if !c.pinned(i) {
+			dropped = append(dropped, c.messages[i])
+		}
+	}
+
+	return dropped
+}
+
+// kept returns the pinned messages before start and the messages from start,
+// the summary is prepended to the content of the first kept turn
+func (c *conversation) kept(start int, summary string) []any {
+	kept := make([]any, 0, len(c.messages)-start+1)
+
+	for i := range start {
+		if c.pinned(i) {
+			kept = append(kept, c.messages[i])
+		}
+	}
+
+	kept = append(kept, c.messages[start:]...)
+
+	if summary != "" {
+		if msg, ok := c.messages[start].(map[string]any); ok {
+			c.prependText(msg, summary)
+		}
+	}
+
+	return kept
+}
+
+func (c *conversation) prependText(msg map[string]any, text string) {
+	key := c.format.contentKey
+
+	switch content := msg[key].(type) {
+	case string:
+		msg[key] = text + "\n\n" + content
+	case []any:
+		msg[key] = append([]any{c.format.textPart(text)}, content...)
+	default:
+		msg[key] = []any{c.format.textPart(text)}
+	}
+}
+
+// transcript formats the messages for the summary model
+func transcript(messages []any) string {
+	var b strings.Builder
+
+	for _, msg := range messages {
+		text := messageText(msg)
+		if text == "" {
+			continue
+		}
+
+		role := "user"
+		if m, ok := msg.(map[string]any); ok {
+			if r, ok := m["role"].(string); ok && r != "" {
+				role = r
+			}
+		}
+
+		if b.Len() > 0 {
+			b.WriteString("\n\n")
+		}
+
+		b.WriteString(role)
+		b.WriteString(": ")
+		b.WriteString(text)
+	}
+
+	return b.String()
+}
@@ -0,0 +1,3 @@
+package contextoverflow
+
+const DefaultHeader = defaultHeader
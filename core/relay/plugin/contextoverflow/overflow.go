@@ -0,0 +1,329 @@
+// Package contextoverflow counts the input tokens of the conversations before
+// they are sent to the providers, and rejects, truncates or summarizes the
+// conversations that exceed the context window of the model
+package contextoverflow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/adaptor/openai"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+	"github.com/labring/aiproxy/core/relay/plugin"
+	"github.com/labring/aiproxy/core/relay/plugin/noop"
+	"github.com/labring/aiproxy/core/relay/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "context-overflow"
+
+	ContextLengthExceeded = "context_length_exceeded"
+
+	metaResultKey = "context-overflow-result"
+	summaryPrefix = "Summary of the earlier conversation:\n"
+)
+
+var _ plugin.Plugin = (*ContextOverflow)(nil)
+
+type ContextOverflow struct {
+	noop.Noop
+	getChannel plugin.GetChannel
+}
+
+// NewContextOverflowPlugin creates a new context overflow plugin, getChannel
+// is used to route the requests of the summary model
+func NewContextOverflowPlugin(getChannel plugin.GetChannel) plugin.Plugin {
+	return &ContextOverflow{getChannel: getChannel}
+}
+
+func (p *ContextOverflow) getConfig(meta *meta.Meta) (Config, error) {
+	pluginConfig := Config{}
+	if err := meta.ModelConfig.LoadPluginConfig(PluginName, &pluginConfig); err != nil {
+		return Config{}, err
+	}
+
+	return pluginConfig, nil
+}
+
+// inputLimit returns the max input tokens of the request, false when the model has no limit
+func inputLimit(meta *meta.Meta, config Config, req *http.Request) (int64, bool) {
+	if config.MaxInputTokens > 0 {
+		return config.MaxInputTokens, true
+	}
+
+	if limit, ok := meta.ModelConfig.MaxInputTokens(); ok && limit > 0 {
+		return int64(limit), true
+	}
+
+	if limit, ok := meta.ModelConfig.MaxContextTokens(); ok && limit > 0 {
+		return int64(limit) - utils.GetRequestMaxOutputTokens(req), true
+	}
+
+	return 0, false
+}
+
+// Result is the action applied to an overflowed conversation
+type Result struct {
+	Action  string
+	Dropped int
+	Before  int64
+	After   int64
+}
+
+func (r Result) String() string {
+	if r.Action == ActionReject {
+		return fmt.Sprintf("%s:tokens=%d", r.Action, r.Before)
+	}
+
+	return fmt.Sprintf("%s:dropped=%d,tokens=%d->%d", r.Action, r.Dropped, r.Before, r.After)
+}
+
+func exceededError(m mode.Mode, message string) adaptor.Error {
+	return relaymodel.WrapperErrorWithMessage(
+		m,
+		http.StatusBadRequest,
+		message,
+		relaymodel.WithType(ContextLengthExceeded),
+		relaymodel.WithCode(ContextLengthExceeded),
+	)
+}
+
+// ConvertRequest counts the input tokens before the request is converted,
+// the conversations that exceed the limit are handled by the action
+func (p *ContextOverflow) ConvertRequest(
+	meta *meta.Meta,
+	store adaptor.Store,
+	req *http.Request,
+	do adaptor.ConvertRequest,
+) (adaptor.ConvertResult, error) {
+	f, ok := formats[meta.Mode]
+	if !ok {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	config, err := p.getConfig(meta)
+	if err != nil || !config.Enable {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	action := config.GetAction()
+	switch action {
+	case ActionReject, ActionTruncate, ActionSummarize:
+	default:
+		return adaptor.ConvertResult{}, relaymodel.WrapperErrorWithMessage(
+			meta.Mode,
+			http.StatusInternalServerError,
+			"invalid context overflow action: "+action,
+		)
+	}
+
+	limit, ok := inputLimit(meta, config, req)
+	if !ok {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	bodyBytes, err := common.GetRequestBodyReusable(req)
+	if err != nil {
+		return adaptor.ConvertResult{}, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	var body map[string]any
+	if err := sonic.Unmarshal(bodyBytes, &body); err != nil {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	conv, ok := newConversation(f, body, meta.ActualModel)
+	if !ok {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	total := conv.total()
+	if total <= limit {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	log := common.GetLoggerFromReq(req)
+
+	result, err := p.handle(req.Context(), log, meta, store, config, action, conv, limit)
+	if err != nil {
+		log.Data["context_overflow"] = Result{Action: ActionReject, Before: total}.String()
+		return adaptor.ConvertResult{}, exceededError(meta.Mode, err.Error())
+	}
+
+	log.Data["context_overflow"] = result.String()
+	meta.Set(metaResultKey, result.Result)
+
+	body[f.messagesKey] = result.messages
+
+	newBody, err := sonic.Marshal(body)
+	if err != nil {
+		return adaptor.ConvertResult{}, err
+	}
+
+	common.SetRequestBody(req, newBody)
+	defer func() {
+		common.SetRequestBody(req, bodyBytes)
+	}()
+
+	return do.ConvertRequest(meta, store, req)
+}
+
+type handled struct {
+	Result
+	messages []any
+}
+
+// handle applies the action to the conversation, an error means the
+// conversation can not fit the limit and the request is rejected
+func (p *ContextOverflow) handle(
+	ctx context.Context,
+	log *logrus.Entry,
+	meta *meta.Meta,
+	store adaptor.Store,
+	config Config,
+	action string,
+	conv *conversation,
+	limit int64,
+) (handled, error) {
+	total := conv.total()
+
+	if action == ActionReject {
+		return handled{}, fmt.Errorf(
+			"the input has %d tokens, exceeding the limit of %d tokens of the model",
+			total,
+			limit,
+		)
+	}
+
+	if action == ActionSummarize {
+		// the summary is prepended to the first kept turn with its prefix
+		reserved := config.Summary.GetMaxTokens() +
+			openai.CountTokenText(summaryPrefix, conv.model) + tokensPerMessage
+		if start, ok := conv.cut(limit, reserved); ok {
+			dropped := conv.dropped(start)
+
+			summary, err := p.summarize(ctx, meta, store, config.Summary, transcript(dropped))
+			if err == nil {
+				summary = summaryPrefix + summary
+				after := conv.keptTokens(start) + openai.CountTokenText(summary, conv.model)
+
+				return handled{
+					Result: Result{
+						Action:  ActionSummarize,
+						Dropped: len(dropped),
+						Before:  total,
+						After:   after,
+					},
+					messages: conv.kept(start, summary),
+				}, nil
+			}
+
+			log.Warnf("context overflow summary failed, fallback to truncate: %v", err)
+		}
+	}
+
+	start, ok := conv.cut(limit, 0)
+	if !ok {
+		return handled{}, fmt.Errorf(
+			"the input has %d tokens, and its last turn alone exceeds the limit of %d tokens of the model",
+			total,
+			limit,
+		)
+	}
+
+	return handled{
+		Result: Result{
+			Action:  ActionTruncate,
+			Dropped: len(conv.dropped(start)),
+			Before:  total,
+			After:   conv.keptTokens(start),
+		},
+		messages: conv.kept(start, ""),
+	}, nil
+}
+
+// summarize calls the summary model with the transcript of the dropped messages
+func (p *ContextOverflow) summarize(
+	ctx context.Context,
+	meta *meta.Meta,
+	store adaptor.Store,
+	config SummaryConfig,
+	input string,
+) (string, error) {
+	if input == "" {
+		return "", errors.New("no text to summarize")
+	}
+
+	summaryBody, err := sonic.Marshal(map[string]any{
+		"model":      config.ModelName,
+		"stream":     false,
+		"max_tokens": config.GetMaxTokens(),
+		"messages": []map[string]any{
+			{"role": "system", "content": config.GetPrompt()},
+			{"role": "user", "content": input},
+		},
+	})
+	if err != nil {
+		return "", err
+	}
+
+	body, err := plugin.CallModel(
+		ctx,
+		meta,
+		store,
+		p.getChannel,
+		mode.ChatCompletions,
+		config.ModelName,
+		summaryBody,
+	)
+	if err != nil {
+		return "", err
+	}
+
+	contentNode, err := sonic.Get(body, "choices", 0, "message", "content")
+	if err != nil {
+		return "", err
+	}
+
+	summary, err := contentNode.String()
+	if err != nil {
+		return "", err
+	}
+
+	if summary == "" {
+		return "", errors.New("empty summary")
+	}
+
+	return summary, nil
+}
+
+// DoResponse reports the action in the response header
+func (p *ContextOverflow) DoResponse(
+	meta *meta.Meta,
+	store adaptor.Store,
+	c *gin.Context,
+	resp *http.Response,
+	do adaptor.DoResponse,
+) (model.Usage, adaptor.Error) {
+	result, ok := meta.Get(metaResultKey)
+	if !ok {
+		return do.DoResponse(meta, store, c, resp)
+	}
+
+	config, err := p.getConfig(meta)
+	if r, ok := result.(Result); ok && err == nil {
+		c.Header(config.GetHeader(), r.String())
+	}
+
+	return do.DoResponse(meta, store, c, resp)
+}
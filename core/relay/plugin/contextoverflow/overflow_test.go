@@ -0,0 +1,339 @@
+package contextoverflow_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/labring/aiproxy/core/relay/plugin"
+	"github.com/labring/aiproxy/core/relay/plugin/contextoverflow"
+	"github.com/labring/aiproxy/core/relay/plugin/plugintest"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// text returns a text of about n tokens
+func text(n int) string {
+	return strings.Repeat("hello ", n)
+}
+
+func roles(t *testing.T, messages any) []string {
+	t.Helper()
+
+	list, ok := messages.([]any)
+	require.True(t, ok)
+
+	roles := make([]string, 0, len(list))
+	for _, m := range list {
+		msg, ok := m.(map[string]any)
+		require.True(t, ok)
+
+		role, _ := msg["role"].(string)
+		roles = append(roles, role)
+	}
+
+	return roles
+}
+
+func chatBody() map[string]any {
+	return map[string]any{
+		"model": "gpt-4o",
+		"messages": []map[string]any{
+			{"role": "system", "content": "be brief"},
+			{"role": "user", "content": text(100)},
+			{"role": "assistant", "content": text(100)},
+			{"role": "user", "content": text(100)},
+			{
+				"role": "assistant",
+				"tool_calls": []map[string]any{
+					{
+						"id":       "call_1",
+						"type":     "function",
+						"function": map[string]any{"name": "lookup", "arguments": "{}"},
+					},
+				},
+			},
+			{"role": "tool", "tool_call_id": "call_1", "content": text(100)},
+			{"role": "user", "content": text(10)},
+		},
+	}
+}
+
+type convertResult struct {
+	meta *meta.Meta
+	// body is the body passed to the adaptor
+	body map[string]any
+	// header is the header reported in the response
+	header string
+	err    error
+}
+
+// convert runs the plugin on the request body of the mode with the plugin config
+func convert(
+	t *testing.T,
+	m mode.Mode,
+	body map[string]any,
+	config map[string]any,
+	opts ...model.ModelConfigOption,
+) convertResult {
+	t.Helper()
+
+	return convertWith(t, contextoverflow.NewContextOverflowPlugin(nil), m, body, config, opts...)
+}
+
+// convertWith runs the plugin p like convert
+func convertWith(
+	t *testing.T,
+	p plugin.Plugin,
+	m mode.Mode,
+	body map[string]any,
+	config map[string]any,
+	opts ...model.ModelConfigOption,
+) convertResult {
+	t.Helper()
+
+	raw, err := sonic.Marshal(body)
+	require.NoError(t, err)
+
+	mc := model.ModelConfig{
+		Model:  "gpt-4o",
+		Type:   m,
+		Config: model.NewModelConfig(opts...),
+		Plugin: map[string]map[string]any{contextoverflow.PluginName: config},
+	}
+	meta := meta.NewMeta(nil, m, "gpt-4o", mc)
+
+	req := plugintest.NewRequest(raw)
+
+	converted, err := plugintest.Convert(p, meta, req)
+	if err != nil {
+		return convertResult{meta: meta, err: err}
+	}
+
+	// the body of the request is restored for the retries
+	restored, err := common.GetRequestBodyReusable(req)
+	require.NoError(t, err)
+	assert.JSONEq(t, string(raw), string(restored))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	_, relayErr := p.DoResponse(meta, nil, c, &http.Response{}, plugintest.DoResponse(""))
+	require.Nil(t, relayErr)
+
+	result := convertResult{
+		meta:   meta,
+		header: w.Header().Get(contextoverflow.DefaultHeader),
+	}
+	require.NoError(t, sonic.Unmarshal(converted, &result.body))
+
+	return result
+}
+
+func TestConvertRequestWithinLimit(t *testing.T) {
+	result := convert(t, mode.ChatCompletions, chatBody(), map[string]any{
+		"enable":           true,
+		"max_input_tokens": 10000,
+	})
+	require.NoError(t, result.err)
+	assert.Len(t, result.body["messages"], 7)
+	assert.Empty(t, result.header)
+}
+
+func TestConvertRequestDisabled(t *testing.T) {
+	result := convert(t, mode.ChatCompletions, chatBody(), map[string]any{
+		"max_input_tokens": 300,
+	})
+	require.NoError(t, result.err)
+	assert.Len(t, result.body["messages"], 7)
+}
+
+func TestConvertRequestReject(t *testing.T) {
+	result := convert(t, mode.ChatCompletions, chatBody(), map[string]any{
+		"enable":           true,
+		"max_input_tokens": 300,
+	})
+
+	var relayErr adaptor.Error
+	require.ErrorAs(t, result.err, &relayErr)
+	assert.Equal(t, http.StatusBadRequest, relayErr.StatusCode())
+	assert.Contains(t, relayErr.Error(), "exceeding the limit of 300 tokens")
+}
+
+func TestConvertRequestInvalidAction(t *testing.T) {
+	result := convert(t, mode.ChatCompletions, chatBody(), map[string]any{
+		"enable":           true,
+		"action":           "drop",
+		"max_input_tokens": 300,
+	})
+
+	var relayErr adaptor.Error
+	require.ErrorAs(t, result.err, &relayErr)
+	assert.Equal(t, http.StatusInternalServerError, relayErr.StatusCode())
+}
+
+func TestConvertRequestTruncate(t *testing.T) {
+	result := convert(t, mode.ChatCompletions, chatBody(), map[string]any{
+		"enable":           true,
+		"action":           contextoverflow.ActionTruncate,
+		"max_input_tokens": 300,
+	})
+	require.NoError(t, result.err)
+
+	// the tool result is kept with its tool call
+	assert.Equal(
+		t,
+		[]string{"system", "user", "assistant", "tool", "user"},
+		roles(t, result.body["messages"]),
+	)
+	assert.True(t, strings.HasPrefix(result.header, "truncate:dropped=2,"), result.header)
+}
+
+func TestConvertRequestModelContextLimit(t *testing.T) {
+	body := chatBody()
+	body["max_tokens"] = 100
+
+	// the limit is the context window minus the max output tokens of the request
+	result := convert(
+		t,
+		mode.ChatCompletions,
+		body,
+		map[string]any{
+			"enable": true,
+			"action": contextoverflow.ActionTruncate,
+		},
+		model.WithModelConfigMaxContextTokens(400),
+	)
+	require.NoError(t, result.err)
+	assert.True(t, strings.HasPrefix(result.header, "truncate:dropped=2,"), result.header)
+}
+
+func TestConvertRequestTruncateLastTurn(t *testing.T) {
+	result := convert(t, mode.ChatCompletions, chatBody(), map[string]any{
+		"enable":           true,
+		"action":           contextoverflow.ActionTruncate,
+		"max_input_tokens": 50,
+	})
+	require.NoError(t, result.err)
+	assert.Equal(t, []string{"system", "user"}, roles(t, result.body["messages"]))
+	assert.True(t, strings.HasPrefix(result.header, "truncate:dropped=5,"), result.header)
+}
+
+func TestConvertRequestTruncateTooLong(t *testing.T) {
+	result := convert(t, mode.ChatCompletions, chatBody(), map[string]any{
+		"enable":           true,
+		"action":           contextoverflow.ActionTruncate,
+		"max_input_tokens": 20,
+	})
+	require.Error(t, result.err)
+	assert.Contains(t, result.err.Error(), "last turn alone exceeds")
+}
+
+func TestConvertRequestSummarizeFallback(t *testing.T) {
+	// the summary model has no channel, so the conversation is truncated
+	result := convert(t, mode.ChatCompletions, chatBody(), map[string]any{
+		"enable":           true,
+		"action":           contextoverflow.ActionSummarize,
+		"max_input_tokens": 300,
+		"summary":          map[string]any{"model_name": "summary", "max_tokens": 10},
+	})
+	require.NoError(t, result.err)
+	assert.True(t, strings.HasPrefix(result.header, "truncate:"), result.header)
+}
+
+func TestConvertRequestSummarize(t *testing.T) {
+	channel := plugintest.NewChannel(
+		t,
+		1000003,
+		`{"id":"chatcmpl-1","object":"chat.completion","model":"summary",`+
+			`"choices":[{"index":0,"finish_reason":"stop",`+
+			`"message":{"role":"assistant","content":"the earlier turns"}}],`+
+			`"usage":{"prompt_tokens":200,"completion_tokens":4,"total_tokens":204}}`,
+	)
+
+	p := contextoverflow.NewContextOverflowPlugin(func(string, mode.Mode) (*model.Channel, error) {
+		return channel, nil
+	})
+
+	result := convertWith(t, p, mode.ChatCompletions, chatBody(), map[string]any{
+		"enable":           true,
+		"action":           contextoverflow.ActionSummarize,
+		"max_input_tokens": 300,
+		"summary":          map[string]any{"model_name": "summary", "max_tokens": 10},
+	})
+	require.NoError(t, result.err)
+	assert.True(t, strings.HasPrefix(result.header, "summarize:dropped=2,"), result.header)
+
+	messages, ok := result.body["messages"].([]any)
+	require.True(t, ok)
+
+	first, ok := messages[1].(map[string]any)
+	require.True(t, ok)
+
+	content, _ := first["content"].(string)
+	assert.True(
+		t,
+		strings.HasPrefix(content, "Summary of the earlier conversation:\nthe earlier turns"),
+		content,
+	)
+
+	// the summary is billed with the request
+	calls := plugin.GetModelCalls(result.meta)
+	require.Len(t, calls, 1)
+	assert.Equal(t, "summary", calls[0].Meta.OriginModel)
+	assert.Equal(t, model.ZeroNullInt64(204), calls[0].Usage.TotalTokens)
+}
+
+func TestConvertRequestAnthropic(t *testing.T) {
+	body := map[string]any{
+		"system": "be brief",
+		"messages": []map[string]any{
+			{"role": "user", "content": text(100)},
+			{"role": "assistant", "content": []map[string]any{
+				{"type": "tool_use", "id": "tu_1", "name": "lookup", "input": map[string]any{}},
+			}},
+			{"role": "user", "content": []map[string]any{
+				{"type": "tool_result", "tool_use_id": "tu_1", "content": text(100)},
+			}},
+			{"role": "assistant", "content": text(100)},
+			{"role": "user", "content": text(10)},
+		},
+	}
+
+	// the tool result does not start a turn, so it is dropped with its tool use
+	result := convert(t, mode.Anthropic, body, map[string]any{
+		"enable":           true,
+		"action":           contextoverflow.ActionTruncate,
+		"max_input_tokens": 250,
+	})
+	require.NoError(t, result.err)
+	assert.Equal(t, []string{"user"}, roles(t, result.body["messages"]))
+	assert.True(t, strings.HasPrefix(result.header, "truncate:dropped=4,"), result.header)
+}
+
+func TestConvertRequestGemini(t *testing.T) {
+	body := map[string]any{
+		"contents": []map[string]any{
+			{"role": "user", "parts": []map[string]any{{"text": text(100)}}},
+			{"role": "model", "parts": []map[string]any{{"text": text(100)}}},
+			{"role": "user", "parts": []map[string]any{{"text": text(10)}}},
+		},
+	}
+
+	result := convert(t, mode.Gemini, body, map[string]any{
+		"enable":           true,
+		"action":           contextoverflow.ActionTruncate,
+		"max_input_tokens": 100,
+	})
+	require.NoError(t, result.err)
+	assert.Equal(t, []string{"user"}, roles(t, result.body["contents"]))
+	assert.True(t, strings.HasPrefix(result.header, "truncate:dropped=2,"), result.header)
+}
@@ -0,0 +1,72 @@
+package moderation
+
+const (
+	ActionBlock  = "block"
+	ActionRedact = "redact"
+	ActionFlag   = "flag"
+)
+
+const (
+	DetectorEmail      = "email"
+	DetectorPhone      = "phone"
+	DetectorIDCard     = "id_card"
+	DetectorCreditCard = "credit_card"
+)
+
+const defaultReplacement = "[REDACTED]"
+
+type Config struct {
+	Enable bool `json:"enable"`
+	// CheckResponse also checks the outputs of the non-stream responses
+	CheckResponse   bool                  `json:"check_response"`
+	Rules           []Rule                `json:"rules"`
+	ModerationModel ModerationModelConfig `json:"moderation_model"`
+}
+
+// Rule matches the texts with the keywords, the regular expressions and the
+// built-in pii detectors, the action is applied to the matched texts
+type Rule struct {
+	Name string `json:"name"`
+	// Keywords are matched case-insensitively
+	Keywords  []string `json:"keywords"`
+	Patterns  []string `json:"patterns"`
+	Detectors []string `json:"detectors"` // email, phone, id_card, credit_card
+	// Action is block, redact or flag, default is block
+	Action string `json:"action"`
+	// Replacement replaces the matched texts of the redact action, default is [REDACTED]
+	Replacement string `json:"replacement"`
+}
+
+func (r *Rule) GetAction() string {
+	if r.Action == "" {
+		return ActionBlock
+	}
+	return r.Action
+}
+
+func (r *Rule) GetReplacement() string {
+	if r.Replacement == "" {
+		return defaultReplacement
+	}
+	return r.Replacement
+}
+
+// ModerationModelConfig checks the texts with a moderation model routed through the proxy,
+// the texts are checked after the rules, so the redacted texts are not sent to the model
+type ModerationModelConfig struct {
+	Enable    bool   `json:"enable"`
+	ModelName string `json:"model_name"`
+	// Categories are the categories that trigger the action, empty triggers on any flagged result
+	Categories []string `json:"categories"`
+	// Action is block or flag, default is block
+	Action string `json:"action"`
+	// FailClosed blocks the request when the moderation model fails, by default it is allowed
+	FailClosed bool `json:"fail_closed"`
+}
+
+func (c *ModerationModelConfig) GetAction() string {
+	if c.Action == "" {
+		return ActionBlock
+	}
+	return c.Action
+}
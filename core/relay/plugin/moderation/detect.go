@@ -0,0 +1,268 @@
+package moderation
+
+import (
+	"cmp"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/bytedance/sonic"
+)
+
+// detector finds the pii in a text, the matches are validated
+// to reduce the false positives of the numbers
+type detector struct {
+	re       *regexp.Regexp
+	validate func(match string) bool
+}
+
+var detectors = map[string]detector{
+	DetectorEmail: {
+		re: regexp.MustCompile(`[A-Za-z0-9._%+-]+@[A-Za-z0-9.-]+\.[A-Za-z]{2,}`),
+	},
+	DetectorPhone: {
+		re: regexp.MustCompile(
+			`(?:\+\d{1,3}[\s-]?)?(?:\b1[3-9]\d{9}\b|\(?\b\d{3}\)?[\s.-]\d{3}[\s.-]\d{4}\b)`,
+		),
+	},
+	DetectorIDCard: {
+		re: regexp.MustCompile(
+			`\b[1-9]\d{5}(?:19|20)\d{2}(?:0[1-9]|1[0-2])(?:0[1-9]|[12]\d|3[01])\d{3}[\dXx]\b`,
+		),
+		validate: validIDCard,
+	},
+	DetectorCreditCard: {
+		re:       regexp.MustCompile(`\b(?:\d[ -]?){12,18}\d\b`),
+		validate: validLuhn,
+	},
+}
+
+// validIDCard checks the checksum of the 18 digits resident identity card number
+func validIDCard(s string) bool {
+	weights := [17]int{7, 9, 10, 5, 8, 4, 2, 1, 6, 3, 7, 9, 10, 5, 8, 4, 2}
+
+	sum := 0
+	for i, w := range weights {
+		sum += int(s[i]-'0') * w
+	}
+
+	check := "10X98765432"[sum%11]
+
+	return strings.ToUpper(s[17:]) == string(check)
+}
+
+// validLuhn checks the luhn checksum of the credit card number
+func validLuhn(s string) bool {
+	sum := 0
+	digits := 0
+
+	for i := len(s) - 1; i >= 0; i-- {
+		c := s[i]
+		if c < '0' || c > '9' {
+			continue
+		}
+
+		d := int(c - '0')
+		if digits%2 == 1 {
+			d *= 2
+			if d > 9 {
+				d -= 9
+			}
+		}
+
+		sum += d
+		digits++
+	}
+
+	return digits >= 13 && sum%10 == 0
+}
+
+// compiledRule is the rule with its matchers compiled
+type compiledRule struct {
+	Rule
+	matchers []detector
+}
+
+func compileRule(rule Rule) (*compiledRule, error) {
+	switch rule.GetAction() {
+	case ActionBlock, ActionRedact, ActionFlag:
+	default:
+		return nil, fmt.Errorf("invalid action of rule %s: %s", rule.Name, rule.Action)
+	}
+
+	r := &compiledRule{Rule: rule}
+
+	if len(rule.Keywords) > 0 {
+		quoted := make([]string, 0, len(rule.Keywords))
+		for _, keyword := range rule.Keywords {
+			if keyword != "" {
+				quoted = append(quoted, regexp.QuoteMeta(keyword))
+			}
+		}
+
+		if len(quoted) > 0 {
+			re, err := regexp.Compile("(?i)" + strings.Join(quoted, "|"))
+			if err != nil {
+				return nil, err
+			}
+
+			r.matchers = append(r.matchers, detector{re: re})
+		}
+	}
+
+	for _, pattern := range rule.Patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern of rule %s: %w", rule.Name, err)
+		}
+
+		r.matchers = append(r.matchers, detector{re: re})
+	}
+
+	for _, name := range rule.Detectors {
+		d, ok := detectors[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown detector of rule %s: %s", rule.Name, name)
+		}
+
+		r.matchers = append(r.matchers, d)
+	}
+
+	if len(r.matchers) == 0 {
+		return nil, fmt.Errorf("rule %s has no keywords, patterns or detectors", rule.Name)
+	}
+
+	return r, nil
+}
+
+// the compiled rules are shared by the requests of the same config,
+// they are keyed by the json of the rules
+var rulesCache sync.Map
+
+// loadRules returns the compiled rules, they are compiled on the first use of the config
+func loadRules(rules []Rule) ([]*compiledRule, error) {
+	key, err := sonic.MarshalString(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	if v, ok := rulesCache.Load(key); ok {
+		compiled, _ := v.([]*compiledRule)
+		return compiled, nil
+	}
+
+	compiled, err := compileRules(rules)
+	if err != nil {
+		return nil, err
+	}
+
+	rulesCache.Store(key, compiled)
+
+	return compiled, nil
+}
+
+func compileRules(rules []Rule) ([]*compiledRule, error) {
+	compiled := make([]*compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		r, err := compileRule(rule)
+		if err != nil {
+			return nil, err
+		}
+
+		compiled = append(compiled, r)
+	}
+
+	return compiled, nil
+}
+
+// find returns the sorted and merged ranges of the text matched by the rule
+func (r *compiledRule) find(text string) [][2]int {
+	var ranges [][2]int
+
+	for _, m := range r.matchers {
+		for _, loc := range m.re.FindAllStringIndex(text, -1) {
+			if m.validate != nil && !m.validate(text[loc[0]:loc[1]]) {
+				continue
+			}
+
+			ranges = append(ranges, [2]int{loc[0], loc[1]})
+		}
+	}
+
+	if len(ranges) <= 1 {
+		return ranges
+	}
+
+	slices.SortFunc(ranges, func(a, b [2]int) int {
+		return cmp.Compare(a[0], b[0])
+	})
+
+	merged := ranges[:1]
+	for _, rg := range ranges[1:] {
+		last := &merged[len(merged)-1]
+		if rg[0] <= last[1] {
+			last[1] = max(last[1], rg[1])
+			continue
+		}
+
+		merged = append(merged, rg)
+	}
+
+	return merged
+}
+
+// Result is the result of checking the texts of a request or a response
+type Result struct {
+	// Blocked is the name of the rule that blocked the content
+	Blocked  string
+	Flagged  []string
+	Redacted []string
+}
+
+func (r *Result) add(list *[]string, name string) {
+	if !slices.Contains(*list, name) {
+		*list = append(*list, name)
+	}
+}
+
+// check applies the rules to the text and returns the redacted text
+func check(rules []*compiledRule, text string, result *Result) string {
+	for _, rule := range rules {
+		ranges := rule.find(text)
+		if len(ranges) == 0 {
+			continue
+		}
+
+		switch rule.GetAction() {
+		case ActionBlock:
+			if result.Blocked == "" {
+				result.Blocked = rule.Name
+			}
+		case ActionFlag:
+			result.add(&result.Flagged, rule.Name)
+		case ActionRedact:
+			result.add(&result.Redacted, rule.Name)
+			text = redact(text, ranges, rule.GetReplacement())
+		}
+	}
+
+	return text
+}
+
+func redact(text string, ranges [][2]int, replacement string) string {
+	var b strings.Builder
+
+	last := 0
+	for _, rg := range ranges {
+		b.WriteString(text[last:rg[0]])
+		b.WriteString(replacement)
+
+		last = rg[1]
+	}
+
+	b.WriteString(text[last:])
+
+	return b.String()
+}
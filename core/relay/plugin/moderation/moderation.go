@@ -0,0 +1,456 @@
+// Package moderation blocks, redacts or flags the prompts and the outputs
+// that match the configured rules before they reach the providers
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+	"github.com/labring/aiproxy/core/relay/plugin"
+	"github.com/labring/aiproxy/core/relay/plugin/noop"
+	"github.com/labring/aiproxy/core/relay/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	PluginName = "moderation"
+
+	ContentPolicyViolation = "content_policy_violation"
+
+	metaStreamKey = "moderation-stream"
+)
+
+var _ plugin.Plugin = (*Moderation)(nil)
+
+type Moderation struct {
+	noop.Noop
+	getChannel plugin.GetChannel
+}
+
+// NewModerationPlugin creates a new moderation plugin, getChannel is used to
+// route the requests of the moderation model
+func NewModerationPlugin(getChannel plugin.GetChannel) plugin.Plugin {
+	return &Moderation{getChannel: getChannel}
+}
+
+func (p *Moderation) getConfig(meta *meta.Meta) (Config, error) {
+	pluginConfig := Config{}
+	if err := meta.ModelConfig.LoadPluginConfig(PluginName, &pluginConfig); err != nil {
+		return Config{}, err
+	}
+
+	return pluginConfig, nil
+}
+
+func supportedMode(m mode.Mode) bool {
+	switch m {
+	case mode.ChatCompletions,
+		mode.Completions,
+		mode.Anthropic,
+		mode.Responses,
+		mode.Gemini:
+		return true
+	default:
+		return false
+	}
+}
+
+// textKeys are the keys of the request and response fields that hold the texts,
+// the other fields like the roles, the ids and the images are not checked
+var textKeys = map[string]struct{}{
+	"messages":          {},
+	"content":           {},
+	"text":              {},
+	"system":            {},
+	"prompt":            {},
+	"input":             {},
+	"instructions":      {},
+	"contents":          {},
+	"parts":             {},
+	"systemInstruction": {},
+	"choices":           {},
+	"message":           {},
+	"output":            {},
+	"candidates":        {},
+	"tool_calls":        {},
+	"function_call":     {},
+	"function":          {},
+	"functionCall":      {},
+	"arguments":         {},
+	"args":              {},
+}
+
+// isArguments reports whether the field holds the arguments of a tool call, all
+// their strings are checked, the input of the anthropic tool use is an object
+// while the inputs of the responses are strings or arrays
+func isArguments(key string, value any) bool {
+	switch key {
+	case "arguments", "args":
+		return true
+	case "input":
+		_, ok := value.(map[string]any)
+		return ok
+	default:
+		return false
+	}
+}
+
+// walkTexts calls fn with the texts of the value and replaces them with the returned ones,
+// all the strings are texts when arguments is set
+func walkTexts(v any, arguments bool, fn func(string) string) any {
+	switch v := v.(type) {
+	case string:
+		return fn(v)
+	case []any:
+		for i, e := range v {
+			v[i] = walkTexts(e, arguments, fn)
+		}
+
+		return v
+	case map[string]any:
+		for k, e := range v {
+			if _, ok := textKeys[k]; ok || arguments {
+				v[k] = walkTexts(e, arguments || isArguments(k, e), fn)
+			}
+		}
+
+		return v
+	default:
+		return v
+	}
+}
+
+// moderate applies the rules and the moderation model to the texts of the body,
+// the body is modified in place when texts are redacted
+func (p *Moderation) moderate(
+	ctx context.Context,
+	log *logrus.Entry,
+	meta *meta.Meta,
+	store adaptor.Store,
+	config Config,
+	body map[string]any,
+) (*Result, error) {
+	rules, err := loadRules(config.Rules)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &Result{}
+
+	var texts []string
+
+	walkTexts(body, false, func(text string) string {
+		text = check(rules, text, result)
+		if text != "" {
+			texts = append(texts, text)
+		}
+
+		return text
+	})
+
+	mc := config.ModerationModel
+	if !mc.Enable || result.Blocked != "" || len(texts) == 0 {
+		return result, nil
+	}
+
+	categories, flagged, err := p.callModerationModel(
+		ctx,
+		meta,
+		store,
+		mc.ModelName,
+		strings.Join(texts, "\n"),
+	)
+	if err != nil {
+		if mc.FailClosed {
+			return nil, fmt.Errorf("moderation model failed: %w", err)
+		}
+
+		log.Warnf("moderation model failed: %v", err)
+
+		return result, nil
+	}
+
+	if !flagged || !matchCategories(mc.Categories, categories) {
+		return result, nil
+	}
+
+	name := mc.ModelName
+	if len(categories) > 0 {
+		name += ":" + strings.Join(categories, ",")
+	}
+
+	if mc.GetAction() == ActionFlag {
+		result.add(&result.Flagged, name)
+	} else {
+		result.Blocked = name
+	}
+
+	return result, nil
+}
+
+func matchCategories(want, got []string) bool {
+	if len(want) == 0 {
+		return true
+	}
+
+	return slices.ContainsFunc(got, func(c string) bool {
+		return slices.Contains(want, c)
+	})
+}
+
+// logResult adds the flagged and redacted rules to the log fields
+func logResult(log *logrus.Entry, field string, result *Result) {
+	var parts []string
+	if result.Blocked != "" {
+		parts = append(parts, "blocked:"+result.Blocked)
+	}
+
+	if len(result.Redacted) > 0 {
+		parts = append(parts, "redacted:"+strings.Join(result.Redacted, ","))
+	}
+
+	if len(result.Flagged) > 0 {
+		parts = append(parts, "flagged:"+strings.Join(result.Flagged, ","))
+	}
+
+	if len(parts) > 0 {
+		log.Data[field] = strings.Join(parts, ";")
+	}
+}
+
+func blockedError(m mode.Mode, what, name string) adaptor.Error {
+	return relaymodel.WrapperErrorWithMessage(
+		m,
+		http.StatusBadRequest,
+		fmt.Sprintf("the %s is blocked by the content moderation rule `%s`", what, name),
+		relaymodel.WithType(ContentPolicyViolation),
+	)
+}
+
+// ConvertRequest checks the prompts before the request is converted,
+// the redacted body is only sent to the provider
+func (p *Moderation) ConvertRequest(
+	meta *meta.Meta,
+	store adaptor.Store,
+	req *http.Request,
+	do adaptor.ConvertRequest,
+) (adaptor.ConvertResult, error) {
+	if !supportedMode(meta.Mode) {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	config, err := p.getConfig(meta)
+	if err != nil || !config.Enable {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	bodyBytes, err := common.GetRequestBodyReusable(req)
+	if err != nil {
+		return adaptor.ConvertResult{}, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	var body map[string]any
+	if err := sonic.Unmarshal(bodyBytes, &body); err != nil {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	stream, _ := body["stream"].(bool)
+	meta.Set(metaStreamKey, stream || utils.IsGeminiStreamRequest(req.URL.Path))
+
+	log := common.GetLoggerFromReq(req)
+
+	result, err := p.moderate(req.Context(), log, meta, store, config, body)
+	if err != nil {
+		return adaptor.ConvertResult{}, relaymodel.WrapperErrorWithMessage(
+			meta.Mode,
+			http.StatusInternalServerError,
+			err.Error(),
+		)
+	}
+
+	logResult(log, "moderation", result)
+
+	if result.Blocked != "" {
+		return adaptor.ConvertResult{}, blockedError(meta.Mode, "prompt", result.Blocked)
+	}
+
+	if len(result.Redacted) == 0 {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	redactedBody, err := sonic.Marshal(body)
+	if err != nil {
+		return adaptor.ConvertResult{}, err
+	}
+
+	common.SetRequestBody(req, redactedBody)
+	defer func() {
+		common.SetRequestBody(req, bodyBytes)
+	}()
+
+	return do.ConvertRequest(meta, store, req)
+}
+
+// responseWriter buffers the non-stream response until the output is checked
+type responseWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	return rw.body.Write(b)
+}
+
+func (rw *responseWriter) WriteString(s string) (int, error) {
+	return rw.body.WriteString(s)
+}
+
+// DoResponse checks the outputs of the non-stream responses when check_response is enabled
+func (p *Moderation) DoResponse(
+	meta *meta.Meta,
+	store adaptor.Store,
+	c *gin.Context,
+	resp *http.Response,
+	do adaptor.DoResponse,
+) (model.Usage, adaptor.Error) {
+	if !supportedMode(meta.Mode) || meta.GetBool(metaStreamKey) {
+		return do.DoResponse(meta, store, c, resp)
+	}
+
+	config, err := p.getConfig(meta)
+	if err != nil || !config.Enable || !config.CheckResponse {
+		return do.DoResponse(meta, store, c, resp)
+	}
+
+	rw := &responseWriter{ResponseWriter: c.Writer}
+
+	c.Writer = rw
+	usage, relayErr := do.DoResponse(meta, store, c, resp)
+	c.Writer = rw.ResponseWriter
+
+	if relayErr != nil || rw.body.Len() == 0 {
+		_, _ = c.Writer.Write(rw.body.Bytes())
+		return usage, relayErr
+	}
+
+	var body map[string]any
+	if err := sonic.Unmarshal(rw.body.Bytes(), &body); err != nil {
+		_, _ = c.Writer.Write(rw.body.Bytes())
+		return usage, nil
+	}
+
+	log := common.GetLogger(c)
+
+	result, err := p.moderate(c.Request.Context(), log, meta, store, config, body)
+	if err != nil {
+		return usage, relaymodel.WrapperErrorWithMessage(
+			meta.Mode,
+			http.StatusInternalServerError,
+			err.Error(),
+		)
+	}
+
+	logResult(log, "moderation_response", result)
+
+	// the output is billed even if it is blocked, the provider has generated it
+	if result.Blocked != "" {
+		return usage, blockedError(meta.Mode, "response", result.Blocked)
+	}
+
+	out := rw.body.Bytes()
+	if len(result.Redacted) > 0 {
+		if out, err = sonic.Marshal(body); err != nil {
+			return usage, relaymodel.WrapperErrorWithMessage(
+				meta.Mode,
+				http.StatusInternalServerError,
+				err.Error(),
+			)
+		}
+
+		if c.Writer.Header().Get("Content-Length") != "" {
+			c.Writer.Header().Set("Content-Length", strconv.Itoa(len(out)))
+		}
+	}
+
+	_, _ = c.Writer.Write(out)
+
+	return usage, nil
+}
+
+type moderationResponse struct {
+	Results []struct {
+		Flagged    bool            `json:"flagged"`
+		Categories map[string]bool `json:"categories"`
+	} `json:"results"`
+}
+
+// callModerationModel checks the input with the moderation model,
+// the flagged categories are returned
+func (p *Moderation) callModerationModel(
+	ctx context.Context,
+	meta *meta.Meta,
+	store adaptor.Store,
+	modelName, input string,
+) ([]string, bool, error) {
+	moderationBody, err := sonic.Marshal(map[string]any{
+		"model": modelName,
+		"input": input,
+	})
+	if err != nil {
+		return nil, false, err
+	}
+
+	body, err := plugin.CallModel(
+		ctx,
+		meta,
+		store,
+		p.getChannel,
+		mode.Moderations,
+		modelName,
+		moderationBody,
+	)
+	if err != nil {
+		return nil, false, err
+	}
+
+	var resp moderationResponse
+	if err := sonic.Unmarshal(body, &resp); err != nil {
+		return nil, false, err
+	}
+
+	var (
+		flagged    bool
+		categories []string
+	)
+
+	for _, r := range resp.Results {
+		if !r.Flagged {
+			continue
+		}
+
+		flagged = true
+
+		for category, hit := range r.Categories {
+			if hit && !slices.Contains(categories, category) {
+				categories = append(categories, category)
+			}
+		}
+	}
+
+	slices.Sort(categories)
+
+	return categories, flagged, nil
+}
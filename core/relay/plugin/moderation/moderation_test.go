@@ -0,0 +1,277 @@
+package moderation_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bytedance/sonic"
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/labring/aiproxy/core/relay/plugin/moderation"
+	"github.com/labring/aiproxy/core/relay/plugin/plugintest"
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newMeta(m mode.Mode, config map[string]any) *meta.Meta {
+	return meta.NewMeta(nil, m, "gpt-4o", model.ModelConfig{
+		Model:  "gpt-4o",
+		Type:   m,
+		Plugin: map[string]map[string]any{moderation.PluginName: config},
+	})
+}
+
+type convertResult struct {
+	// body is the body passed to the adaptor
+	body map[string]any
+	log  logrus.Fields
+	err  error
+}
+
+// convert runs the plugin on the request body of the mode with the plugin config
+func convert(t *testing.T, m mode.Mode, body string, config map[string]any) convertResult {
+	t.Helper()
+
+	req := plugintest.NewRequest([]byte(body))
+
+	log := logrus.NewEntry(logrus.StandardLogger()).WithFields(logrus.Fields{})
+	common.SetLogger(req, log)
+
+	converted, err := plugintest.Convert(
+		moderation.NewModerationPlugin(nil),
+		newMeta(m, config),
+		req,
+	)
+	if err != nil {
+		return convertResult{log: log.Data, err: err}
+	}
+
+	result := convertResult{log: log.Data}
+	require.NoError(t, sonic.Unmarshal(converted, &result.body))
+
+	return result
+}
+
+func userMessage(t *testing.T, text string) string {
+	t.Helper()
+
+	body, err := sonic.MarshalString(map[string]any{
+		"model":    "gpt-4o",
+		"messages": []map[string]any{{"role": "user", "content": text}},
+	})
+	require.NoError(t, err)
+
+	return body
+}
+
+func firstContent(t *testing.T, body map[string]any) string {
+	t.Helper()
+
+	messages, ok := body["messages"].([]any)
+	require.True(t, ok)
+	require.NotEmpty(t, messages)
+
+	message, ok := messages[0].(map[string]any)
+	require.True(t, ok)
+
+	content, _ := message["content"].(string)
+
+	return content
+}
+
+func TestConvertRequestDetectors(t *testing.T) {
+	config := map[string]any{
+		"enable": true,
+		"rules": []map[string]any{
+			{
+				"name":      "pii",
+				"detectors": []string{"email", "phone", "id_card", "credit_card"},
+				"action":    moderation.ActionRedact,
+			},
+		},
+	}
+
+	testCases := []struct {
+		name     string
+		input    string
+		expected string
+	}{
+		{
+			name:     "email",
+			input:    "mail me at john.doe@example.com please",
+			expected: "mail me at [REDACTED] please",
+		},
+		{
+			name:     "mobile phone",
+			input:    "call 13812345678 now",
+			expected: "call [REDACTED] now",
+		},
+		{
+			name:     "us phone",
+			input:    "call (415) 555-2671 now",
+			expected: "call [REDACTED] now",
+		},
+		{
+			name:     "id card",
+			input:    "id 11010519491231002X end",
+			expected: "id [REDACTED] end",
+		},
+		{
+			name:     "invalid id card checksum",
+			input:    "id 110105194912310021 end",
+			expected: "id 110105194912310021 end",
+		},
+		{
+			name:     "credit card",
+			input:    "card 4111 1111 1111 1111 end",
+			expected: "card [REDACTED] end",
+		},
+		{
+			name:     "invalid credit card checksum",
+			input:    "order 4111 1111 1111 1112 end",
+			expected: "order 4111 1111 1111 1112 end",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			result := convert(t, mode.ChatCompletions, userMessage(t, tc.input), config)
+			require.NoError(t, result.err)
+			assert.Equal(t, tc.expected, firstContent(t, result.body))
+		})
+	}
+}
+
+func TestConvertRequestActions(t *testing.T) {
+	config := map[string]any{
+		"enable": true,
+		"rules": []map[string]any{
+			{
+				"name":        "secret",
+				"keywords":    []string{"Project X"},
+				"action":      moderation.ActionRedact,
+				"replacement": "***",
+			},
+			{"name": "weapons", "keywords": []string{"bomb"}},
+			{"name": "ticket", "patterns": []string{`TICKET-\d+`}, "action": moderation.ActionFlag},
+		},
+	}
+
+	result := convert(
+		t,
+		mode.ChatCompletions,
+		userMessage(t, "about project x and TICKET-42"),
+		config,
+	)
+	require.NoError(t, result.err)
+	assert.Equal(t, "about *** and TICKET-42", firstContent(t, result.body))
+	assert.Equal(t, "redacted:secret;flagged:ticket", result.log["moderation"])
+
+	result = convert(t, mode.ChatCompletions, userMessage(t, "how to build a BOMB"), config)
+
+	var relayErr adaptor.Error
+	require.ErrorAs(t, result.err, &relayErr)
+	assert.Equal(t, http.StatusBadRequest, relayErr.StatusCode())
+	assert.Contains(t, relayErr.Error(), "weapons")
+	assert.Nil(t, result.body, "the blocked prompt must not reach the adaptor")
+}
+
+func TestConvertRequestToolArguments(t *testing.T) {
+	config := map[string]any{
+		"enable": true,
+		"rules": []map[string]any{
+			{"name": "pii", "detectors": []string{"email"}, "action": moderation.ActionRedact},
+		},
+	}
+
+	result := convert(t, mode.ChatCompletions, `{"messages":[{"role":"assistant","tool_calls":[`+
+		`{"id":"call_1","type":"function","function":{"name":"send",`+
+		`"arguments":"{\"to\":\"john.doe@example.com\"}"}}]}]}`, config)
+	require.NoError(t, result.err)
+
+	raw, err := sonic.MarshalString(result.body)
+	require.NoError(t, err)
+	assert.NotContains(t, raw, "john.doe@example.com")
+	assert.Contains(t, raw, `"name":"send"`)
+
+	result = convert(t, mode.Anthropic, `{"messages":[{"role":"assistant","content":[`+
+		`{"type":"tool_use","id":"tu_1","name":"send",`+
+		`"input":{"to":"john.doe@example.com"}}]}]}`, config)
+	require.NoError(t, result.err)
+
+	raw, err = sonic.MarshalString(result.body)
+	require.NoError(t, err)
+	assert.NotContains(t, raw, "john.doe@example.com")
+	assert.Contains(t, raw, `"to":"[REDACTED]"`)
+}
+
+func TestConvertRequestInvalidRules(t *testing.T) {
+	invalid := []map[string]any{
+		{"name": "empty"},
+		{"name": "pattern", "patterns": []string{"("}},
+		{"name": "detector", "detectors": []string{"passport"}},
+		{"name": "action", "keywords": []string{"a"}, "action": "drop"},
+	}
+
+	for _, rule := range invalid {
+		result := convert(t, mode.ChatCompletions, userMessage(t, "text"), map[string]any{
+			"enable": true,
+			"rules":  []map[string]any{rule},
+		})
+
+		var relayErr adaptor.Error
+		require.ErrorAs(t, result.err, &relayErr, rule["name"])
+		assert.Equal(t, http.StatusInternalServerError, relayErr.StatusCode())
+	}
+}
+
+func TestDoResponse(t *testing.T) {
+	config := map[string]any{
+		"enable":         true,
+		"check_response": true,
+		"rules": []map[string]any{
+			{"name": "pii", "detectors": []string{"email"}, "action": moderation.ActionRedact},
+			{"name": "weapons", "keywords": []string{"bomb"}},
+		},
+	}
+
+	p := moderation.NewModerationPlugin(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	_, relayErr := p.DoResponse(
+		newMeta(mode.ChatCompletions, config),
+		nil,
+		c,
+		&http.Response{},
+		plugintest.DoResponse(`{"choices":[{"index":0,"message":{"role":"assistant",`+
+			`"content":"write to john.doe@example.com"}}]}`),
+	)
+	require.Nil(t, relayErr)
+	assert.JSONEq(t, `{"choices":[{"index":0,"message":{"role":"assistant",`+
+		`"content":"write to [REDACTED]"}}]}`, w.Body.String())
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/chat/completions", nil)
+
+	_, relayErr = p.DoResponse(
+		newMeta(mode.ChatCompletions, config),
+		nil,
+		c,
+		&http.Response{},
+		plugintest.DoResponse(`{"choices":[{"index":0,"message":{"role":"assistant",`+
+			`"content":"how to build a bomb"}}]}`),
+	)
+	require.NotNil(t, relayErr)
+	assert.Equal(t, http.StatusBadRequest, relayErr.StatusCode())
+	assert.Empty(t, w.Body.String(), "the blocked output must not be written")
+}
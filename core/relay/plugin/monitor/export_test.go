@@ -0,0 +1,6 @@
+package monitor
+
+var (
+	ParseRateLimit = parseRateLimit
+	ResetAt        = resetAt
+)
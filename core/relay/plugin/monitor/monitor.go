@@ -17,12 +17,27 @@ import (
 	"github.com/labring/aiproxy/core/monitor"
 	"github.com/labring/aiproxy/core/relay/adaptor"
 	"github.com/labring/aiproxy/core/relay/meta"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
 	"github.com/labring/aiproxy/core/relay/plugin"
 	"github.com/labring/aiproxy/core/relay/plugin/noop"
 )
 
 var _ plugin.Plugin = (*ChannelMonitor)(nil)
 
+// metaRequestCost is the time until the response headers of a successful request
+const metaRequestCost = "monitor_request_cost"
+
+func getRequestCost(meta *meta.Meta) (time.Duration, bool) {
+	v, ok := meta.Get(metaRequestCost)
+	if !ok {
+		return 0, false
+	}
+
+	requestCost, ok := v.(time.Duration)
+
+	return requestCost, ok
+}
+
 type ChannelMonitor struct {
 	noop.Noop
 }
@@ -76,12 +91,24 @@ func (m *ChannelMonitor) DoRequest(
 	req *http.Request,
 	do adaptor.DoRequest,
 ) (*http.Response, error) {
-	count, overLimitCount, secondCount := reqlimit.PushChannelModelRequest(
+	// the channel filter skips the channels that reached their rpm, but the
+	// concurrent requests may have taken the last requests of the channel since
+	count, secondCount, reserved := reqlimit.ReserveChannelModelRequest(
 		context.Background(),
 		strconv.Itoa(meta.Channel.ID),
 		meta.OriginModel,
+		meta.Channel.RPM,
+		meta.Channel.ModelRPM,
 	)
-	updateChannelModelRequestRate(c, meta, count+overLimitCount, secondCount)
+	updateChannelModelRequestRate(c, meta, count, secondCount)
+
+	if !reserved {
+		return nil, relaymodel.WrapperErrorWithMessage(
+			meta.Mode,
+			http.StatusTooManyRequests,
+			"the channel reached its max requests per minute",
+		)
+	}
 
 	requestAt := time.Now()
 	meta.Set("requestAt", requestAt)
@@ -92,10 +119,22 @@ func (m *ChannelMonitor) DoRequest(
 	log := common.GetLogger(c)
 	log.Data["req_cost"] = requestCost.String()
 
+	if resp != nil {
+		learnRateLimit(meta, c, resp)
+	}
+
 	if err == nil {
+		meta.Set(metaRequestCost, requestCost)
 		return resp, nil
 	}
 
+	// a hedged request canceled after another channel answered, the upstream
+	// context is not canceled when the client goes away so the channel errors
+	// are still recorded
+	if meta.UpstreamContext().Err() != nil {
+		return resp, err
+	}
+
 	var adaptorErr adaptor.Error
 
 	ok := errors.As(err, &adaptorErr)
@@ -226,10 +265,21 @@ func (m *ChannelMonitor) DoResponse(
 			common.GetLogger(c).Errorf("add request failed: %+v", err)
 		}
 
+		if requestCost, ok := getRequestCost(meta); ok {
+			if err := monitor.AddLatency(
+				context.Background(),
+				meta.OriginModel,
+				int64(meta.Channel.ID),
+				requestCost,
+			); err != nil {
+				common.GetLogger(c).Errorf("add latency failed: %+v", err)
+			}
+		}
+
 		return usage, nil
 	}
 
-	if !ShouldRetry(relayErr) {
+	if !ShouldRetry(relayErr) || meta.UpstreamContext().Err() != nil {
 		return usage, relayErr
 	}
 
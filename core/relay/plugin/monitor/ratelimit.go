@@ -0,0 +1,165 @@
+package monitor
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/monitor"
+	"github.com/labring/aiproxy/core/relay/meta"
+)
+
+// rateLimitHeaders are the names of the rate limit headers of a provider
+type rateLimitHeaders struct {
+	limitRequests     string
+	limitTokens       string
+	remainingRequests string
+	remainingTokens   string
+	resetRequests     string
+	resetTokens       string
+}
+
+var (
+	// https://platform.openai.com/docs/guides/rate-limits#rate-limits-in-headers
+	openAIRateLimitHeaders = rateLimitHeaders{
+		limitRequests:     "X-Ratelimit-Limit-Requests",
+		limitTokens:       "X-Ratelimit-Limit-Tokens",
+		remainingRequests: "X-Ratelimit-Remaining-Requests",
+		remainingTokens:   "X-Ratelimit-Remaining-Tokens",
+		resetRequests:     "X-Ratelimit-Reset-Requests",
+		resetTokens:       "X-Ratelimit-Reset-Tokens",
+	}
+	// https://docs.anthropic.com/en/api/rate-limits#response-headers
+	anthropicRateLimitHeaders = rateLimitHeaders{
+		limitRequests:     "Anthropic-Ratelimit-Requests-Limit",
+		limitTokens:       "Anthropic-Ratelimit-Tokens-Limit",
+		remainingRequests: "Anthropic-Ratelimit-Requests-Remaining",
+		remainingTokens:   "Anthropic-Ratelimit-Tokens-Remaining",
+		resetRequests:     "Anthropic-Ratelimit-Requests-Reset",
+		resetTokens:       "Anthropic-Ratelimit-Tokens-Reset",
+	}
+)
+
+const (
+	headerRetryAfter = "Retry-After"
+
+	// defaultRateLimitReset is used when the upstream reports no remaining
+	// requests or tokens without a parsable reset time
+	defaultRateLimitReset = time.Minute
+)
+
+// learnRateLimit saves the rate limit reported by the upstream response headers
+func learnRateLimit(meta *meta.Meta, c *gin.Context, resp *http.Response) {
+	limit, ok := parseRateLimit(resp.StatusCode, resp.Header, time.Now())
+	if !ok {
+		return
+	}
+
+	if err := monitor.SetRateLimit(
+		context.Background(),
+		meta.OriginModel,
+		int64(meta.Channel.ID),
+		limit,
+	); err != nil {
+		common.GetLogger(c).Errorf("set channel rate limit failed: %+v", err)
+	}
+}
+
+// parseRateLimit parses the openai style x-ratelimit-* headers, or the
+// anthropic-ratelimit-* headers, the request and token limits are treated as
+// per minute limits
+func parseRateLimit(statusCode int, header http.Header, now time.Time) (monitor.RateLimit, bool) {
+	limit, found := parseRateLimitHeaders(header, openAIRateLimitHeaders, now)
+	if !found {
+		limit, found = parseRateLimitHeaders(header, anthropicRateLimitHeaders, now)
+	}
+
+	// a rate limited response without the remaining headers
+	if statusCode == http.StatusTooManyRequests &&
+		limit.RequestsExhaustedUntil == 0 &&
+		limit.TokensExhaustedUntil == 0 {
+		if retryAfter := header.Get(headerRetryAfter); retryAfter != "" {
+			limit.RequestsExhaustedUntil = resetAt(retryAfter, now)
+			found = true
+		}
+	}
+
+	return limit, found
+}
+
+func parseRateLimitHeaders(
+	header http.Header,
+	names rateLimitHeaders,
+	now time.Time,
+) (monitor.RateLimit, bool) {
+	var (
+		limit monitor.RateLimit
+		found bool
+	)
+
+	if rpm, ok := parseHeaderInt(header, names.limitRequests); ok {
+		limit.RPM = rpm
+		found = true
+	}
+
+	if tpm, ok := parseHeaderInt(header, names.limitTokens); ok {
+		limit.TPM = tpm
+		found = true
+	}
+
+	if remaining, ok := parseHeaderInt(header, names.remainingRequests); ok {
+		found = true
+
+		if remaining <= 0 {
+			limit.RequestsExhaustedUntil = resetAt(header.Get(names.resetRequests), now)
+		}
+	}
+
+	if remaining, ok := parseHeaderInt(header, names.remainingTokens); ok {
+		found = true
+
+		if remaining <= 0 {
+			limit.TokensExhaustedUntil = resetAt(header.Get(names.resetTokens), now)
+		}
+	}
+
+	return limit, found
+}
+
+func parseHeaderInt(header http.Header, key string) (int64, bool) {
+	value := header.Get(key)
+	if value == "" {
+		return 0, false
+	}
+
+	i, err := strconv.ParseInt(strings.TrimSpace(value), 10, 64)
+	if err != nil {
+		return 0, false
+	}
+
+	return i, true
+}
+
+// resetAt parses a reset time like "1s", "6m0s", "20ms", seconds like "30",
+// or a timestamp like "2024-01-01T00:00:30Z" into unix milliseconds
+func resetAt(value string, now time.Time) int64 {
+	value = strings.TrimSpace(value)
+
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(d).UnixMilli()
+	}
+
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds >= 0 {
+		return now.Add(time.Duration(seconds * float64(time.Second))).UnixMilli()
+	}
+
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return max(t.UnixMilli(), now.UnixMilli())
+	}
+
+	return now.Add(defaultRateLimitReset).UnixMilli()
+}
@@ -0,0 +1,135 @@
+package monitor_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	coremonitor "github.com/labring/aiproxy/core/monitor"
+	"github.com/labring/aiproxy/core/relay/plugin/monitor"
+	"github.com/stretchr/testify/assert"
+)
+
+func header(kv ...string) http.Header {
+	h := make(http.Header)
+	for i := 0; i+1 < len(kv); i += 2 {
+		h.Set(kv[i], kv[i+1])
+	}
+
+	return h
+}
+
+func TestParseRateLimitOpenAI(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	limit, ok := monitor.ParseRateLimit(http.StatusOK, header(
+		"x-ratelimit-limit-requests", "10000",
+		"x-ratelimit-limit-tokens", "2000000",
+		"x-ratelimit-remaining-requests", "9999",
+		"x-ratelimit-remaining-tokens", "1999985",
+		"x-ratelimit-reset-requests", "6ms",
+		"x-ratelimit-reset-tokens", "0s",
+	), now)
+	assert.True(t, ok)
+	assert.Equal(t, coremonitor.RateLimit{RPM: 10000, TPM: 2000000}, limit)
+	assert.False(t, limit.Exhausted(now))
+
+	limit, ok = monitor.ParseRateLimit(http.StatusTooManyRequests, header(
+		"x-ratelimit-limit-requests", "500",
+		"x-ratelimit-limit-tokens", "30000",
+		"x-ratelimit-remaining-requests", "0",
+		"x-ratelimit-remaining-tokens", "0",
+		"x-ratelimit-reset-requests", "1m30.5s",
+		"x-ratelimit-reset-tokens", "6m0s",
+	), now)
+	assert.True(t, ok)
+	assert.Equal(t, coremonitor.RateLimit{
+		RPM:                    500,
+		TPM:                    30000,
+		RequestsExhaustedUntil: now.Add(90500 * time.Millisecond).UnixMilli(),
+		TokensExhaustedUntil:   now.Add(6 * time.Minute).UnixMilli(),
+	}, limit)
+	assert.True(t, limit.Exhausted(now))
+	assert.False(t, limit.Exhausted(now.Add(7*time.Minute)))
+}
+
+func TestParseRateLimitAnthropic(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	limit, ok := monitor.ParseRateLimit(http.StatusOK, header(
+		"anthropic-ratelimit-requests-limit", "50",
+		"anthropic-ratelimit-requests-remaining", "49",
+		"anthropic-ratelimit-requests-reset", "2025-01-01T00:00:01Z",
+		"anthropic-ratelimit-tokens-limit", "40000",
+		"anthropic-ratelimit-tokens-remaining", "39000",
+		"anthropic-ratelimit-tokens-reset", "2025-01-01T00:00:02Z",
+		"anthropic-ratelimit-input-tokens-limit", "40000",
+		"anthropic-ratelimit-output-tokens-limit", "8000",
+	), now)
+	assert.True(t, ok)
+	assert.Equal(t, coremonitor.RateLimit{RPM: 50, TPM: 40000}, limit)
+
+	limit, ok = monitor.ParseRateLimit(http.StatusTooManyRequests, header(
+		"anthropic-ratelimit-requests-limit", "50",
+		"anthropic-ratelimit-requests-remaining", "0",
+		"anthropic-ratelimit-requests-reset", "2025-01-01T00:00:30Z",
+		"anthropic-ratelimit-tokens-limit", "40000",
+		"anthropic-ratelimit-tokens-remaining", "0",
+		"anthropic-ratelimit-tokens-reset", "2025-01-01T00:00:45.5+00:00",
+		"retry-after", "30",
+	), now)
+	assert.True(t, ok)
+	assert.Equal(t, coremonitor.RateLimit{
+		RPM:                    50,
+		TPM:                    40000,
+		RequestsExhaustedUntil: now.Add(30 * time.Second).UnixMilli(),
+		TokensExhaustedUntil:   now.Add(45500 * time.Millisecond).UnixMilli(),
+	}, limit)
+}
+
+func TestParseRateLimitRetryAfter(t *testing.T) {
+	now := time.UnixMilli(1_700_000_000_000)
+
+	limit, ok := monitor.ParseRateLimit(
+		http.StatusTooManyRequests,
+		header("retry-after", "20"),
+		now,
+	)
+	assert.True(t, ok)
+	assert.Equal(t, now.Add(20*time.Second).UnixMilli(), limit.RequestsExhaustedUntil)
+
+	// the retry after of a successful response is ignored
+	_, ok = monitor.ParseRateLimit(http.StatusOK, header("retry-after", "20"), now)
+	assert.False(t, ok)
+
+	_, ok = monitor.ParseRateLimit(http.StatusOK, header("x-ratelimit-limit-requests", "abc"), now)
+	assert.False(t, ok)
+}
+
+func TestResetAt(t *testing.T) {
+	now := time.Date(2025, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Duration
+	}{
+		{"1s", time.Second},
+		{"6m0s", 6 * time.Minute},
+		{"20ms", 20 * time.Millisecond},
+		{"0s", 0},
+		{"30", 30 * time.Second},
+		{"1.5", 1500 * time.Millisecond},
+		{" 2s ", 2 * time.Second},
+		{"2025-01-01T00:00:10Z", 10 * time.Second},
+		{"2025-01-01T08:00:10+08:00", 10 * time.Second},
+		// a reset in the past resets now
+		{"2024-12-31T23:59:00Z", 0},
+		{"", time.Minute},
+		{"soon", time.Minute},
+		{"-1s", time.Minute},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, now.Add(tt.want).UnixMilli(), monitor.ResetAt(tt.value, now), tt.value)
+	}
+}
@@ -0,0 +1,93 @@
+// Package plugintest provides the adaptor stubs to test the plugins with
+package plugintest
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/plugin"
+)
+
+// NewRequest returns a json request with the body
+func NewRequest(body []byte) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, "/v1/chat/completions", bytes.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+
+	return req
+}
+
+// convertRecorder records the body passed to the adaptor
+type convertRecorder struct {
+	body []byte
+}
+
+func (r *convertRecorder) ConvertRequest(
+	_ *meta.Meta,
+	_ adaptor.Store,
+	req *http.Request,
+) (adaptor.ConvertResult, error) {
+	body, err := common.GetRequestBodyReusable(req)
+	if err != nil {
+		return adaptor.ConvertResult{}, err
+	}
+
+	r.body = bytes.Clone(body)
+
+	return adaptor.ConvertResult{}, nil
+}
+
+// Convert runs ConvertRequest of the plugin on the request, the body passed
+// to the adaptor is returned, it is nil when the plugin rejects the request
+func Convert(p plugin.Plugin, meta *meta.Meta, req *http.Request) ([]byte, error) {
+	recorder := &convertRecorder{}
+
+	_, err := p.ConvertRequest(meta, nil, req, recorder)
+	if err != nil {
+		return nil, err
+	}
+
+	return recorder.body, nil
+}
+
+// DoResponse writes the body as the response of the provider
+type DoResponse string
+
+func (d DoResponse) DoResponse(
+	_ *meta.Meta,
+	_ adaptor.Store,
+	c *gin.Context,
+	_ *http.Response,
+) (model.Usage, adaptor.Error) {
+	if d != "" {
+		c.Writer.Header().Set("Content-Type", "application/json")
+		_, _ = c.Writer.WriteString(string(d))
+	}
+
+	return model.Usage{}, nil
+}
+
+// NewChannel returns an openai channel whose requests are answered with the
+// response body, the server is closed at the end of the test
+func NewChannel(t *testing.T, id int, response string) *model.Channel {
+	t.Helper()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(response))
+	}))
+	t.Cleanup(server.Close)
+
+	return &model.Channel{
+		ID:      id,
+		Type:    model.ChannelTypeOpenAI,
+		BaseURL: server.URL,
+		Key:     "sk-test",
+	}
+}
@@ -154,7 +154,7 @@ func (p *StreamFake) handleFakeStreamResponse(
 	}
 
 	// Convert collected streaming chunks to non-streaming response
-	respBody, err := rw.convertToNonStream()
+	respBody, err := rw.NonStream()
 	if err != nil {
 		log.Errorf("failed to convert to non-streaming response: %v", err)
 		return usage, relayErr
@@ -179,7 +179,12 @@ func (p *StreamFake) handleFakeStreamResponse(
 // fakeStreamResponseWriter captures streaming response data
 type fakeStreamResponseWriter struct {
 	gin.ResponseWriter
+	Aggregator
+}
 
+// Aggregator aggregates the chunks of a streamed chat completion
+// into a non-stream chat completion
+type Aggregator struct {
 	lastChunk        *ast.Node
 	usageNode        *ast.Node
 	contentBuilder   bytes.Buffer
@@ -204,7 +209,7 @@ func (rw *fakeStreamResponseWriter) WriteHeaderNow() {}
 
 func (rw *fakeStreamResponseWriter) Write(b []byte) (int, error) {
 	// Parse streaming data
-	_ = rw.parseStreamingData(b)
+	_ = rw.Add(b)
 
 	return len(b), nil
 }
@@ -213,8 +218,8 @@ func (rw *fakeStreamResponseWriter) WriteString(s string) (int, error) {
 	return rw.Write(conv.StringToBytes(s))
 }
 
-// parseStreamingData extracts individual chunks from streaming response
-func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
+// Add parses the json data of a chunk of the streaming response
+func (a *Aggregator) Add(data []byte) error {
 	if render.IsValidSSEData(data) {
 		return nil
 	}
@@ -224,7 +229,7 @@ func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
 		return err
 	}
 
-	rw.lastChunk = &node
+	a.lastChunk = &node
 
 	usageNode := node.Get("usage")
 	if err := usageNode.Check(); err != nil {
@@ -232,14 +237,14 @@ func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
 			return err
 		}
 	} else {
-		rw.usageNode = usageNode
+		a.usageNode = usageNode
 	}
 
 	// Extract prompt_filter_results from first chunk (only save once)
-	if rw.promptFilterResults == nil {
+	if a.promptFilterResults == nil {
 		promptFilterResultsNode := node.Get("prompt_filter_results")
 		if err := promptFilterResultsNode.Check(); err == nil {
-			rw.promptFilterResults = promptFilterResultsNode
+			a.promptFilterResults = promptFilterResultsNode
 		}
 	}
 
@@ -252,13 +257,13 @@ func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
 		// Extract content_filter_results from choice (keep last non-empty value)
 		contentFilterResultsNode := choiceNode.Get("content_filter_results")
 		if err := contentFilterResultsNode.Check(); err == nil {
-			rw.contentFilterResults = contentFilterResultsNode
+			a.contentFilterResults = contentFilterResultsNode
 		}
 
 		// Extract content_filter_result from choice (alternative field name, keep last non-empty value)
 		contentFilterResultNode := choiceNode.Get("content_filter_result")
 		if err := contentFilterResultNode.Check(); err == nil {
-			rw.contentFilterResult = contentFilterResultNode
+			a.contentFilterResult = contentFilterResultNode
 		}
 
 		deltaNode := choiceNode.Get("delta")
@@ -270,7 +275,7 @@ func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
 		if err := contentNode.Check(); err == nil {
 			// Try as string first (common case)
 			if content, err := contentNode.String(); err == nil {
-				rw.contentBuilder.WriteString(content)
+				a.contentBuilder.WriteString(content)
 			} else {
 				// Try as array (for image/multimodal content)
 				_ = contentNode.ForEach(func(_ ast.Sequence, partNode *ast.Node) bool {
@@ -285,7 +290,7 @@ func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
 					}
 
 					// Keep all parts in contentParts for multimodal content
-					rw.contentParts = append(rw.contentParts, part)
+					a.contentParts = append(a.contentParts, part)
 
 					return true
 				})
@@ -294,12 +299,12 @@ func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
 
 		reasoningContent, err := deltaNode.Get("reasoning_content").String()
 		if err == nil {
-			rw.reasoningContent.WriteString(reasoningContent)
+			a.reasoningContent.WriteString(reasoningContent)
 		}
 
 		// Handle signature for thought
 		if signature, err := deltaNode.Get("signature").String(); err == nil && signature != "" {
-			rw.signature = signature
+			a.signature = signature
 		}
 
 		_ = deltaNode.Get("tool_calls").
@@ -318,14 +323,14 @@ func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
 					return true
 				}
 
-				rw.toolCalls = mergeToolCalls(rw.toolCalls, &toolCall)
+				a.toolCalls = mergeToolCalls(a.toolCalls, &toolCall)
 
 				return true
 			})
 
 		finishReason, err := choiceNode.Get("finish_reason").String()
 		if err == nil && finishReason != "" {
-			rw.finishReason = finishReason
+			a.finishReason = finishReason
 		}
 
 		logprobsContentNode := choiceNode.GetByPath("logprobs", "content")
@@ -335,10 +340,10 @@ func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
 				return true
 			}
 
-			rw.logprobsContent = slices.Grow(rw.logprobsContent, l)
+			a.logprobsContent = slices.Grow(a.logprobsContent, l)
 			_ = logprobsContentNode.ForEach(
 				func(_ ast.Sequence, logprobsContentNode *ast.Node) bool {
-					rw.logprobsContent = append(rw.logprobsContent, *logprobsContentNode)
+					a.logprobsContent = append(a.logprobsContent, *logprobsContentNode)
 					return true
 				},
 			)
@@ -348,8 +353,9 @@ func (rw *fakeStreamResponseWriter) parseStreamingData(data []byte) error {
 	})
 }
 
-func (rw *fakeStreamResponseWriter) convertToNonStream() ([]byte, error) {
-	lastChunk := rw.lastChunk
+// NonStream builds the non-stream chat completion of the added chunks
+func (a *Aggregator) NonStream() ([]byte, error) {
+	lastChunk := a.lastChunk
 	if lastChunk == nil {
 		return nil, errors.New("last chunk is nil")
 	}
@@ -359,8 +365,8 @@ func (rw *fakeStreamResponseWriter) convertToNonStream() ([]byte, error) {
 		return nil, err
 	}
 
-	if rw.usageNode != nil {
-		_, err = lastChunk.Set("usage", *rw.usageNode)
+	if a.usageNode != nil {
+		_, err = lastChunk.Set("usage", *a.usageNode)
 		if err != nil {
 			return nil, err
 		}
@@ -371,28 +377,28 @@ func (rw *fakeStreamResponseWriter) convertToNonStream() ([]byte, error) {
 	}
 
 	// Use contentParts if available (for image/multimodal content), otherwise use string content
-	if len(rw.contentParts) > 0 {
-		message["content"] = rw.contentParts
+	if len(a.contentParts) > 0 {
+		message["content"] = a.contentParts
 	} else {
-		message["content"] = rw.contentBuilder.String()
+		message["content"] = a.contentBuilder.String()
 	}
 
-	reasoningContent := rw.reasoningContent.String()
+	reasoningContent := a.reasoningContent.String()
 	if reasoningContent != "" {
 		message["reasoning_content"] = reasoningContent
 	}
 
-	if rw.signature != "" {
-		message["signature"] = rw.signature
+	if a.signature != "" {
+		message["signature"] = a.signature
 	}
 
-	if len(rw.toolCalls) > 0 {
-		message["tool_calls"] = rw.buildToolCalls()
+	if len(a.toolCalls) > 0 {
+		message["tool_calls"] = a.buildToolCalls()
 	}
 
-	if len(rw.logprobsContent) > 0 {
+	if len(a.logprobsContent) > 0 {
 		message["logprobs"] = map[string]any{
-			"content": rw.logprobsContent,
+			"content": a.logprobsContent,
 		}
 	}
 
@@ -400,20 +406,20 @@ func (rw *fakeStreamResponseWriter) convertToNonStream() ([]byte, error) {
 	choice := map[string]any{
 		"index":         0,
 		"message":       message,
-		"finish_reason": rw.finishReason,
+		"finish_reason": a.finishReason,
 	}
 
 	// Add content_filter_results to choice if present
-	if rw.contentFilterResults != nil {
-		contentFilterResultsRaw, err := rw.contentFilterResults.Interface()
+	if a.contentFilterResults != nil {
+		contentFilterResultsRaw, err := a.contentFilterResults.Interface()
 		if err == nil {
 			choice["content_filter_results"] = contentFilterResultsRaw
 		}
 	}
 
 	// Add content_filter_result to choice if present (alternative field name)
-	if rw.contentFilterResult != nil {
-		contentFilterResultRaw, err := rw.contentFilterResult.Interface()
+	if a.contentFilterResult != nil {
+		contentFilterResultRaw, err := a.contentFilterResult.Interface()
 		if err == nil {
 			choice["content_filter_result"] = contentFilterResultRaw
 		}
@@ -425,8 +431,8 @@ func (rw *fakeStreamResponseWriter) convertToNonStream() ([]byte, error) {
 	}
 
 	// Add prompt_filter_results to response if present
-	if rw.promptFilterResults != nil {
-		_, err = lastChunk.Set("prompt_filter_results", *rw.promptFilterResults)
+	if a.promptFilterResults != nil {
+		_, err = lastChunk.Set("prompt_filter_results", *a.promptFilterResults)
 		if err != nil {
 			return nil, err
 		}
@@ -435,24 +441,24 @@ func (rw *fakeStreamResponseWriter) convertToNonStream() ([]byte, error) {
 	return lastChunk.MarshalJSON()
 }
 
-func (rw *fakeStreamResponseWriter) buildToolCalls() []*relaymodel.ToolCall {
-	if len(rw.toolCalls) == 0 {
+func (a *Aggregator) buildToolCalls() []*relaymodel.ToolCall {
+	if len(a.toolCalls) == 0 {
 		return nil
 	}
 
-	slices.SortFunc(rw.toolCalls, func(a, b *relaymodel.ToolCall) int {
-		return a.Index - b.Index
+	slices.SortFunc(a.toolCalls, func(x, y *relaymodel.ToolCall) int {
+		return x.Index - y.Index
 	})
 
-	if rw.toolCalls[0].Index == 0 {
-		return rw.toolCalls
+	if a.toolCalls[0].Index == 0 {
+		return a.toolCalls
 	}
 	// fix tool call index start with 0
-	for i, v := range rw.toolCalls {
+	for i, v := range a.toolCalls {
 		v.Index = i
 	}
 
-	return rw.toolCalls
+	return a.toolCalls
 }
 
 func mergeToolCalls(
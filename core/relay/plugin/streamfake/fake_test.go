@@ -57,7 +57,7 @@ func TestParseStreamingDataWithContentFilterFields(t *testing.T) {
 
 			// Parse all chunks
 			for _, chunk := range tt.chunks {
-				err := rw.parseStreamingData([]byte(chunk))
+				err := rw.Add([]byte(chunk))
 				require.NoError(t, err)
 			}
 
@@ -89,7 +89,7 @@ func TestParseStreamingDataWithContentFilterFields(t *testing.T) {
 			}
 
 			// Convert to non-stream and verify
-			result, err := rw.convertToNonStream()
+			result, err := rw.NonStream()
 			require.NoError(t, err)
 
 			var response map[string]any
@@ -156,11 +156,11 @@ func TestConvertToNonStreamWithAllFields(t *testing.T) {
 	}
 
 	for _, chunk := range chunks {
-		err := rw.parseStreamingData([]byte(chunk))
+		err := rw.Add([]byte(chunk))
 		require.NoError(t, err)
 	}
 
-	result, err := rw.convertToNonStream()
+	result, err := rw.NonStream()
 	require.NoError(t, err)
 
 	var response map[string]any
@@ -222,12 +222,12 @@ func TestParseStreamingDataIgnoresSSEData(t *testing.T) {
 	rw := &fakeStreamResponseWriter{}
 
 	// SSE data prefix should be ignored (IsValidSSEData checks for "data: " prefix)
-	err := rw.parseStreamingData([]byte("data: "))
+	err := rw.Add([]byte("data: "))
 	assert.NoError(t, err)
 	assert.Nil(t, rw.lastChunk)
 
 	// SSE data with actual content should also be ignored
-	err = rw.parseStreamingData([]byte("data: {\"test\":1}"))
+	err = rw.Add([]byte("data: {\"test\":1}"))
 	assert.NoError(t, err)
 	assert.Nil(t, rw.lastChunk)
 }
@@ -237,7 +237,7 @@ func TestParseStreamingDataWithEmptyChoices(t *testing.T) {
 
 	// First chunk with empty choices (prompt_filter_results only)
 	chunk := `{"choices":[],"created":0,"id":"","model":"gpt-4.1-mini","object":"","prompt_filter_results":[{"prompt_index":0,"content_filter_results":{}}]}`
-	err := rw.parseStreamingData([]byte(chunk))
+	err := rw.Add([]byte(chunk))
 	require.NoError(t, err)
 
 	assert.NotNil(t, rw.promptFilterResults)
@@ -248,10 +248,10 @@ func TestContentFilterResultPreservesErrorDetails(t *testing.T) {
 	rw := &fakeStreamResponseWriter{}
 
 	chunk := `{"choices":[{"content_filter_result":{"error":{"code":"content_filter_error","message":"The contents are not filtered"}},"delta":{"content":"test"},"finish_reason":"stop","index":0}],"created":1767597874,"id":"chatcmpl-test","model":"gpt-4","object":"chat.completion.chunk","usage":{"completion_tokens":1,"prompt_tokens":10,"total_tokens":11}}`
-	err := rw.parseStreamingData([]byte(chunk))
+	err := rw.Add([]byte(chunk))
 	require.NoError(t, err)
 
-	result, err := rw.convertToNonStream()
+	result, err := rw.NonStream()
 	require.NoError(t, err)
 
 	var response map[string]any
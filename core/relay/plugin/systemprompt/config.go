@@ -0,0 +1,37 @@
+package systemprompt
+
+const (
+	ActionPrepend = "prepend"
+	ActionAppend  = "append"
+	ActionReplace = "replace"
+)
+
+const defaultSeparator = "\n\n"
+
+type Config struct {
+	Enable bool `json:"enable"`
+	// Content is the system prompt, the variables {{group}}, {{token_name}},
+	// {{model}}, {{date}} and {{datetime}} are replaced
+	Content string `json:"content"`
+	// Action is prepend, append or replace, default is prepend,
+	// replace removes the system prompts of the request
+	Action string `json:"action"`
+	// Separator joins the content and the system prompt of the request, default is two newlines
+	Separator *string `json:"separator"`
+	// Tokens limits the system prompt to the token names, empty applies to all tokens
+	Tokens []string `json:"tokens"`
+}
+
+func (c *Config) GetAction() string {
+	if c.Action == "" {
+		return ActionPrepend
+	}
+	return c.Action
+}
+
+func (c *Config) GetSeparator() string {
+	if c.Separator == nil {
+		return defaultSeparator
+	}
+	return *c.Separator
+}
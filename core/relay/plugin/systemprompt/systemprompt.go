@@ -0,0 +1,271 @@
+// Package systemprompt injects a system prompt into the chat requests of the
+// openai, anthropic, gemini and responses formats
+package systemprompt
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	relaymodel "github.com/labring/aiproxy/core/relay/model"
+	"github.com/labring/aiproxy/core/relay/plugin"
+	"github.com/labring/aiproxy/core/relay/plugin/noop"
+)
+
+const (
+	PluginName = "system-prompt"
+
+	roleDeveloper = "developer"
+)
+
+var _ plugin.Plugin = (*SystemPrompt)(nil)
+
+type SystemPrompt struct {
+	noop.Noop
+}
+
+func NewSystemPromptPlugin() plugin.Plugin {
+	return &SystemPrompt{}
+}
+
+func (p *SystemPrompt) getConfig(meta *meta.Meta) (Config, error) {
+	pluginConfig := Config{}
+	if err := meta.ModelConfig.LoadPluginConfig(PluginName, &pluginConfig); err != nil {
+		return Config{}, err
+	}
+
+	return pluginConfig, nil
+}
+
+// injector injects the content into the system prompt of a request format
+type injector func(body map[string]any, content string, config Config) error
+
+var injectors = map[mode.Mode]injector{
+	mode.ChatCompletions: injectOpenAI,
+	mode.Anthropic:       injectAnthropic,
+	mode.Gemini:          injectGemini,
+	mode.Responses:       injectResponses,
+}
+
+// renderContent replaces the variables of the content
+func renderContent(content string, meta *meta.Meta, now time.Time) string {
+	return strings.NewReplacer(
+		"{{group}}", meta.Group.ID,
+		"{{token_name}}", meta.Token.Name,
+		"{{model}}", meta.OriginModel,
+		"{{date}}", now.Format(time.DateOnly),
+		"{{datetime}}", now.Format(time.RFC3339),
+	).Replace(content)
+}
+
+// ConvertRequest injects the system prompt before the request is converted
+func (p *SystemPrompt) ConvertRequest(
+	meta *meta.Meta,
+	store adaptor.Store,
+	req *http.Request,
+	do adaptor.ConvertRequest,
+) (adaptor.ConvertResult, error) {
+	inject, ok := injectors[meta.Mode]
+	if !ok {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	config, err := p.getConfig(meta)
+	if err != nil || !config.Enable || config.Content == "" {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	if len(config.Tokens) > 0 && !slices.Contains(config.Tokens, meta.Token.Name) {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	switch config.GetAction() {
+	case ActionPrepend, ActionAppend, ActionReplace:
+	default:
+		return adaptor.ConvertResult{}, relaymodel.WrapperErrorWithMessage(
+			meta.Mode,
+			http.StatusInternalServerError,
+			"invalid system prompt action: "+config.Action,
+		)
+	}
+
+	bodyBytes, err := common.GetRequestBodyReusable(req)
+	if err != nil {
+		return adaptor.ConvertResult{}, fmt.Errorf("failed to read request body: %w", err)
+	}
+
+	var body map[string]any
+	if err := sonic.Unmarshal(bodyBytes, &body); err != nil {
+		return do.ConvertRequest(meta, store, req)
+	}
+
+	if err := inject(body, renderContent(config.Content, meta, time.Now()), config); err != nil {
+		return adaptor.ConvertResult{}, fmt.Errorf("failed to inject system prompt: %w", err)
+	}
+
+	injectedBody, err := sonic.Marshal(body)
+	if err != nil {
+		return adaptor.ConvertResult{}, err
+	}
+
+	common.SetRequestBody(req, injectedBody)
+	defer func() {
+		common.SetRequestBody(req, bodyBytes)
+	}()
+
+	return do.ConvertRequest(meta, store, req)
+}
+
+// joinText joins the content and the text of the request by the action
+func joinText(text, content string, config Config) string {
+	switch {
+	case text == "" || config.GetAction() == ActionReplace:
+		return content
+	case config.GetAction() == ActionAppend:
+		return text + config.GetSeparator() + content
+	default:
+		return content + config.GetSeparator() + text
+	}
+}
+
+// injectParts injects the content into the text parts of a system prompt,
+// the content becomes a new part so the other parts like the cache controls are kept
+func injectParts(parts []any, content string, config Config, textPart func(string) any) []any {
+	switch config.GetAction() {
+	case ActionReplace:
+		return []any{textPart(content)}
+	case ActionAppend:
+		return append(parts, textPart(content))
+	default:
+		return append([]any{textPart(content)}, parts...)
+	}
+}
+
+// textPart is the text part of the openai messages and the text block of the anthropic system
+func textPart(text string) any {
+	return map[string]any{"type": relaymodel.ContentTypeText, "text": text}
+}
+
+func isOpenAISystemMessage(message any) bool {
+	m, ok := message.(map[string]any)
+	if !ok {
+		return false
+	}
+
+	role, _ := m["role"].(string)
+
+	return role == relaymodel.RoleSystem || role == roleDeveloper
+}
+
+// injectOpenAI injects the content into the first system message when prepending,
+// the last one when appending, and replaces all the system messages when replacing
+func injectOpenAI(body map[string]any, content string, config Config) error {
+	messages, _ := body["messages"].([]any)
+
+	if config.GetAction() == ActionReplace {
+		messages = slices.DeleteFunc(messages, isOpenAISystemMessage)
+	}
+
+	index := slices.IndexFunc(messages, isOpenAISystemMessage)
+	if config.GetAction() == ActionAppend {
+		for i, message := range slices.Backward(messages) {
+			if isOpenAISystemMessage(message) {
+				index = i
+				break
+			}
+		}
+	}
+
+	if index < 0 {
+		body["messages"] = slices.Insert(messages, 0, any(map[string]any{
+			"role":    relaymodel.RoleSystem,
+			"content": content,
+		}))
+
+		return nil
+	}
+
+	message, _ := messages[index].(map[string]any)
+	switch c := message["content"].(type) {
+	case string:
+		message["content"] = joinText(c, content, config)
+	case []any:
+		message["content"] = injectParts(c, content, config, textPart)
+	case nil:
+		message["content"] = content
+	default:
+		return fmt.Errorf("invalid system message content type: %T", c)
+	}
+
+	body["messages"] = messages
+
+	return nil
+}
+
+// injectAnthropic injects the content into the system field, a string or text blocks
+func injectAnthropic(body map[string]any, content string, config Config) error {
+	switch system := body["system"].(type) {
+	case nil:
+		body["system"] = content
+	case string:
+		body["system"] = joinText(system, content, config)
+	case []any:
+		body["system"] = injectParts(system, content, config, textPart)
+	default:
+		return fmt.Errorf("invalid system type: %T", system)
+	}
+
+	return nil
+}
+
+func geminiTextPart(text string) any {
+	return map[string]any{"text": text}
+}
+
+// injectGemini injects the content into the parts of the system instruction
+func injectGemini(body map[string]any, content string, config Config) error {
+	key := "systemInstruction"
+	if _, ok := body[key]; !ok {
+		if _, ok := body["system_instruction"]; ok {
+			key = "system_instruction"
+		}
+	}
+
+	switch instruction := body[key].(type) {
+	case nil:
+		body[key] = map[string]any{"parts": []any{geminiTextPart(content)}}
+	case map[string]any:
+		parts, _ := instruction["parts"].([]any)
+		instruction["parts"] = injectParts(parts, content, config, geminiTextPart)
+	default:
+		return fmt.Errorf("invalid system instruction type: %T", instruction)
+	}
+
+	return nil
+}
+
+// injectResponses injects the content into the instructions, replacing also
+// removes the system and developer messages of the input
+func injectResponses(body map[string]any, content string, config Config) error {
+	if input, ok := body["input"].([]any); ok && config.GetAction() == ActionReplace {
+		body["input"] = slices.DeleteFunc(input, isOpenAISystemMessage)
+	}
+
+	switch instructions := body["instructions"].(type) {
+	case nil:
+		body["instructions"] = content
+	case string:
+		body["instructions"] = joinText(instructions, content, config)
+	default:
+		return fmt.Errorf("invalid instructions type: %T", instructions)
+	}
+
+	return nil
+}
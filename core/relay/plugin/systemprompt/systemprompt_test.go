@@ -0,0 +1,192 @@
+package systemprompt_test
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/labring/aiproxy/core/common"
+	"github.com/labring/aiproxy/core/model"
+	"github.com/labring/aiproxy/core/relay/adaptor"
+	"github.com/labring/aiproxy/core/relay/meta"
+	"github.com/labring/aiproxy/core/relay/mode"
+	"github.com/labring/aiproxy/core/relay/plugin/plugintest"
+	"github.com/labring/aiproxy/core/relay/plugin/systemprompt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// convert runs the plugin on the request body of the mode with the plugin config,
+// the body passed to the adaptor is returned
+func convert(
+	t *testing.T,
+	m mode.Mode,
+	body string,
+	config map[string]any,
+	opts ...meta.Option,
+) (string, error) {
+	t.Helper()
+
+	meta := meta.NewMeta(nil, m, "gpt-4o", model.ModelConfig{
+		Model:  "gpt-4o",
+		Type:   m,
+		Plugin: map[string]map[string]any{systemprompt.PluginName: config},
+	}, opts...)
+
+	req := plugintest.NewRequest([]byte(body))
+
+	converted, err := plugintest.Convert(systemprompt.NewSystemPromptPlugin(), meta, req)
+	if err != nil {
+		return "", err
+	}
+
+	// the body of the request is restored for the retries
+	restored, err := common.GetRequestBodyReusable(req)
+	require.NoError(t, err)
+	assert.Equal(t, body, string(restored))
+
+	return string(converted), nil
+}
+
+func TestConvertRequest(t *testing.T) {
+	testCases := []struct {
+		name     string
+		mode     mode.Mode
+		action   string
+		input    string
+		expected string
+	}{
+		{
+			name:     "openai without system",
+			mode:     mode.ChatCompletions,
+			input:    `{"messages":[{"role":"user","content":"hi"}]}`,
+			expected: `{"messages":[{"content":"NOTICE","role":"system"},{"content":"hi","role":"user"}]}`,
+		},
+		{
+			name:     "openai prepend",
+			mode:     mode.ChatCompletions,
+			input:    `{"messages":[{"role":"system","content":"be brief"},{"role":"user","content":"hi"}]}`,
+			expected: `{"messages":[{"content":"NOTICE\n\nbe brief","role":"system"},{"content":"hi","role":"user"}]}`,
+		},
+		{
+			name:     "openai append to parts",
+			mode:     mode.ChatCompletions,
+			action:   systemprompt.ActionAppend,
+			input:    `{"messages":[{"role":"developer","content":[{"type":"text","text":"be brief"}]}]}`,
+			expected: `{"messages":[{"content":[{"text":"be brief","type":"text"},{"text":"NOTICE","type":"text"}],"role":"developer"}]}`,
+		},
+		{
+			name:     "openai replace",
+			mode:     mode.ChatCompletions,
+			action:   systemprompt.ActionReplace,
+			input:    `{"messages":[{"role":"system","content":"a"},{"role":"user","content":"hi"},{"role":"system","content":"b"}]}`,
+			expected: `{"messages":[{"content":"NOTICE","role":"system"},{"content":"hi","role":"user"}]}`,
+		},
+		{
+			name:     "anthropic string",
+			mode:     mode.Anthropic,
+			action:   systemprompt.ActionAppend,
+			input:    `{"system":"be brief"}`,
+			expected: `{"system":"be brief\n\nNOTICE"}`,
+		},
+		{
+			name:     "anthropic blocks",
+			mode:     mode.Anthropic,
+			input:    `{"system":[{"type":"text","text":"be brief","cache_control":{"type":"ephemeral"}}]}`,
+			expected: `{"system":[{"text":"NOTICE","type":"text"},{"cache_control":{"type":"ephemeral"},"text":"be brief","type":"text"}]}`,
+		},
+		{
+			name:     "gemini without instruction",
+			mode:     mode.Gemini,
+			input:    `{"contents":[]}`,
+			expected: `{"contents":[],"systemInstruction":{"parts":[{"text":"NOTICE"}]}}`,
+		},
+		{
+			name:     "gemini snake case",
+			mode:     mode.Gemini,
+			action:   systemprompt.ActionReplace,
+			input:    `{"system_instruction":{"parts":[{"text":"be brief"}]}}`,
+			expected: `{"system_instruction":{"parts":[{"text":"NOTICE"}]}}`,
+		},
+		{
+			name:     "responses",
+			mode:     mode.Responses,
+			input:    `{"instructions":"be brief"}`,
+			expected: `{"instructions":"NOTICE\n\nbe brief"}`,
+		},
+		{
+			name:     "responses replace",
+			mode:     mode.Responses,
+			action:   systemprompt.ActionReplace,
+			input:    `{"instructions":"be brief","input":[{"role":"developer","content":"a"},{"role":"user","content":"hi"}]}`,
+			expected: `{"instructions":"NOTICE","input":[{"content":"hi","role":"user"}]}`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			output, err := convert(t, tc.mode, tc.input, map[string]any{
+				"enable":  true,
+				"content": "NOTICE",
+				"action":  tc.action,
+			})
+			require.NoError(t, err)
+			assert.JSONEq(t, tc.expected, output)
+		})
+	}
+}
+
+func TestConvertRequestVariables(t *testing.T) {
+	output, err := convert(
+		t,
+		mode.Anthropic,
+		`{"system":"be brief"}`,
+		map[string]any{
+			"enable":  true,
+			"content": "group {{group}}, token {{token_name}}, model {{model}}",
+		},
+		meta.WithGroup(model.GroupCache{ID: "g1"}),
+		meta.WithToken(model.TokenCache{Name: "t1"}),
+	)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"system":"group g1, token t1, model gpt-4o\n\nbe brief"}`, output)
+}
+
+func TestConvertRequestTokens(t *testing.T) {
+	config := map[string]any{
+		"enable":  true,
+		"content": "NOTICE",
+		"tokens":  []string{"t1"},
+	}
+
+	output, err := convert(
+		t,
+		mode.Anthropic,
+		`{"system":"be brief"}`,
+		config,
+		meta.WithToken(model.TokenCache{Name: "t1"}),
+	)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"system":"NOTICE\n\nbe brief"}`, output)
+
+	output, err = convert(
+		t,
+		mode.Anthropic,
+		`{"system":"be brief"}`,
+		config,
+		meta.WithToken(model.TokenCache{Name: "t2"}),
+	)
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"system":"be brief"}`, output)
+}
+
+func TestConvertRequestInvalidAction(t *testing.T) {
+	_, err := convert(t, mode.ChatCompletions, `{"messages":[]}`, map[string]any{
+		"enable":  true,
+		"content": "NOTICE",
+		"action":  "drop",
+	})
+
+	var relayErr adaptor.Error
+	require.ErrorAs(t, err, &relayErr)
+	assert.Equal(t, http.StatusInternalServerError, relayErr.StatusCode())
+}
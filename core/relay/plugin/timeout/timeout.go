@@ -55,6 +55,10 @@ func (t *Timeout) ConvertRequest(
 		mode.ResponsesCancel,
 		mode.ResponsesInputItems:
 		meta.RequestTimeout = time.Second * 30
+	case mode.FilesGet, mode.FilesDelete:
+		meta.RequestTimeout = time.Second * 30
+	case mode.FilesUpload, mode.FilesContent:
+		meta.RequestTimeout = time.Minute * 5
 	case mode.ChatCompletions,
 		mode.Completions,
 		mode.Responses,
@@ -2,6 +2,8 @@ package plugin
 
 import (
 	"net/http"
+	"path"
+	"reflect"
 
 	"github.com/gin-gonic/gin"
 	"github.com/labring/aiproxy/core/model"
@@ -60,6 +62,7 @@ func WrapperAdaptor(adaptor adaptor.Adaptor, plugins ...Plugin) adaptor.Adaptor
 		result = &wrappedAdaptor{
 			Adaptor: result,
 			plugin:  plugins[i],
+			name:    pluginName(plugins[i]),
 		}
 	}
 
@@ -71,6 +74,23 @@ var _ adaptor.Adaptor = (*wrappedAdaptor)(nil)
 type wrappedAdaptor struct {
 	adaptor.Adaptor
 	plugin Plugin
+	name   string
+}
+
+// pluginName is the package and type name of the plugin, like cache.Cache
+func pluginName(p Plugin) string {
+	t := reflect.TypeOf(p)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	return path.Base(t.PkgPath()) + "." + t.Name()
+}
+
+// startSpan starts the span of a hook of the plugin, the spans of the inner
+// plugins and the adaptor are nested in it
+func (w *wrappedAdaptor) startSpan(meta *meta.Meta, hook string) func(err error) {
+	return meta.StartSpan("plugin " + w.name + " " + hook)
 }
 
 func (w *wrappedAdaptor) GetRequestURL(
@@ -78,7 +98,12 @@ func (w *wrappedAdaptor) GetRequestURL(
 	store adaptor.Store,
 	c *gin.Context,
 ) (adaptor.RequestURL, error) {
-	return w.plugin.GetRequestURL(meta, store, c, w.Adaptor)
+	end := w.startSpan(meta, "GetRequestURL")
+
+	url, err := w.plugin.GetRequestURL(meta, store, c, w.Adaptor)
+	end(err)
+
+	return url, err
 }
 
 func (w *wrappedAdaptor) SetupRequestHeader(
@@ -87,7 +112,12 @@ func (w *wrappedAdaptor) SetupRequestHeader(
 	c *gin.Context,
 	req *http.Request,
 ) error {
-	return w.plugin.SetupRequestHeader(meta, store, c, req, w.Adaptor)
+	end := w.startSpan(meta, "SetupRequestHeader")
+
+	err := w.plugin.SetupRequestHeader(meta, store, c, req, w.Adaptor)
+	end(err)
+
+	return err
 }
 
 func (w *wrappedAdaptor) ConvertRequest(
@@ -95,7 +125,12 @@ func (w *wrappedAdaptor) ConvertRequest(
 	store adaptor.Store,
 	req *http.Request,
 ) (adaptor.ConvertResult, error) {
-	return w.plugin.ConvertRequest(meta, store, req, w.Adaptor)
+	end := w.startSpan(meta, "ConvertRequest")
+
+	result, err := w.plugin.ConvertRequest(meta, store, req, w.Adaptor)
+	end(err)
+
+	return result, err
 }
 
 func (w *wrappedAdaptor) DoRequest(
@@ -104,7 +139,12 @@ func (w *wrappedAdaptor) DoRequest(
 	c *gin.Context,
 	req *http.Request,
 ) (*http.Response, error) {
-	return w.plugin.DoRequest(meta, store, c, req, w.Adaptor)
+	end := w.startSpan(meta, "DoRequest")
+
+	resp, err := w.plugin.DoRequest(meta, store, c, req, w.Adaptor)
+	end(err)
+
+	return resp, err
 }
 
 func (w *wrappedAdaptor) DoResponse(
@@ -113,5 +153,10 @@ func (w *wrappedAdaptor) DoResponse(
 	c *gin.Context,
 	resp *http.Response,
 ) (model.Usage, adaptor.Error) {
-	return w.plugin.DoResponse(meta, store, c, resp, w.Adaptor)
+	end := w.startSpan(meta, "DoResponse")
+
+	usage, relayErr := w.plugin.DoResponse(meta, store, c, resp, w.Adaptor)
+	end(relayErr)
+
+	return usage, relayErr
 }
@@ -321,3 +321,34 @@ func NewScanner(r io.Reader) (*bufio.Scanner, func()) {
 func IsGeminiStreamRequest(path string) bool {
 	return strings.HasSuffix(path, ":streamGenerateContent")
 }
+
+// maxOutputTokensPaths are the fields of the max output tokens in the openai chat,
+// completions, responses, anthropic and gemini requests
+var maxOutputTokensPaths = [][]any{
+	{"max_completion_tokens"},
+	{"max_tokens"},
+	{"max_output_tokens"},
+	{"generationConfig", "maxOutputTokens"},
+}
+
+// GetRequestMaxOutputTokens returns the max output tokens set in the json request body,
+// zero if it is not set
+func GetRequestMaxOutputTokens(req *http.Request) int64 {
+	requestBody, err := common.GetRequestBodyReusable(req)
+	if err != nil || len(requestBody) == 0 {
+		return 0
+	}
+
+	for _, path := range maxOutputTokensPaths {
+		node, err := sonic.Get(requestBody, path...)
+		if err != nil {
+			continue
+		}
+
+		if maxTokens, err := node.Int64(); err == nil && maxTokens > 0 {
+			return maxTokens
+		}
+	}
+
+	return 0
+}
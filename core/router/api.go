@@ -134,6 +134,7 @@ func SetAPIRouter(router *gin.Engine) {
 			tokensRoute.PUT("/:id", controller.UpdateToken)
 			tokensRoute.POST("/:id/status", controller.UpdateTokenStatus)
 			tokensRoute.POST("/:id/name", controller.UpdateTokenName)
+			tokensRoute.POST("/:id/rotate", controller.RotateToken)
 			tokensRoute.DELETE("/:id", controller.DeleteToken)
 			tokensRoute.GET("/search", controller.SearchTokens)
 			tokensRoute.POST("/batch_delete", controller.DeleteTokens)
@@ -149,6 +150,7 @@ func SetAPIRouter(router *gin.Engine) {
 			tokenRoute.PUT("/:group/:id", controller.UpdateGroupToken)
 			tokenRoute.POST("/:group/:id/status", controller.UpdateGroupTokenStatus)
 			tokenRoute.POST("/:group/:id/name", controller.UpdateGroupTokenName)
+			tokenRoute.POST("/:group/:id/rotate", controller.RotateGroupToken)
 			tokenRoute.DELETE("/:group/:id", controller.DeleteGroupToken)
 		}
 
@@ -194,6 +196,8 @@ func SetAPIRouter(router *gin.Engine) {
 			monitorRoute.DELETE("/:id/*model", controller.ClearChannelModelErrors)
 			monitorRoute.GET("/models", controller.GetModelsErrorRate)
 			monitorRoute.GET("/banned_channels", controller.GetAllBannedModelChannels)
+			monitorRoute.GET("/inflight", controller.GetAllChannelsInFlight)
+			monitorRoute.GET("/inflight/:id", controller.GetChannelInFlight)
 		}
 
 		publicsMcpRoute := apiRouter.Group("/mcp/publics")
@@ -8,6 +8,7 @@ func SetRouter(router *gin.Engine) {
 	SetAPIRouter(router)
 	SetRelayRouter(router)
 	SetMCPRouter(router)
+	SetMetricsRouter(router)
 	SetStaticFileRouter(router)
 	SetSwaggerRouter(router)
 }
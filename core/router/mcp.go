@@ -19,6 +19,11 @@ func SetMCPRouter(router *gin.Engine) {
 	mcpRoute.POST("/group/:id", mcp.GroupMCPStreamable)
 	mcpRoute.DELETE("/group/:id", mcp.GroupMCPStreamable)
 
+	mcpRoute.GET("/aggregate/sse", mcp.AggregateMCPSSEServer)
+	mcpRoute.GET("/aggregate", mcp.AggregateMCPStreamable)
+	mcpRoute.POST("/aggregate", mcp.AggregateMCPStreamable)
+	mcpRoute.DELETE("/aggregate", mcp.AggregateMCPStreamable)
+
 	router.GET("/sse", middleware.MCPAuth, mcp.HostMCPSSEServer)
 	router.POST("/message", mcp.MCPMessage)
 	router.GET("/mcp", middleware.MCPAuth, mcp.HostMCPStreamable)
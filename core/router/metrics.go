@@ -0,0 +1,16 @@
+package router
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/labring/aiproxy/core/common/config"
+	"github.com/labring/aiproxy/core/controller"
+	"github.com/labring/aiproxy/core/middleware"
+)
+
+func SetMetricsRouter(router *gin.Engine) {
+	if config.DisableMetrics {
+		return
+	}
+
+	router.GET("/metrics", middleware.AdminAuth, controller.Metrics)
+}
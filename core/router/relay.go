@@ -9,10 +9,10 @@ import (
 func SetRelayRouter(router *gin.Engine) {
 	// https://platform.openai.com/docs/api-reference/introduction
 	v1Router := router.Group("/v1")
-	v1Router.Use(middleware.IPBlock, middleware.TokenAuth)
+	v1Router.Use(middleware.Trace, middleware.IPBlock, middleware.TokenAuth)
 
 	v1betaRouter := router.Group("/v1beta")
-	v1betaRouter.Use(middleware.IPBlock, middleware.TokenAuth)
+	v1betaRouter.Use(middleware.Trace, middleware.IPBlock, middleware.TokenAuth)
 
 	modelsRouter := v1Router.Group("/models")
 	{
@@ -117,12 +117,18 @@ func SetRelayRouter(router *gin.Engine) {
 			"/responses/:response_id/input_items",
 			controller.GetResponseInputItems()...)
 
+		relayRouter.GET("/files", controller.FilesList()...)
+		relayRouter.POST("/files", controller.FilesUpload()...)
+		relayRouter.GET("/files/:id", controller.FilesGet()...)
+		relayRouter.DELETE("/files/:id", controller.FilesDelete()...)
+		relayRouter.GET("/files/:id/content", controller.FilesContent()...)
+
+		relayRouter.POST("/batches", controller.CreateBatch)
+		relayRouter.GET("/batches", controller.ListBatches)
+		relayRouter.GET("/batches/:id", controller.GetBatch)
+		relayRouter.POST("/batches/:id/cancel", controller.CancelBatch)
+
 		relayRouter.POST("/images/variations", controller.RelayNotImplemented)
-		relayRouter.GET("/files", controller.RelayNotImplemented)
-		relayRouter.POST("/files", controller.RelayNotImplemented)
-		relayRouter.DELETE("/files/:id", controller.RelayNotImplemented)
-		relayRouter.GET("/files/:id", controller.RelayNotImplemented)
-		relayRouter.GET("/files/:id/content", controller.RelayNotImplemented)
 		relayRouter.POST("/fine_tuning/jobs", controller.RelayNotImplemented)
 		relayRouter.GET("/fine_tuning/jobs", controller.RelayNotImplemented)
 		relayRouter.GET("/fine_tuning/jobs/:id", controller.RelayNotImplemented)
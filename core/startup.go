@@ -18,6 +18,7 @@ import (
 	"github.com/labring/aiproxy/core/common/balance"
 	"github.com/labring/aiproxy/core/common/config"
 	"github.com/labring/aiproxy/core/common/conv"
+	"github.com/labring/aiproxy/core/common/filestorage"
 	"github.com/labring/aiproxy/core/common/notify"
 	"github.com/labring/aiproxy/core/common/oncall"
 	"github.com/labring/aiproxy/core/common/pprof"
@@ -42,6 +43,10 @@ func initializeServices(pprofPort int) error {
 		return err
 	}
 
+	if err := initializeFileStorage(); err != nil {
+		return err
+	}
+
 	if err := model.InitDB(); err != nil {
 		return err
 	}
@@ -74,6 +79,11 @@ func initializeBalance() error {
 	return balance.InitSealos(sealosJwtKey, os.Getenv("SEALOS_ACCOUNT_URL"))
 }
 
+func initializeFileStorage() error {
+	log.Infof("file storage type: %s, path: %s", config.FileStorageType, config.FileStoragePath)
+	return filestorage.Init(config.FileStorageType, config.FileStoragePath)
+}
+
 func initializeNotifier() {
 	feishuWh := os.Getenv("NOTIFY_FEISHU_WEBHOOK")
 	if feishuWh != "" {
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/bytedance/sonic"
@@ -253,3 +255,56 @@ func CleanLogTask(ctx context.Context) {
 		}
 	}
 }
+
+// BatchTask 处理离线批量请求
+func BatchTask(ctx context.Context, wg *sync.WaitGroup) {
+	defer wg.Done()
+
+	ticker := time.NewTicker(time.Second * 5)
+	defer ticker.Stop()
+
+	var (
+		running atomic.Int64
+		batchWG sync.WaitGroup
+	)
+
+	defer batchWG.Wait()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		for running.Load() < config.GetBatchWorkers() {
+			batch, err := model.ClaimBatch(controller.BatchLease)
+			if err != nil {
+				notify.ErrorThrottle(
+					"claimBatchError",
+					time.Minute*5,
+					"claim batch failed",
+					err.Error(),
+				)
+
+				break
+			}
+
+			if batch == nil {
+				break
+			}
+
+			running.Add(1)
+			batchWG.Add(1)
+
+			go func() {
+				defer func() {
+					running.Add(-1)
+					batchWG.Done()
+				}()
+
+				controller.RunBatch(ctx, batch)
+			}()
+		}
+	}
+}
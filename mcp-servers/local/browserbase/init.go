@@ -13,14 +13,34 @@ var readme string
 //go:embed README.cn.md
 var readmeCN string
 
+var configTemplates = mcpservers.ConfigTemplates{
+	"BROWSERBASE_API_KEY": {
+		Name:        "Browserbase API Key",
+		Required:    mcpservers.ConfigRequiredTypeInitOrReusingOnly,
+		Example:     "bb_live_xxxxxxxxxxxxxxxx",
+		Description: "The API key of Browserbase",
+	},
+	"BROWSERBASE_PROJECT_ID": {
+		Name:        "Browserbase Project ID",
+		Required:    mcpservers.ConfigRequiredTypeInitOrReusingOnly,
+		Example:     "00000000-0000-0000-0000-000000000000",
+		Description: "The project id of Browserbase",
+	},
+}
+
 // need import in mcpregister/init.go
 func init() {
 	mcpservers.Register(
 		mcpservers.NewMcp(
 			"browserbase",
 			"BrowserBase Cloud Browser Automation",
-			model.PublicMCPTypeDocs,
+			model.PublicMCPTypeStdio,
 			mcpservers.WithNameCN("BrowserBase云浏览器自动化"),
+			mcpservers.WithStdioConfig(&model.MCPStdioConfig{
+				Command: "npx",
+				Args:    []string{"-y", "@browserbasehq/mcp"},
+			}),
+			mcpservers.WithConfigTemplates(configTemplates),
 			mcpservers.WithTags([]string{"browser"}),
 			mcpservers.WithGitHubURL(
 				"https://github.com/browserbase/mcp-server-browserbase/tree/main/browserbase",
@@ -236,6 +236,12 @@ func WithProxyConfigTemplates(proxyConfigTemplates ProxyConfigTemplates) McpConf
 	}
 }
 
+func WithStdioConfig(stdioConfig *model.MCPStdioConfig) McpConfig {
+	return func(e *McpServer) {
+		e.StdioConfig = stdioConfig
+	}
+}
+
 func WithListToolsFunc(listTools ListToolsFunc) McpConfig {
 	return func(e *McpServer) {
 		e.listTools = listTools